@@ -10,9 +10,14 @@ require (
 	github.com/gobeam/stringy v0.0.4
 	github.com/onsi/ginkgo v1.16.4 // indirect
 	github.com/onsi/gomega v1.13.0 // indirect
+	github.com/prometheus/client_golang v1.11.1
 	github.com/satori/go.uuid v1.2.0
 	github.com/sirupsen/logrus v1.8.1
+	go.opentelemetry.io/otel v1.0.1
+	go.opentelemetry.io/otel/sdk v1.0.1
+	go.opentelemetry.io/otel/trace v1.0.1
 	gopkg.in/check.v1 v1.0.0-20201130134442-10cb98267c6c // indirect
 	gopkg.in/confluentinc/confluent-kafka-go.v1 v1.7.0
+	gopkg.in/yaml.v3 v3.0.1
 	gorm.io/gorm v1.21.10
 )
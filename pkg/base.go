@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"sort"
 	"time"
 )
 
@@ -14,26 +15,18 @@ const (
 	inactive = 1
 )
 
-type Status int
-
-var statusMapping map[string]int
-var statusReverseMapping map[int]string
+// ErrMissingOption is returned by constructors when a required option was not given.
+var ErrMissingOption = errors.New("missing required option")
 
-func init() {
-	statusMapping = make(map[string]int)
-	statusReverseMapping = make(map[int]string)
-	statusMapping["active"] = active
-	statusMapping["inactive"] = inactive
-	statusReverseMapping[active] = "active"
-	statusReverseMapping[inactive] = "inactive"
-}
+type Status int
 
-func GetStatusInt(status string) int {
-	return statusMapping[status]
+// GetStatusInt resolves a status name through the lifecycle of domainName.
+func GetStatusInt(domainName DomainName, status string) (int, error) {
+	return GetStatusLifecycle(domainName).StatusInt(status)
 }
 
-func GetStatusStr(status int) string {
-	return statusReverseMapping[status]
+func GetStatusStr(domainName DomainName, status int) (string, error) {
+	return GetStatusLifecycle(domainName).StatusStr(status)
 }
 
 type EntityCreator func() Base
@@ -52,6 +45,17 @@ func (d *DomainFactory) GetMapping(domainName DomainName) EntityCreator {
 	return d.entityMappings[domainName]
 }
 
+func (d *DomainFactory) DomainNames() []DomainName {
+	var domainNames []DomainName
+	for domainName := range d.entityMappings {
+		domainNames = append(domainNames, domainName)
+	}
+	sort.Slice(domainNames, func(i, j int) bool {
+		return domainNames[i] < domainNames[j]
+	})
+	return domainNames
+}
+
 func NewDomainFactory() *DomainFactory {
 	return &DomainFactory{entityMappings: make(map[DomainName]EntityCreator)}
 }
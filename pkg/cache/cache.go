@@ -1,17 +1,20 @@
 package cache
 
 import (
+	"context"
 	"github.com/kutty-kumar/charminder/pkg"
 	"time"
 )
 
 type Cache interface {
-	Put(base pkg.Base) error
-	Get(externalId string) (pkg.Base, error)
-	MultiGet(externalIds []string) ([]pkg.Base, error)
-	Delete(externalId string) error
-	MultiDelete(externalIds []string) error
-	PutWithTtl(base pkg.Base, duration time.Duration) error
-	DeleteAll() error
-	Health() error
+	Put(ctx context.Context, base pkg.Base) error
+	Get(ctx context.Context, externalId string) (pkg.Base, error)
+	MultiGet(ctx context.Context, externalIds []string) ([]pkg.Base, error)
+	Delete(ctx context.Context, externalId string) error
+	MultiDelete(ctx context.Context, externalIds []string) error
+	PutWithTtl(ctx context.Context, base pkg.Base, duration time.Duration) error
+	DeleteAll(ctx context.Context) error
+	Health(ctx context.Context) error
+	Start(ctx context.Context) error
+	Stop(ctx context.Context) error
 }
@@ -1,40 +1,104 @@
 package cache
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"github.com/go-redis/redis"
 	"github.com/kutty-kumar/charminder/pkg"
-	"github.com/sirupsen/logrus"
+	"github.com/kutty-kumar/charminder/pkg/logging"
+	"github.com/kutty-kumar/charminder/pkg/metrics"
+	"github.com/kutty-kumar/charminder/pkg/tracing"
+	"go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
 	"time"
 )
 
 type RedisCache struct {
 	*redis.Client
-	logger        *logrus.Logger
+	logger        logging.Logger
 	entityCreator pkg.EntityCreator
+	metrics       *metrics.CacheMetrics
+	tracer        trace.Tracer
 }
 
-func (r *RedisCache) Put(base pkg.Base) error {
-	cmd := r.Client.Set(base.GetExternalId(), base, 0)
+type RedisCacheOption func(r *RedisCache)
+
+// WithMetrics records operation latencies, hits, misses and error kinds.
+func WithMetrics(m *metrics.CacheMetrics) RedisCacheOption {
+	return func(r *RedisCache) {
+		r.metrics = m
+	}
+}
+
+func WithTracerProvider(provider trace.TracerProvider) RedisCacheOption {
+	return func(r *RedisCache) {
+		r.tracer = tracing.Tracer(provider)
+	}
+}
+
+func (r *RedisCache) instrument(ctx context.Context, operation string) (context.Context, func(err *error)) {
+	domain := string(r.entityCreator().GetName())
+	ctx, span := r.tracer.Start(ctx, "redis."+operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemRedis, semconv.DBOperationKey.String(operation), tracing.DomainKey.String(domain)))
+	start := time.Now()
+	return ctx, func(err *error) {
+		// redis.Nil is a miss, counted separately, not a failure
+		failure := *err
+		if failure == redis.Nil {
+			failure = nil
+		}
+		if r.metrics != nil {
+			errKind := ""
+			if failure != nil {
+				errKind = metrics.ErrorKind(failure)
+			}
+			r.metrics.Observe(domain, operation, time.Since(start), errKind)
+		}
+		tracing.End(span, failure)
+	}
+}
+
+func (r *RedisCache) countLookup(err error) {
+	if r.metrics == nil {
+		return
+	}
+	if err == redis.Nil {
+		r.metrics.Miss(string(r.entityCreator().GetName()))
+	} else if err == nil {
+		r.metrics.Hit(string(r.entityCreator().GetName()))
+	}
+}
+
+func (r *RedisCache) Put(ctx context.Context, base pkg.Base) (err error) {
+	ctx, done := r.instrument(ctx, "put")
+	defer done(&err)
+	cmd := r.Client.WithContext(ctx).Set(base.GetExternalId(), base, 0)
 	return cmd.Err()
 }
 
-func (r *RedisCache) Get(externalId string) (pkg.Base, error) {
-	cmd := r.Client.Get(externalId)
+func (r *RedisCache) Get(ctx context.Context, externalId string) (result pkg.Base, err error) {
+	ctx, done := r.instrument(ctx, "get")
+	defer done(&err)
+	cmd := r.Client.WithContext(ctx).Get(externalId)
+	r.countLookup(cmd.Err())
 	if cmd.Err() != nil {
 		return nil, cmd.Err()
 	}
 	entity := r.entityCreator()
-	err := cmd.Scan(&entity)
-	if err != nil {
+	if err := cmd.Scan(&entity); err != nil {
 		return nil, err
 	}
 	return entity, nil
 }
 
-func (r *RedisCache) MultiGet(externalIds []string) ([]pkg.Base, error) {
+func (r *RedisCache) MultiGet(ctx context.Context, externalIds []string) (results []pkg.Base, err error) {
+	ctx, done := r.instrument(ctx, "multi_get")
+	defer done(&err)
 	var result []pkg.Base
 	for _, externalId := range externalIds {
-		base, err := r.Get(externalId)
+		base, err := r.Get(ctx, externalId)
 		if err != nil {
 			return nil, err
 		}
@@ -43,57 +107,85 @@ func (r *RedisCache) MultiGet(externalIds []string) ([]pkg.Base, error) {
 	return result, nil
 }
 
-func (r *RedisCache) Delete(externalId string) error {
-	statusCmd := r.Client.Del(externalId)
+func (r *RedisCache) Delete(ctx context.Context, externalId string) (err error) {
+	ctx, done := r.instrument(ctx, "delete")
+	defer done(&err)
+	statusCmd := r.Client.WithContext(ctx).Del(externalId)
 	if statusCmd.Err() != nil {
 		return statusCmd.Err()
 	}
 	return nil
 }
 
-func (r *RedisCache) MultiDelete(externalIds []string) error {
-	statusCmd := r.Client.Del(externalIds...)
+func (r *RedisCache) MultiDelete(ctx context.Context, externalIds []string) (err error) {
+	ctx, done := r.instrument(ctx, "multi_delete")
+	defer done(&err)
+	statusCmd := r.Client.WithContext(ctx).Del(externalIds...)
 	if statusCmd.Err() != nil {
 		return statusCmd.Err()
 	}
 	return nil
 }
 
-func (r *RedisCache) PutWithTtl(base pkg.Base, duration time.Duration) error {
-	statusCmd := r.Client.Set(base.GetExternalId(), base, duration)
+func (r *RedisCache) PutWithTtl(ctx context.Context, base pkg.Base, duration time.Duration) (err error) {
+	ctx, done := r.instrument(ctx, "put_with_ttl")
+	defer done(&err)
+	statusCmd := r.Client.WithContext(ctx).Set(base.GetExternalId(), base, duration)
 	if statusCmd.Err() != nil {
 		return statusCmd.Err()
 	}
 	return nil
 }
 
-func (r *RedisCache) DeleteAll() error {
-	cmd := r.Client.FlushDB()
+func (r *RedisCache) DeleteAll(ctx context.Context) (err error) {
+	ctx, done := r.instrument(ctx, "delete_all")
+	defer done(&err)
+	cmd := r.Client.WithContext(ctx).FlushDB()
 	if cmd.Err() != nil {
 		return cmd.Err()
 	}
 	return nil
 }
 
-func (r *RedisCache) Health() error {
-	pong, err := r.Client.Ping().Result()
+func (r *RedisCache) Health(ctx context.Context) error {
+	pong, err := r.Client.WithContext(ctx).Ping().Result()
 	if err != nil {
 		return err
 	}
-	r.logger.Infof("Health check ping response <%v>", pong)
+	r.logger.Debug(ctx, "redis health check", logging.F("response", pong))
 	return nil
 }
 
-func NewRedisCache(addr string, password string, pkg uint, logger *logrus.Logger, entityCreator pkg.EntityCreator) Cache {
+// Start fails fast when redis is unreachable.
+func (r *RedisCache) Start(ctx context.Context) error {
+	return r.Health(ctx)
+}
+
+func (r *RedisCache) Stop(ctx context.Context) error {
+	return r.Client.Close()
+}
+
+func NewRedisCache(addr string, password string, db uint, logger logging.Logger, entityCreator pkg.EntityCreator, opts ...RedisCacheOption) (Cache, error) {
+	if addr == "" {
+		return nil, errors.New("redis cache: address is required")
+	}
+	if entityCreator == nil {
+		return nil, fmt.Errorf("redis cache: %w, the entity creator is nil", pkg.ErrMissingOption)
+	}
 	client := redis.NewClient(
 		&redis.Options{
 			Addr:     addr,
 			Password: password,
-			DB:       int(pkg),
+			DB:       int(db),
 		})
-	return &RedisCache{
-		client,
-		logger,
-		entityCreator,
+	cache := &RedisCache{
+		Client:        client,
+		logger:        logging.OrNop(logger),
+		entityCreator: entityCreator,
+		tracer:        tracing.Tracer(nil),
+	}
+	for _, opt := range opts {
+		opt(cache)
 	}
+	return cache, nil
 }
@@ -0,0 +1,425 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/elastic/go-elasticsearch/v7"
+	"github.com/kutty-kumar/charminder/pkg"
+	"github.com/kutty-kumar/charminder/pkg/cache"
+	"github.com/kutty-kumar/charminder/pkg/db"
+	"github.com/kutty-kumar/charminder/pkg/event"
+	"github.com/kutty-kumar/charminder/pkg/lifecycle"
+	"github.com/kutty-kumar/charminder/pkg/logging"
+	"github.com/kutty-kumar/charminder/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var ErrNotConfigured = errors.New("component is not configured")
+
+// Dialector opens a GORM dialect, e.g. mysql.Open, so the library does not depend on any SQL driver.
+type Dialector func(dsn string) gorm.Dialector
+
+// Builder constructs components from a Config, sharing the database connection, the Elasticsearch client
+// and the metric collectors between every component it builds.
+type Builder struct {
+	config         *Config
+	dialectors     map[string]Dialector
+	gormConfig     *gorm.Config
+	logger         logging.Logger
+	tracerProvider trace.TracerProvider
+	registerer     prometheus.Registerer
+
+	mu                sync.Mutex
+	db                *gorm.DB
+	esClient          *elasticsearch.Client
+	esTransport       *http.Transport
+	caches            []cache.Cache
+	publishers        []*event.KafkaPublisher
+	consumers         []*event.KafkaConsumer
+	repositoryMetrics *metrics.RepositoryMetrics
+	cacheMetrics      *metrics.CacheMetrics
+	kafkaMetrics      *metrics.KafkaMetrics
+}
+
+type BuilderOption func(b *Builder)
+
+func WithDialector(dialect string, dialector Dialector) BuilderOption {
+	return func(b *Builder) {
+		b.dialectors[dialect] = dialector
+	}
+}
+
+func WithGormConfig(gormConfig *gorm.Config) BuilderOption {
+	return func(b *Builder) {
+		b.gormConfig = gormConfig
+	}
+}
+
+func WithLogger(logger logging.Logger) BuilderOption {
+	return func(b *Builder) {
+		b.logger = logging.OrNop(logger)
+	}
+}
+
+func WithTracerProvider(provider trace.TracerProvider) BuilderOption {
+	return func(b *Builder) {
+		b.tracerProvider = provider
+	}
+}
+
+// WithRegisterer enables metrics on every component, registered on registerer.
+func WithRegisterer(registerer prometheus.Registerer) BuilderOption {
+	return func(b *Builder) {
+		b.registerer = registerer
+	}
+}
+
+func NewBuilder(config *Config, opts ...BuilderOption) *Builder {
+	b := &Builder{
+		config:     config,
+		dialectors: make(map[string]Dialector),
+		gormConfig: &gorm.Config{},
+		logger:     logging.Nop(),
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b
+}
+
+func (b *Builder) Config() *Config {
+	return b.config
+}
+
+// DB opens the database on first use and applies the pool settings.
+func (b *Builder) DB() (*gorm.DB, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.db != nil {
+		return b.db, nil
+	}
+	dbConfig := b.config.Database
+	if dbConfig == nil {
+		return nil, fmt.Errorf("database: %w", ErrNotConfigured)
+	}
+	dialector, ok := b.dialectors[dbConfig.Dialect]
+	if !ok {
+		return nil, fmt.Errorf("database: no dialector registered for %v, use WithDialector", dbConfig.Dialect)
+	}
+	gormDb, err := gorm.Open(dialector(dbConfig.DSN.Value()), b.gormConfig)
+	if err != nil {
+		return nil, fmt.Errorf("database: %w", err)
+	}
+	sqlDb, err := gormDb.DB()
+	if err != nil {
+		return nil, fmt.Errorf("database: %w", err)
+	}
+	sqlDb.SetMaxOpenConns(dbConfig.MaxOpenConns)
+	sqlDb.SetMaxIdleConns(dbConfig.MaxIdleConns)
+	sqlDb.SetConnMaxLifetime(dbConfig.ConnMaxLifetime.Duration())
+	b.db = gormDb
+	return gormDb, nil
+}
+
+func (b *Builder) metricsEnabled() bool {
+	return b.registerer != nil
+}
+
+func (b *Builder) repoMetrics() (*metrics.RepositoryMetrics, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.repositoryMetrics == nil {
+		m, err := metrics.NewRepositoryMetrics(b.registerer)
+		if err != nil {
+			return nil, err
+		}
+		b.repositoryMetrics = m
+	}
+	return b.repositoryMetrics, nil
+}
+
+func (b *Builder) GORMRepository(creator pkg.EntityCreator, setter pkg.ExternalIdSetter, opts ...db.GORMRepositoryOption) (*db.GORMRepository, error) {
+	gormDb, err := b.DB()
+	if err != nil {
+		return nil, err
+	}
+	repoOpts := []db.GORMRepositoryOption{
+		db.WithDb(gormDb),
+		db.WithCreator(creator),
+		db.WithExternalIdSetter(setter),
+		db.WithLogger(b.logger),
+		db.WithTracerProvider(b.tracerProvider),
+	}
+	if b.metricsEnabled() {
+		m, err := b.repoMetrics()
+		if err != nil {
+			return nil, err
+		}
+		repoOpts = append(repoOpts, db.WithMetrics(m))
+	}
+	return db.NewGORMRepository(append(repoOpts, opts...)...)
+}
+
+func (b *Builder) ElasticsearchClient() (*elasticsearch.Client, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.esClient != nil {
+		return b.esClient, nil
+	}
+	esConfig := b.config.Elasticsearch
+	if esConfig == nil {
+		return nil, fmt.Errorf("elasticsearch: %w", ErrNotConfigured)
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	client, err := elasticsearch.NewClient(elasticsearch.Config{
+		Addresses: esConfig.Addresses,
+		Username:  esConfig.Username,
+		Password:  esConfig.Password.Value(),
+		Transport: transport,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("elasticsearch: %w", err)
+	}
+	b.esClient = client
+	b.esTransport = transport
+	return client, nil
+}
+
+// ElasticsearchRepository still needs the entity options, such as WithEntityConverter, from the caller.
+func (b *Builder) ElasticsearchRepository(index string, opts ...db.ElasticsearchRepoOption) (db.BaseNoSQLRepo, error) {
+	client, err := b.ElasticsearchClient()
+	if err != nil {
+		return nil, err
+	}
+	repoOpts := []db.ElasticsearchRepoOption{
+		db.WithClient(client),
+		db.WithIndex(index),
+		db.WithESLogger(b.logger),
+		db.WithESTracerProvider(b.tracerProvider),
+	}
+	if b.metricsEnabled() {
+		m, err := b.repoMetrics()
+		if err != nil {
+			return nil, err
+		}
+		repoOpts = append(repoOpts, db.WithESMetrics(m))
+	}
+	return db.NewElasticsearchRepo(append(repoOpts, opts...)...)
+}
+
+func (b *Builder) Cache(creator pkg.EntityCreator, opts ...cache.RedisCacheOption) (cache.Cache, error) {
+	redisConfig := b.config.Redis
+	if redisConfig == nil {
+		return nil, fmt.Errorf("redis: %w", ErrNotConfigured)
+	}
+	cacheOpts := []cache.RedisCacheOption{cache.WithTracerProvider(b.tracerProvider)}
+	if b.metricsEnabled() {
+		b.mu.Lock()
+		if b.cacheMetrics == nil {
+			m, err := metrics.NewCacheMetrics(b.registerer)
+			if err != nil {
+				b.mu.Unlock()
+				return nil, err
+			}
+			b.cacheMetrics = m
+		}
+		cacheOpts = append(cacheOpts, cache.WithMetrics(b.cacheMetrics))
+		b.mu.Unlock()
+	}
+	redisCache, err := cache.NewRedisCache(redisConfig.Addr, redisConfig.Password.Value(), uint(redisConfig.DB), b.logger, creator, append(cacheOpts, opts...)...)
+	if err != nil {
+		return nil, err
+	}
+	b.mu.Lock()
+	b.caches = append(b.caches, redisCache)
+	b.mu.Unlock()
+	return redisCache, nil
+}
+
+func (b *Builder) kafkaMetricsOrNil() (*metrics.KafkaMetrics, error) {
+	if !b.metricsEnabled() {
+		return nil, nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.kafkaMetrics == nil {
+		m, err := metrics.NewKafkaMetrics(b.registerer)
+		if err != nil {
+			return nil, err
+		}
+		b.kafkaMetrics = m
+	}
+	return b.kafkaMetrics, nil
+}
+
+// commonKafkaConfig holds the librdkafka settings shared by producers and consumers.
+func (k *KafkaConfig) commonKafkaConfig() map[string]string {
+	config := map[string]string{
+		"bootstrap.servers": strings.Join(k.Brokers, ","),
+		"security.protocol": k.SecurityProtocol,
+	}
+	if k.ClientId != "" {
+		config["client.id"] = k.ClientId
+	}
+	if k.SASL != nil {
+		config["sasl.mechanisms"] = k.SASL.Mechanism
+		config["sasl.username"] = k.SASL.Username
+		config["sasl.password"] = k.SASL.Password.Value()
+	}
+	return config
+}
+
+func (k *KafkaConfig) ProducerConfigMap() map[string]string {
+	config := k.commonKafkaConfig()
+	config["acks"] = k.Producer.Acks
+	config["compression.type"] = k.Producer.Compression
+	config["linger.ms"] = strconv.Itoa(k.Producer.LingerMs)
+	for key, value := range k.Extra {
+		config[key] = value
+	}
+	return config
+}
+
+// ConsumerConfigMap enables the events channel and application rebalancing, which KafkaConsumer.Consume relies on.
+func (k *KafkaConfig) ConsumerConfigMap() map[string]interface{} {
+	config := make(map[string]interface{})
+	for key, value := range k.commonKafkaConfig() {
+		config[key] = value
+	}
+	config["group.id"] = k.Consumer.GroupId
+	config["auto.offset.reset"] = k.Consumer.AutoOffsetReset
+	config["enable.auto.commit"] = !k.Consumer.DisableAutoCommit
+	config["go.events.channel.enable"] = true
+	config["go.application.rebalance.enable"] = true
+	for key, value := range k.Extra {
+		config[key] = value
+	}
+	return config
+}
+
+func (b *Builder) Publisher(opts ...event.KafkaPublisherOption) (*event.KafkaPublisher, error) {
+	kafkaConfig := b.config.Kafka
+	if kafkaConfig == nil {
+		return nil, fmt.Errorf("kafka: %w", ErrNotConfigured)
+	}
+	publisherOpts := []event.KafkaPublisherOption{
+		event.WithPublisherLogger(b.logger),
+		event.WithPublisherTracerProvider(b.tracerProvider),
+	}
+	m, err := b.kafkaMetricsOrNil()
+	if err != nil {
+		return nil, err
+	}
+	if m != nil {
+		publisherOpts = append(publisherOpts, event.WithPublisherMetrics(m))
+	}
+	publisher, err := event.NewKafkaProducer(kafkaConfig.Producer.Topics, kafkaConfig.ProducerConfigMap(), append(publisherOpts, opts...)...)
+	if err != nil {
+		return nil, err
+	}
+	b.mu.Lock()
+	b.publishers = append(b.publishers, publisher)
+	b.mu.Unlock()
+	return publisher, nil
+}
+
+// Consumer still needs event.WithEventConstructor from the caller.
+func (b *Builder) Consumer(consumerMapping map[string]event.EventConsumer, opts ...event.KafkaConsumerOption) (*event.KafkaConsumer, error) {
+	kafkaConfig := b.config.Kafka
+	if kafkaConfig == nil {
+		return nil, fmt.Errorf("kafka: %w", ErrNotConfigured)
+	}
+	if kafkaConfig.Consumer.GroupId == "" || len(kafkaConfig.Consumer.Topics) == 0 {
+		return nil, fmt.Errorf("kafka consumer: %w, group_id and topics are required", ErrNotConfigured)
+	}
+	consumerOpts := []event.KafkaConsumerOption{
+		event.WithConsumerLogger(b.logger),
+		event.WithConsumerTracerProvider(b.tracerProvider),
+	}
+	m, err := b.kafkaMetricsOrNil()
+	if err != nil {
+		return nil, err
+	}
+	if m != nil {
+		consumerOpts = append(consumerOpts, event.WithConsumerMetrics(m))
+	}
+	consumer, err := event.NewKafkaConsumer(kafkaConfig.Consumer.Topics, kafkaConfig.ConsumerConfigMap(), consumerMapping, append(consumerOpts, opts...)...)
+	if err != nil {
+		return nil, err
+	}
+	b.mu.Lock()
+	b.consumers = append(b.consumers, consumer)
+	b.mu.Unlock()
+	return consumer, nil
+}
+
+// Register adds every component built so far to m, so call it once everything is built. Stores start first,
+// then publishers, then consumers; shutdown runs the other way round: consumers drain, publishers flush and
+// the database, Elasticsearch and Redis connections close last.
+func (b *Builder) Register(m *lifecycle.Manager) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var stores []string
+	if b.db != nil {
+		sqlDb, err := b.db.DB()
+		if err != nil {
+			return fmt.Errorf("database: %w", err)
+		}
+		if err := m.Append("database", sqlDb.PingContext, func(ctx context.Context) error {
+			return sqlDb.Close()
+		}); err != nil {
+			return err
+		}
+		stores = append(stores, "database")
+	}
+	if b.esClient != nil {
+		client, transport := b.esClient, b.esTransport
+		start := func(ctx context.Context) error {
+			response, err := client.Ping(client.Ping.WithContext(ctx))
+			if err != nil {
+				return err
+			}
+			defer response.Body.Close()
+			if response.IsError() {
+				return fmt.Errorf("elasticsearch ping: %v", response.Status())
+			}
+			return nil
+		}
+		stop := func(ctx context.Context) error {
+			transport.CloseIdleConnections()
+			return nil
+		}
+		if err := m.Append("elasticsearch", start, stop); err != nil {
+			return err
+		}
+		stores = append(stores, "elasticsearch")
+	}
+	for i, redisCache := range b.caches {
+		name := fmt.Sprintf("redis-%d", i)
+		if err := m.Add(name, redisCache); err != nil {
+			return err
+		}
+		stores = append(stores, name)
+	}
+	var publishers []string
+	for i, publisher := range b.publishers {
+		name := fmt.Sprintf("kafka-publisher-%d", i)
+		if err := m.Add(name, publisher, stores...); err != nil {
+			return err
+		}
+		publishers = append(publishers, name)
+	}
+	for i, consumer := range b.consumers {
+		if err := m.Add(fmt.Sprintf("kafka-consumer-%d", i), consumer, append(append([]string{}, stores...), publishers...)...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,198 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/kutty-kumar/charminder/pkg/logging"
+	"github.com/kutty-kumar/charminder/pkg/validation"
+	"gopkg.in/yaml.v3"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config describes every infrastructure component; a nil section means the component is not used.
+type Config struct {
+	Database      *DatabaseConfig      `json:"database,omitempty" yaml:"database,omitempty"`
+	Elasticsearch *ElasticsearchConfig `json:"elasticsearch,omitempty" yaml:"elasticsearch,omitempty"`
+	Redis         *RedisConfig         `json:"redis,omitempty" yaml:"redis,omitempty"`
+	Kafka         *KafkaConfig         `json:"kafka,omitempty" yaml:"kafka,omitempty"`
+}
+
+type DatabaseConfig struct {
+	Dialect         string   `json:"dialect" yaml:"dialect" env:"DATABASE_DIALECT" validate:"required"`
+	DSN             Secret   `json:"dsn" yaml:"dsn" env:"DATABASE_DSN" validate:"required"`
+	MaxOpenConns    int      `json:"max_open_conns" yaml:"max_open_conns" env:"DATABASE_MAX_OPEN_CONNS" default:"10" validate:"min=1"`
+	MaxIdleConns    int      `json:"max_idle_conns" yaml:"max_idle_conns" env:"DATABASE_MAX_IDLE_CONNS" default:"2" validate:"min=0,ltefield=MaxOpenConns"`
+	ConnMaxLifetime Duration `json:"conn_max_lifetime" yaml:"conn_max_lifetime" env:"DATABASE_CONN_MAX_LIFETIME" default:"30m"`
+}
+
+type ElasticsearchConfig struct {
+	Addresses []string `json:"addresses" yaml:"addresses" env:"ELASTICSEARCH_ADDRESSES" default:"http://localhost:9200" validate:"required"`
+	Username  string   `json:"username,omitempty" yaml:"username,omitempty" env:"ELASTICSEARCH_USERNAME"`
+	Password  Secret   `json:"password,omitempty" yaml:"password,omitempty" env:"ELASTICSEARCH_PASSWORD"`
+}
+
+type RedisConfig struct {
+	Addr     string `json:"addr" yaml:"addr" env:"REDIS_ADDR" default:"localhost:6379" validate:"required"`
+	Password Secret `json:"password,omitempty" yaml:"password,omitempty" env:"REDIS_PASSWORD"`
+	DB       int    `json:"db" yaml:"db" env:"REDIS_DB" validate:"min=0,max=15"`
+}
+
+type KafkaConfig struct {
+	Brokers          []string       `json:"brokers" yaml:"brokers" env:"KAFKA_BROKERS" validate:"required"`
+	ClientId         string         `json:"client_id,omitempty" yaml:"client_id,omitempty" env:"KAFKA_CLIENT_ID"`
+	SecurityProtocol string         `json:"security_protocol" yaml:"security_protocol" env:"KAFKA_SECURITY_PROTOCOL" default:"plaintext" validate:"enum=plaintext|ssl|sasl_plaintext|sasl_ssl"`
+	SASL             *SASLConfig    `json:"sasl,omitempty" yaml:"sasl,omitempty"`
+	Producer         ProducerConfig `json:"producer" yaml:"producer"`
+	Consumer         ConsumerConfig `json:"consumer" yaml:"consumer"`
+	// Extra is passed to librdkafka as is, after the typed settings, for options this model does not cover.
+	Extra Properties `json:"extra,omitempty" yaml:"extra,omitempty" env:"KAFKA_EXTRA"`
+}
+
+type SASLConfig struct {
+	Mechanism string `json:"mechanism" yaml:"mechanism" env:"KAFKA_SASL_MECHANISM" default:"PLAIN" validate:"enum=PLAIN|SCRAM-SHA-256|SCRAM-SHA-512"`
+	Username  string `json:"username" yaml:"username" env:"KAFKA_SASL_USERNAME" validate:"required"`
+	Password  Secret `json:"password" yaml:"password" env:"KAFKA_SASL_PASSWORD" validate:"required"`
+}
+
+type ProducerConfig struct {
+	Acks        string   `json:"acks" yaml:"acks" env:"KAFKA_PRODUCER_ACKS" default:"all" validate:"enum=0|1|all"`
+	Compression string   `json:"compression" yaml:"compression" env:"KAFKA_PRODUCER_COMPRESSION" default:"none" validate:"enum=none|gzip|snappy|lz4|zstd"`
+	LingerMs    int      `json:"linger_ms" yaml:"linger_ms" env:"KAFKA_PRODUCER_LINGER_MS" default:"5" validate:"min=0"`
+	Topics      []string `json:"topics,omitempty" yaml:"topics,omitempty" env:"KAFKA_PRODUCER_TOPICS"`
+}
+
+type ConsumerConfig struct {
+	GroupId           string   `json:"group_id,omitempty" yaml:"group_id,omitempty" env:"KAFKA_CONSUMER_GROUP_ID"`
+	Topics            []string `json:"topics,omitempty" yaml:"topics,omitempty" env:"KAFKA_CONSUMER_TOPICS"`
+	AutoOffsetReset   string   `json:"auto_offset_reset" yaml:"auto_offset_reset" env:"KAFKA_CONSUMER_AUTO_OFFSET_RESET" default:"earliest" validate:"enum=earliest|latest"`
+	DisableAutoCommit bool     `json:"disable_auto_commit,omitempty" yaml:"disable_auto_commit,omitempty" env:"KAFKA_CONSUMER_DISABLE_AUTO_COMMIT"`
+}
+
+// Validate covers the rules spanning several fields that the validate tags cannot express.
+func (c *Config) Validate() error {
+	vErr := &validation.Error{}
+	if c.Kafka != nil {
+		if len(c.Kafka.Consumer.Topics) > 0 && c.Kafka.Consumer.GroupId == "" {
+			vErr.Add("kafka.consumer.group_id", validation.CodeRequired, "is required when consumer topics are set")
+		}
+		if strings.HasPrefix(c.Kafka.SecurityProtocol, "sasl") && c.Kafka.SASL == nil {
+			vErr.Add("kafka.sasl", validation.CodeRequired, fmt.Sprintf("is required with security protocol %v", c.Kafka.SecurityProtocol))
+		}
+	}
+	if vErr.HasErrors() {
+		return vErr
+	}
+	return nil
+}
+
+// String prints the configuration as YAML with every secret redacted, so it is safe to log.
+func (c *Config) String() string {
+	out, err := yaml.Marshal(c)
+	if err != nil {
+		return fmt.Sprintf("invalid config: %v", err)
+	}
+	return string(out)
+}
+
+// Secret holds credentials. It never prints or marshals its value, use Value to read it.
+type Secret string
+
+func (s Secret) Value() string {
+	return string(s)
+}
+
+func (s Secret) String() string {
+	if s == "" {
+		return ""
+	}
+	return logging.Redacted
+}
+
+func (s Secret) GoString() string {
+	return strconv.Quote(s.String())
+}
+
+func (s Secret) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+func (s Secret) MarshalYAML() (interface{}, error) {
+	return s.String(), nil
+}
+
+// Duration reads Go duration strings such as "30s" or "5m".
+type Duration time.Duration
+
+func (d Duration) Duration() time.Duration {
+	return time.Duration(d)
+}
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+func (d *Duration) parse(raw string) error {
+	parsed, err := time.ParseDuration(raw)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var raw string
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return fmt.Errorf("duration must be a string such as \"30s\": %w", err)
+	}
+	return d.parse(raw)
+}
+
+func (d Duration) MarshalYAML() (interface{}, error) {
+	return d.String(), nil
+}
+
+func (d *Duration) UnmarshalYAML(value *yaml.Node) error {
+	return d.parse(value.Value)
+}
+
+// Properties are free form key value settings; values under sensitive keys are redacted when marshalled.
+type Properties map[string]string
+
+func (p Properties) redacted() map[string]interface{} {
+	out := make(map[string]interface{}, len(p))
+	for key, value := range p {
+		out[key] = logging.DefaultRedactor(key, value)
+	}
+	return out
+}
+
+func (p Properties) MarshalJSON() ([]byte, error) {
+	return json.Marshal(p.redacted())
+}
+
+func (p Properties) MarshalYAML() (interface{}, error) {
+	return p.redacted(), nil
+}
+
+func (p *Properties) parse(raw string) error {
+	properties := make(Properties)
+	for _, pair := range strings.Split(raw, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		idx := strings.Index(pair, "=")
+		if idx <= 0 {
+			return fmt.Errorf("expected key=value, got %q", pair)
+		}
+		properties[strings.TrimSpace(pair[:idx])] = strings.TrimSpace(pair[idx+1:])
+	}
+	*p = properties
+	return nil
+}
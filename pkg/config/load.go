@@ -0,0 +1,273 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/kutty-kumar/charminder/pkg/validation"
+	"gopkg.in/yaml.v3"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+type Format string
+
+const (
+	YAML Format = "yaml"
+	JSON Format = "json"
+)
+
+var (
+	durationType   = reflect.TypeOf(Duration(0))
+	propertiesType = reflect.TypeOf(Properties{})
+)
+
+type loader struct {
+	envPrefix string
+	lookupEnv func(key string) (string, bool)
+	// set holds the paths of the fields the file or the environment set, e.g. "Database.MaxIdleConns", so that
+	// an explicit zero is not mistaken for a missing value and replaced by the default.
+	set map[string]bool
+}
+
+type LoadOption func(l *loader)
+
+// WithEnvPrefix prefixes every variable name, e.g. with "APP_" DATABASE_DSN is read from APP_DATABASE_DSN.
+func WithEnvPrefix(prefix string) LoadOption {
+	return func(l *loader) {
+		l.envPrefix = prefix
+	}
+}
+
+// WithLookupEnv replaces os.LookupEnv as the source of overrides.
+func WithLookupEnv(lookupEnv func(key string) (string, bool)) LoadOption {
+	return func(l *loader) {
+		l.lookupEnv = lookupEnv
+	}
+}
+
+func newLoader(opts []LoadOption) *loader {
+	l := &loader{lookupEnv: os.LookupEnv, set: make(map[string]bool)}
+	for _, opt := range opts {
+		opt(l)
+	}
+	return l
+}
+
+// Load reads a YAML or JSON file, chosen by extension, then applies environment overrides and defaults and
+// validates the result. An empty path configures from the environment alone.
+func Load(path string, opts ...LoadOption) (*Config, error) {
+	if path == "" {
+		return Parse(nil, YAML, opts...)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading config %v: %w", path, err)
+	}
+	format := YAML
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		format = JSON
+	}
+	config, err := Parse(data, format, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("loading config %v: %w", path, err)
+	}
+	return config, nil
+}
+
+func Parse(data []byte, format Format, opts ...LoadOption) (*Config, error) {
+	config := &Config{}
+	l := newLoader(opts)
+	if len(bytes.TrimSpace(data)) > 0 {
+		if err := decode(data, format, config); err != nil {
+			return nil, err
+		}
+		if err := l.markDecoded(data, format); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := l.applyEnv(reflect.ValueOf(config).Elem(), ""); err != nil {
+		return nil, err
+	}
+	if err := l.applyDefaults(reflect.ValueOf(config).Elem(), ""); err != nil {
+		return nil, err
+	}
+	if err := validation.Validate(config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+func decode(data []byte, format Format, config *Config) error {
+	switch format {
+	case JSON:
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		return decoder.Decode(config)
+	case YAML:
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		return decoder.Decode(config)
+	}
+	return fmt.Errorf("unsupported config format %v", format)
+}
+
+// markDecoded records the fields present in data, whatever their value.
+func (l *loader) markDecoded(data []byte, format Format) error {
+	var keys map[string]interface{}
+	var err error
+	switch format {
+	case JSON:
+		err = json.Unmarshal(data, &keys)
+	case YAML:
+		err = yaml.Unmarshal(data, &keys)
+	}
+	if err != nil {
+		return err
+	}
+	l.mark(reflect.TypeOf(Config{}), keys, string(format), "")
+	return nil
+}
+
+func (l *loader) mark(t reflect.Type, keys map[string]interface{}, tag, prefix string) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		value, ok := keys[strings.Split(field.Tag.Get(tag), ",")[0]]
+		if !ok {
+			continue
+		}
+		l.set[prefix+field.Name] = true
+		if section, ok := value.(map[string]interface{}); ok && isSection(field.Type) {
+			sectionType := field.Type
+			if sectionType.Kind() == reflect.Ptr {
+				sectionType = sectionType.Elem()
+			}
+			l.mark(sectionType, section, tag, prefix+field.Name+".")
+		}
+	}
+}
+
+func isSection(t reflect.Type) bool {
+	return t.Kind() == reflect.Struct || (t.Kind() == reflect.Ptr && t.Elem().Kind() == reflect.Struct)
+}
+
+// applyEnv sets every field carrying an env tag whose variable is present. Nil sections are only allocated
+// when at least one of their variables is set, so the environment alone can enable a component.
+func (l *loader) applyEnv(v reflect.Value, prefix string) (bool, error) {
+	applied := false
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		target := v.Field(i)
+		path := prefix + field.Name
+		if name := field.Tag.Get("env"); name != "" {
+			raw, ok := l.lookupEnv(l.envPrefix + name)
+			if !ok {
+				continue
+			}
+			if err := setFromString(target, raw); err != nil {
+				return applied, fmt.Errorf("environment variable %v: %w", l.envPrefix+name, err)
+			}
+			l.set[path] = true
+			applied = true
+			continue
+		}
+		if !isSection(field.Type) {
+			continue
+		}
+		if field.Type.Kind() == reflect.Struct {
+			ok, err := l.applyEnv(target, path+".")
+			if err != nil {
+				return applied, err
+			}
+			applied = applied || ok
+			continue
+		}
+		section := target
+		if target.IsNil() {
+			section = reflect.New(field.Type.Elem())
+		}
+		ok, err := l.applyEnv(section.Elem(), path+".")
+		if err != nil {
+			return applied, err
+		}
+		if ok && target.IsNil() {
+			target.Set(section)
+		}
+		applied = applied || ok
+	}
+	return applied, nil
+}
+
+// applyDefaults fills the fields neither the file nor the environment set from their default tag, skipping
+// sections that are not configured.
+func (l *loader) applyDefaults(v reflect.Value, prefix string) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		target := v.Field(i)
+		path := prefix + field.Name
+		if raw, ok := field.Tag.Lookup("default"); ok {
+			if !l.set[path] && target.IsZero() {
+				if err := setFromString(target, raw); err != nil {
+					return fmt.Errorf("default of %v: %w", field.Name, err)
+				}
+			}
+			continue
+		}
+		switch {
+		case field.Type.Kind() == reflect.Struct:
+			if err := l.applyDefaults(target, path+"."); err != nil {
+				return err
+			}
+		case isSection(field.Type) && !target.IsNil():
+			if err := l.applyDefaults(target.Elem(), path+"."); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func setFromString(target reflect.Value, raw string) error {
+	switch target.Type() {
+	case durationType:
+		return target.Addr().Interface().(*Duration).parse(raw)
+	case propertiesType:
+		return target.Addr().Interface().(*Properties).parse(raw)
+	}
+	switch target.Kind() {
+	case reflect.String:
+		target.SetString(raw)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		parsed, err := strconv.ParseInt(strings.TrimSpace(raw), 10, 64)
+		if err != nil {
+			return err
+		}
+		target.SetInt(parsed)
+	case reflect.Bool:
+		parsed, err := strconv.ParseBool(strings.TrimSpace(raw))
+		if err != nil {
+			return err
+		}
+		target.SetBool(parsed)
+	case reflect.Slice:
+		if target.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported slice type %v", target.Type())
+		}
+		var values []string
+		for _, value := range strings.Split(raw, ",") {
+			if value = strings.TrimSpace(value); value != "" {
+				values = append(values, value)
+			}
+		}
+		target.Set(reflect.ValueOf(values))
+	default:
+		return fmt.Errorf("unsupported type %v", target.Type())
+	}
+	return nil
+}
@@ -0,0 +1,193 @@
+package config
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kutty-kumar/charminder/pkg/logging"
+	"github.com/kutty-kumar/charminder/pkg/validation"
+)
+
+func env(values map[string]string) LoadOption {
+	return WithLookupEnv(func(key string) (string, bool) {
+		value, ok := values[key]
+		return value, ok
+	})
+}
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name     string
+		file     string
+		contents string
+	}{
+		{name: "yaml", file: "config.yaml", contents: "database:\n  dialect: mysql\n  dsn: user:pass@/db\n  max_idle_conns: 0\n  conn_max_lifetime: 5m\n"},
+		{name: "json", file: "config.json", contents: `{"database":{"dialect":"mysql","dsn":"user:pass@/db","max_idle_conns":0,"conn_max_lifetime":"5m"}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tt.file)
+			if err := ioutil.WriteFile(path, []byte(tt.contents), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			config, err := Load(path, env(nil))
+			if err != nil {
+				t.Fatal(err)
+			}
+			database := config.Database
+			if database.Dialect != "mysql" || database.DSN.Value() != "user:pass@/db" {
+				t.Errorf("database %+v, want the file values", database)
+			}
+			if database.MaxOpenConns != 10 || database.MaxIdleConns != 0 || database.ConnMaxLifetime.Duration() != 5*time.Minute {
+				t.Errorf("database %+v, want the default open conns and the explicit zero idle conns", database)
+			}
+			if config.Redis != nil || config.Kafka != nil || config.Elasticsearch != nil {
+				t.Errorf("config %+v configures sections the file does not", config)
+			}
+		})
+	}
+}
+
+func TestLoadRejectsUnknownKeys(t *testing.T) {
+	for _, format := range []Format{YAML, JSON} {
+		data := "database:\n  dialect: mysql\n  dsn: a\n  pool: 3\n"
+		if format == JSON {
+			data = `{"database":{"dialect":"mysql","dsn":"a","pool":3}}`
+		}
+		if _, err := Parse([]byte(data), format, env(nil)); err == nil {
+			t.Errorf("Parse(%v) accepted an unknown key", format)
+		}
+	}
+}
+
+func TestParseAppliesEnvironmentOverrides(t *testing.T) {
+	tests := []struct {
+		name  string
+		data  string
+		env   map[string]string
+		check func(t *testing.T, config *Config)
+	}{
+		{
+			name: "override",
+			data: "redis:\n  addr: cache:6379\n  db: 1\n",
+			env:  map[string]string{"APP_REDIS_DB": "3", "APP_REDIS_PASSWORD": "hunter2"},
+			check: func(t *testing.T, config *Config) {
+				if config.Redis.Addr != "cache:6379" || config.Redis.DB != 3 || config.Redis.Password.Value() != "hunter2" {
+					t.Errorf("redis %+v, want the file address and the environment db and password", config.Redis)
+				}
+			},
+		},
+		{
+			name: "environment alone enables a section",
+			env:  map[string]string{"APP_KAFKA_BROKERS": "a:9092, b:9092", "APP_KAFKA_PRODUCER_LINGER_MS": "0", "APP_KAFKA_EXTRA": "socket.timeout.ms=100"},
+			check: func(t *testing.T, config *Config) {
+				kafka := config.Kafka
+				if kafka == nil || strings.Join(kafka.Brokers, ",") != "a:9092,b:9092" {
+					t.Fatalf("kafka %+v, want the brokers of the environment", kafka)
+				}
+				if kafka.Producer.LingerMs != 0 || kafka.Producer.Acks != "all" || kafka.SecurityProtocol != "plaintext" {
+					t.Errorf("producer %+v, want the explicit zero linger and the default acks", kafka.Producer)
+				}
+				if kafka.Extra["socket.timeout.ms"] != "100" {
+					t.Errorf("extra %v, want the environment properties", kafka.Extra)
+				}
+			},
+		},
+		{
+			name: "unset variables keep the defaults",
+			env:  map[string]string{"APP_ELASTICSEARCH_USERNAME": "elastic"},
+			check: func(t *testing.T, config *Config) {
+				if config.Elasticsearch == nil || strings.Join(config.Elasticsearch.Addresses, ",") != "http://localhost:9200" {
+					t.Errorf("elasticsearch %+v, want the default address", config.Elasticsearch)
+				}
+				if config.Database != nil {
+					t.Errorf("database %+v, want none", config.Database)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := Parse([]byte(tt.data), YAML, WithEnvPrefix("APP_"), env(tt.env))
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.check(t, config)
+		})
+	}
+}
+
+func TestParseValidates(t *testing.T) {
+	tests := []struct {
+		name  string
+		data  string
+		env   map[string]string
+		field string
+	}{
+		{name: "required", data: "database:\n  dialect: mysql\n", field: "dsn"},
+		{name: "cross field", data: "database:\n  dialect: mysql\n  dsn: a\n  max_open_conns: 1\n  max_idle_conns: 2\n", field: "max_idle_conns"},
+		{name: "enum", data: "kafka:\n  brokers: [a]\n  producer:\n    acks: some\n", field: "kafka.producer.acks"},
+		{name: "config rule", data: "kafka:\n  brokers: [a]\n  consumer:\n    topics: [orders]\n", field: "kafka.consumer.group_id"},
+		{name: "malformed variable", env: map[string]string{"REDIS_DB": "one"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse([]byte(tt.data), YAML, env(tt.env))
+			if err == nil {
+				t.Fatal("Parse() succeeded")
+			}
+			if tt.field == "" {
+				return
+			}
+			vErr, ok := validation.AsError(err)
+			if !ok {
+				t.Fatalf("Parse() = %v, want a validation error", err)
+			}
+			for _, fieldErr := range vErr.Errors {
+				if strings.HasSuffix(fieldErr.Field, tt.field) {
+					return
+				}
+			}
+			t.Fatalf("errors %+v, want one on %v", vErr.Errors, tt.field)
+		})
+	}
+}
+
+func TestConfigRedactsSecrets(t *testing.T) {
+	config, err := Parse([]byte(`
+database:
+  dialect: mysql
+  dsn: user:hunter2@/db
+kafka:
+  brokers: [a]
+  security_protocol: sasl_ssl
+  sasl:
+    username: app
+    password: hunter2
+  extra:
+    sasl.oauthbearer.token: hunter2
+    socket.timeout.ms: "100"
+`), YAML, env(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	asJson, err := json.Marshal(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, out := range map[string]string{"String": config.String(), "json": string(asJson), "GoString": config.Database.DSN.GoString()} {
+		if strings.Contains(out, "hunter2") {
+			t.Errorf("%v leaks a secret: %v", name, out)
+		}
+		if !strings.Contains(out, logging.Redacted) {
+			t.Errorf("%v does not redact: %v", name, out)
+		}
+	}
+	if !strings.Contains(config.String(), "socket.timeout.ms") || config.Kafka.SASL.Password.Value() != "hunter2" {
+		t.Errorf("redaction hides more than the secrets: %v", config)
+	}
+}
@@ -4,12 +4,30 @@ import (
 	"context"
 	_ "github.com/elastic/go-elasticsearch/v7/esapi"
 	"github.com/kutty-kumar/charminder/pkg"
+	"time"
 )
 
 type BaseNoSQLRepo interface {
 	BaseRepository
+	Replace(ctx context.Context, externalId string, replacement pkg.Base) (error, pkg.Base)
 	ExactSearch(ctx context.Context, key string, value interface{}) (error, []pkg.Base)
 	RangeSearch(ctx context.Context, key string, start, end interface{}) (error, []pkg.Base)
 	TextSearch(ctx context.Context, value string) (error, []pkg.Base)
+	BulkCreate(ctx context.Context, bases []pkg.Base) (error, []BulkItemResult)
 	IndexMappings(ctx context.Context) error
+	DiffMapping(ctx context.Context) (error, *MappingDiff)
+	Reindex(ctx context.Context, opts ...ReindexOption) (error, *ReindexResult)
+	Analyze(ctx context.Context, analyzer, field, text string) (error, []AnalyzeToken)
+	TextSearchResult(ctx context.Context, value string, opts ...TextSearchOption) (error, *SearchResult)
+	Query(ctx context.Context, source *SearchSource) (error, *SearchResult)
+	FacetedSearch(ctx context.Context, source *SearchSource, selected map[string][]interface{}) (error, *FacetedResult)
+	NearSearch(ctx context.Context, field string, origin pkg.GeoPoint, distance string, limit int) (error, *SearchResult)
+	Suggest(ctx context.Context, prefix string, limit int, filters map[string]interface{}, opts ...SuggestOption) (error, []Suggestion)
+	Delete(ctx context.Context, externalId string) error
+	DeleteByQuery(ctx context.Context, query Query, opts ...ByQueryOption) (error, *ByQueryResult)
+	UpdateByQuery(ctx context.Context, query Query, script *Script, opts ...ByQueryOption) (error, *ByQueryResult)
+	Task(ctx context.Context, taskId string) (error, *TaskStatus)
+	WaitForTask(ctx context.Context, taskId string, interval time.Duration) (error, *TaskStatus)
+	CancelTask(ctx context.Context, taskId string) error
+	Health(ctx context.Context) error
 }
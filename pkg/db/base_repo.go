@@ -2,7 +2,16 @@ package db
 
 import (
 	"context"
+	"errors"
 	"github.com/kutty-kumar/charminder/pkg"
+	"github.com/kutty-kumar/charminder/pkg/query"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrNotFound     = errors.New("not found")
+	ErrNotSupported = errors.New("operation not supported by repository")
+	ErrInvalidPatch = errors.New("invalid patch")
 )
 
 type BaseRepository interface {
@@ -15,12 +24,32 @@ type BaseRepository interface {
 	GetDb() interface{}
 }
 
+type Deleter interface {
+	Delete(ctx context.Context, externalId string) error
+}
+
+// Replacer stores an entity as given, zero values included, where Update merges the non-zero fields into the
+// stored entity.
+type Replacer interface {
+	Replace(ctx context.Context, externalId string, replacement pkg.Base) (error, pkg.Base)
+}
+
+type FilterSearcher interface {
+	Find(ctx context.Context, filter *query.Filter) (error, []pkg.Base)
+}
+
+func IsNotFound(err error) bool {
+	return errors.Is(err, ErrNotFound) || errors.Is(err, gorm.ErrRecordNotFound)
+}
+
 type BaseDao struct {
 	BaseRepository
 }
 
-func NewBaseGORMDao(opts ...GORMRepositoryOption) BaseDao {
-	return BaseDao{
-		NewGORMRepository(opts...),
+func NewBaseGORMDao(opts ...GORMRepositoryOption) (BaseDao, error) {
+	repo, err := NewGORMRepository(opts...)
+	if err != nil {
+		return BaseDao{}, err
 	}
+	return BaseDao{repo}, nil
 }
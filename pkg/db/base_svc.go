@@ -2,7 +2,21 @@ package db
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/kutty-kumar/charminder/pkg"
+	"github.com/kutty-kumar/charminder/pkg/query"
+	"github.com/kutty-kumar/charminder/pkg/util/jsonpatch"
+	"github.com/kutty-kumar/charminder/pkg/validation"
+	"reflect"
+)
+
+type PatchFormat string
+
+const (
+	MergePatch PatchFormat = "application/merge-patch+json"
+	JSONPatch  PatchFormat = "application/json-patch+json"
 )
 
 type BaseSvc struct {
@@ -26,13 +40,128 @@ func (b *BaseSvc) MultiGetByExternalId(ctx context.Context, ids []string) (error
 }
 
 func (b *BaseSvc) Create(ctx context.Context, base pkg.Base) (error, pkg.Base) {
+	if err := validation.Validate(base); err != nil {
+		return err, nil
+	}
 	return b.Persistence.Create(ctx, base)
 }
 
 func (b *BaseSvc) Update(ctx context.Context, id string, base pkg.Base) (error, pkg.Base) {
+	err, current := b.Persistence.GetByExternalId(ctx, id)
+	if err != nil {
+		return err, nil
+	}
+	currentStatus := current.GetStatus()
+	current.Merge(base)
+	if err := b.check(ctx, currentStatus, current); err != nil {
+		return err, nil
+	}
 	return b.Persistence.Update(ctx, id, base)
 }
 
+// check validates merged, the stored entity with the changes applied, so rules such as required hold for the
+// entity as it will be stored rather than for the fields the caller sent. The status transition is checked on
+// merged too, a payload without a status keeps the current one.
+func (b *BaseSvc) check(ctx context.Context, currentStatus pkg.Status, merged pkg.Base) error {
+	if err := validation.Validate(merged); err != nil {
+		return err
+	}
+	return pkg.GetStatusLifecycle(merged.GetName()).Transition(ctx, merged, currentStatus, merged.GetStatus())
+}
+
+func applyPatch(doc, patchDoc []byte, format PatchFormat) ([]byte, error) {
+	switch format {
+	case MergePatch:
+		return jsonpatch.MergePatch(doc, patchDoc)
+	case JSONPatch:
+		return jsonpatch.ApplyPatch(doc, patchDoc)
+	}
+	return nil, fmt.Errorf("unsupported patch format %v", format)
+}
+
+// DecodeDto unmarshals raw into a fresh value of the same type as dto, preserving whether dto was a pointer.
+func DecodeDto(dto interface{}, raw []byte) (interface{}, error) {
+	if dto == nil {
+		return nil, errors.New("cannot decode into a nil dto")
+	}
+	dtoType := reflect.TypeOf(dto)
+	if dtoType.Kind() == reflect.Ptr {
+		target := reflect.New(dtoType.Elem())
+		if err := json.Unmarshal(raw, target.Interface()); err != nil {
+			return nil, err
+		}
+		return target.Interface(), nil
+	}
+	target := reflect.New(dtoType)
+	if err := json.Unmarshal(raw, target.Interface()); err != nil {
+		return nil, err
+	}
+	return target.Elem().Interface(), nil
+}
+
+// Patch applies patchDoc to the DTO of the stored entity and stores the validated result through the Replacer
+// of the repository, so a patch can clear fields and set them to zero.
+func (b *BaseSvc) Patch(ctx context.Context, id string, patchDoc []byte, format PatchFormat) (error, pkg.Base) {
+	replacer, ok := b.Persistence.(Replacer)
+	if !ok {
+		return ErrNotSupported, nil
+	}
+	err, current := b.Persistence.GetByExternalId(ctx, id)
+	if err != nil {
+		return err, nil
+	}
+	currentStatus := current.GetStatus()
+	dto := current.ToDto()
+	if dto == nil {
+		return fmt.Errorf("%v has no dto to patch", current.GetName()), nil
+	}
+	original, err := json.Marshal(dto)
+	if err != nil {
+		return err, nil
+	}
+	patched, err := applyPatch(original, patchDoc, format)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidPatch, err), nil
+	}
+	patchedDto, err := DecodeDto(dto, patched)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidPatch, err), nil
+	}
+	patchedEntity := current.FillProperties(patchedDto)
+	if err := b.check(ctx, currentStatus, patchedEntity); err != nil {
+		return err, nil
+	}
+	return replacer.Replace(ctx, id, patchedEntity)
+}
+
+func (b *BaseSvc) NextStatuses(ctx context.Context, id string) (error, []string) {
+	err, current := b.Persistence.GetByExternalId(ctx, id)
+	if err != nil {
+		return err, nil
+	}
+	return nil, pkg.GetStatusLifecycle(current.GetName()).NextStatuses(current.GetStatus())
+}
+
+func (b *BaseSvc) Search(ctx context.Context, params map[string]string) (error, []pkg.Base) {
+	return b.Persistence.Search(ctx, params)
+}
+
+func (b *BaseSvc) Find(ctx context.Context, filter *query.Filter) (error, []pkg.Base) {
+	searcher, ok := b.Persistence.(FilterSearcher)
+	if !ok {
+		return ErrNotSupported, nil
+	}
+	return searcher.Find(ctx, filter)
+}
+
+func (b *BaseSvc) Delete(ctx context.Context, id string) error {
+	deleter, ok := b.Persistence.(Deleter)
+	if !ok {
+		return ErrNotSupported
+	}
+	return deleter.Delete(ctx, id)
+}
+
 func (b *BaseSvc) GetPersistence() BaseRepository {
 	return b.Persistence
 }
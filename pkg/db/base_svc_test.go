@@ -0,0 +1,233 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/kutty-kumar/charminder/pkg"
+	"github.com/kutty-kumar/charminder/pkg/validation"
+)
+
+// memoryRepository keeps copies of the entities, so a service cannot change what is stored without an Update.
+type memoryRepository struct {
+	entities map[string][]byte
+}
+
+func newMemoryRepository(entities ...*testEntity) *memoryRepository {
+	repo := &memoryRepository{entities: make(map[string][]byte)}
+	for _, entity := range entities {
+		repo.entities[entity.ExternalId], _ = json.Marshal(entity)
+	}
+	return repo
+}
+
+func (m *memoryRepository) load(externalId string) (*testEntity, error) {
+	stored, ok := m.entities[externalId]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	entity := &testEntity{}
+	return entity, json.Unmarshal(stored, entity)
+}
+
+func (m *memoryRepository) GetById(ctx context.Context, id uint64) (error, pkg.Base) {
+	return ErrNotSupported, nil
+}
+
+func (m *memoryRepository) GetByExternalId(ctx context.Context, externalId string) (error, pkg.Base) {
+	entity, err := m.load(externalId)
+	if err != nil {
+		return err, nil
+	}
+	return nil, entity
+}
+
+func (m *memoryRepository) MultiGetByExternalId(ctx context.Context, externalIds []string) (error, []pkg.Base) {
+	return ErrNotSupported, nil
+}
+
+func (m *memoryRepository) Create(ctx context.Context, base pkg.Base) (error, pkg.Base) {
+	m.entities[base.GetExternalId()], _ = json.Marshal(base)
+	return nil, base
+}
+
+func (m *memoryRepository) Update(ctx context.Context, externalId string, updatedBase pkg.Base) (error, pkg.Base) {
+	entity, err := m.load(externalId)
+	if err != nil {
+		return err, nil
+	}
+	entity.Merge(updatedBase)
+	m.entities[externalId], _ = json.Marshal(entity)
+	return nil, entity
+}
+
+func (m *memoryRepository) Search(ctx context.Context, params map[string]string) (error, []pkg.Base) {
+	return ErrNotSupported, nil
+}
+
+func (m *memoryRepository) GetDb() interface{} {
+	return nil
+}
+
+func TestUpdateValidatesTheMergedEntity(t *testing.T) {
+	tests := []struct {
+		name    string
+		stored  *testEntity
+		payload *testEntity
+		wantErr bool
+		want    testEntity
+	}{
+		{
+			name:    "partial update without the required name",
+			stored:  &testEntity{BaseDomain: pkg.BaseDomain{ExternalId: "a"}, Name: "lamp", Price: 10},
+			payload: &testEntity{Price: 12},
+			want:    testEntity{Name: "lamp", Price: 12},
+		},
+		{
+			name:    "stored entity missing the required name",
+			stored:  &testEntity{BaseDomain: pkg.BaseDomain{ExternalId: "a"}, Price: 10},
+			payload: &testEntity{Price: 12},
+			wantErr: true,
+			want:    testEntity{Price: 10},
+		},
+		{
+			name:    "update setting the required name",
+			stored:  &testEntity{BaseDomain: pkg.BaseDomain{ExternalId: "a"}, Price: 10},
+			payload: &testEntity{Name: "lamp"},
+			want:    testEntity{Name: "lamp", Price: 10},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := newMemoryRepository(tt.stored)
+			svc := NewBaseSvc(repo)
+			err, _ := svc.Update(context.Background(), "a", tt.payload)
+			if tt.wantErr {
+				if _, ok := validation.AsError(err); !ok {
+					t.Fatalf("Update() error = %v, want a validation error", err)
+				}
+			} else if err != nil {
+				t.Fatalf("Update() error = %v", err)
+			}
+			stored, _ := repo.load("a")
+			if stored.Name != tt.want.Name || stored.Price != tt.want.Price {
+				t.Fatalf("stored %v/%v, want %v/%v", stored.Name, stored.Price, tt.want.Name, tt.want.Price)
+			}
+		})
+	}
+}
+
+func TestUpdateChecksTheTransitionOfTheMergedStatus(t *testing.T) {
+	lifecycle := pkg.NewStatusLifecycle().AddStatus("draft", 1).AddStatus("published", 2).AddStatus("archived", 3)
+	_ = lifecycle.AddTransition("draft", "published")
+	_ = lifecycle.AddTransition("published", "archived")
+	previous := pkg.GetStatusLifecycle("test_entities")
+	pkg.RegisterStatusLifecycle("test_entities", lifecycle)
+	defer pkg.RegisterStatusLifecycle("test_entities", previous)
+
+	tests := []struct {
+		name       string
+		payload    *testEntity
+		wantErr    error
+		wantStatus int
+	}{
+		{name: "payload without a status", payload: &testEntity{Price: 12}, wantStatus: 2},
+		{name: "allowed transition", payload: &testEntity{BaseDomain: pkg.BaseDomain{Status: 3}}, wantStatus: 3},
+		{name: "disallowed transition", payload: &testEntity{BaseDomain: pkg.BaseDomain{Status: 1}}, wantErr: pkg.ErrInvalidTransition, wantStatus: 2},
+		{name: "unknown status", payload: &testEntity{BaseDomain: pkg.BaseDomain{Status: 9}}, wantErr: pkg.ErrUnknownStatus, wantStatus: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := newMemoryRepository(&testEntity{BaseDomain: pkg.BaseDomain{ExternalId: "a", Status: 2}, Name: "lamp"})
+			svc := NewBaseSvc(repo)
+			err, _ := svc.Update(context.Background(), "a", tt.payload)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Update() error = %v, want %v", err, tt.wantErr)
+			}
+			if stored, _ := repo.load("a"); stored.Status != tt.wantStatus {
+				t.Fatalf("stored status %v, want %v", stored.Status, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func (m *memoryRepository) Replace(ctx context.Context, externalId string, replacement pkg.Base) (error, pkg.Base) {
+	if _, err := m.load(externalId); err != nil {
+		return err, nil
+	}
+	m.entities[externalId], _ = json.Marshal(replacement)
+	return nil, replacement
+}
+
+func TestPatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  PatchFormat
+		patch   string
+		want    testEntity
+		wantErr error
+	}{
+		{name: "merge patch setting zero", format: MergePatch, patch: `{"price":0}`, want: testEntity{Name: "lamp", Tags: []string{"a"}}},
+		{name: "merge patch removing a member", format: MergePatch, patch: `{"tags":null}`, want: testEntity{Name: "lamp", Price: 10}},
+		{name: "json patch replacing with zero", format: JSONPatch, patch: `[{"op":"replace","path":"/price","value":0}]`, want: testEntity{Name: "lamp", Tags: []string{"a"}}},
+		{name: "json patch adding a tag", format: JSONPatch, patch: `[{"op":"add","path":"/tags/-","value":"b"}]`, want: testEntity{Name: "lamp", Price: 10, Tags: []string{"a", "b"}}},
+		{name: "json patch without a value", format: JSONPatch, patch: `[{"op":"replace","path":"/price"}]`, wantErr: ErrInvalidPatch},
+		{name: "merge patch clearing a required field", format: MergePatch, patch: `{"name":""}`, wantErr: &validation.Error{}},
+		{name: "unknown format", format: "text/plain", patch: `{}`, wantErr: ErrInvalidPatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stored := &testEntity{BaseDomain: pkg.BaseDomain{ExternalId: "a"}, Name: "lamp", Price: 10, Tags: []string{"a"}}
+			repo := newMemoryRepository(stored)
+			svc := NewBaseSvc(repo)
+			err, _ := svc.Patch(context.Background(), "a", []byte(tt.patch), tt.format)
+			if tt.wantErr != nil {
+				if _, isValidation := tt.wantErr.(*validation.Error); isValidation {
+					if _, ok := validation.AsError(err); !ok {
+						t.Fatalf("Patch() error = %v, want a validation error", err)
+					}
+				} else if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Patch() error = %v, want %v", err, tt.wantErr)
+				}
+				if got, _ := repo.load("a"); got.Price != 10 || got.Name != "lamp" {
+					t.Fatalf("a failed patch changed the entity to %+v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Patch() error = %v", err)
+			}
+			got, _ := repo.load("a")
+			if got.Name != tt.want.Name || got.Price != tt.want.Price || !reflect.DeepEqual(got.Tags, tt.want.Tags) {
+				t.Fatalf("stored %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeDto(t *testing.T) {
+	tests := []struct {
+		name    string
+		dto     interface{}
+		want    interface{}
+		wantErr bool
+	}{
+		{name: "pointer", dto: &testEntity{Name: "old"}, want: &testEntity{Name: "new"}},
+		{name: "value", dto: testEntity{Name: "old"}, want: testEntity{Name: "new"}},
+		{name: "nil", dto: nil, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DecodeDto(tt.dto, []byte(`{"name":"new"}`))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DecodeDto() error = %v, want error %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("DecodeDto() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+
+	"github.com/kutty-kumar/charminder/pkg"
+)
+
+// testEntity is the entity the repository tests store.
+type testEntity struct {
+	pkg.BaseDomain
+	Name  string   `json:"name" validate:"required"`
+	Price float64  `json:"price"`
+	Tags  []string `json:"tags" gorm:"-"`
+	Sku   string   `json:"sku" es:"keyword"`
+	// Caption is stored in a column named unlike the field.
+	Caption string `json:"caption" gorm:"column:title"`
+}
+
+func newTestEntity() pkg.Base {
+	return &testEntity{}
+}
+
+func setTestExternalId(externalId string, base pkg.Base) pkg.Base {
+	base.(*testEntity).ExternalId = externalId
+	return base
+}
+
+func (e *testEntity) GetName() pkg.DomainName {
+	return "test_entities"
+}
+
+func (e *testEntity) ToDto() interface{} {
+	return e
+}
+
+func (e *testEntity) FillProperties(dto interface{}) pkg.Base {
+	*e = *dto.(*testEntity)
+	return e
+}
+
+func (e *testEntity) Merge(other interface{}) {
+	o := other.(*testEntity)
+	if o.Name != "" {
+		e.Name = o.Name
+	}
+	if o.Price != 0 {
+		e.Price = o.Price
+	}
+	if o.Status != 0 {
+		e.Status = o.Status
+	}
+}
+
+func (e *testEntity) FromSqlRow(rows *sql.Rows) (pkg.Base, error) {
+	return e, nil
+}
+
+func (e *testEntity) ToJson() (string, error) {
+	out, err := json.Marshal(e)
+	return string(out), err
+}
+
+func (e *testEntity) MarshalBinary() ([]byte, error) {
+	return json.Marshal(e)
+}
+
+func (e *testEntity) UnmarshalBinary(buffer []byte) error {
+	return json.Unmarshal(buffer, e)
+}
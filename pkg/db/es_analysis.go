@@ -0,0 +1,543 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/elastic/go-elasticsearch/v7"
+	"github.com/elastic/go-elasticsearch/v7/esapi"
+	"github.com/kutty-kumar/charminder/pkg/validation"
+	"sort"
+	"strings"
+)
+
+type Tokenizer string
+
+type CharFilter string
+
+type TokenFilter string
+
+var TokenizerMapping = struct {
+	Standard    Tokenizer
+	Letter      Tokenizer
+	Lowercase   Tokenizer
+	Whitespace  Tokenizer
+	Classic     Tokenizer
+	Punctuation Tokenizer
+	Keyword     Tokenizer
+	NGram       Tokenizer
+	EdgeNGram   Tokenizer
+	Pattern     Tokenizer
+}{
+	Standard:    "standard",
+	Letter:      "letter",
+	Lowercase:   "lowercase",
+	Whitespace:  "whitespace",
+	Classic:     "classic",
+	Punctuation: "punctuation",
+	Keyword:     "keyword",
+	NGram:       "ngram",
+	EdgeNGram:   "edge_ngram",
+	Pattern:     "pattern",
+}
+
+var CharFilterMapping = struct {
+	HTMLStrip      CharFilter
+	Mapping        CharFilter
+	PatternReplace CharFilter
+	// Deprecated: use PatternReplace.
+	PatterReplace CharFilter
+}{
+	HTMLStrip:      "html_strip",
+	Mapping:        "mapping",
+	PatternReplace: "pattern_replace",
+	PatterReplace:  "pattern_replace",
+}
+
+var TokenFilterMapping = struct {
+	Lowercase    TokenFilter
+	StopWords    TokenFilter
+	AsciiFolding TokenFilter
+	Stop         TokenFilter
+	Synonym      TokenFilter
+	Stemmer      TokenFilter
+	Shingle      TokenFilter
+}{
+	Lowercase:    "lowercase",
+	StopWords:    "english_stop",
+	AsciiFolding: "asciifolding",
+	Stop:         "stop",
+	Synonym:      "synonym",
+	Stemmer:      "stemmer",
+	Shingle:      "shingle",
+}
+
+var (
+	builtinTokenizers = names("standard", "letter", "lowercase", "whitespace", "classic", "keyword", "ngram", "edge_ngram",
+		"pattern", "simple_pattern", "simple_pattern_split", "char_group", "uax_url_email", "path_hierarchy", "thai")
+	builtinCharFilters  = names("html_strip", "mapping", "pattern_replace")
+	builtinTokenFilters = names("lowercase", "uppercase", "asciifolding", "stop", "stemmer", "porter_stem", "kstem",
+		"snowball", "synonym", "synonym_graph", "shingle", "trim", "unique", "remove_duplicates", "word_delimiter",
+		"word_delimiter_graph", "ngram", "edge_ngram", "length", "reverse", "elision", "apostrophe", "classic",
+		"decimal_digit", "keyword_marker", "truncate", "cjk_width", "cjk_bigram", "german_normalization")
+	builtinAnalyzers = names("standard", "simple", "whitespace", "stop", "keyword", "pattern", "fingerprint", "arabic",
+		"brazilian", "cjk", "dutch", "english", "french", "german", "hindi", "italian", "portuguese", "russian",
+		"spanish", "swedish", "turkish")
+	builtinNormalizers = names("lowercase")
+)
+
+func names(values ...string) map[string]bool {
+	set := make(map[string]bool, len(values))
+	for _, value := range values {
+		set[value] = true
+	}
+	return set
+}
+
+// Analyzer is a custom analyzer when Type is empty or "custom"; any other Type names a built-in analyzer,
+// e.g. "english", that is configured through the remaining fields.
+type Analyzer struct {
+	Type       string   `json:"type,omitempty"`
+	Tokenizer  string   `json:"tokenizer,omitempty"`
+	CharFilter []string `json:"char_filter,omitempty"`
+	Filter     []string `json:"filter,omitempty"`
+	Stopwords  []string `json:"stopwords,omitempty"`
+}
+
+// Normalizer is an analyzer without tokenizer, applied to keyword fields.
+type Normalizer struct {
+	Type       string   `json:"type,omitempty"`
+	CharFilter []string `json:"char_filter,omitempty"`
+	Filter     []string `json:"filter,omitempty"`
+}
+
+type CharFilterDefinition struct {
+	Type        CharFilter `json:"type"`
+	Mappings    []string   `json:"mappings,omitempty"`
+	Pattern     string     `json:"pattern,omitempty"`
+	Replacement string     `json:"replacement,omitempty"`
+	EscapedTags []string   `json:"escaped_tags,omitempty"`
+}
+
+type TokenizerDefinition struct {
+	Type       Tokenizer `json:"type"`
+	MinGram    int       `json:"min_gram,omitempty"`
+	MaxGram    int       `json:"max_gram,omitempty"`
+	TokenChars []string  `json:"token_chars,omitempty"`
+	Pattern    string    `json:"pattern,omitempty"`
+	Flags      string    `json:"flags,omitempty"`
+	Group      *int      `json:"group,omitempty"`
+}
+
+type TokenFilterDefinition struct {
+	Type             TokenFilter `json:"type"`
+	Stopwords        []string    `json:"stopwords,omitempty"`
+	IgnoreCase       bool        `json:"ignore_case,omitempty"`
+	Synonyms         []string    `json:"synonyms,omitempty"`
+	SynonymsPath     string      `json:"synonyms_path,omitempty"`
+	Language         string      `json:"language,omitempty"`
+	MinShingleSize   int         `json:"min_shingle_size,omitempty"`
+	MaxShingleSize   int         `json:"max_shingle_size,omitempty"`
+	OutputUnigrams   *bool       `json:"output_unigrams,omitempty"`
+	PreserveOriginal bool        `json:"preserve_original,omitempty"`
+}
+
+type Analysis struct {
+	Analyzer   map[string]Analyzer              `json:"analyzer,omitempty"`
+	Normalizer map[string]Normalizer            `json:"normalizer,omitempty"`
+	CharFilter map[string]CharFilterDefinition  `json:"char_filter,omitempty"`
+	Tokenizer  map[string]TokenizerDefinition   `json:"tokenizer,omitempty"`
+	Filter     map[string]TokenFilterDefinition `json:"filter,omitempty"`
+}
+
+type Settings struct {
+	Analysis Analysis `json:"analysis"`
+}
+
+func MappingCharFilter(mappings ...string) CharFilterDefinition {
+	return CharFilterDefinition{Type: CharFilterMapping.Mapping, Mappings: mappings}
+}
+
+func PatternReplaceCharFilter(pattern, replacement string) CharFilterDefinition {
+	return CharFilterDefinition{Type: CharFilterMapping.PatternReplace, Pattern: pattern, Replacement: replacement}
+}
+
+func HTMLStripCharFilter(escapedTags ...string) CharFilterDefinition {
+	return CharFilterDefinition{Type: CharFilterMapping.HTMLStrip, EscapedTags: escapedTags}
+}
+
+// NGramTokenizer keeps the token chars classes given, e.g. letter and digit; none keeps everything.
+func NGramTokenizer(minGram, maxGram int, tokenChars ...string) TokenizerDefinition {
+	return TokenizerDefinition{Type: TokenizerMapping.NGram, MinGram: minGram, MaxGram: maxGram, TokenChars: tokenChars}
+}
+
+func EdgeNGramTokenizer(minGram, maxGram int, tokenChars ...string) TokenizerDefinition {
+	return TokenizerDefinition{Type: TokenizerMapping.EdgeNGram, MinGram: minGram, MaxGram: maxGram, TokenChars: tokenChars}
+}
+
+// PatternTokenizer splits on pattern, a Java regular expression.
+func PatternTokenizer(pattern string) TokenizerDefinition {
+	return TokenizerDefinition{Type: TokenizerMapping.Pattern, Pattern: pattern}
+}
+
+// SynonymFilter takes rules in Solr format, e.g. "tv, television" or "i-pod => ipod".
+func SynonymFilter(synonyms ...string) TokenFilterDefinition {
+	return TokenFilterDefinition{Type: TokenFilterMapping.Synonym, Synonyms: synonyms}
+}
+
+func StemmerFilter(language string) TokenFilterDefinition {
+	return TokenFilterDefinition{Type: TokenFilterMapping.Stemmer, Language: language}
+}
+
+func ShingleFilter(minSize, maxSize int, outputUnigrams bool) TokenFilterDefinition {
+	return TokenFilterDefinition{Type: TokenFilterMapping.Shingle, MinShingleSize: minSize, MaxShingleSize: maxSize, OutputUnigrams: &outputUnigrams}
+}
+
+// StopFilter takes the words themselves or a predefined list such as "_english_".
+func StopFilter(stopwords ...string) TokenFilterDefinition {
+	return TokenFilterDefinition{Type: TokenFilterMapping.Stop, Stopwords: stopwords}
+}
+
+func AsciiFoldingFilter(preserveOriginal bool) TokenFilterDefinition {
+	return TokenFilterDefinition{Type: TokenFilterMapping.AsciiFolding, PreserveOriginal: preserveOriginal}
+}
+
+// AnalysisBuilder collects analysis components; Build checks that every name an analyzer or normalizer refers to
+// is either defined here or built into Elasticsearch.
+type AnalysisBuilder struct {
+	analysis Analysis
+}
+
+func NewAnalysisBuilder() *AnalysisBuilder {
+	return &AnalysisBuilder{analysis: Analysis{
+		Analyzer:   make(map[string]Analyzer),
+		Normalizer: make(map[string]Normalizer),
+		CharFilter: make(map[string]CharFilterDefinition),
+		Tokenizer:  make(map[string]TokenizerDefinition),
+		Filter:     make(map[string]TokenFilterDefinition),
+	}}
+}
+
+func (b *AnalysisBuilder) Analyzer(name string, analyzer Analyzer) *AnalysisBuilder {
+	b.analysis.Analyzer[name] = analyzer
+	return b
+}
+
+// CustomAnalyzer defines an analyzer made of tokenizer and filters, in order.
+func (b *AnalysisBuilder) CustomAnalyzer(name, tokenizer string, charFilters []string, filters ...string) *AnalysisBuilder {
+	return b.Analyzer(name, Analyzer{Type: "custom", Tokenizer: tokenizer, CharFilter: charFilters, Filter: filters})
+}
+
+func (b *AnalysisBuilder) Normalizer(name string, charFilters []string, filters ...string) *AnalysisBuilder {
+	b.analysis.Normalizer[name] = Normalizer{Type: "custom", CharFilter: charFilters, Filter: filters}
+	return b
+}
+
+func (b *AnalysisBuilder) CharFilter(name string, definition CharFilterDefinition) *AnalysisBuilder {
+	b.analysis.CharFilter[name] = definition
+	return b
+}
+
+func (b *AnalysisBuilder) Tokenizer(name string, definition TokenizerDefinition) *AnalysisBuilder {
+	b.analysis.Tokenizer[name] = definition
+	return b
+}
+
+func (b *AnalysisBuilder) TokenFilter(name string, definition TokenFilterDefinition) *AnalysisBuilder {
+	b.analysis.Filter[name] = definition
+	return b
+}
+
+func (b *AnalysisBuilder) Build() (Settings, error) {
+	settings := Settings{Analysis: b.analysis}
+	if err := settings.Validate(); err != nil {
+		return Settings{}, err
+	}
+	return settings, nil
+}
+
+func sortedKeys(m interface{}) []string {
+	var keys []string
+	switch typed := m.(type) {
+	case map[string]Analyzer:
+		for key := range typed {
+			keys = append(keys, key)
+		}
+	case map[string]Normalizer:
+		for key := range typed {
+			keys = append(keys, key)
+		}
+	case map[string]CharFilterDefinition:
+		for key := range typed {
+			keys = append(keys, key)
+		}
+	case map[string]TokenizerDefinition:
+		for key := range typed {
+			keys = append(keys, key)
+		}
+	case map[string]TokenFilterDefinition:
+		for key := range typed {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Validate checks the references between analysis components and the parameters each component type needs.
+func (s Settings) Validate() error {
+	a := s.Analysis
+	vErr := &validation.Error{}
+	hasCharFilter := func(name string) bool {
+		_, ok := a.CharFilter[name]
+		return ok || builtinCharFilters[name]
+	}
+	hasTokenizer := func(name string) bool {
+		_, ok := a.Tokenizer[name]
+		return ok || builtinTokenizers[name]
+	}
+	hasFilter := func(name string) bool {
+		_, ok := a.Filter[name]
+		return ok || builtinTokenFilters[name]
+	}
+	checkRefs := func(path string, charFilters, filters []string) {
+		for _, name := range charFilters {
+			if !hasCharFilter(name) {
+				vErr.Add(path+".char_filter", validation.CodeInvalid, fmt.Sprintf("unknown char filter %v", name))
+			}
+		}
+		for _, name := range filters {
+			if !hasFilter(name) {
+				vErr.Add(path+".filter", validation.CodeInvalid, fmt.Sprintf("unknown token filter %v", name))
+			}
+		}
+	}
+	for _, name := range sortedKeys(a.Analyzer) {
+		analyzer := a.Analyzer[name]
+		path := "analysis.analyzer." + name
+		if analyzer.Type != "" && analyzer.Type != "custom" {
+			continue
+		}
+		if analyzer.Tokenizer == "" {
+			vErr.Add(path+".tokenizer", validation.CodeRequired, "is required for a custom analyzer")
+		} else if !hasTokenizer(analyzer.Tokenizer) {
+			vErr.Add(path+".tokenizer", validation.CodeInvalid, fmt.Sprintf("unknown tokenizer %v", analyzer.Tokenizer))
+		}
+		checkRefs(path, analyzer.CharFilter, analyzer.Filter)
+	}
+	for _, name := range sortedKeys(a.Normalizer) {
+		normalizer := a.Normalizer[name]
+		checkRefs("analysis.normalizer."+name, normalizer.CharFilter, normalizer.Filter)
+	}
+	for _, name := range sortedKeys(a.CharFilter) {
+		definition := a.CharFilter[name]
+		path := "analysis.char_filter." + name
+		switch definition.Type {
+		case CharFilterMapping.Mapping:
+			if len(definition.Mappings) == 0 {
+				vErr.Add(path+".mappings", validation.CodeRequired, "is required for a mapping char filter")
+			}
+		case CharFilterMapping.PatternReplace:
+			if definition.Pattern == "" {
+				vErr.Add(path+".pattern", validation.CodeRequired, "is required for a pattern_replace char filter")
+			}
+		case "":
+			vErr.Add(path+".type", validation.CodeRequired, "is required")
+		}
+	}
+	for _, name := range sortedKeys(a.Tokenizer) {
+		definition := a.Tokenizer[name]
+		path := "analysis.tokenizer." + name
+		switch definition.Type {
+		case TokenizerMapping.NGram, TokenizerMapping.EdgeNGram:
+			if definition.MinGram < 1 || definition.MaxGram < definition.MinGram {
+				vErr.Add(path, validation.CodeInvalid, "needs 1 <= min_gram <= max_gram")
+			}
+		case TokenizerMapping.Pattern:
+			if definition.Pattern == "" {
+				vErr.Add(path+".pattern", validation.CodeRequired, "is required for a pattern tokenizer")
+			}
+		case "":
+			vErr.Add(path+".type", validation.CodeRequired, "is required")
+		}
+	}
+	for _, name := range sortedKeys(a.Filter) {
+		definition := a.Filter[name]
+		path := "analysis.filter." + name
+		switch definition.Type {
+		case TokenFilterMapping.Synonym:
+			if len(definition.Synonyms) == 0 && definition.SynonymsPath == "" {
+				vErr.Add(path+".synonyms", validation.CodeRequired, "or synonyms_path is required for a synonym filter")
+			}
+		case TokenFilterMapping.Stemmer:
+			if definition.Language == "" {
+				vErr.Add(path+".language", validation.CodeRequired, "is required for a stemmer filter")
+			}
+		case TokenFilterMapping.Shingle:
+			if definition.MinShingleSize != 0 && definition.MaxShingleSize != 0 && definition.MaxShingleSize < definition.MinShingleSize {
+				vErr.Add(path, validation.CodeInvalid, "needs min_shingle_size <= max_shingle_size")
+			}
+		case "":
+			vErr.Add(path+".type", validation.CodeRequired, "is required")
+		}
+	}
+	if vErr.HasErrors() {
+		return vErr
+	}
+	return nil
+}
+
+// CheckMapping fails when a field of mapping uses an analyzer or normalizer that is neither defined in s nor
+// built into Elasticsearch.
+func (s Settings) CheckMapping(mapping *Mapping) error {
+	vErr := &validation.Error{}
+	s.checkProperties("", mapping.Properties, vErr)
+	if vErr.HasErrors() {
+		return vErr
+	}
+	return nil
+}
+
+func (s Settings) checkProperties(prefix string, properties map[string]Property, vErr *validation.Error) {
+	for _, name := range sortedPropertyNames(properties) {
+		property, path := properties[name], prefix+name
+		s.checkAnalyzer(path+".analyzer", property.Analyzer, vErr)
+		s.checkAnalyzer(path+".search_analyzer", property.SearchAnalyzer, vErr)
+		if _, ok := s.Analysis.Normalizer[property.Normalizer]; property.Normalizer != "" && !ok && !builtinNormalizers[property.Normalizer] {
+			vErr.Add(path+".normalizer", validation.CodeInvalid, fmt.Sprintf("unknown normalizer %v", property.Normalizer))
+		}
+		s.checkProperties(path+".", property.Fields, vErr)
+		s.checkProperties(path+".", property.Properties, vErr)
+	}
+}
+
+func (s Settings) checkAnalyzer(path, analyzer string, vErr *validation.Error) {
+	if _, ok := s.Analysis.Analyzer[analyzer]; analyzer != "" && !ok && !builtinAnalyzers[analyzer] {
+		vErr.Add(path, validation.CodeInvalid, fmt.Sprintf("unknown analyzer %v", analyzer))
+	}
+}
+
+func sortedPropertyNames(properties map[string]Property) []string {
+	keys := make([]string, 0, len(properties))
+	for key := range properties {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+type AnalyzeToken struct {
+	Token       string `json:"token"`
+	StartOffset int    `json:"start_offset"`
+	EndOffset   int    `json:"end_offset"`
+	Type        string `json:"type"`
+	Position    int    `json:"position"`
+}
+
+// analyzeRequest inlines the definitions of analyzer, so _analyze can run it without an index carrying the settings.
+func (s Settings) analyzeRequest(analyzer, text string) (map[string]interface{}, error) {
+	a := s.Analysis
+	var charFilters, filters []string
+	var tokenizer string
+	custom, isAnalyzer := a.Analyzer[analyzer]
+	if isAnalyzer && (custom.Type == "" || custom.Type == "custom") {
+		charFilters, filters, tokenizer = custom.CharFilter, custom.Filter, custom.Tokenizer
+	} else if isAnalyzer {
+		return nil, fmt.Errorf("analyzer %v configures built-in type %v, analyze it against an index", analyzer, custom.Type)
+	} else if normalizer, ok := a.Normalizer[analyzer]; ok {
+		charFilters, filters, tokenizer = normalizer.CharFilter, normalizer.Filter, string(TokenizerMapping.Keyword)
+	} else {
+		return map[string]interface{}{"analyzer": analyzer, "text": text}, nil
+	}
+	body := map[string]interface{}{"text": text}
+	if definition, ok := a.Tokenizer[tokenizer]; ok {
+		body["tokenizer"] = definition
+	} else {
+		body["tokenizer"] = tokenizer
+	}
+	var inlinedCharFilters []interface{}
+	for _, name := range charFilters {
+		if definition, ok := a.CharFilter[name]; ok {
+			inlinedCharFilters = append(inlinedCharFilters, definition)
+		} else {
+			inlinedCharFilters = append(inlinedCharFilters, name)
+		}
+	}
+	if len(inlinedCharFilters) > 0 {
+		body["char_filter"] = inlinedCharFilters
+	}
+	var inlinedFilters []interface{}
+	for _, name := range filters {
+		if definition, ok := a.Filter[name]; ok {
+			inlinedFilters = append(inlinedFilters, definition)
+		} else {
+			inlinedFilters = append(inlinedFilters, name)
+		}
+	}
+	if len(inlinedFilters) > 0 {
+		body["filter"] = inlinedFilters
+	}
+	return body, nil
+}
+
+// Analyze runs text through analyzer, a custom analyzer or normalizer of s or a built-in analyzer, with the
+// _analyze API. No index is needed, so settings can be checked before they are deployed.
+func (s Settings) Analyze(ctx context.Context, client *elasticsearch.Client, analyzer, text string) ([]AnalyzeToken, error) {
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+	request, err := s.analyzeRequest(analyzer, text)
+	if err != nil {
+		return nil, err
+	}
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	var response struct {
+		Tokens []AnalyzeToken `json:"tokens"`
+	}
+	if err := doRequest(ctx, client, "analyzing with "+analyzer, esapi.IndicesAnalyzeRequest{Body: bytes.NewReader(body)}, &response); err != nil {
+		return nil, err
+	}
+	return response.Tokens, nil
+}
+
+// CheckAnalyzer fails unless analyzer turns text into exactly the expected tokens.
+func (s Settings) CheckAnalyzer(ctx context.Context, client *elasticsearch.Client, analyzer, text string, expected ...string) error {
+	tokens, err := s.Analyze(ctx, client, analyzer, text)
+	if err != nil {
+		return err
+	}
+	actual := make([]string, 0, len(tokens))
+	for _, token := range tokens {
+		actual = append(actual, token.Token)
+	}
+	if len(actual) != len(expected) || strings.Join(actual, "\x00") != strings.Join(expected, "\x00") {
+		return fmt.Errorf("analyzer %v turned %q into %q, expected %q", analyzer, text, actual, expected)
+	}
+	return nil
+}
+
+// Analyze runs text through an analyzer of the index, including the custom analyzers of its settings, or through
+// the analyzer of field when analyzer is empty.
+func (esr *ElasticsearchRepo) Analyze(ctx context.Context, analyzer, field, text string) (err error, tokens []AnalyzeToken) {
+	ctx, done := esr.instrument(ctx, "analyze")
+	defer done(&err)
+	request := map[string]interface{}{"text": text}
+	putIfSet(request, "analyzer", analyzer)
+	putIfSet(request, "field", field)
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err, nil
+	}
+	var response struct {
+		Tokens []AnalyzeToken `json:"tokens"`
+	}
+	req := esapi.IndicesAnalyzeRequest{Index: esr.index, Body: bytes.NewReader(body)}
+	if err := doRequest(ctx, esr.client, "analyzing in "+esr.index, req, &response); err != nil {
+		return err, nil
+	}
+	return nil, response.Tokens
+}
@@ -0,0 +1,249 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/kutty-kumar/charminder/pkg/validation"
+)
+
+func TestAnalysisBuilderBuildsValidSettings(t *testing.T) {
+	settings, err := NewAnalysisBuilder().
+		CharFilter("ampersand", MappingCharFilter("& => and")).
+		CharFilter("digits", PatternReplaceCharFilter(`(\d+)-(\d+)`, "$1$2")).
+		CharFilter("html", HTMLStripCharFilter("b")).
+		Tokenizer("grams", EdgeNGramTokenizer(2, 10, "letter", "digit")).
+		Tokenizer("csv", PatternTokenizer(",")).
+		TokenFilter("synonyms", SynonymFilter("tv, television")).
+		TokenFilter("stem", StemmerFilter("english")).
+		TokenFilter("pairs", ShingleFilter(2, 3, true)).
+		TokenFilter("stops", StopFilter("_english_")).
+		TokenFilter("folding", AsciiFoldingFilter(true)).
+		CustomAnalyzer("autocomplete", "grams", []string{"html", "ampersand"}, "lowercase", "folding").
+		CustomAnalyzer("search", "standard", nil, "lowercase", "synonyms", "stem", "stops", "pairs").
+		CustomAnalyzer("tags", "csv", []string{"digits"}, "trim").
+		Analyzer("english_stop", Analyzer{Type: "english", Stopwords: []string{"the"}}).
+		Normalizer("folded", []string{"ampersand"}, "lowercase", "folding").
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := json.Marshal(settings.Analysis.Tokenizer["grams"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"type":"edge_ngram","min_gram":2,"max_gram":10,"token_chars":["letter","digit"]}`; string(out) != want {
+		t.Fatalf("tokenizer = %s, want %s", out, want)
+	}
+	out, err = json.Marshal(settings.Analysis.Filter["pairs"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"type":"shingle","min_shingle_size":2,"max_shingle_size":3,"output_unigrams":true}`; string(out) != want {
+		t.Fatalf("filter = %s, want %s", out, want)
+	}
+}
+
+func TestSettingsValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		builder *AnalysisBuilder
+		want    []validation.FieldError
+	}{
+		{
+			name:    "missing tokenizer",
+			builder: NewAnalysisBuilder().CustomAnalyzer("a", "", nil),
+			want:    []validation.FieldError{{Field: "analysis.analyzer.a.tokenizer", Code: validation.CodeRequired}},
+		},
+		{
+			name:    "unknown references",
+			builder: NewAnalysisBuilder().CustomAnalyzer("a", "grams", []string{"nope"}, "lowercase", "missing"),
+			want: []validation.FieldError{
+				{Field: "analysis.analyzer.a.tokenizer", Code: validation.CodeInvalid},
+				{Field: "analysis.analyzer.a.char_filter", Code: validation.CodeInvalid},
+				{Field: "analysis.analyzer.a.filter", Code: validation.CodeInvalid},
+			},
+		},
+		{
+			name:    "unknown normalizer filter",
+			builder: NewAnalysisBuilder().Normalizer("n", nil, "missing"),
+			want:    []validation.FieldError{{Field: "analysis.normalizer.n.filter", Code: validation.CodeInvalid}},
+		},
+		{
+			name:    "built-in analyzer type",
+			builder: NewAnalysisBuilder().Analyzer("a", Analyzer{Type: "english"}),
+		},
+		{
+			name: "char filter parameters",
+			builder: NewAnalysisBuilder().
+				CharFilter("m", MappingCharFilter()).
+				CharFilter("p", PatternReplaceCharFilter("", "x")).
+				CharFilter("t", CharFilterDefinition{}),
+			want: []validation.FieldError{
+				{Field: "analysis.char_filter.m.mappings", Code: validation.CodeRequired},
+				{Field: "analysis.char_filter.p.pattern", Code: validation.CodeRequired},
+				{Field: "analysis.char_filter.t.type", Code: validation.CodeRequired},
+			},
+		},
+		{
+			name: "tokenizer parameters",
+			builder: NewAnalysisBuilder().
+				Tokenizer("e", EdgeNGramTokenizer(3, 2)).
+				Tokenizer("n", NGramTokenizer(0, 2)).
+				Tokenizer("p", PatternTokenizer("")).
+				Tokenizer("t", TokenizerDefinition{}),
+			want: []validation.FieldError{
+				{Field: "analysis.tokenizer.e", Code: validation.CodeInvalid},
+				{Field: "analysis.tokenizer.n", Code: validation.CodeInvalid},
+				{Field: "analysis.tokenizer.p.pattern", Code: validation.CodeRequired},
+				{Field: "analysis.tokenizer.t.type", Code: validation.CodeRequired},
+			},
+		},
+		{
+			name: "filter parameters",
+			builder: NewAnalysisBuilder().
+				TokenFilter("sh", ShingleFilter(3, 2, false)).
+				TokenFilter("st", StemmerFilter("")).
+				TokenFilter("sy", SynonymFilter()).
+				TokenFilter("t", TokenFilterDefinition{}),
+			want: []validation.FieldError{
+				{Field: "analysis.filter.sh", Code: validation.CodeInvalid},
+				{Field: "analysis.filter.st.language", Code: validation.CodeRequired},
+				{Field: "analysis.filter.sy.synonyms", Code: validation.CodeRequired},
+				{Field: "analysis.filter.t.type", Code: validation.CodeRequired},
+			},
+		},
+		{
+			name:    "synonyms from a file",
+			builder: NewAnalysisBuilder().TokenFilter("sy", TokenFilterDefinition{Type: TokenFilterMapping.Synonym, SynonymsPath: "analysis/synonyms.txt"}),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.builder.Build()
+			if tt.want == nil {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			vErr, ok := validation.AsError(err)
+			if !ok {
+				t.Fatalf("Build() error = %v, want a validation error", err)
+			}
+			got := make([]validation.FieldError, 0, len(vErr.Errors))
+			for _, fieldError := range vErr.Errors {
+				got = append(got, validation.FieldError{Field: fieldError.Field, Code: fieldError.Code})
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("Build() errors = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSettingsCheckMapping(t *testing.T) {
+	settings, err := NewAnalysisBuilder().
+		CustomAnalyzer("folded", "standard", nil, "lowercase", "asciifolding").
+		Normalizer("lower", nil, "lowercase").
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mapping := &Mapping{Properties: map[string]Property{
+		"title": {Type: "text", Analyzer: "folded", SearchAnalyzer: "english", Fields: map[string]Property{
+			"raw": {Type: "keyword", Normalizer: "lower"},
+		}},
+	}}
+	if err := settings.CheckMapping(mapping); err != nil {
+		t.Fatal(err)
+	}
+	mapping.Properties["author"] = Property{Properties: map[string]Property{
+		"name": {Type: "text", Analyzer: "missing", Fields: map[string]Property{"raw": {Type: "keyword", Normalizer: "upper"}}},
+	}}
+	vErr, ok := validation.AsError(settings.CheckMapping(mapping))
+	if !ok {
+		t.Fatal("CheckMapping() accepted unknown analyzers")
+	}
+	var fields []string
+	for _, fieldError := range vErr.Errors {
+		fields = append(fields, fieldError.Field)
+	}
+	if want := []string{"author.name.analyzer", "author.name.raw.normalizer"}; !reflect.DeepEqual(fields, want) {
+		t.Fatalf("CheckMapping() errors on %v, want %v", fields, want)
+	}
+}
+
+func TestSettingsAnalyzeInlinesTheDefinitions(t *testing.T) {
+	settings, err := NewAnalysisBuilder().
+		CharFilter("ampersand", MappingCharFilter("& => and")).
+		Tokenizer("grams", EdgeNGramTokenizer(2, 3)).
+		TokenFilter("stem", StemmerFilter("english")).
+		CustomAnalyzer("custom", "grams", []string{"ampersand", "html_strip"}, "lowercase", "stem").
+		Normalizer("lower", nil, "lowercase").
+		Analyzer("configured", Analyzer{Type: "english", Stopwords: []string{"the"}}).
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo, requests := newTestESRepo(t, func(esRequest) (int, string) {
+		return http.StatusOK, `{"tokens":[{"token":"to","position":0},{"token":"tom","position":0}]}`
+	})
+	tests := []struct {
+		analyzer string
+		want     string
+	}{
+		{
+			analyzer: "custom",
+			want: `{"char_filter":[{"type":"mapping","mappings":["& => and"]},"html_strip"],` +
+				`"filter":["lowercase",{"type":"stemmer","language":"english"}],"text":"Tom",` +
+				`"tokenizer":{"type":"edge_ngram","min_gram":2,"max_gram":3}}`,
+		},
+		{analyzer: "lower", want: `{"filter":["lowercase"],"text":"Tom","tokenizer":"keyword"}`},
+		{analyzer: "standard", want: `{"analyzer":"standard","text":"Tom"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.analyzer, func(t *testing.T) {
+			*requests = nil
+			tokens, err := settings.Analyze(context.Background(), repo.client, tt.analyzer, "Tom")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(tokens) != 2 || tokens[1].Token != "tom" {
+				t.Fatalf("Analyze() = %+v", tokens)
+			}
+			var got, want interface{}
+			if err := json.Unmarshal([]byte((*requests)[0].body), &got); err != nil {
+				t.Fatal(err)
+			}
+			if err := json.Unmarshal([]byte(tt.want), &want); err != nil {
+				t.Fatal(err)
+			}
+			if (*requests)[0].path != "/_analyze" || !reflect.DeepEqual(got, want) {
+				t.Fatalf("request %v %v, want /_analyze %v", (*requests)[0].path, (*requests)[0].body, tt.want)
+			}
+		})
+	}
+	if _, err := settings.Analyze(context.Background(), repo.client, "configured", "Tom"); err == nil {
+		t.Fatal("Analyze() inlined a configured built-in analyzer")
+	}
+}
+
+func TestSettingsCheckAnalyzer(t *testing.T) {
+	repo, _ := newTestESRepo(t, func(esRequest) (int, string) {
+		return http.StatusOK, `{"tokens":[{"token":"red"},{"token":"shoe"}]}`
+	})
+	settings := Settings{}
+	if err := settings.CheckAnalyzer(context.Background(), repo.client, "english", "Red shoes", "red", "shoe"); err != nil {
+		t.Fatal(err)
+	}
+	if err := settings.CheckAnalyzer(context.Background(), repo.client, "english", "Red shoes", "red", "shoes"); err == nil {
+		t.Fatal("CheckAnalyzer() accepted other tokens")
+	}
+	if err := settings.CheckAnalyzer(context.Background(), repo.client, "english", "Red shoes", "red"); err == nil {
+		t.Fatal("CheckAnalyzer() accepted a missing token")
+	}
+}
@@ -0,0 +1,479 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/elastic/go-elasticsearch/v7"
+	"github.com/elastic/go-elasticsearch/v7/esapi"
+	"github.com/kutty-kumar/charminder/pkg"
+	"github.com/kutty-kumar/charminder/pkg/logging"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultBulkFlushSize     = 500
+	defaultBulkFlushBytes    = 5 << 20
+	defaultBulkFlushInterval = 30 * time.Second
+)
+
+var ErrBulkIndexerClosed = errors.New("bulk indexer is closed")
+
+type BulkAction string
+
+const (
+	BulkIndex  BulkAction = "index"
+	BulkCreate BulkAction = "create"
+	BulkUpdate BulkAction = "update"
+	BulkDelete BulkAction = "delete"
+)
+
+// RefreshPolicy controls when bulk changes become visible to search.
+type RefreshPolicy string
+
+const (
+	RefreshNone    RefreshPolicy = "false"
+	RefreshTrue    RefreshPolicy = "true"
+	RefreshWaitFor RefreshPolicy = "wait_for"
+)
+
+// BulkItem is one operation of a bulk request. Body is the document for index and create, and the partial
+// document for update; delete has none. A positive Version is checked as an external version, so stale writes
+// come back as version conflicts.
+type BulkItem struct {
+	Action     BulkAction
+	Index      string
+	DocumentId string
+	Body       []byte
+	Version    int64
+	// position is the place of the item in the input of BulkCreate.
+	position int
+}
+
+type BulkItemError struct {
+	Type   string `json:"type"`
+	Reason string `json:"reason"`
+}
+
+type BulkItemResult struct {
+	Item    BulkItem
+	Status  int
+	Result  string
+	Version int64
+	Error   *BulkItemError
+	// Err is set when the whole request failed and the item never reached Elasticsearch.
+	Err error
+}
+
+func (r BulkItemResult) Failed() bool {
+	return r.Err != nil || r.Error != nil || r.Status >= http.StatusMultipleChoices
+}
+
+func (r BulkItemResult) IsVersionConflict() bool {
+	return r.Status == http.StatusConflict
+}
+
+type BulkStats struct {
+	Added     uint64
+	Flushed   uint64
+	Succeeded uint64
+	Failed    uint64
+	Conflicts uint64
+	Requests  uint64
+}
+
+type BulkIndexerOption func(bi *BulkIndexer)
+
+// WithBulkIndex is the index of items that do not name one.
+func WithBulkIndex(index string) BulkIndexerOption {
+	return func(bi *BulkIndexer) {
+		bi.index = index
+	}
+}
+
+// WithBulkFlushSize sends a request once this many items are buffered.
+func WithBulkFlushSize(items int) BulkIndexerOption {
+	return func(bi *BulkIndexer) {
+		bi.flushSize = items
+	}
+}
+
+// WithBulkFlushBytes sends a request once the buffered body reaches this size.
+func WithBulkFlushBytes(size int) BulkIndexerOption {
+	return func(bi *BulkIndexer) {
+		bi.flushBytes = size
+	}
+}
+
+// WithBulkFlushInterval sends whatever is buffered at this interval, zero disables the timer.
+func WithBulkFlushInterval(interval time.Duration) BulkIndexerOption {
+	return func(bi *BulkIndexer) {
+		bi.flushInterval = interval
+	}
+}
+
+// WithBulkConcurrency is the number of requests in flight; Add blocks while all of them are busy.
+func WithBulkConcurrency(workers int) BulkIndexerOption {
+	return func(bi *BulkIndexer) {
+		bi.concurrency = workers
+	}
+}
+
+func WithBulkRefresh(policy RefreshPolicy) BulkIndexerOption {
+	return func(bi *BulkIndexer) {
+		bi.refresh = policy
+	}
+}
+
+// WithBulkOnResult is called once per item, from the worker that sent it.
+func WithBulkOnResult(onResult func(ctx context.Context, result BulkItemResult)) BulkIndexerOption {
+	return func(bi *BulkIndexer) {
+		bi.onResult = onResult
+	}
+}
+
+func WithBulkLogger(logger logging.Logger) BulkIndexerOption {
+	return func(bi *BulkIndexer) {
+		bi.logger = logging.OrNop(logger)
+	}
+}
+
+// bulkContext carries the values of the Add call that opened a batch, such as its span, and the cancellation of
+// the indexer, so a batch outlives the call that opened it.
+type bulkContext struct {
+	context.Context
+	values context.Context
+}
+
+func (c bulkContext) Value(key interface{}) interface{} {
+	return c.values.Value(key)
+}
+
+type bulkBatch struct {
+	ctx   context.Context
+	items []BulkItem
+	body  *bytes.Buffer
+	done  chan struct{}
+}
+
+// BulkIndexer buffers items and sends them through the _bulk API.
+type BulkIndexer struct {
+	client        *elasticsearch.Client
+	index         string
+	flushSize     int
+	flushBytes    int
+	flushInterval time.Duration
+	concurrency   int
+	refresh       RefreshPolicy
+	onResult      func(ctx context.Context, result BulkItemResult)
+	logger        logging.Logger
+
+	mu     sync.Mutex
+	batch  *bulkBatch
+	closed bool
+	// sendMu keeps batches open while a send is in progress, drained is set once it is closed.
+	sendMu  sync.RWMutex
+	drained bool
+	batches chan *bulkBatch
+	workers sync.WaitGroup
+	stop    chan struct{}
+	// ctx bounds the requests, it is cancelled once Close returns.
+	ctx    context.Context
+	cancel context.CancelFunc
+	stats  BulkStats
+}
+
+func NewBulkIndexer(client *elasticsearch.Client, opts ...BulkIndexerOption) (*BulkIndexer, error) {
+	if client == nil {
+		return nil, fmt.Errorf("bulk indexer: %w, the client is nil", pkg.ErrMissingOption)
+	}
+	bi := &BulkIndexer{
+		client:        client,
+		flushSize:     defaultBulkFlushSize,
+		flushBytes:    defaultBulkFlushBytes,
+		flushInterval: defaultBulkFlushInterval,
+		concurrency:   1,
+		logger:        logging.Nop(),
+		stop:          make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(bi)
+	}
+	if bi.flushSize < 1 || bi.flushBytes < 1 || bi.concurrency < 1 {
+		return nil, errors.New("bulk indexer: flush size, flush bytes and concurrency must be positive")
+	}
+	bi.ctx, bi.cancel = context.WithCancel(context.Background())
+	bi.batches = make(chan *bulkBatch)
+	for i := 0; i < bi.concurrency; i++ {
+		bi.workers.Add(1)
+		go bi.work()
+	}
+	if bi.flushInterval > 0 {
+		go bi.tick()
+	}
+	return bi, nil
+}
+
+func (bi *BulkIndexer) newBatch(ctx context.Context) *bulkBatch {
+	return &bulkBatch{ctx: bulkContext{Context: bi.ctx, values: ctx}, body: &bytes.Buffer{}, done: make(chan struct{})}
+}
+
+func (bi *BulkIndexer) encode(item BulkItem, body *bytes.Buffer) error {
+	meta := map[string]interface{}{}
+	if index := item.Index; index != "" {
+		meta["_index"] = index
+	} else if bi.index != "" {
+		meta["_index"] = bi.index
+	}
+	if item.DocumentId != "" {
+		meta["_id"] = item.DocumentId
+	}
+	if item.Version > 0 {
+		meta["version"] = item.Version
+		meta["version_type"] = "external"
+	}
+	metaLine, err := json.Marshal(map[string]interface{}{string(item.Action): meta})
+	if err != nil {
+		return err
+	}
+	body.Write(metaLine)
+	body.WriteByte('\n')
+	switch item.Action {
+	case BulkDelete:
+		return nil
+	case BulkUpdate:
+		doc, err := json.Marshal(map[string]json.RawMessage{"doc": item.Body})
+		if err != nil {
+			return err
+		}
+		body.Write(doc)
+	case BulkIndex, BulkCreate:
+		body.Write(bytes.TrimSpace(item.Body))
+	default:
+		return fmt.Errorf("unknown bulk action %q", item.Action)
+	}
+	body.WriteByte('\n')
+	return nil
+}
+
+// Add buffers item, sending the buffer when it reaches the flush size. ctx only bounds how long Add blocks while
+// all the workers are busy; the batch is sent whatever becomes of it, carrying its values, e.g. the span.
+func (bi *BulkIndexer) Add(ctx context.Context, item BulkItem) error {
+	bi.mu.Lock()
+	if bi.closed {
+		bi.mu.Unlock()
+		return ErrBulkIndexerClosed
+	}
+	var encoded bytes.Buffer
+	if err := bi.encode(item, &encoded); err != nil {
+		bi.mu.Unlock()
+		return fmt.Errorf("encoding bulk item %v: %w", item.DocumentId, err)
+	}
+	if bi.batch == nil {
+		bi.batch = bi.newBatch(ctx)
+	}
+	bi.batch.body.Write(encoded.Bytes())
+	bi.batch.items = append(bi.batch.items, item)
+	atomic.AddUint64(&bi.stats.Added, 1)
+	var full *bulkBatch
+	if len(bi.batch.items) >= bi.flushSize || bi.batch.body.Len() >= bi.flushBytes {
+		full, bi.batch = bi.batch, nil
+	}
+	bi.mu.Unlock()
+	if full != nil {
+		return bi.send(ctx, full)
+	}
+	return nil
+}
+
+func (bi *BulkIndexer) send(ctx context.Context, batch *bulkBatch) error {
+	bi.sendMu.RLock()
+	defer bi.sendMu.RUnlock()
+	err := ErrBulkIndexerClosed
+	if !bi.drained {
+		select {
+		case bi.batches <- batch:
+			return nil
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	}
+	bi.fail(batch, err)
+	close(batch.done)
+	return err
+}
+
+// Flush sends the buffered items and waits until their results are reported.
+func (bi *BulkIndexer) Flush(ctx context.Context) error {
+	bi.mu.Lock()
+	batch := bi.batch
+	bi.batch = nil
+	bi.mu.Unlock()
+	if batch == nil {
+		return nil
+	}
+	if err := bi.send(ctx, batch); err != nil {
+		return err
+	}
+	select {
+	case <-batch.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close flushes the buffer and waits for the requests in flight. Items added afterwards are rejected.
+func (bi *BulkIndexer) Close(ctx context.Context) error {
+	bi.mu.Lock()
+	if bi.closed {
+		bi.mu.Unlock()
+		return nil
+	}
+	bi.closed = true
+	batch := bi.batch
+	bi.batch = nil
+	bi.mu.Unlock()
+	close(bi.stop)
+	var err error
+	if batch != nil {
+		err = bi.send(ctx, batch)
+	}
+	bi.sendMu.Lock()
+	bi.drained = true
+	close(bi.batches)
+	bi.sendMu.Unlock()
+	done := make(chan struct{})
+	go func() {
+		bi.workers.Wait()
+		close(done)
+	}()
+	defer bi.cancel()
+	select {
+	case <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (bi *BulkIndexer) Start(ctx context.Context) error {
+	return nil
+}
+
+func (bi *BulkIndexer) Stop(ctx context.Context) error {
+	return bi.Close(ctx)
+}
+
+func (bi *BulkIndexer) Stats() BulkStats {
+	return BulkStats{
+		Added:     atomic.LoadUint64(&bi.stats.Added),
+		Flushed:   atomic.LoadUint64(&bi.stats.Flushed),
+		Succeeded: atomic.LoadUint64(&bi.stats.Succeeded),
+		Failed:    atomic.LoadUint64(&bi.stats.Failed),
+		Conflicts: atomic.LoadUint64(&bi.stats.Conflicts),
+		Requests:  atomic.LoadUint64(&bi.stats.Requests),
+	}
+}
+
+func (bi *BulkIndexer) tick() {
+	ticker := time.NewTicker(bi.flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			bi.mu.Lock()
+			batch := bi.batch
+			bi.batch = nil
+			bi.mu.Unlock()
+			if batch != nil {
+				_ = bi.send(bi.ctx, batch)
+			}
+		case <-bi.stop:
+			return
+		}
+	}
+}
+
+func (bi *BulkIndexer) work() {
+	defer bi.workers.Done()
+	for batch := range bi.batches {
+		bi.flush(batch)
+	}
+}
+
+type bulkResponse struct {
+	Errors bool `json:"errors"`
+	Items  []map[string]struct {
+		Index   string         `json:"_index"`
+		Id      string         `json:"_id"`
+		Version int64          `json:"_version"`
+		Result  string         `json:"result"`
+		Status  int            `json:"status"`
+		Error   *BulkItemError `json:"error"`
+	} `json:"items"`
+}
+
+func (bi *BulkIndexer) flush(batch *bulkBatch) {
+	defer close(batch.done)
+	atomic.AddUint64(&bi.stats.Requests, 1)
+	atomic.AddUint64(&bi.stats.Flushed, uint64(len(batch.items)))
+	req := esapi.BulkRequest{Body: bytes.NewReader(batch.body.Bytes()), Refresh: string(bi.refresh)}
+	res, err := req.Do(batch.ctx, bi.client)
+	if err != nil {
+		bi.fail(batch, err)
+		return
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		bi.fail(batch, responseError("bulk request", res))
+		return
+	}
+	var response bulkResponse
+	if err := json.NewDecoder(res.Body).Decode(&response); err != nil {
+		bi.fail(batch, fmt.Errorf("decoding bulk response: %w", err))
+		return
+	}
+	if len(response.Items) != len(batch.items) {
+		bi.fail(batch, fmt.Errorf("bulk response has %v items for %v sent", len(response.Items), len(batch.items)))
+		return
+	}
+	for i, entry := range response.Items {
+		for _, outcome := range entry {
+			bi.report(batch.ctx, BulkItemResult{
+				Item:    batch.items[i],
+				Status:  outcome.Status,
+				Result:  outcome.Result,
+				Version: outcome.Version,
+				Error:   outcome.Error,
+			})
+		}
+	}
+}
+
+func (bi *BulkIndexer) fail(batch *bulkBatch, err error) {
+	bi.logger.Error(batch.ctx, "bulk request failed", logging.Err(err), logging.F("items", len(batch.items)))
+	for _, item := range batch.items {
+		bi.report(batch.ctx, BulkItemResult{Item: item, Err: err})
+	}
+}
+
+func (bi *BulkIndexer) report(ctx context.Context, result BulkItemResult) {
+	switch {
+	case result.IsVersionConflict():
+		atomic.AddUint64(&bi.stats.Conflicts, 1)
+		atomic.AddUint64(&bi.stats.Failed, 1)
+	case result.Failed():
+		atomic.AddUint64(&bi.stats.Failed, 1)
+	default:
+		atomic.AddUint64(&bi.stats.Succeeded, 1)
+	}
+	if bi.onResult != nil {
+		bi.onResult(ctx, result)
+	}
+}
@@ -0,0 +1,71 @@
+package db
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kutty-kumar/charminder/pkg"
+)
+
+// bulkResponseFor answers a _bulk request with one outcome per action line, taken from results in turn.
+func bulkResponseFor(r esRequest, results ...string) string {
+	var items []string
+	for _, line := range strings.Split(strings.TrimSpace(r.body), "\n") {
+		if !strings.HasPrefix(line, `{"index"`) {
+			continue
+		}
+		result := results[len(items)%len(results)]
+		status := "200"
+		if result == "created" {
+			status = "201"
+		}
+		items = append(items, `{"index":{"_index":"test_entities","_id":"e1","result":"`+result+`","status":`+status+`}}`)
+	}
+	return `{"errors":false,"items":[` + strings.Join(items, ",") + `]}`
+}
+
+func TestBulkTimerFlushOutlivesTheAddContext(t *testing.T) {
+	repo, _ := newTestESRepo(t, func(r esRequest) (int, string) { return http.StatusOK, bulkResponseFor(r, "created") })
+	results := make(chan BulkItemResult, 1)
+	indexer, err := NewBulkIndexer(repo.client, WithBulkIndex("test_entities"), WithBulkFlushInterval(10*time.Millisecond),
+		WithBulkOnResult(func(ctx context.Context, result BulkItemResult) { results <- result }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer indexer.Close(context.Background())
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := indexer.Add(ctx, BulkItem{Action: BulkIndex, DocumentId: "e1", Body: []byte(`{}`)}); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	select {
+	case result := <-results:
+		if result.Failed() {
+			t.Fatalf("the timer flush failed: %+v", result)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the timer never flushed the batch")
+	}
+}
+
+func TestBulkCreateReportsDuplicateIdsInOrder(t *testing.T) {
+	repo, _ := newTestESRepo(t, func(r esRequest) (int, string) { return http.StatusOK, bulkResponseFor(r, "created", "updated") })
+	bases := make([]pkg.Base, 2)
+	for i := range bases {
+		entity := &testEntity{Name: "lamp"}
+		entity.ExternalId = "e1"
+		bases[i] = entity
+	}
+	err, results := repo.BulkCreate(context.Background(), bases)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []string{"created", "updated"} {
+		if results[i].Result != want {
+			t.Errorf("result %v is %q, want %q", i, results[i].Result, want)
+		}
+	}
+}
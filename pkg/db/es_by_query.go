@@ -0,0 +1,293 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/elastic/go-elasticsearch/v7/esapi"
+	"github.com/kutty-kumar/charminder/pkg/logging"
+	"net/http"
+	"reflect"
+	"time"
+)
+
+// Script is a painless script, e.g. for UpdateByQuery. Params keep values out of the source, so the script is
+// compiled once.
+type Script struct {
+	source string
+	lang   string
+	params map[string]interface{}
+}
+
+func NewScript(source string) *Script {
+	return &Script{source: source}
+}
+
+func (s *Script) Lang(lang string) *Script {
+	s.lang = lang
+	return s
+}
+
+// Param makes value available to the script as params.name.
+func (s *Script) Param(name string, value interface{}) *Script {
+	if s.params == nil {
+		s.params = make(map[string]interface{})
+	}
+	s.params[name] = value
+	return s
+}
+
+func (s *Script) Source() interface{} {
+	body := map[string]interface{}{"source": s.source}
+	putIfSet(body, "lang", s.lang)
+	if len(s.params) > 0 {
+		body["params"] = s.params
+	}
+	return body
+}
+
+// ConflictPolicy decides what a by-query operation does when a document changed while it ran.
+type ConflictPolicy string
+
+const (
+	ConflictsAbort   ConflictPolicy = "abort"
+	ConflictsProceed ConflictPolicy = "proceed"
+)
+
+type byQueryConfig struct {
+	conflicts         ConflictPolicy
+	async             bool
+	refresh           bool
+	requestsPerSecond *int
+	slices            interface{}
+}
+
+type ByQueryOption func(c *byQueryConfig)
+
+// WithConflicts sets the conflict policy, by default the operation aborts on the first version conflict.
+func WithConflicts(policy ConflictPolicy) ByQueryOption {
+	return func(c *byQueryConfig) {
+		c.conflicts = policy
+	}
+}
+
+// WithAsync starts the operation as a task and returns at once; poll it with Task or WaitForTask.
+func WithAsync() ByQueryOption {
+	return func(c *byQueryConfig) {
+		c.async = true
+	}
+}
+
+// WithByQueryRefresh refreshes the affected shards once the operation completes.
+func WithByQueryRefresh() ByQueryOption {
+	return func(c *byQueryConfig) {
+		c.refresh = true
+	}
+}
+
+// WithRequestsPerSecond throttles the operation, to spare the cluster on large indices.
+func WithRequestsPerSecond(requests int) ByQueryOption {
+	return func(c *byQueryConfig) {
+		c.requestsPerSecond = &requests
+	}
+}
+
+// WithSlices splits the operation into parallel slices, 0 lets Elasticsearch pick one slice per shard.
+func WithSlices(slices int) ByQueryOption {
+	return func(c *byQueryConfig) {
+		if slices == 0 {
+			c.slices = "auto"
+		} else {
+			c.slices = slices
+		}
+	}
+}
+
+// ByQueryResult reports a delete or update by query. Task is set instead of the counts when it runs async.
+type ByQueryResult struct {
+	Task             string            `json:"task,omitempty"`
+	Took             int64             `json:"took"`
+	TimedOut         bool              `json:"timed_out"`
+	Total            int64             `json:"total"`
+	Deleted          int64             `json:"deleted"`
+	Updated          int64             `json:"updated"`
+	Batches          int64             `json:"batches"`
+	VersionConflicts int64             `json:"version_conflicts"`
+	Noops            int64             `json:"noops"`
+	Failures         []json.RawMessage `json:"failures,omitempty"`
+}
+
+func (r *ByQueryResult) err(operation string) error {
+	if len(r.Failures) > 0 {
+		return fmt.Errorf("%v: %v failures, first: %s", operation, len(r.Failures), r.Failures[0])
+	}
+	if r.TimedOut {
+		return fmt.Errorf("%v timed out after %v of %v documents", operation, r.Deleted+r.Updated+r.Noops, r.Total)
+	}
+	return nil
+}
+
+// TaskStatus is the state of an async task. Response is set once the task completed, Error when it failed.
+type TaskStatus struct {
+	Completed bool `json:"completed"`
+	Task      struct {
+		Node        string          `json:"node"`
+		Id          int64           `json:"id"`
+		Action      string          `json:"action"`
+		Description string          `json:"description"`
+		Status      json.RawMessage `json:"status"`
+		Cancelled   bool            `json:"cancelled"`
+	} `json:"task"`
+	Response *ByQueryResult  `json:"response,omitempty"`
+	Error    json.RawMessage `json:"error,omitempty"`
+}
+
+// Delete removes the document of externalId from the index.
+func (esr *ElasticsearchRepo) Delete(ctx context.Context, externalId string) (err error) {
+	ctx, done := esr.instrument(ctx, "delete")
+	defer done(&err)
+	res, err := esapi.DeleteRequest{Index: esr.index, DocumentID: externalId, Refresh: "true"}.Do(ctx, esr.client)
+	if err != nil {
+		return fmt.Errorf("deleting %v from %v: %w", externalId, esr.index, err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("document %v of %v: %w", externalId, esr.index, ErrNotFound)
+	}
+	if res.IsError() {
+		return responseError("deleting "+externalId+" from "+esr.index, res)
+	}
+	return nil
+}
+
+// byQueryBody rejects a missing query rather than treating it as matching every document.
+func byQueryBody(query Query, script *Script) ([]byte, error) {
+	if query == nil || (reflect.ValueOf(query).Kind() == reflect.Ptr && reflect.ValueOf(query).IsNil()) {
+		return nil, errors.New("by query operations need a query")
+	}
+	body := map[string]interface{}{"query": query.Source()}
+	if script != nil {
+		body["script"] = script.Source()
+	}
+	return json.Marshal(body)
+}
+
+func byQueryOptions(opts []ByQueryOption) *byQueryConfig {
+	config := &byQueryConfig{conflicts: ConflictsAbort}
+	for _, opt := range opts {
+		opt(config)
+	}
+	return config
+}
+
+func (esr *ElasticsearchRepo) byQuery(ctx context.Context, operation string, config *byQueryConfig, req esapi.Request) (*ByQueryResult, error) {
+	var result ByQueryResult
+	if err := doRequest(ctx, esr.client, operation, req, &result); err != nil {
+		return nil, err
+	}
+	if config.async {
+		esr.logger.Info(ctx, "task started", logging.F("operation", operation), logging.F("task", result.Task))
+		return &result, nil
+	}
+	return &result, result.err(operation)
+}
+
+// DeleteByQuery deletes every document matching query. The result carries the counts, or the task to poll with
+// WithAsync.
+func (esr *ElasticsearchRepo) DeleteByQuery(ctx context.Context, query Query, opts ...ByQueryOption) (err error, result *ByQueryResult) {
+	ctx, done := esr.instrument(ctx, "delete_by_query")
+	defer done(&err)
+	config := byQueryOptions(opts)
+	body, err := byQueryBody(query, nil)
+	if err != nil {
+		return err, nil
+	}
+	wait := !config.async
+	req := esapi.DeleteByQueryRequest{
+		Index:             []string{esr.index},
+		Body:              bytes.NewReader(body),
+		Conflicts:         string(config.conflicts),
+		Refresh:           &config.refresh,
+		WaitForCompletion: &wait,
+		RequestsPerSecond: config.requestsPerSecond,
+		Slices:            config.slices,
+	}
+	result, err = esr.byQuery(ctx, "delete by query on "+esr.index, config, req)
+	return err, result
+}
+
+// UpdateByQuery runs script on every document matching query, e.g. NewScript("ctx._source.status = params.status").
+func (esr *ElasticsearchRepo) UpdateByQuery(ctx context.Context, query Query, script *Script, opts ...ByQueryOption) (err error, result *ByQueryResult) {
+	ctx, done := esr.instrument(ctx, "update_by_query")
+	defer done(&err)
+	if script == nil {
+		return fmt.Errorf("update by query on %v needs a script", esr.index), nil
+	}
+	config := byQueryOptions(opts)
+	body, err := byQueryBody(query, script)
+	if err != nil {
+		return err, nil
+	}
+	wait := !config.async
+	req := esapi.UpdateByQueryRequest{
+		Index:             []string{esr.index},
+		Body:              bytes.NewReader(body),
+		Conflicts:         string(config.conflicts),
+		Refresh:           &config.refresh,
+		WaitForCompletion: &wait,
+		RequestsPerSecond: config.requestsPerSecond,
+		Slices:            config.slices,
+	}
+	result, err = esr.byQuery(ctx, "update by query on "+esr.index, config, req)
+	return err, result
+}
+
+// Task returns the state of an async task started with WithAsync.
+func (esr *ElasticsearchRepo) Task(ctx context.Context, taskId string) (err error, status *TaskStatus) {
+	ctx, done := esr.instrument(ctx, "task")
+	defer done(&err)
+	status = &TaskStatus{}
+	if err := doRequest(ctx, esr.client, "getting task "+taskId, esapi.TasksGetRequest{TaskID: taskId}, status); err != nil {
+		return err, nil
+	}
+	return nil, status
+}
+
+// WaitForTask polls the task every interval until it completes, and reports its failures like the synchronous
+// operation would. Cancelling ctx stops the polling, not the task.
+func (esr *ElasticsearchRepo) WaitForTask(ctx context.Context, taskId string, interval time.Duration) (error, *TaskStatus) {
+	if interval <= 0 {
+		return fmt.Errorf("waiting for task %v: the polling interval must be positive, got %v", taskId, interval), nil
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		err, status := esr.Task(ctx, taskId)
+		if err != nil {
+			return err, nil
+		}
+		if status.Completed {
+			if len(status.Error) > 0 {
+				return fmt.Errorf("task %v failed: %s", taskId, status.Error), status
+			}
+			if status.Response != nil {
+				return status.Response.err("task " + taskId), status
+			}
+			return nil, status
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("waiting for task %v: %w", taskId, ctx.Err()), status
+		case <-ticker.C:
+		}
+	}
+}
+
+// CancelTask asks Elasticsearch to stop a running task; the documents it already handled stay changed.
+func (esr *ElasticsearchRepo) CancelTask(ctx context.Context, taskId string) (err error) {
+	ctx, done := esr.instrument(ctx, "cancel_task")
+	defer done(&err)
+	return doRequest(ctx, esr.client, "cancelling task "+taskId, esapi.TasksCancelRequest{TaskID: taskId}, nil)
+}
@@ -0,0 +1,93 @@
+package db
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestByQueryRejectsAMissingQuery(t *testing.T) {
+	var typedNil *TermQuery
+	tests := []struct {
+		name string
+		call func(repo *ElasticsearchRepo) error
+	}{
+		{name: "delete", call: func(repo *ElasticsearchRepo) error {
+			err, _ := repo.DeleteByQuery(context.Background(), nil)
+			return err
+		}},
+		{name: "update", call: func(repo *ElasticsearchRepo) error {
+			err, _ := repo.UpdateByQuery(context.Background(), nil, NewScript("ctx._source.price = 0"))
+			return err
+		}},
+		{name: "typed nil", call: func(repo *ElasticsearchRepo) error {
+			err, _ := repo.DeleteByQuery(context.Background(), typedNil)
+			return err
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo, requests := newTestESRepo(t, func(esRequest) (int, string) { return http.StatusOK, `{"total":0}` })
+			if err := tt.call(repo); err == nil {
+				t.Fatal("the call succeeded without a query")
+			}
+			if len(*requests) != 0 {
+				t.Fatalf("the cluster received %v", *requests)
+			}
+		})
+	}
+}
+
+func TestUpdateByQueryBody(t *testing.T) {
+	repo, requests := newTestESRepo(t, func(esRequest) (int, string) { return http.StatusOK, `{"total":2,"updated":2}` })
+	script := NewScript("ctx._source.price = params.price").Param("price", 3)
+	err, result := repo.UpdateByQuery(context.Background(), Term("sku", "A-1"), script, WithConflicts(ConflictsProceed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Updated != 2 {
+		t.Errorf("result %+v, want 2 updated", result)
+	}
+	request := (*requests)[0]
+	want := `{"query":{"term":{"sku":"A-1"}},"script":{"params":{"price":3},"source":"ctx._source.price = params.price"}}`
+	if request.path != "/test_entities/_update_by_query" || request.body != want || request.query.Get("conflicts") != "proceed" {
+		t.Fatalf("request %v %v?%v, want %v", request.path, request.body, request.query.Encode(), want)
+	}
+}
+
+func TestWaitForTask(t *testing.T) {
+	tests := []struct {
+		name     string
+		interval time.Duration
+		statuses []string
+		want     string
+		polls    int
+	}{
+		{name: "zero interval", interval: 0, want: "interval must be positive"},
+		{name: "negative interval", interval: -time.Second, want: "interval must be positive"},
+		{name: "completes", interval: time.Millisecond, statuses: []string{`{"completed":false}`, `{"completed":true,"response":{"total":1,"deleted":1}}`}, polls: 2},
+		{name: "fails", interval: time.Millisecond, statuses: []string{`{"completed":true,"error":{"type":"search_phase_execution_exception"}}`}, want: "search_phase_execution_exception", polls: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			polls := 0
+			repo, _ := newTestESRepo(t, func(esRequest) (int, string) {
+				status := tt.statuses[polls]
+				polls++
+				return http.StatusOK, status
+			})
+			err, _ := repo.WaitForTask(context.Background(), "node:1", tt.interval)
+			if tt.want == "" && err != nil {
+				t.Fatal(err)
+			}
+			if tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)) {
+				t.Fatalf("WaitForTask() = %v, want an error containing %v", err, tt.want)
+			}
+			if polls != tt.polls {
+				t.Fatalf("polled %v times, want %v", polls, tt.polls)
+			}
+		})
+	}
+}
@@ -0,0 +1,326 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type FacetKind string
+
+const (
+	FacetTerms         FacetKind = "terms"
+	FacetRange         FacetKind = "range"
+	FacetHistogram     FacetKind = "histogram"
+	FacetDateHistogram FacetKind = "date_histogram"
+)
+
+// FacetRangeBucket is one bucket of a range facet, From is inclusive and To exclusive; a nil bound is open.
+type FacetRangeBucket struct {
+	Key  string
+	From interface{}
+	To   interface{}
+}
+
+// Facet counts the hits per value of Field. Name is what selections and results refer to, the dotted path of
+// the field for facets declared with the facet tag option.
+type Facet struct {
+	Name  string
+	Field string
+	Kind  FacetKind
+	// Size is the most buckets of a terms facet, 10 when it is not set.
+	Size   int
+	Ranges []FacetRangeBucket
+	// Interval is the bucket width of a histogram, or a calendar unit such as month for a date histogram.
+	Interval string
+}
+
+// dateMathUnits maps calendar intervals onto date math, a selected date bucket ends one interval after its key.
+var dateMathUnits = map[string]string{
+	"minute": "1m", "hour": "1h", "day": "1d", "week": "1w", "month": "1M", "quarter": "3M", "year": "1y",
+}
+
+func parseFacet(value string) (*Facet, error) {
+	kind, parameter := value, ""
+	if idx := strings.Index(value, ":"); idx >= 0 {
+		kind, parameter = value[:idx], value[idx+1:]
+	}
+	facet := &Facet{Kind: FacetKind(kind)}
+	switch facet.Kind {
+	case "", FacetTerms:
+		facet.Kind = FacetTerms
+		if parameter != "" {
+			size, err := strconv.Atoi(parameter)
+			if err != nil {
+				return nil, fmt.Errorf("facet=%v: %w", value, err)
+			}
+			facet.Size = size
+		}
+	case FacetRange:
+		for _, spec := range strings.Split(parameter, "|") {
+			bounds := strings.Split(spec, "..")
+			if len(bounds) != 2 || spec == ".." {
+				return nil, fmt.Errorf("facet range %q must be from..to", spec)
+			}
+			facet.Ranges = append(facet.Ranges, FacetRangeBucket{Key: spec, From: rangeBound(bounds[0]), To: rangeBound(bounds[1])})
+		}
+	case FacetHistogram:
+		if _, err := strconv.ParseFloat(parameter, 64); err != nil {
+			return nil, fmt.Errorf("facet=%v: the interval must be a number", value)
+		}
+		facet.Interval = parameter
+	case FacetDateHistogram:
+		if _, ok := dateMathUnits[parameter]; !ok {
+			return nil, fmt.Errorf("facet=%v: unknown calendar interval %v", value, parameter)
+		}
+		facet.Interval = parameter
+	default:
+		return nil, fmt.Errorf("unknown facet kind %v", kind)
+	}
+	return facet, nil
+}
+
+func rangeBound(bound string) interface{} {
+	if bound == "" {
+		return nil
+	}
+	if number, err := strconv.ParseFloat(bound, 64); err == nil {
+		return number
+	}
+	return bound
+}
+
+// facets lists the facets declared with the facet tag option, by name. Terms facets on text fields count the
+// keyword subfield.
+func (m *Mapping) facets() (map[string]Facet, error) {
+	facets := make(map[string]Facet)
+	var walk func(prefix string, properties map[string]Property) error
+	walk = func(prefix string, properties map[string]Property) error {
+		for name, property := range properties {
+			path := prefix + name
+			if property.facet != nil {
+				facet := *property.facet
+				facet.Name, facet.Field = path, path
+				switch property.typeName() {
+				case "text":
+					if facet.Kind != FacetTerms {
+						return fmt.Errorf("field %v: %v facet on a text field", path, facet.Kind)
+					}
+					if property.Fields["keyword"].Type != "keyword" {
+						return fmt.Errorf("field %v: a terms facet on a text field needs its keyword subfield", path)
+					}
+					facet.Field = path + ".keyword"
+				case "object", "nested":
+					return fmt.Errorf("field %v: facet on an %v field", path, property.typeName())
+				}
+				facets[path] = facet
+			}
+			if property.typeName() == "object" {
+				if err := walk(path+".", property.Properties); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	if err := walk("", m.Properties); err != nil {
+		return nil, err
+	}
+	return facets, nil
+}
+
+// facetKey formats a bucket key the way selections refer to it, numbers without an exponent.
+func facetKey(key interface{}) string {
+	if number, ok := key.(float64); ok {
+		return strconv.FormatFloat(number, 'f', -1, 64)
+	}
+	return fmt.Sprint(key)
+}
+
+// filter is the query matching the hits in any of the selected buckets.
+func (f Facet) filter(selected []interface{}) (Query, error) {
+	if f.Kind == FacetTerms {
+		return Terms(f.Field, selected...), nil
+	}
+	queries := make([]Query, 0, len(selected))
+	for _, key := range selected {
+		rangeQuery := Range(f.Field)
+		switch f.Kind {
+		case FacetRange:
+			found := false
+			for _, bucket := range f.Ranges {
+				if facetKey(key) == bucket.Key {
+					if bucket.From != nil {
+						rangeQuery.Gte(bucket.From)
+					}
+					if bucket.To != nil {
+						rangeQuery.Lt(bucket.To)
+					}
+					found = true
+				}
+			}
+			if !found {
+				return nil, fmt.Errorf("facet %v has no range %v", f.Name, key)
+			}
+		case FacetHistogram:
+			interval, _ := strconv.ParseFloat(f.Interval, 64)
+			from, err := strconv.ParseFloat(facetKey(key), 64)
+			if err != nil {
+				return nil, fmt.Errorf("facet %v: bucket %v is not a number", f.Name, key)
+			}
+			rangeQuery.Gte(from).Lt(from + interval)
+		case FacetDateHistogram:
+			from := facetKey(key)
+			rangeQuery.Gte(from).Lt(from + "||+" + dateMathUnits[f.Interval])
+		}
+		queries = append(queries, rangeQuery)
+	}
+	if len(queries) == 1 {
+		return queries[0], nil
+	}
+	return Bool().Should(queries...).MinimumShouldMatch("1"), nil
+}
+
+type aggregation map[string]interface{}
+
+func (a aggregation) Source() interface{} {
+	return map[string]interface{}(a)
+}
+
+func (f Facet) aggregation() map[string]interface{} {
+	body := map[string]interface{}{"field": f.Field}
+	switch f.Kind {
+	case FacetRange:
+		ranges := make([]interface{}, 0, len(f.Ranges))
+		for _, bucket := range f.Ranges {
+			r := map[string]interface{}{"key": bucket.Key}
+			if bucket.From != nil {
+				r["from"] = bucket.From
+			}
+			if bucket.To != nil {
+				r["to"] = bucket.To
+			}
+			ranges = append(ranges, r)
+		}
+		body["ranges"] = ranges
+	case FacetHistogram:
+		interval, _ := strconv.ParseFloat(f.Interval, 64)
+		body["interval"] = interval
+		body["min_doc_count"] = 1
+	case FacetDateHistogram:
+		body["calendar_interval"] = f.Interval
+		body["min_doc_count"] = 1
+	default:
+		if f.Size > 0 {
+			body["size"] = f.Size
+		}
+	}
+	return map[string]interface{}{string(f.Kind): body}
+}
+
+type FacetBucket struct {
+	Key         interface{}
+	KeyAsString string
+	Count       int64
+	// Selected reports whether the search was narrowed to this bucket.
+	Selected bool
+}
+
+type FacetedResult struct {
+	*SearchResult
+	Facets map[string][]FacetBucket
+}
+
+type facetResponse struct {
+	Facet struct {
+		Buckets []struct {
+			Key         interface{} `json:"key"`
+			KeyAsString string      `json:"key_as_string"`
+			DocCount    int64       `json:"doc_count"`
+		} `json:"buckets"`
+	} `json:"facet"`
+}
+
+// FacetedSearch runs source and counts the buckets of every facet of the entity. The hits are narrowed to the
+// selected buckets, keyed by facet name, through a post filter; each facet is counted with the selections of the
+// other facets only, so selecting a bucket leaves the counts of its siblings in place. A post filter of source
+// still narrows the hits, together with the selections.
+func (esr *ElasticsearchRepo) FacetedSearch(ctx context.Context, source *SearchSource, selected map[string][]interface{}) (err error, result *FacetedResult) {
+	ctx, done := esr.instrument(ctx, "faceted_search")
+	defer done(&err)
+	names := make([]string, 0, len(esr.facets))
+	for name := range esr.facets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	filters := make(map[string]Query, len(selected))
+	for name, values := range selected {
+		facet, ok := esr.facets[name]
+		if !ok {
+			return fmt.Errorf("%v has no facet %v", esr.index, name), nil
+		}
+		if len(values) > 0 {
+			if filters[name], err = facet.filter(values); err != nil {
+				return err, nil
+			}
+		}
+	}
+
+	faceted := *source
+	faceted.aggregations = make(map[string]Aggregation, len(source.aggregations)+len(names))
+	for name, agg := range source.aggregations {
+		faceted.aggregations[name] = agg
+	}
+	postFilter := Bool()
+	if source.postFilter != nil {
+		postFilter.Filter(source.postFilter)
+	}
+	for _, name := range names {
+		others := Bool()
+		for _, other := range names {
+			if filter, ok := filters[other]; ok {
+				if other == name {
+					postFilter.Filter(filter)
+				} else {
+					others.Filter(filter)
+				}
+			}
+		}
+		faceted.aggregations[name] = aggregation{
+			"filter": others.Source(),
+			"aggs":   map[string]interface{}{"facet": esr.facets[name].aggregation()},
+		}
+	}
+	if len(filters) > 0 || source.postFilter != nil {
+		faceted.postFilter = postFilter
+	}
+
+	response, err := esr.search(ctx, &faceted)
+	if err != nil {
+		return err, nil
+	}
+	result = &FacetedResult{SearchResult: esr.searchResult(response, &faceted), Facets: make(map[string][]FacetBucket, len(names))}
+	for _, name := range names {
+		var facet facetResponse
+		if raw, ok := response.Aggregations[name]; ok {
+			if err := json.Unmarshal(raw, &facet); err != nil {
+				return fmt.Errorf("decoding facet %v: %w", name, err), nil
+			}
+		}
+		chosen := make(map[string]bool, len(selected[name]))
+		for _, value := range selected[name] {
+			chosen[facetKey(value)] = true
+		}
+		buckets := make([]FacetBucket, 0, len(facet.Facet.Buckets))
+		for _, b := range facet.Facet.Buckets {
+			bucket := FacetBucket{Key: b.Key, KeyAsString: b.KeyAsString, Count: b.DocCount}
+			bucket.Selected = chosen[facetKey(b.Key)] || (b.KeyAsString != "" && chosen[b.KeyAsString])
+			buckets = append(buckets, bucket)
+		}
+		result.Facets[name] = buckets
+	}
+	return nil, result
+}
@@ -0,0 +1,124 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func sourceJson(t *testing.T, query Query) string {
+	t.Helper()
+	out, err := json.Marshal(query.Source())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out)
+}
+
+func TestParseFacet(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    *Facet
+		wantErr bool
+	}{
+		{value: "", want: &Facet{Kind: FacetTerms}},
+		{value: "terms:20", want: &Facet{Kind: FacetTerms, Size: 20}},
+		{value: "range:..100|100..500|500..", want: &Facet{Kind: FacetRange, Ranges: []FacetRangeBucket{
+			{Key: "..100", To: 100.0}, {Key: "100..500", From: 100.0, To: 500.0}, {Key: "500..", From: 500.0},
+		}}},
+		{value: "range:now-1d..now", want: &Facet{Kind: FacetRange, Ranges: []FacetRangeBucket{{Key: "now-1d..now", From: "now-1d", To: "now"}}}},
+		{value: "histogram:50", want: &Facet{Kind: FacetHistogram, Interval: "50"}},
+		{value: "date_histogram:month", want: &Facet{Kind: FacetDateHistogram, Interval: "month"}},
+		{value: "terms:many", wantErr: true},
+		{value: "range:100", wantErr: true},
+		{value: "range:..", wantErr: true},
+		{value: "histogram:wide", wantErr: true},
+		{value: "date_histogram:fortnight", wantErr: true},
+		{value: "cloud", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := parseFacet(tt.value)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseFacet(%q) = %+v, want an error", tt.value, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("parseFacet(%q) = %+v, want %+v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFacetFilter(t *testing.T) {
+	prices := Facet{Name: "price", Field: "price", Kind: FacetRange, Ranges: []FacetRangeBucket{
+		{Key: "..100", To: 100.0}, {Key: "100..", From: 100.0},
+	}}
+	tests := []struct {
+		name     string
+		facet    Facet
+		selected []interface{}
+		want     string
+		wantErr  bool
+	}{
+		{name: "terms", facet: Facet{Name: "sku", Field: "sku", Kind: FacetTerms}, selected: []interface{}{"a", "b"}, want: `{"terms":{"sku":["a","b"]}}`},
+		{name: "open range", facet: prices, selected: []interface{}{"..100"}, want: `{"range":{"price":{"lt":100}}}`},
+		{name: "several ranges", facet: prices, selected: []interface{}{"..100", "100.."},
+			want: `{"bool":{"minimum_should_match":"1","should":[{"range":{"price":{"lt":100}}},{"range":{"price":{"gte":100}}}]}}`},
+		{name: "unknown range", facet: prices, selected: []interface{}{"5..6"}, wantErr: true},
+		{name: "histogram", facet: Facet{Name: "price", Field: "price", Kind: FacetHistogram, Interval: "50"}, selected: []interface{}{100.0}, want: `{"range":{"price":{"gte":100,"lt":150}}}`},
+		{name: "histogram key that is not a number", facet: Facet{Name: "price", Field: "price", Kind: FacetHistogram, Interval: "50"}, selected: []interface{}{"cheap"}, wantErr: true},
+		{name: "date histogram", facet: Facet{Name: "created_at", Field: "created_at", Kind: FacetDateHistogram, Interval: "month"}, selected: []interface{}{"2021-06-01"},
+			want: `{"range":{"created_at":{"gte":"2021-06-01","lt":"2021-06-01||+1M"}}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter, err := tt.facet.filter(tt.selected)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("filter() = %v, want an error", sourceJson(t, filter))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := sourceJson(t, filter); got != tt.want {
+				t.Fatalf("filter() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFacetedSearchKeepsThePostFilterOfTheSource(t *testing.T) {
+	tests := []struct {
+		name     string
+		selected map[string][]interface{}
+		want     string
+	}{
+		{name: "without selections", want: `"post_filter":{"bool":{"filter":[{"term":{"sku":"A-1"}}]}}`},
+		{name: "with a selection", selected: map[string][]interface{}{"tags": {"red"}},
+			want: `"post_filter":{"bool":{"filter":[{"term":{"sku":"A-1"}},{"terms":{"tags":["red"]}}]}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo, requests := newTestESRepo(t, func(esRequest) (int, string) { return http.StatusOK, emptySearchResponse },
+				WithESFacets(Facet{Name: "tags", Field: "tags", Kind: FacetTerms}))
+			source := NewSearchSource(Term("name", "lamp")).PostFilter(Term("sku", "A-1"))
+			if err, _ := repo.FacetedSearch(context.Background(), source, tt.selected); err != nil {
+				t.Fatal(err)
+			}
+			if body := (*requests)[0].body; !strings.Contains(body, tt.want) {
+				t.Fatalf("search body %v does not contain %v", body, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"github.com/kutty-kumar/charminder/pkg"
+)
+
+type GeoDistanceQuery struct {
+	field    string
+	origin   pkg.GeoPoint
+	distance string
+}
+
+// GeoDistance matches the points of field within distance of origin, distance carries its unit, e.g. "5km".
+func GeoDistance(field string, origin pkg.GeoPoint, distance string) *GeoDistanceQuery {
+	return &GeoDistanceQuery{field: field, origin: origin, distance: distance}
+}
+
+func (q *GeoDistanceQuery) Source() interface{} {
+	return map[string]interface{}{"geo_distance": map[string]interface{}{"distance": q.distance, q.field: q.origin}}
+}
+
+type GeoBoundingBoxQuery struct {
+	field       string
+	topLeft     pkg.GeoPoint
+	bottomRight pkg.GeoPoint
+}
+
+func GeoBoundingBox(field string, topLeft, bottomRight pkg.GeoPoint) *GeoBoundingBoxQuery {
+	return &GeoBoundingBoxQuery{field: field, topLeft: topLeft, bottomRight: bottomRight}
+}
+
+func (q *GeoBoundingBoxQuery) Source() interface{} {
+	box := map[string]interface{}{"top_left": q.topLeft, "bottom_right": q.bottomRight}
+	return map[string]interface{}{"geo_bounding_box": map[string]interface{}{q.field: box}}
+}
+
+type GeoPolygonQuery struct {
+	field  string
+	points []pkg.GeoPoint
+}
+
+// GeoPolygon matches the points of field inside the polygon with the given corners.
+func GeoPolygon(field string, points ...pkg.GeoPoint) *GeoPolygonQuery {
+	return &GeoPolygonQuery{field: field, points: points}
+}
+
+func (q *GeoPolygonQuery) Source() interface{} {
+	return map[string]interface{}{"geo_polygon": map[string]interface{}{q.field: map[string]interface{}{"points": q.points}}}
+}
+
+// SortByDistance sorts the hits by the distance of field to origin, nearest first, in unit such as "m" or "km".
+// Each hit of the result carries that distance.
+func (s *SearchSource) SortByDistance(field string, origin pkg.GeoPoint, unit string) *SearchSource {
+	source := map[string]interface{}{
+		"_geo_distance": map[string]interface{}{field: origin, "order": "asc", "unit": unit, "distance_type": "arc"},
+	}
+	s.sort = append(s.sort, sortField{source: source, distanceUnit: unit})
+	return s
+}
+
+// distanceSort is the position of the first distance sort, whose value is the distance of each hit.
+func (s *SearchSource) distanceSort() int {
+	for i, sort := range s.sort {
+		if sort.distanceUnit != "" {
+			return i
+		}
+	}
+	return -1
+}
+
+// NearSearch returns up to limit entities whose field lies within distance of origin, e.g. "2km", nearest first.
+// The distance of each hit is in meters.
+func (esr *ElasticsearchRepo) NearSearch(ctx context.Context, field string, origin pkg.GeoPoint, distance string, limit int) (err error, result *SearchResult) {
+	ctx, done := esr.instrument(ctx, "near_search")
+	defer done(&err)
+	if !origin.Valid() {
+		return fmt.Errorf("invalid origin %v", origin), nil
+	}
+	if limit <= 0 {
+		return fmt.Errorf("near search limit must be positive, got %v", limit), nil
+	}
+	source := NewSearchSource(Bool().Filter(GeoDistance(field, origin, distance))).Size(limit).SortByDistance(field, origin, "m")
+	response, err := esr.search(ctx, source)
+	if err != nil {
+		return err, nil
+	}
+	return nil, esr.searchResult(response, source)
+}
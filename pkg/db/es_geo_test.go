@@ -0,0 +1,119 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/kutty-kumar/charminder/pkg"
+)
+
+var (
+	berlin  = pkg.GeoPoint{Lat: 52.52, Lon: 13.405}
+	potsdam = pkg.GeoPoint{Lat: 52.39, Lon: 13.065}
+)
+
+func TestGeoQuerySource(t *testing.T) {
+	tests := []struct {
+		name  string
+		query Query
+		want  string
+	}{
+		{
+			name:  "distance",
+			query: GeoDistance("location", berlin, "5km"),
+			want:  `{"geo_distance":{"distance":"5km","location":{"lat":52.52,"lon":13.405}}}`,
+		},
+		{
+			name:  "bounding box",
+			query: GeoBoundingBox("location", berlin, potsdam),
+			want:  `{"geo_bounding_box":{"location":{"bottom_right":{"lat":52.39,"lon":13.065},"top_left":{"lat":52.52,"lon":13.405}}}}`,
+		},
+		{
+			name:  "polygon",
+			query: GeoPolygon("location", berlin, potsdam, pkg.GeoPoint{Lat: 52.4, Lon: 13.5}),
+			want:  `{"geo_polygon":{"location":{"points":[{"lat":52.52,"lon":13.405},{"lat":52.39,"lon":13.065},{"lat":52.4,"lon":13.5}]}}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sourceJson(t, tt.query); got != tt.want {
+				t.Fatalf("source = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSortByDistance(t *testing.T) {
+	source := NewSearchSource(nil)
+	if got := source.distanceSort(); got != -1 {
+		t.Fatalf("distanceSort() without a distance sort = %v, want -1", got)
+	}
+	source.Sort("rank", true).SortByDistance("location", berlin, "km").Sort("name", false)
+	if got := source.distanceSort(); got != 1 {
+		t.Fatalf("distanceSort() = %v, want the position of the distance sort", got)
+	}
+	out, err := json.Marshal(source.Source()["sort"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `[{"rank":{"order":"desc"}},` +
+		`{"_geo_distance":{"distance_type":"arc","location":{"lat":52.52,"lon":13.405},"order":"asc","unit":"km"}},` +
+		`{"name":{"order":"asc"}}]`
+	if string(out) != want {
+		t.Fatalf("sort = %s, want %s", out, want)
+	}
+}
+
+func TestSearchResultFillsTheDistance(t *testing.T) {
+	repo, _ := newTestESRepo(t, func(esRequest) (int, string) { return http.StatusOK, emptySearchResponse })
+	source := NewSearchSource(nil).Sort("rank", true).SortByDistance("location", berlin, "km")
+	var response ESSearchResponse
+	if err := json.Unmarshal([]byte(`{"hits":{"total":{"value":2,"relation":"eq"},"hits":[
+		{"_id":"a","_source":{"name":"near"},"sort":[3,1.5]},
+		{"_id":"b","_source":{"name":"unsorted"},"sort":[2]}
+	]}}`), &response); err != nil {
+		t.Fatal(err)
+	}
+	result := repo.searchResult(&response, source)
+	if distance := result.Hits[0].Distance; distance == nil || *distance != 1.5 {
+		t.Fatalf("distance of the first hit = %v, want 1.5", distance)
+	}
+	if distance := result.Hits[1].Distance; distance != nil {
+		t.Fatalf("distance of a hit without the sort value = %v, want nil", *distance)
+	}
+}
+
+func TestNearSearch(t *testing.T) {
+	repo, requests := newTestESRepo(t, func(esRequest) (int, string) {
+		return http.StatusOK, `{"hits":{"total":{"value":1,"relation":"eq"},"hits":[{"_id":"a","_source":{"name":"tower"},"sort":[812.5]}]}}`
+	})
+	err, result := repo.NearSearch(context.Background(), "location", berlin, "2km", 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if distance := result.Hits[0].Distance; distance == nil || *distance != 812.5 {
+		t.Fatalf("distance = %v, want 812.5", distance)
+	}
+	want := `{"query":{"bool":{"filter":[{"geo_distance":{"distance":"2km","location":{"lat":52.52,"lon":13.405}}}]}},"size":5,` +
+		`"sort":[{"_geo_distance":{"distance_type":"arc","location":{"lat":52.52,"lon":13.405},"order":"asc","unit":"m"}}]}`
+	if got := (*requests)[0].body; got != want {
+		t.Fatalf("body = %v\nwant %v", got, want)
+	}
+}
+
+func TestNearSearchRejectsBadArguments(t *testing.T) {
+	repo, requests := newTestESRepo(t, func(esRequest) (int, string) { return http.StatusOK, emptySearchResponse })
+	if err, _ := repo.NearSearch(context.Background(), "location", pkg.GeoPoint{Lat: 91}, "2km", 5); err == nil {
+		t.Fatal("NearSearch() with an invalid origin succeeded")
+	}
+	for _, limit := range []int{0, -1} {
+		if err, _ := repo.NearSearch(context.Background(), "location", berlin, "2km", limit); err == nil {
+			t.Fatalf("NearSearch() with limit %v succeeded", limit)
+		}
+	}
+	if len(*requests) != 0 {
+		t.Fatalf("rejected searches reached the cluster: %v", *requests)
+	}
+}
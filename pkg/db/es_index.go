@@ -0,0 +1,332 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/elastic/go-elasticsearch/v7"
+	"github.com/elastic/go-elasticsearch/v7/esapi"
+	"github.com/kutty-kumar/charminder/pkg"
+	"github.com/kutty-kumar/charminder/pkg/logging"
+	"net/http"
+	"regexp"
+	"strconv"
+)
+
+var ErrCountMismatch = errors.New("document count mismatch")
+
+var versionSuffix = regexp.MustCompile(`_v(\d+)$`)
+
+// VersionedIndexName is the physical index behind alias for a mapping version, e.g. users_v3.
+func VersionedIndexName(alias string, version int) string {
+	return fmt.Sprintf("%v_v%d", alias, version)
+}
+
+func indexVersion(alias, index string) (int, bool) {
+	match := versionSuffix.FindStringSubmatch(index)
+	if match == nil || index[:len(index)-len(match[0])] != alias {
+		return 0, false
+	}
+	version, err := strconv.Atoi(match[1])
+	return version, err == nil
+}
+
+// doRequest executes req and decodes a successful response into out, when out is not nil.
+func doRequest(ctx context.Context, client *elasticsearch.Client, operation string, req esapi.Request, out interface{}) error {
+	res, err := req.Do(ctx, client)
+	if err != nil {
+		return fmt.Errorf("%v: %w", operation, err)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return responseError(operation, res)
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(res.Body).Decode(out); err != nil {
+		return fmt.Errorf("decoding %v response: %w", operation, err)
+	}
+	return nil
+}
+
+// IndexManager maintains versioned physical indices behind an alias, so that mappings can change without
+// downtime: readers and writers only ever address the alias.
+type IndexManager struct {
+	client *elasticsearch.Client
+	logger logging.Logger
+}
+
+type IndexManagerOption func(m *IndexManager)
+
+func WithIndexManagerLogger(logger logging.Logger) IndexManagerOption {
+	return func(m *IndexManager) {
+		m.logger = logging.OrNop(logger)
+	}
+}
+
+func NewIndexManager(client *elasticsearch.Client, opts ...IndexManagerOption) (*IndexManager, error) {
+	if client == nil {
+		return nil, fmt.Errorf("index manager: %w, the client is nil", pkg.ErrMissingOption)
+	}
+	m := &IndexManager{client: client, logger: logging.Nop()}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m, nil
+}
+
+func (m *IndexManager) Exists(ctx context.Context, index string) (bool, error) {
+	res, err := esapi.IndicesExistsRequest{Index: []string{index}}.Do(ctx, m.client)
+	if err != nil {
+		return false, fmt.Errorf("checking index %v: %w", index, err)
+	}
+	defer res.Body.Close()
+	switch res.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	}
+	return false, responseError("checking index "+index, res)
+}
+
+// CurrentIndex returns the highest versioned index alias points to, or an empty name when the alias does not
+// exist yet.
+func (m *IndexManager) CurrentIndex(ctx context.Context, alias string) (string, int, error) {
+	res, err := esapi.IndicesGetAliasRequest{Name: []string{alias}}.Do(ctx, m.client)
+	if err != nil {
+		return "", 0, fmt.Errorf("getting alias %v: %w", alias, err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return "", 0, nil
+	}
+	if res.IsError() {
+		return "", 0, responseError("getting alias "+alias, res)
+	}
+	var indices map[string]interface{}
+	if err := json.NewDecoder(res.Body).Decode(&indices); err != nil {
+		return "", 0, fmt.Errorf("decoding alias %v: %w", alias, err)
+	}
+	current, currentVersion := "", 0
+	for index := range indices {
+		version, ok := indexVersion(alias, index)
+		if !ok {
+			return "", 0, fmt.Errorf("alias %v points to %v, which is not a versioned index", alias, index)
+		}
+		if version > currentVersion {
+			current, currentVersion = index, version
+		}
+	}
+	return current, currentVersion, nil
+}
+
+func (m *IndexManager) CreateIndex(ctx context.Context, index string, body []byte) error {
+	return doRequest(ctx, m.client, "creating index "+index, esapi.IndicesCreateRequest{Index: index, Body: bytes.NewReader(body)}, nil)
+}
+
+func (m *IndexManager) DeleteIndex(ctx context.Context, index string) error {
+	return doRequest(ctx, m.client, "deleting index "+index, esapi.IndicesDeleteRequest{Index: []string{index}}, nil)
+}
+
+func (m *IndexManager) Refresh(ctx context.Context, index string) error {
+	return doRequest(ctx, m.client, "refreshing index "+index, esapi.IndicesRefreshRequest{Index: []string{index}}, nil)
+}
+
+func (m *IndexManager) Count(ctx context.Context, index string) (int64, error) {
+	var response struct {
+		Count int64 `json:"count"`
+	}
+	if err := doRequest(ctx, m.client, "counting "+index, esapi.CountRequest{Index: []string{index}}, &response); err != nil {
+		return 0, err
+	}
+	return response.Count, nil
+}
+
+// SwapAlias points alias at to and away from from in one atomic request. An empty from only adds the alias.
+// A concrete index named like the alias, left over from before aliases were used, is removed in the same request.
+func (m *IndexManager) SwapAlias(ctx context.Context, alias, from, to string) error {
+	actions := []interface{}{
+		map[string]interface{}{"add": map[string]interface{}{"index": to, "alias": alias}},
+	}
+	switch {
+	case from == alias:
+		actions = append(actions, map[string]interface{}{"remove_index": map[string]interface{}{"index": from}})
+	case from != "":
+		actions = append(actions, map[string]interface{}{"remove": map[string]interface{}{"index": from, "alias": alias}})
+	}
+	body, err := json.Marshal(map[string]interface{}{"actions": actions})
+	if err != nil {
+		return err
+	}
+	return doRequest(ctx, m.client, "swapping alias "+alias, esapi.IndicesUpdateAliasesRequest{Body: bytes.NewReader(body)}, nil)
+}
+
+// CopyIndex copies every document of from into to with the _reindex API and waits for it to finish.
+func (m *IndexManager) CopyIndex(ctx context.Context, from, to string) (int64, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"source": map[string]interface{}{"index": from},
+		"dest":   map[string]interface{}{"index": to},
+	})
+	if err != nil {
+		return 0, err
+	}
+	truthy := true
+	var response struct {
+		Total    int64             `json:"total"`
+		Failures []json.RawMessage `json:"failures"`
+	}
+	req := esapi.ReindexRequest{Body: bytes.NewReader(body), WaitForCompletion: &truthy, Refresh: &truthy}
+	if err := doRequest(ctx, m.client, fmt.Sprintf("reindexing %v into %v", from, to), req, &response); err != nil {
+		return 0, err
+	}
+	if len(response.Failures) > 0 {
+		return response.Total, fmt.Errorf("reindexing %v into %v: %v failures, first: %s", from, to, len(response.Failures), response.Failures[0])
+	}
+	return response.Total, nil
+}
+
+type reindexConfig struct {
+	source      func(ctx context.Context, indexer *BulkIndexer) error
+	bulkOptions []BulkIndexerOption
+	deleteOld   bool
+}
+
+type ReindexOption func(c *reindexConfig)
+
+// WithReindexSource fills the new index from the source of truth, e.g. the database, instead of copying the
+// current index. The indexer already targets the new index; the count check expects every item to succeed.
+func WithReindexSource(source func(ctx context.Context, indexer *BulkIndexer) error) ReindexOption {
+	return func(c *reindexConfig) {
+		c.source = source
+	}
+}
+
+func WithReindexBulkOptions(opts ...BulkIndexerOption) ReindexOption {
+	return func(c *reindexConfig) {
+		c.bulkOptions = append(c.bulkOptions, opts...)
+	}
+}
+
+// WithDeleteOldIndex deletes the previous index once the alias has moved. Reindex requires it to migrate a
+// concrete index named like the alias, since the alias can only take that name once the index is removed.
+func WithDeleteOldIndex() ReindexOption {
+	return func(c *reindexConfig) {
+		c.deleteOld = true
+	}
+}
+
+type ReindexResult struct {
+	Alias         string
+	PreviousIndex string
+	Index         string
+	Version       int
+	Documents     int64
+	OldDeleted    bool
+}
+
+// Reindex creates the next versioned index with body, fills it, checks the document count, then moves alias to
+// it. On failure the alias is left untouched and the new index is kept for inspection; the next run skips past
+// the versions that already exist, so the failed index can be deleted later.
+//
+// Writes keep going to the previous index until the alias moves, and the copy is a snapshot: a document written,
+// updated or deleted after the copy starts is not carried over. Writers must pause for the duration of Reindex,
+// or the changes made in the meantime must be replayed through the alias once it returns.
+func (m *IndexManager) Reindex(ctx context.Context, alias string, body []byte, opts ...ReindexOption) (*ReindexResult, error) {
+	config := &reindexConfig{}
+	for _, opt := range opts {
+		opt(config)
+	}
+	previous, version, err := m.CurrentIndex(ctx, alias)
+	if err != nil {
+		return nil, err
+	}
+	if previous == "" {
+		legacy, err := m.Exists(ctx, alias)
+		if err != nil {
+			return nil, err
+		}
+		if legacy {
+			if !config.deleteOld {
+				return nil, fmt.Errorf("%v is an index, not an alias: migrating it deletes it, pass WithDeleteOldIndex", alias)
+			}
+			previous = alias
+		}
+	}
+	for version++; ; version++ {
+		exists, err := m.Exists(ctx, VersionedIndexName(alias, version))
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			break
+		}
+		m.logger.Warn(ctx, "skipping existing index", logging.F("alias", alias), logging.F("index", VersionedIndexName(alias, version)))
+	}
+	result := &ReindexResult{Alias: alias, PreviousIndex: previous, Version: version, Index: VersionedIndexName(alias, version)}
+	if err := m.CreateIndex(ctx, result.Index, body); err != nil {
+		return nil, err
+	}
+	m.logger.Info(ctx, "reindexing", logging.F("alias", alias), logging.F("from", previous), logging.F("to", result.Index))
+
+	var expected int64
+	switch {
+	case config.source != nil:
+		if expected, err = m.fill(ctx, result.Index, config); err != nil {
+			return result, err
+		}
+	case previous != "":
+		if expected, err = m.Count(ctx, previous); err != nil {
+			return result, err
+		}
+		if _, err := m.CopyIndex(ctx, previous, result.Index); err != nil {
+			return result, err
+		}
+	}
+	if err := m.Refresh(ctx, result.Index); err != nil {
+		return result, err
+	}
+	if result.Documents, err = m.Count(ctx, result.Index); err != nil {
+		return result, err
+	}
+	if result.Documents != expected {
+		return result, fmt.Errorf("%w: %v has %v documents, expected %v", ErrCountMismatch, result.Index, result.Documents, expected)
+	}
+
+	if err := m.SwapAlias(ctx, alias, previous, result.Index); err != nil {
+		return result, err
+	}
+	m.logger.Info(ctx, "alias swapped", logging.F("alias", alias), logging.F("index", result.Index), logging.F("documents", result.Documents))
+	if previous == alias {
+		result.OldDeleted = true
+	} else if config.deleteOld && previous != "" {
+		if err := m.DeleteIndex(ctx, previous); err != nil {
+			return result, err
+		}
+		result.OldDeleted = true
+	}
+	return result, nil
+}
+
+func (m *IndexManager) fill(ctx context.Context, index string, config *reindexConfig) (int64, error) {
+	opts := append([]BulkIndexerOption{WithBulkLogger(m.logger)}, config.bulkOptions...)
+	indexer, err := NewBulkIndexer(m.client, append(opts, WithBulkIndex(index))...)
+	if err != nil {
+		return 0, err
+	}
+	if err := config.source(ctx, indexer); err != nil {
+		_ = indexer.Close(ctx)
+		return 0, fmt.Errorf("filling %v: %w", index, err)
+	}
+	if err := indexer.Close(ctx); err != nil {
+		return 0, err
+	}
+	stats := indexer.Stats()
+	if stats.Failed > 0 {
+		return 0, fmt.Errorf("filling %v: %v of %v documents failed", index, stats.Failed, stats.Added)
+	}
+	return int64(stats.Succeeded), nil
+}
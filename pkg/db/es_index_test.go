@@ -0,0 +1,104 @@
+package db
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/elastic/go-elasticsearch/v7"
+)
+
+func newTestIndexManager(t *testing.T, respond func(r esRequest) (int, string)) (*IndexManager, *[]esRequest) {
+	t.Helper()
+	var requests []esRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		request := esRequest{method: r.Method, path: r.URL.Path, query: r.URL.Query(), body: string(body)}
+		requests = append(requests, request)
+		status, response := respond(request)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(response))
+	}))
+	t.Cleanup(server.Close)
+	client, err := elasticsearch.NewClient(elasticsearch.Config{Addresses: []string{server.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager, err := NewIndexManager(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return manager, &requests
+}
+
+// legacyCluster holds a concrete users index with 3 documents and no alias, plus the indices in existing.
+func legacyCluster(existing ...string) func(r esRequest) (int, string) {
+	return func(r esRequest) (int, string) {
+		switch {
+		case r.path == "/_alias/users":
+			return http.StatusNotFound, `{}`
+		case r.method == http.MethodHead && r.path == "/users":
+			return http.StatusOK, ``
+		case r.method == http.MethodHead:
+			for _, index := range existing {
+				if r.path == "/"+index {
+					return http.StatusOK, ``
+				}
+			}
+			return http.StatusNotFound, ``
+		case strings.HasSuffix(r.path, "/_count"):
+			return http.StatusOK, `{"count":3}`
+		case r.path == "/_reindex":
+			return http.StatusOK, `{"total":3,"failures":[]}`
+		}
+		return http.StatusOK, `{"acknowledged":true}`
+	}
+}
+
+func TestReindexMigratesALegacyIndex(t *testing.T) {
+	manager, requests := newTestIndexManager(t, legacyCluster())
+	result, err := manager.Reindex(context.Background(), "users", []byte(`{}`), WithDeleteOldIndex())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.PreviousIndex != "users" || result.Index != "users_v1" || result.Documents != 3 || !result.OldDeleted {
+		t.Fatalf("result = %+v", result)
+	}
+	last := (*requests)[len(*requests)-1]
+	want := `{"actions":[{"add":{"alias":"users","index":"users_v1"}},{"remove_index":{"index":"users"}}]}`
+	if last.path != "/_aliases" || last.body != want {
+		t.Fatalf("last request = %v %v, want the alias swap %v", last.path, last.body, want)
+	}
+}
+
+func TestReindexRequiresDeleteOldIndexForALegacyIndex(t *testing.T) {
+	manager, requests := newTestIndexManager(t, legacyCluster())
+	if _, err := manager.Reindex(context.Background(), "users", []byte(`{}`)); err == nil {
+		t.Fatal("Reindex() of a legacy index without WithDeleteOldIndex succeeded")
+	}
+	for _, request := range *requests {
+		if request.method != http.MethodGet && request.method != http.MethodHead {
+			t.Fatalf("the rejected migration changed the cluster: %v %v", request.method, request.path)
+		}
+	}
+}
+
+func TestReindexSkipsExistingVersions(t *testing.T) {
+	manager, requests := newTestIndexManager(t, legacyCluster("users_v1", "users_v2"))
+	result, err := manager.Reindex(context.Background(), "users", []byte(`{}`), WithDeleteOldIndex())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Index != "users_v3" || result.Version != 3 {
+		t.Fatalf("result = %+v, want users_v3 past the indices left by failed runs", result)
+	}
+	for _, request := range *requests {
+		if request.method == http.MethodPut && request.path != "/users_v3" {
+			t.Fatalf("created %v, want only users_v3", request.path)
+		}
+	}
+}
@@ -0,0 +1,547 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/elastic/go-elasticsearch/v7/esapi"
+	"github.com/kutty-kumar/charminder/pkg"
+	"github.com/kutty-kumar/charminder/pkg/util/fieldcrypt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	geoPointType = reflect.TypeOf(pkg.GeoPoint{})
+)
+
+// Property is the mapping of one field. Object fields carry Properties and no Type, multi-fields carry Fields.
+type Property struct {
+	Type           string              `json:"type,omitempty"`
+	Index          *bool               `json:"index,omitempty"`
+	Format         string              `json:"format,omitempty"`
+	Analyzer       string              `json:"analyzer,omitempty"`
+	SearchAnalyzer string              `json:"search_analyzer,omitempty"`
+	Normalizer     string              `json:"normalizer,omitempty"`
+	IgnoreAbove    int                 `json:"ignore_above,omitempty"`
+	CopyTo         []string            `json:"copy_to,omitempty"`
+	Contexts       []CompletionContext `json:"contexts,omitempty"`
+	Fields         map[string]Property `json:"fields,omitempty"`
+	Properties     map[string]Property `json:"properties,omitempty"`
+	// facet is declared by the facet tag option, it is not part of the mapping.
+	facet *Facet
+}
+
+// CompletionContext lets completion suggestions be filtered by the value of the field at Path, e.g. a tenant.
+type CompletionContext struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	Path string `json:"path,omitempty"`
+}
+
+// SuggestSubfield is the subfield the autocomplete tag option adds.
+const SuggestSubfield = "suggest"
+
+func (p Property) typeName() string {
+	if p.Type == "" {
+		return "object"
+	}
+	return p.Type
+}
+
+type Mapping struct {
+	Dynamic    string              `json:"dynamic,omitempty"`
+	Properties map[string]Property `json:"properties"`
+}
+
+// GenerateMapping derives the mapping of entity from its fields, named like encoding/json names them. The es tag
+// overrides the inferred mapping, options are comma separated:
+//
+//	es:"-"                                   not indexed at all
+//	es:"keyword"                             a bare word sets the type, e.g. keyword, text, date, nested, object
+//	es:"text,analyzer=english,search_analyzer=standard"
+//	es:"text,fields=raw:keyword|english:text:english"   subfields as name:type[:analyzer], fields= for none
+//	es:"date,format=yyyy-MM-dd"
+//	es:"keyword,index=false,ignore_above=256,normalizer=lowercase"
+//	es:"text,copy_to=all_text|suggest"
+//	es:"autocomplete"                        adds a search_as_you_type suggest subfield
+//	es:"autocomplete=completion,contexts=tenant|status"   a completion suggest subfield, filterable by those fields
+//	es:"keyword,facet"                       a terms facet, facet=terms:20 returns 20 buckets
+//	es:"float,facet=range:..100|100..500|500.."          range buckets, an empty bound is open
+//	es:"float,facet=histogram:50"            buckets of 50
+//	es:"date,facet=date_histogram:month"     calendar buckets: minute, hour, day, week, month, quarter or year
+//
+// Strings default to text with a keyword subfield, time.Time to date, pkg.GeoPoint to geo_point, structs to object
+// and pointers to their element. Encrypted fields are left out.
+func GenerateMapping(entity interface{}) (*Mapping, error) {
+	t := reflect.TypeOf(entity)
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("mapping requires a struct, got %v", t)
+	}
+	properties, err := structProperties(t)
+	if err != nil {
+		return nil, err
+	}
+	return &Mapping{Properties: properties}, nil
+}
+
+// jsonName follows encoding/json: the json tag name, or the Go field name when the tag has none.
+func jsonName(field reflect.StructField) (string, bool) {
+	tag := field.Tag.Get("json")
+	if tag == "-" {
+		return "", false
+	}
+	if name := strings.Split(tag, ",")[0]; name != "" {
+		return name, true
+	}
+	return field.Name, true
+}
+
+func structProperties(t reflect.Type) (map[string]Property, error) {
+	properties := make(map[string]Property)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if fieldcrypt.IsEncryptedField(field) || field.Tag.Get("es") == "-" {
+			continue
+		}
+		fieldType := field.Type
+		for fieldType.Kind() == reflect.Ptr {
+			fieldType = fieldType.Elem()
+		}
+		if field.Anonymous && fieldType.Kind() == reflect.Struct && strings.Split(field.Tag.Get("json"), ",")[0] == "" {
+			embedded, err := structProperties(fieldType)
+			if err != nil {
+				return nil, err
+			}
+			for name, property := range embedded {
+				if _, ok := properties[name]; !ok {
+					properties[name] = property
+				}
+			}
+			continue
+		}
+		if field.PkgPath != "" {
+			continue
+		}
+		name, ok := jsonName(field)
+		if !ok {
+			continue
+		}
+		property, err := fieldProperty(field, fieldType)
+		if err != nil {
+			return nil, fmt.Errorf("field %v: %w", field.Name, err)
+		}
+		if property != nil {
+			properties[name] = *property
+		}
+	}
+	return properties, nil
+}
+
+// inferredType is the mapping type of t without any tag, empty for structs.
+func inferredType(t reflect.Type) string {
+	if t == timeType || (t.Kind() == reflect.Struct && t.ConvertibleTo(timeType)) {
+		return "date"
+	}
+	if t == geoPointType {
+		return "geo_point"
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return "text"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int8:
+		return "byte"
+	case reflect.Int16:
+		return "short"
+	case reflect.Int, reflect.Int32, reflect.Uint8, reflect.Uint16:
+		return "integer"
+	case reflect.Int64, reflect.Uint, reflect.Uint32, reflect.Uint64:
+		return "long"
+	case reflect.Float32, reflect.Float64:
+		return "float"
+	case reflect.Map, reflect.Interface:
+		return "object"
+	}
+	return ""
+}
+
+// legacyTypes maps the values of the older type tag onto mapping types.
+var legacyTypes = map[string]string{"int": "integer", "bool": "boolean"}
+
+func fieldProperty(field reflect.StructField, t reflect.Type) (*Property, error) {
+	if t.Kind() == reflect.Chan || t.Kind() == reflect.Func {
+		return nil, nil
+	}
+	element := t
+	if t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Property{Type: "binary"}, nil
+		}
+		element = t.Elem()
+		for element.Kind() == reflect.Ptr {
+			element = element.Elem()
+		}
+	}
+	property := &Property{Type: inferredType(element)}
+	if legacy := field.Tag.Get("type"); legacy != "" {
+		if mapped, ok := legacyTypes[legacy]; ok {
+			legacy = mapped
+		}
+		property.Type = legacy
+	}
+	property.Analyzer = field.Tag.Get("analyzer")
+	property.SearchAnalyzer = field.Tag.Get("search_analyzer")
+	explicitFields := false
+	var autocomplete string
+	var contexts []string
+	if tag := field.Tag.Get("es"); tag != "" {
+		for _, option := range strings.Split(tag, ",") {
+			option = strings.TrimSpace(option)
+			key, value := option, ""
+			if idx := strings.Index(option, "="); idx >= 0 {
+				key, value = option[:idx], option[idx+1:]
+			}
+			switch key {
+			case "":
+			case "analyzer":
+				property.Analyzer = value
+			case "search_analyzer":
+				property.SearchAnalyzer = value
+			case "normalizer":
+				property.Normalizer = value
+			case "format":
+				property.Format = value
+			case "copy_to":
+				property.CopyTo = strings.Split(value, "|")
+			case "index":
+				indexed, err := strconv.ParseBool(value)
+				if err != nil {
+					return nil, fmt.Errorf("index=%v: %w", value, err)
+				}
+				property.Index = &indexed
+			case "ignore_above":
+				ignoreAbove, err := strconv.Atoi(value)
+				if err != nil {
+					return nil, fmt.Errorf("ignore_above=%v: %w", value, err)
+				}
+				property.IgnoreAbove = ignoreAbove
+			case "autocomplete":
+				autocomplete = value
+				if autocomplete == "" {
+					autocomplete = "search_as_you_type"
+				}
+				if autocomplete != "search_as_you_type" && autocomplete != "completion" {
+					return nil, fmt.Errorf("autocomplete=%v, expected search_as_you_type or completion", value)
+				}
+			case "facet":
+				facet, err := parseFacet(value)
+				if err != nil {
+					return nil, err
+				}
+				property.facet = facet
+			case "contexts":
+				contexts = strings.Split(value, "|")
+			case "fields":
+				subfields, err := parseSubfields(value)
+				if err != nil {
+					return nil, err
+				}
+				property.Fields = subfields
+				explicitFields = true
+			default:
+				if value != "" {
+					return nil, fmt.Errorf("unknown es tag option %v", key)
+				}
+				property.Type = key
+			}
+		}
+	}
+	switch property.Type {
+	case "", "object", "nested":
+		if element.Kind() == reflect.Struct {
+			properties, err := structProperties(element)
+			if err != nil {
+				return nil, err
+			}
+			property.Properties = properties
+		}
+		if property.Type == "" && property.Properties == nil {
+			property.Type = "object"
+		} else if property.Type == "object" && property.Properties != nil {
+			property.Type = ""
+		}
+	case "text":
+		if !explicitFields {
+			property.Fields = map[string]Property{"keyword": {Type: "keyword"}}
+		}
+	}
+	if autocomplete != "" {
+		if err := addSuggestSubfield(property, autocomplete, contexts); err != nil {
+			return nil, err
+		}
+	} else if contexts != nil {
+		return nil, fmt.Errorf("contexts needs autocomplete=completion")
+	}
+	return property, nil
+}
+
+func addSuggestSubfield(property *Property, autocomplete string, contexts []string) error {
+	if property.Type != "text" && property.Type != "keyword" {
+		return fmt.Errorf("autocomplete needs a text or keyword field, not %v", property.typeName())
+	}
+	suggest := Property{Type: autocomplete, Analyzer: property.Analyzer}
+	if autocomplete == "completion" {
+		// Elasticsearch reports the default analyzer of completion fields, naming it keeps DiffMapping quiet.
+		if suggest.Analyzer == "" {
+			suggest.Analyzer = "simple"
+		}
+		for _, context := range contexts {
+			suggest.Contexts = append(suggest.Contexts, CompletionContext{Name: context, Type: "category", Path: context})
+		}
+	} else if contexts != nil {
+		return fmt.Errorf("contexts needs autocomplete=completion")
+	}
+	if property.Fields == nil {
+		property.Fields = make(map[string]Property)
+	}
+	property.Fields[SuggestSubfield] = suggest
+	return nil
+}
+
+func parseSubfields(value string) (map[string]Property, error) {
+	if value == "" {
+		return nil, nil
+	}
+	subfields := make(map[string]Property)
+	for _, spec := range strings.Split(value, "|") {
+		parts := strings.Split(spec, ":")
+		if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("subfield %q must be name:type[:analyzer]", spec)
+		}
+		subfield := Property{Type: parts[1]}
+		if len(parts) == 3 {
+			subfield.Analyzer = parts[2]
+		}
+		subfields[parts[0]] = subfield
+	}
+	return subfields, nil
+}
+
+// textFields lists the dotted paths of the text fields outside nested objects, with their analysis.
+func (m *Mapping) textFields() map[string]FieldAnalysis {
+	fields := make(map[string]FieldAnalysis)
+	var walk func(prefix string, properties map[string]Property)
+	walk = func(prefix string, properties map[string]Property) {
+		for name, property := range properties {
+			switch property.typeName() {
+			case "text":
+				fields[prefix+name] = FieldAnalysis{Analyzer: property.Analyzer, SearchAnalyzer: property.SearchAnalyzer}
+			case "object":
+				walk(prefix+name+".", property.Properties)
+			}
+		}
+	}
+	walk("", m.Properties)
+	return fields
+}
+
+// exactFields maps the dotted paths of the fields outside nested objects onto the field that matches them exactly:
+// the keyword subfield of text fields and the field itself otherwise. Text fields without a keyword subfield are
+// left out.
+func (m *Mapping) exactFields() map[string]string {
+	fields := make(map[string]string)
+	var walk func(prefix string, properties map[string]Property)
+	walk = func(prefix string, properties map[string]Property) {
+		for name, property := range properties {
+			path := prefix + name
+			switch property.typeName() {
+			case "text":
+				if property.Fields["keyword"].Type == "keyword" {
+					fields[path] = path + ".keyword"
+				}
+			case "object":
+				walk(path+".", property.Properties)
+			case "nested":
+			default:
+				fields[path] = path
+			}
+		}
+	}
+	walk("", m.Properties)
+	return fields
+}
+
+type autocompleteField struct {
+	kind     string
+	contexts []string
+}
+
+// autocompleteFields lists the dotted paths of the fields with a suggest subfield, outside nested objects.
+func (m *Mapping) autocompleteFields() map[string]autocompleteField {
+	fields := make(map[string]autocompleteField)
+	var walk func(prefix string, properties map[string]Property)
+	walk = func(prefix string, properties map[string]Property) {
+		for name, property := range properties {
+			if suggest, ok := property.Fields[SuggestSubfield]; ok {
+				field := autocompleteField{kind: suggest.Type}
+				for _, context := range suggest.Contexts {
+					field.contexts = append(field.contexts, context.Name)
+				}
+				fields[prefix+name] = field
+			}
+			if property.typeName() == "object" {
+				walk(prefix+name+".", property.Properties)
+			}
+		}
+	}
+	walk("", m.Properties)
+	return fields
+}
+
+type MappingChangeKind string
+
+const (
+	MappingFieldAdded   MappingChangeKind = "added"
+	MappingFieldRemoved MappingChangeKind = "removed"
+	MappingFieldChanged MappingChangeKind = "changed"
+)
+
+// MappingChange describes one difference between the live and the generated mapping. Incompatible changes can
+// only be applied by reindexing into a new index.
+type MappingChange struct {
+	Path       string
+	Kind       MappingChangeKind
+	Attribute  string
+	Live       interface{}
+	Generated  interface{}
+	Compatible bool
+}
+
+func (c MappingChange) String() string {
+	verdict := "compatible"
+	if !c.Compatible {
+		verdict = "incompatible"
+	}
+	if c.Kind != MappingFieldChanged {
+		return fmt.Sprintf("%v %v (%v)", c.Path, c.Kind, verdict)
+	}
+	return fmt.Sprintf("%v %v changed from %v to %v (%v)", c.Path, c.Attribute, c.Live, c.Generated, verdict)
+}
+
+type MappingDiff struct {
+	Index   string
+	Changes []MappingChange
+}
+
+func (d *MappingDiff) Incompatible() []MappingChange {
+	var incompatible []MappingChange
+	for _, change := range d.Changes {
+		if !change.Compatible {
+			incompatible = append(incompatible, change)
+		}
+	}
+	return incompatible
+}
+
+func (d *MappingDiff) Compatible() bool {
+	return len(d.Incompatible()) == 0
+}
+
+// CompareMappings lists how generated differs from live. New fields and subfields, copy_to, ignore_above and
+// search_analyzer can be applied to the live index; anything else needs a reindex. Fields only present in the
+// live mapping are reported as compatible, they simply stay in the index.
+func CompareMappings(live, generated *Mapping) []MappingChange {
+	var changes []MappingChange
+	compareProperties("", live.Properties, generated.Properties, &changes)
+	sort.SliceStable(changes, func(i, j int) bool {
+		return changes[i].Path < changes[j].Path
+	})
+	return changes
+}
+
+func compareProperties(prefix string, live, generated map[string]Property, changes *[]MappingChange) {
+	for name, generatedProperty := range generated {
+		path := prefix + name
+		liveProperty, ok := live[name]
+		if !ok {
+			*changes = append(*changes, MappingChange{Path: path, Kind: MappingFieldAdded, Compatible: true})
+			continue
+		}
+		compareProperty(path, liveProperty, generatedProperty, changes)
+	}
+	for name := range live {
+		if _, ok := generated[name]; !ok {
+			*changes = append(*changes, MappingChange{Path: prefix + name, Kind: MappingFieldRemoved, Compatible: true})
+		}
+	}
+}
+
+func compareProperty(path string, live, generated Property, changes *[]MappingChange) {
+	changed := func(attribute string, liveValue, generatedValue interface{}, compatible bool) {
+		if !reflect.DeepEqual(liveValue, generatedValue) {
+			*changes = append(*changes, MappingChange{Path: path, Kind: MappingFieldChanged, Attribute: attribute, Live: liveValue, Generated: generatedValue, Compatible: compatible})
+		}
+	}
+	if live.typeName() != generated.typeName() {
+		changed("type", live.typeName(), generated.typeName(), false)
+		return
+	}
+	changed("index", indexed(live.Index), indexed(generated.Index), false)
+	changed("format", live.Format, generated.Format, false)
+	changed("analyzer", live.Analyzer, generated.Analyzer, false)
+	changed("normalizer", live.Normalizer, generated.Normalizer, false)
+	changed("contexts", live.Contexts, generated.Contexts, false)
+	changed("search_analyzer", live.SearchAnalyzer, generated.SearchAnalyzer, true)
+	changed("ignore_above", live.IgnoreAbove, generated.IgnoreAbove, true)
+	changed("copy_to", strings.Join(live.CopyTo, ","), strings.Join(generated.CopyTo, ","), true)
+	compareProperties(path+".", live.Fields, generated.Fields, changes)
+	compareProperties(path+".", live.Properties, generated.Properties, changes)
+}
+
+func indexed(index *bool) bool {
+	return index == nil || *index
+}
+
+// DiffMapping compares the mapping generated from WithDefaultEntity with the one of the live index, or of the
+// index behind the alias.
+func (esr *ElasticsearchRepo) DiffMapping(ctx context.Context) (err error, diff *MappingDiff) {
+	ctx, done := esr.instrument(ctx, "diff_mapping")
+	defer done(&err)
+	generated, err := esr.mapping()
+	if err != nil {
+		return err, nil
+	}
+	var response map[string]struct {
+		Mappings Mapping `json:"mappings"`
+	}
+	if err := doRequest(ctx, esr.client, "getting mapping of "+esr.index, esapi.IndicesGetMappingRequest{Index: []string{esr.index}}, &response); err != nil {
+		return err, nil
+	}
+	indices := make([]string, 0, len(response))
+	for index := range response {
+		indices = append(indices, index)
+	}
+	sort.Strings(indices)
+	if len(indices) == 0 {
+		return fmt.Errorf("no mapping found for %v", esr.index), nil
+	}
+	live := response[indices[len(indices)-1]].Mappings
+	return nil, &MappingDiff{Index: indices[len(indices)-1], Changes: CompareMappings(&live, generated)}
+}
+
+func (m *Mapping) String() string {
+	out, err := json.Marshal(m)
+	if err != nil {
+		return fmt.Sprintf("invalid mapping: %v", err)
+	}
+	return string(out)
+}
@@ -0,0 +1,245 @@
+package db
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/kutty-kumar/charminder/pkg"
+)
+
+type mappingBase struct {
+	Tenant string `json:"tenant" es:"keyword"`
+}
+
+type mappingAuthor struct {
+	Name  string `json:"name" es:"keyword"`
+	Email string `json:"email" encrypt:"true"`
+}
+
+type mappingComment struct {
+	Text   string    `json:"text" es:"text,fields="`
+	Posted time.Time `json:"posted"`
+}
+
+type mappingEntity struct {
+	mappingBase
+	Id       string           `json:"id" es:"keyword"`
+	Title    string           `json:"title"`
+	Body     string           `json:"body" es:"text,analyzer=english,search_analyzer=standard,fields=raw:keyword|english:text:english"`
+	Created  time.Time        `json:"created"`
+	Updated  *time.Time       `json:"updated_at" es:"date,format=yyyy-MM-dd"`
+	Code     string           `json:"code" es:"keyword,index=false,ignore_above=256,normalizer=lowercase"`
+	Active   bool             `json:"active"`
+	Count    int64            // no json tag, named like the field
+	Level    int              `json:"level" type:"int"`
+	Tags     []string         `json:"tags" es:"keyword,copy_to=all_text"`
+	Raw      []byte           `json:"raw"`
+	Location pkg.GeoPoint     `json:"location"`
+	Author   *mappingAuthor   `json:"author"`
+	Comments []mappingComment `json:"comments" es:"nested"`
+	Extra    map[string]int   `json:"extra"`
+	Name     string           `json:"name" es:"text,autocomplete=completion,contexts=tenant"`
+	Skipped  string           `json:"skipped" es:"-"`
+	Ignored  string           `json:"-"`
+	Secret   string           `json:"secret" encrypt:"true"`
+	Notify   func()           `json:"notify"`
+	internal string
+}
+
+func TestGenerateMapping(t *testing.T) {
+	notIndexed := false
+	keywordSubfield := map[string]Property{"keyword": {Type: "keyword"}}
+	want := &Mapping{Properties: map[string]Property{
+		"tenant": {Type: "keyword"},
+		"id":     {Type: "keyword"},
+		"title":  {Type: "text", Fields: keywordSubfield},
+		"body": {Type: "text", Analyzer: "english", SearchAnalyzer: "standard", Fields: map[string]Property{
+			"raw": {Type: "keyword"}, "english": {Type: "text", Analyzer: "english"},
+		}},
+		"created":    {Type: "date"},
+		"updated_at": {Type: "date", Format: "yyyy-MM-dd"},
+		"code":       {Type: "keyword", Index: &notIndexed, IgnoreAbove: 256, Normalizer: "lowercase"},
+		"active":     {Type: "boolean"},
+		"Count":      {Type: "long"},
+		"level":      {Type: "integer"},
+		"tags":       {Type: "keyword", CopyTo: []string{"all_text"}},
+		"raw":        {Type: "binary"},
+		"location":   {Type: "geo_point"},
+		"author":     {Properties: map[string]Property{"name": {Type: "keyword"}}},
+		"comments": {Type: "nested", Properties: map[string]Property{
+			"text": {Type: "text"}, "posted": {Type: "date"},
+		}},
+		"extra": {Type: "object"},
+		"name": {Type: "text", Fields: map[string]Property{
+			"keyword": {Type: "keyword"},
+			SuggestSubfield: {Type: "completion", Analyzer: "simple", Contexts: []CompletionContext{
+				{Name: "tenant", Type: "category", Path: "tenant"},
+			}},
+		}},
+	}}
+	got, err := GenerateMapping(&mappingEntity{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("GenerateMapping() = %v\nwant %v", got, want)
+	}
+}
+
+func TestGenerateMappingAutocomplete(t *testing.T) {
+	type entity struct {
+		Name string `json:"name" es:"text,analyzer=english,autocomplete"`
+	}
+	got, err := GenerateMapping(entity{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Property{Type: "text", Analyzer: "english", Fields: map[string]Property{
+		"keyword":       {Type: "keyword"},
+		SuggestSubfield: {Type: "search_as_you_type", Analyzer: "english"},
+	}}
+	if !reflect.DeepEqual(got.Properties["name"], want) {
+		t.Fatalf("name = %+v, want %+v", got.Properties["name"], want)
+	}
+}
+
+func TestGenerateMappingErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		entity interface{}
+	}{
+		{name: "not a struct", entity: "entity"},
+		{name: "nil", entity: nil},
+		{name: "index", entity: struct {
+			A string `es:"keyword,index=maybe"`
+		}{}},
+		{name: "ignore_above", entity: struct {
+			A string `es:"keyword,ignore_above=long"`
+		}{}},
+		{name: "unknown option", entity: struct {
+			A string `es:"keyword,boost=2"`
+		}{}},
+		{name: "subfield", entity: struct {
+			A string `es:"text,fields=raw"`
+		}{}},
+		{name: "autocomplete kind", entity: struct {
+			A string `es:"autocomplete=prefix"`
+		}{}},
+		{name: "autocomplete on a number", entity: struct {
+			A int `es:"autocomplete"`
+		}{}},
+		{name: "contexts without autocomplete", entity: struct {
+			A string `es:"keyword,contexts=tenant"`
+		}{}},
+		{name: "contexts of search_as_you_type", entity: struct {
+			A string `es:"keyword,autocomplete,contexts=tenant"`
+		}{}},
+		{name: "facet", entity: struct {
+			A string `es:"keyword,facet=cloud"`
+		}{}},
+		{name: "nested field", entity: struct {
+			A struct {
+				B string `es:"keyword,index=maybe"`
+			}
+		}{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, err := GenerateMapping(tt.entity); err == nil {
+				t.Fatalf("GenerateMapping() = %v, want an error", got)
+			}
+		})
+	}
+}
+
+func TestCompareMappings(t *testing.T) {
+	notIndexed := false
+	live := &Mapping{Properties: map[string]Property{
+		"title":   {Type: "text", Analyzer: "standard", SearchAnalyzer: "standard"},
+		"price":   {Type: "double"},
+		"code":    {Type: "keyword", Index: &notIndexed, IgnoreAbove: 128},
+		"legacy":  {Type: "keyword"},
+		"created": {Type: "date", Format: "yyyy-MM-dd"},
+		"author":  {Properties: map[string]Property{"name": {Type: "keyword"}}},
+	}}
+	generated := &Mapping{Properties: map[string]Property{
+		"title":   {Type: "text", Analyzer: "english", SearchAnalyzer: "english", Fields: map[string]Property{"keyword": {Type: "keyword"}}},
+		"price":   {Type: "float"},
+		"code":    {Type: "keyword", IgnoreAbove: 256, CopyTo: []string{"all_text"}},
+		"created": {Type: "date", Format: "yyyy-MM-dd"},
+		"author":  {Properties: map[string]Property{"name": {Type: "keyword"}, "email": {Type: "keyword"}}},
+		"sku":     {Type: "keyword"},
+	}}
+	want := []MappingChange{
+		{Path: "author.email", Kind: MappingFieldAdded, Compatible: true},
+		{Path: "code", Kind: MappingFieldChanged, Attribute: "index", Live: false, Generated: true},
+		{Path: "code", Kind: MappingFieldChanged, Attribute: "ignore_above", Live: 128, Generated: 256, Compatible: true},
+		{Path: "code", Kind: MappingFieldChanged, Attribute: "copy_to", Live: "", Generated: "all_text", Compatible: true},
+		{Path: "legacy", Kind: MappingFieldRemoved, Compatible: true},
+		{Path: "price", Kind: MappingFieldChanged, Attribute: "type", Live: "double", Generated: "float"},
+		{Path: "sku", Kind: MappingFieldAdded, Compatible: true},
+		{Path: "title", Kind: MappingFieldChanged, Attribute: "analyzer", Live: "standard", Generated: "english"},
+		{Path: "title", Kind: MappingFieldChanged, Attribute: "search_analyzer", Live: "standard", Generated: "english", Compatible: true},
+		{Path: "title.keyword", Kind: MappingFieldAdded, Compatible: true},
+	}
+	got := CompareMappings(live, generated)
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("CompareMappings() = %v\nwant %v", got, want)
+	}
+	diff := &MappingDiff{Changes: got}
+	if diff.Compatible() {
+		t.Fatal("a diff changing types is compatible")
+	}
+	if incompatible := diff.Incompatible(); len(incompatible) != 3 {
+		t.Fatalf("Incompatible() = %v, want the index, type and analyzer changes", incompatible)
+	}
+	if changes := CompareMappings(generated, generated); len(changes) != 0 {
+		t.Fatalf("comparing a mapping with itself = %v", changes)
+	}
+}
+
+func TestMappingChangeString(t *testing.T) {
+	added := MappingChange{Path: "sku", Kind: MappingFieldAdded, Compatible: true}
+	if got := added.String(); got != "sku added (compatible)" {
+		t.Fatalf("String() = %v", got)
+	}
+	changed := MappingChange{Path: "price", Kind: MappingFieldChanged, Attribute: "type", Live: "double", Generated: "float"}
+	if got := changed.String(); got != "price type changed from double to float (incompatible)" {
+		t.Fatalf("String() = %v", got)
+	}
+}
+
+func TestDiffMappingComparesTheNewestIndex(t *testing.T) {
+	generated, err := GenerateMapping(&testEntity{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	current := &Mapping{Properties: make(map[string]Property, len(generated.Properties))}
+	for name, property := range generated.Properties {
+		current.Properties[name] = property
+	}
+	current.Properties["price"] = Property{Type: "double"}
+	response := `{"test_entities_v1":{"mappings":{"properties":{}}},"test_entities_v2":{"mappings":` + current.String() + `}}`
+	repo, requests := newTestESRepo(t, func(esRequest) (int, string) { return http.StatusOK, response }, WithDefaultEntity(&testEntity{}))
+	err, diff := repo.DiffMapping(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := (*requests)[0]; got.method != http.MethodGet || got.path != "/test_entities/_mapping" {
+		t.Fatalf("request = %v %v", got.method, got.path)
+	}
+	want := []MappingChange{{Path: "price", Kind: MappingFieldChanged, Attribute: "type", Live: "double", Generated: "float"}}
+	if diff.Index != "test_entities_v2" || !reflect.DeepEqual(diff.Changes, want) {
+		t.Fatalf("DiffMapping() = %+v, want the price change of test_entities_v2", diff)
+	}
+}
+
+func TestDiffMappingRequiresTheDefaultEntity(t *testing.T) {
+	repo, _ := newTestESRepo(t, func(esRequest) (int, string) { return http.StatusOK, `{}` })
+	if err, _ := repo.DiffMapping(context.Background()); err == nil {
+		t.Fatal("DiffMapping() without WithDefaultEntity succeeded")
+	}
+}
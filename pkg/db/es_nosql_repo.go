@@ -4,34 +4,24 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"github.com/elastic/go-elasticsearch/v7"
 	"github.com/elastic/go-elasticsearch/v7/esapi"
 	"github.com/gobeam/stringy"
 	"github.com/kutty-kumar/charminder/pkg"
-	"github.com/sirupsen/logrus"
+	"github.com/kutty-kumar/charminder/pkg/idgen"
+	"github.com/kutty-kumar/charminder/pkg/logging"
+	"github.com/kutty-kumar/charminder/pkg/metrics"
+	"github.com/kutty-kumar/charminder/pkg/query"
+	"github.com/kutty-kumar/charminder/pkg/tracing"
+	"github.com/kutty-kumar/charminder/pkg/util/fieldcrypt"
+	"go.opentelemetry.io/otel/trace"
 	"io"
 	"io/ioutil"
-	"log"
 	"net/http"
-	"reflect"
+	"sort"
 	"strings"
-)
-
-var (
-	kindStr = map[reflect.Kind]string{
-		reflect.String:  "text",
-		reflect.Int64:   "long",
-		reflect.Int32:   "integer",
-		reflect.Bool:    "bool",
-		reflect.Uint64:  "long",
-		reflect.Slice:   "nested",
-		reflect.Struct:  "nested",
-		reflect.Float64: "float",
-		reflect.Float32: "float",
-		reflect.Int:     "integer",
-	}
+	"sync"
 )
 
 type FieldAnalysis struct {
@@ -43,46 +33,6 @@ func (f *FieldAnalysis) String() string {
 	return fmt.Sprintf("{\"analyzer\":\"%v\", \"search_analyzer\":\"%v\"}", f.Analyzer, f.SearchAnalyzer)
 }
 
-type Tokenizer string
-
-type CharFilter string
-
-type TokenFilter string
-
-var TokenizerMapping = struct {
-	Standard    Tokenizer
-	Letter      Tokenizer
-	Lowercase   Tokenizer
-	Whitespace  Tokenizer
-	Classic     Tokenizer
-	Punctuation Tokenizer
-}{
-	Standard:    "standard",
-	Letter:      "letter",
-	Lowercase:   "lowercase",
-	Whitespace:  "whitespace",
-	Classic:     "classic",
-	Punctuation: "punctuation",
-}
-
-var CharFilterMapping = struct {
-	HTMLStrip     CharFilter
-	Mapping       CharFilter
-	PatterReplace CharFilter
-}{
-	HTMLStrip: "html_strip",
-}
-
-var TokenFilterMapping = struct {
-	Lowercase    TokenFilter
-	StopWords    TokenFilter
-	AsciiFolding TokenFilter
-}{
-	Lowercase:    "lowercase",
-	StopWords:    "english_stop",
-	AsciiFolding: "asciifolding",
-}
-
 type ESHealth struct {
 	ClusterName                 string  `json:"cluster_name"`
 	Status                      string  `json:"status"`
@@ -117,6 +67,10 @@ type EsHit struct {
 	Id     string                 `json:"_id"`
 	Score  float64                `json:"_score"`
 	Source map[string]interface{} `json:"_source"`
+	// MatchedQueries names the queries tagged with a name that matched the hit.
+	MatchedQueries []string            `json:"matched_queries,omitempty"`
+	Highlight      map[string][]string `json:"highlight,omitempty"`
+	Sort           []interface{}       `json:"sort,omitempty"`
 }
 
 type Hits struct {
@@ -126,10 +80,13 @@ type Hits struct {
 }
 
 type ESSearchResponse struct {
-	Took     uint   `json:"took"`
-	TimedOut bool   `json:"timed_out"`
-	Shards   Shards `json:"_shards"`
-	Hits     Hits   `json:"hits"`
+	Took     uint                      `json:"took"`
+	TimedOut bool                      `json:"timed_out"`
+	Shards   Shards                    `json:"_shards"`
+	Hits     Hits                      `json:"hits"`
+	Suggest  map[string][]SuggestEntry `json:"suggest,omitempty"`
+	// Aggregations is left raw, its shape depends on the aggregation.
+	Aggregations map[string]json.RawMessage `json:"aggregations,omitempty"`
 }
 
 type ESQuery struct {
@@ -174,30 +131,6 @@ func (hbu *HttpBodyUtil) ResponseToString(factory func() interface{}) (string, e
 	return string(rBytes), nil
 }
 
-type Analyzer struct {
-	Type      string   `json:"type,omitempty"`
-	Tokenizer string   `json:"tokenizer,omitempty"`
-	Filter    []string `json:"filter,omitempty"`
-}
-
-type Analysis struct {
-	Analyzer map[string]Analyzer `json:"analyzer,omitempty"`
-	Filter   Filter              `json:"filter,omitempty"`
-}
-
-type FilterObj struct {
-	Type      string `json:"type,omitempty"`
-	StopWords string `json:"stop_words,omitempty"`
-}
-
-type Filter struct {
-	Filter map[string]FilterObj `json:"filter,omitempty"`
-}
-
-type Settings struct {
-	Analysis Analysis `json:"analysis"`
-}
-
 func getTagValue(rawTag, key string) string {
 	tags := strings.Split(rawTag, ",")
 	for _, tag := range tags {
@@ -208,109 +141,33 @@ func getTagValue(rawTag, key string) string {
 	return ""
 }
 
-func (esr *ElasticsearchRepo) getMappingForSlice(w reflect.Type, parentPath string) string {
-	var mappings []string
-	for j := 0; j < w.NumField(); j++ {
-		if w.Field(j).Type.Kind() != reflect.Struct && w.Field(j).Type.Kind() != reflect.Slice && w.Field(j).Type.Kind() != reflect.Chan {
-			if w.Field(j).Type.Kind() == reflect.String {
-				mappings = append(mappings, fmt.Sprintf("   \"%v\": {\n     \"type\": \"%v\"\n, \"fields\": {\n\"keyword\": {\n \"type\": \"keyword\"} } }", toSnakeCase(w.Field(j).Name), kindStr[w.Field(j).Type.Kind()]))
-				attrName := fmt.Sprintf("%v.%v", parentPath, toSnakeCase(w.Field(j).Name))
-				esr.fieldMappings[attrName] = FieldAnalysis{
-					Analyzer:       w.Field(j).Tag.Get("analyzer"),
-					SearchAnalyzer: w.Field(j).Tag.Get("search_analyzer"),
-				}
-			} else {
-				if w.Field(j).Type.Kind() == reflect.Ptr {
-					mappings = append(mappings, fmt.Sprintf("\"%v\": {\"type\": \"%v\"}", toSnakeCase(w.Field(j).Name), w.Field(j).Tag.Get("type")))
-				} else if w.Field(j).Tag.Get("type") != "" {
-					mappings = append(mappings, fmt.Sprintf("\"%v\": {\"type\": \"%v\"}", toSnakeCase(w.Field(j).Name), w.Field(j).Tag.Get("type")))
-				} else {
-					mappings = append(mappings, fmt.Sprintf("\"%v\": {\"type\": \"%v\"}", toSnakeCase(w.Field(j).Name), kindStr[w.Field(j).Type.Kind()]))
-				}
-			}
-		} else if w.Field(j).Type.Kind() == reflect.Struct || (w.Field(j).Type.Kind() == reflect.Slice && w.Field(j).Type.Elem().Kind() == reflect.Struct) {
-			if w.Field(j).Tag.Get("type") != "" {
-				mappings = append(mappings, fmt.Sprintf("\"%v\": {\"type\": \"%v\"}", toSnakeCase(w.Field(j).Name), w.Field(j).Tag.Get("type")))
-			} else {
-				mappings = append(mappings, fmt.Sprintf("\"%v\":{\"properties\": {\n %v \n}\n}", toSnakeCase(w.Field(j).Name), esr.getMappingForSlice(w.Field(j).Type, fmt.Sprintf("%v.%v", parentPath, toSnakeCase(w.Field(j).Name)))))
-			}
-		} else if w.Field(j).Type.Kind() == reflect.Slice && (w.Field(j).Type.Elem().Kind() != reflect.Struct && w.Field(j).Type.Elem().Kind() != reflect.Chan) {
-			if w.Field(j).Type.Kind() == reflect.String {
-				attrName := fmt.Sprintf("%v.%v", parentPath, toSnakeCase(w.Field(j).Name))
-				esr.fieldMappings[attrName] = FieldAnalysis{
-					Analyzer:       w.Field(j).Tag.Get("analyzer"),
-					SearchAnalyzer: w.Field(j).Tag.Get("search_analyzer"),
-				}
-				mappings = append(mappings, fmt.Sprintf("   \"%v\": {\n \"type\": \"text\"\n }", toSnakeCase(w.Field(j).Name)))
-			} else {
-				attrName := fmt.Sprintf("%v.%v", parentPath, toSnakeCase(w.Field(j).Name))
-				esr.fieldMappings[attrName] = FieldAnalysis{
-					Analyzer:       w.Field(j).Tag.Get("analyzer"),
-					SearchAnalyzer: w.Field(j).Tag.Get("search_analyzer"),
-				}
-				mappings = append(mappings, fmt.Sprintf("\"%v\": {\"type\": \"%v\"}", toSnakeCase(w.Field(j).Name), w.Field(j).Tag.Get("type")))
-			}
-		}
-	}
-	return strings.Join(mappings, ",\n")
-}
-
-func (esr *ElasticsearchRepo) getMapping(v reflect.Value) string {
-	var mappings []string
-	valType := v.Type()
-	for i := 0; i < v.NumField(); i++ {
-		attr := v.Field(i)
-		if attr.Kind() != reflect.Struct && attr.Kind() != reflect.Slice && attr.Kind() != reflect.Chan {
-			if attr.Kind() == reflect.String {
-				attrName := toSnakeCase(valType.Field(i).Name)
-				esr.fieldMappings[attrName] = FieldAnalysis{
-					Analyzer:       valType.Field(i).Tag.Get("analyzer"),
-					SearchAnalyzer: valType.Field(i).Tag.Get("search_analyzer"),
-				}
-				mappings = append(mappings, fmt.Sprintf("   \"%v\": {\n     \"type\": \"%v\"\n, \"fields\": {\n\"keyword\": {\n \"type\": \"keyword\"} } }", toSnakeCase(valType.Field(i).Name), kindStr[attr.Kind()]))
-			} else {
-				mappings = append(mappings, fmt.Sprintf("   \"%v\": {\n     \"type\": \"%v\"\n   }", toSnakeCase(valType.Field(i).Name), kindStr[attr.Kind()]))
-			}
-		} else if attr.Kind() == reflect.Slice {
-			attrType := reflect.TypeOf(attr.Interface()).Elem().Kind()
-			if attrType != reflect.Struct {
-				if attrType == reflect.String {
-					attrName := toSnakeCase(valType.Field(i).Name)
-					mappings = append(mappings, fmt.Sprintf("   \"%v\": {\n     \"type\": \"text\"\n }", toSnakeCase(valType.Field(i).Name)))
-					esr.fieldMappings[attrName] = FieldAnalysis{
-						Analyzer:       valType.Field(i).Tag.Get("analyzer"),
-						SearchAnalyzer: valType.Field(i).Tag.Get("search_analyzer"),
-					}
-				} else {
-					mappings = append(mappings, fmt.Sprintf("   \"%v\": {\n     \"type\": \"%v\"\n   }", toSnakeCase(valType.Field(i).Name), kindStr[attrType]))
-				}
-			} else {
-				sFace := reflect.TypeOf(attr.Interface()).Elem()
-				mappings = append(mappings, fmt.Sprintf("\"%v\":{\n    \"properties\":    {\n %v }\n  }", toSnakeCase(valType.Field(i).Name), esr.getMappingForSlice(sFace, toSnakeCase(valType.Field(i).Name))))
-			}
-		} else if attr.Kind() == reflect.Struct {
-			mappings = append(mappings, fmt.Sprintf("\"%v\":{\n    \"properties\":    {\n %v }\n  }", toSnakeCase(valType.Field(i).Name), esr.getMappingForSlice(reflect.TypeOf(attr.Interface()), toSnakeCase(valType.Field(i).Name))))
-		}
-	}
-	return "{\n \"mappings\":{ \n  \"properties\":{\n" + strings.Join(mappings, ",\n") + "\n  }\n }\n}"
-}
-
 type ElasticsearchRepo struct {
-	marshaller      *HttpBodyUtil
-	client          *elasticsearch.Client
-	sChecker        *HttpStatusChecker
-	entityCreator   pkg.EntityCreator
-	index           string
-	entityConverter func(from map[string]interface{}) pkg.Base
-	fieldMappings   map[string]FieldAnalysis
-	defaultEntity   pkg.Base
-	logger          *logrus.Logger
-	settings        Settings
-	httpClient      *http.Client
+	marshaller       *HttpBodyUtil
+	client           *elasticsearch.Client
+	sChecker         *HttpStatusChecker
+	entityCreator    pkg.EntityCreator
+	index            string
+	entityConverter  func(from map[string]interface{}) pkg.Base
+	fieldMappings    map[string]FieldAnalysis
+	autocomplete     map[string]autocompleteField
+	exactFields      map[string]string
+	facets           map[string]Facet
+	defaultEntity    pkg.Base
+	logger           logging.Logger
+	settings         Settings
+	httpClient       *http.Client
+	externalIdSetter pkg.ExternalIdSetter
+	idGenerator      idgen.ExternalIdGenerator
+	idGenerators     *idgen.Registry
+	metrics          *metrics.RepositoryMetrics
+	tracer           trace.Tracer
+	bulkOptions      []BulkIndexerOption
+	aliased          bool
 }
 
 type ElasticsearchRepoOption func(repo *ElasticsearchRepo)
 
+// Deprecated: every request goes through the client set with WithClient.
 func WithHttpClient(client *http.Client) ElasticsearchRepoOption {
 	return func(repo *ElasticsearchRepo) {
 		repo.httpClient = client
@@ -329,9 +186,9 @@ func WithIndex(index string) ElasticsearchRepoOption {
 	}
 }
 
-func WithESLogger(logger *logrus.Logger) ElasticsearchRepoOption {
+func WithESLogger(logger logging.Logger) ElasticsearchRepoOption {
 	return func(repo *ElasticsearchRepo) {
-		repo.logger = logger
+		repo.logger = logging.OrNop(logger)
 	}
 }
 
@@ -365,312 +222,715 @@ func WithEntityCreator(creator pkg.EntityCreator) ElasticsearchRepoOption {
 	}
 }
 
+func WithESExternalIdSetter(setter pkg.ExternalIdSetter) ElasticsearchRepoOption {
+	return func(repo *ElasticsearchRepo) {
+		repo.externalIdSetter = setter
+	}
+}
+
+func WithESExternalIdGenerator(generator idgen.ExternalIdGenerator) ElasticsearchRepoOption {
+	return func(repo *ElasticsearchRepo) {
+		repo.idGenerator = generator
+	}
+}
+
+func WithESExternalIdGenerators(registry *idgen.Registry) ElasticsearchRepoOption {
+	return func(repo *ElasticsearchRepo) {
+		repo.idGenerators = registry
+	}
+}
+
+func WithESMetrics(m *metrics.RepositoryMetrics) ElasticsearchRepoOption {
+	return func(repo *ElasticsearchRepo) {
+		repo.metrics = m
+	}
+}
+
+func WithESTracerProvider(provider trace.TracerProvider) ElasticsearchRepoOption {
+	return func(repo *ElasticsearchRepo) {
+		repo.tracer = tracing.Tracer(provider)
+	}
+}
+
+// WithESAliasedIndex treats the index name as an alias over versioned indices such as users_v3.
+func WithESAliasedIndex() ElasticsearchRepoOption {
+	return func(repo *ElasticsearchRepo) {
+		repo.aliased = true
+	}
+}
+
+// WithESFacets declares facets in code, for example ranges with date bounds. They take precedence over facets of
+// the same name declared with the facet tag option of WithDefaultEntity.
+func WithESFacets(facets ...Facet) ElasticsearchRepoOption {
+	return func(repo *ElasticsearchRepo) {
+		for _, facet := range facets {
+			repo.facets[facet.Name] = facet
+		}
+	}
+}
+
+// WithESBulkOptions tunes the bulk indexer behind BulkCreate, e.g. its flush size or refresh policy.
+func WithESBulkOptions(opts ...BulkIndexerOption) ElasticsearchRepoOption {
+	return func(repo *ElasticsearchRepo) {
+		repo.bulkOptions = append(repo.bulkOptions, opts...)
+	}
+}
+
 func WithStatusChecker(checker *HttpStatusChecker) ElasticsearchRepoOption {
 	return func(repo *ElasticsearchRepo) {
 		repo.sChecker = checker
 	}
 }
 
-func NewElasticsearchRepo(opts ...ElasticsearchRepoOption) BaseNoSQLRepo {
+// NewElasticsearchRepo requires WithClient, WithIndex, WithEntityConverter, WithEntityCreator and
+// WithESExternalIdSetter.
+func NewElasticsearchRepo(opts ...ElasticsearchRepoOption) (BaseNoSQLRepo, error) {
 	repo := &ElasticsearchRepo{
 		fieldMappings: make(map[string]FieldAnalysis),
+		facets:        make(map[string]Facet),
+		marshaller:    &HttpBodyUtil{},
+		sChecker:      &HttpStatusChecker{},
+		tracer:        tracing.Tracer(nil),
+		logger:        logging.Nop(),
 	}
 
 	for _, opt := range opts {
 		opt(repo)
 	}
-	return repo
+	switch {
+	case repo.client == nil:
+		return nil, fmt.Errorf("elasticsearch repository: %w WithClient", pkg.ErrMissingOption)
+	case repo.index == "":
+		return nil, fmt.Errorf("elasticsearch repository: %w WithIndex", pkg.ErrMissingOption)
+	case repo.entityConverter == nil:
+		return nil, fmt.Errorf("elasticsearch repository %v: %w WithEntityConverter", repo.index, pkg.ErrMissingOption)
+	case repo.entityCreator == nil:
+		return nil, fmt.Errorf("elasticsearch repository %v: %w WithEntityCreator", repo.index, pkg.ErrMissingOption)
+	case repo.externalIdSetter == nil:
+		return nil, fmt.Errorf("elasticsearch repository %v: %w WithESExternalIdSetter", repo.index, pkg.ErrMissingOption)
+	}
+	if repo.defaultEntity != nil {
+		mapping, err := repo.mapping()
+		if err != nil {
+			return nil, err
+		}
+		repo.fieldMappings = mapping.textFields()
+		repo.autocomplete = mapping.autocompleteFields()
+		repo.exactFields = mapping.exactFields()
+		declared, err := mapping.facets()
+		if err != nil {
+			return nil, fmt.Errorf("facets of %v: %w", repo.index, err)
+		}
+		for name, facet := range declared {
+			if _, ok := repo.facets[name]; !ok {
+				repo.facets[name] = facet
+			}
+		}
+	}
+	return repo, nil
 }
 
-func (esr *ElasticsearchRepo) GetById(ctx context.Context, id uint64) (error, pkg.Base) {
+func (esr *ElasticsearchRepo) domainName() pkg.DomainName {
+	return esr.entityCreator().GetName()
+}
+
+func (esr *ElasticsearchRepo) instrument(ctx context.Context, operation string) (context.Context, func(err *error)) {
+	in := instrumentation{store: storeElasticsearch, system: storeElasticsearch, tracer: esr.tracer, metrics: esr.metrics}
+	return in.start(ctx, esr.domainName(), operation)
+}
+
+// responseError reads the body of a failed response, Elasticsearch explains the failure there.
+func responseError(operation string, res *esapi.Response) error {
+	body, _ := ioutil.ReadAll(res.Body)
+	return fmt.Errorf("%v failed with status %v: %s", operation, res.StatusCode, bytes.TrimSpace(body))
+}
+
+func (esr *ElasticsearchRepo) search(ctx context.Context, source *SearchSource) (*ESSearchResponse, error) {
+	body, err := json.Marshal(source)
+	if err != nil {
+		return nil, err
+	}
 	req := esapi.SearchRequest{
 		Index: []string{esr.index},
-		Body:  strings.NewReader(fmt.Sprintf("{\"query\":{\"term\":{\"id\":%v}}}", id)),
+		Body:  bytes.NewReader(body),
 	}
 	res, err := req.Do(ctx, esr.client)
-	if err != nil || !esr.sChecker.IsSuccessFul(res.StatusCode) {
-		return err, nil
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if !esr.sChecker.IsSuccessFul(res.StatusCode) {
+		return nil, responseError("search on "+esr.index, res)
 	}
 	var response ESSearchResponse
 	err = esr.marshaller.BytesToResponse(res.Body, func() interface{} {
 		return &response
 	})
 	if err != nil {
-		return err, nil
+		return nil, err
 	}
-	for _, hit := range response.Hits.Hits {
-		return nil, esr.entityConverter(hit.Source)
+	return &response, nil
+}
+
+func (esr *ElasticsearchRepo) searchEntities(ctx context.Context, source *SearchSource) ([]pkg.Base, error) {
+	response, err := esr.search(ctx, source)
+	if err != nil {
+		return nil, err
 	}
-	return errors.New("not found"), nil
+	return esr.searchResult(response, source).Entities(), nil
 }
 
-func (esr *ElasticsearchRepo) Search(ctx context.Context, params map[string]string) (error, []pkg.Base) {
-	var queries []string
-	for key, value := range params {
-		// supports filtering only on text fields
-		queries = append(queries, fmt.Sprintf("{\"term\": {\"%v\": \"%v\"}\n}", key, value))
+func (esr *ElasticsearchRepo) GetById(ctx context.Context, id uint64) (err error, result pkg.Base) {
+	ctx, done := esr.instrument(ctx, "get_by_id")
+	defer done(&err)
+	entities, err := esr.searchEntities(ctx, NewSearchSource(Term("id", id)).Size(1))
+	if err != nil {
+		return err, nil
 	}
-	req := esapi.SearchRequest{
-		Index: []string{esr.index},
-		Body:  strings.NewReader(fmt.Sprintf("{\"query\":{\"bool\":{\"filter\":[%v]\n}\n}\n}", strings.Join(queries, ",\n"))),
+	if len(entities) == 0 {
+		return ErrNotFound, nil
 	}
-	res, err := req.Do(ctx, esr.client)
-	if err != nil || !esr.sChecker.IsSuccessFul(res.StatusCode) {
-		return err, nil
+	return nil, entities[0]
+}
+
+// Search matches every param exactly; keys are field names, so string fields need their keyword subfield.
+func (esr *ElasticsearchRepo) Search(ctx context.Context, params map[string]string) (err error, results []pkg.Base) {
+	ctx, done := esr.instrument(ctx, "search")
+	defer done(&err)
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		keys = append(keys, key)
 	}
-	var response ESSearchResponse
-	err = esr.marshaller.BytesToResponse(res.Body, func() interface{} {
-		return &response
-	})
+	sort.Strings(keys)
+	boolQuery := Bool()
+	for _, key := range keys {
+		boolQuery.Filter(Term(key, params[key]))
+	}
+	results, err = esr.searchEntities(ctx, NewSearchSource(boolQuery))
 	if err != nil {
 		return err, nil
 	}
-	var result []pkg.Base
-	for _, hit := range response.Hits.Hits {
-		result = append(result, esr.entityConverter(hit.Source))
-	}
-	return nil, result
+	return nil, results
 }
 
 func (esr *ElasticsearchRepo) GetDb() interface{} {
 	return esr.client
 }
 
-func (esr *ElasticsearchRepo) ExactSearch(ctx context.Context, key string, value interface{}) (error, []pkg.Base) {
-	req := esapi.SearchRequest{
-		Index: []string{esr.index},
-		Body:  strings.NewReader(fmt.Sprintf("{\"query\":{\"term\":{\"%v\":%v}}}", key, value)),
-	}
-	res, err := req.Do(ctx, esr.client)
-	if err != nil || !esr.sChecker.IsSuccessFul(res.StatusCode) {
+func (esr *ElasticsearchRepo) ExactSearch(ctx context.Context, key string, value interface{}) (err error, results []pkg.Base) {
+	ctx, done := esr.instrument(ctx, "exact_search")
+	defer done(&err)
+	results, err = esr.searchEntities(ctx, NewSearchSource(Term(key, value)))
+	if err != nil {
 		return err, nil
 	}
-	var response ESSearchResponse
-	err = esr.marshaller.BytesToResponse(res.Body, func() interface{} {
-		return &response
-	})
+	return nil, results
+}
+
+// RangeSearch matches start <= key <= end, a nil bound leaves that side open.
+func (esr *ElasticsearchRepo) RangeSearch(ctx context.Context, key string, start, end interface{}) (err error, results []pkg.Base) {
+	ctx, done := esr.instrument(ctx, "range_search")
+	defer done(&err)
+	rangeQuery := Range(key)
+	if start != nil {
+		rangeQuery.Gte(start)
+	}
+	if end != nil {
+		rangeQuery.Lte(end)
+	}
+	results, err = esr.searchEntities(ctx, NewSearchSource(rangeQuery))
 	if err != nil {
 		return err, nil
 	}
-	var result []pkg.Base
-	for _, hit := range response.Hits.Hits {
-		result = append(result, esr.entityConverter(hit.Source))
-	}
-	return nil, result
+	return nil, results
 }
 
-func (esr *ElasticsearchRepo) RangeSearch(ctx context.Context, key string, start, end interface{}) (error, []pkg.Base) {
-	queryString := fmt.Sprintf("{\"query\":{\"range\": {\"%v\": {\"gte\": %v, \"lte\": %v}}}}", key, start, end)
-	req := esapi.SearchRequest{
-		Index: []string{esr.index},
-		Body:  strings.NewReader(queryString),
+// textFields returns the analysed fields recorded by IndexMappings in a stable order, with the analyzer of the
+// first one that sets it.
+func (esr *ElasticsearchRepo) textFields() ([]string, string) {
+	fields := make([]string, 0, len(esr.fieldMappings))
+	for attr := range esr.fieldMappings {
+		fields = append(fields, attr)
+	}
+	sort.Strings(fields)
+	var analyzer string
+	for _, field := range fields {
+		if analyzer = esr.fieldMappings[field].Analyzer; analyzer != "" {
+			break
+		}
 	}
+	return fields, analyzer
+}
 
-	res, err := req.Do(ctx, esr.client)
-	if err != nil || !esr.sChecker.IsSuccessFul(res.StatusCode) {
-		return err, nil
+func (esr *ElasticsearchRepo) textQuery(value string) Query {
+	fields, analyzer := esr.textFields()
+	boolQuery := Bool()
+	for _, matchType := range []string{"cross_fields", "best_fields", "phrase", "phrase_prefix"} {
+		boolQuery.Should(MultiMatch(value, fields...).Type(matchType).Analyzer(analyzer))
 	}
-	var response ESSearchResponse
-	err = esr.marshaller.BytesToResponse(res.Body, func() interface{} {
-		return &response
-	})
+	return boolQuery
+}
+
+func (esr *ElasticsearchRepo) TextSearch(ctx context.Context, value string) (err error, results []pkg.Base) {
+	ctx, done := esr.instrument(ctx, "text_search")
+	defer done(&err)
+	results, err = esr.searchEntities(ctx, NewSearchSource(esr.textQuery(value)))
 	if err != nil {
 		return err, nil
 	}
-	var result []pkg.Base
-	for _, hit := range response.Hits.Hits {
-		result = append(result, esr.entityConverter(hit.Source))
-	}
-	return nil, result
+	return nil, results
 }
 
-func (esr *ElasticsearchRepo) getMatchQuery(value string) []string {
-	var result []string
-	for attr, mapping := range esr.fieldMappings {
-		var analyzer string
-		if mapping.Analyzer != "" {
-			analyzer = fmt.Sprintf(", \"analyzer\": %v", mapping.Analyzer)
-		}
-		result = append(result, fmt.Sprintf("{\"query\": {\"match\": {\"%v\": {\"query\": %v %v}}}}", attr, value, analyzer))
+type textSearchConfig struct {
+	from, size int
+	highlight  *Highlight
+}
+
+type TextSearchOption func(c *textSearchConfig)
+
+// WithTextPage returns size hits starting at from.
+func WithTextPage(from, size int) TextSearchOption {
+	return func(c *textSearchConfig) {
+		c.from, c.size = from, size
 	}
-	return result
 }
 
-func (esr *ElasticsearchRepo) getMatchPhraseQuery(value string) []string {
-	var result []string
-	for attr, mapping := range esr.fieldMappings {
-		var analyzer string
-		if mapping.Analyzer != "" {
-			analyzer = fmt.Sprintf(", \"analyzer\": %v", mapping.Analyzer)
-		}
-		result = append(result, fmt.Sprintf("{\"query\": {\"match_phrase\": {\"%v\": {\"query\": %v %v}}}}", attr, value, analyzer))
+// WithTextHighlight returns highlight fragments for fields, or for every text field when none are given.
+func WithTextHighlight(fields ...string) TextSearchOption {
+	return func(c *textSearchConfig) {
+		c.highlight = NewHighlight(fields...)
 	}
-	return result
 }
 
-func (esr *ElasticsearchRepo) getMultiMatchQuery(queryType, value string) string {
-	var attrs []string
-	var analyzerType string
-	for attr, mapping := range esr.fieldMappings {
-		if mapping.Analyzer != "" {
-			analyzerType = fmt.Sprintf(", \"analyzer\": \"%v\"", mapping.Analyzer)
-		}
-		attrs = append(attrs, fmt.Sprintf("\"%v\"", attr))
+// WithTextHighlighter highlights as configured by highlight.
+func WithTextHighlighter(highlight *Highlight) TextSearchOption {
+	return func(c *textSearchConfig) {
+		c.highlight = highlight
 	}
-	return fmt.Sprintf("{ \"multi_match\": {\"query\": \"%v\", \"type\": \"%v\", \"fields\": [%v] %v}}", value, queryType, strings.Join(attrs, ","), analyzerType)
 }
 
-func (esr *ElasticsearchRepo) TextSearch(ctx context.Context, value string) (error, []pkg.Base) {
-	var innerQueries []string
-	innerQueries = append(innerQueries, esr.getMultiMatchQuery("cross_fields", value))
-	innerQueries = append(innerQueries, esr.getMultiMatchQuery("best_fields", value))
-	innerQueries = append(innerQueries, esr.getMultiMatchQuery("phrase", value))
-	innerQueries = append(innerQueries, esr.getMultiMatchQuery("phrase_prefix", value))
-	queryBody := fmt.Sprintf("{\"query\": {\"bool\":{\"should\":[%v]}}}", strings.Join(innerQueries, ","))
-	req := esapi.SearchRequest{
-		Index: []string{esr.index},
-		Body:  strings.NewReader(queryBody),
+// TextSearchResult runs the TextSearch query and keeps scores, highlights and the total.
+func (esr *ElasticsearchRepo) TextSearchResult(ctx context.Context, value string, opts ...TextSearchOption) (err error, result *SearchResult) {
+	ctx, done := esr.instrument(ctx, "text_search")
+	defer done(&err)
+	config := &textSearchConfig{size: -1}
+	for _, opt := range opts {
+		opt(config)
 	}
-	res, err := req.Do(ctx, esr.client)
-	if err != nil || !esr.sChecker.IsSuccessFul(res.StatusCode) {
+	source := NewSearchSource(esr.textQuery(value)).From(config.from).Size(config.size)
+	if config.highlight != nil {
+		if len(config.highlight.fields) == 0 {
+			config.highlight.fields, _ = esr.textFields()
+		}
+		source.Highlight(config.highlight)
+	}
+	response, err := esr.search(ctx, source)
+	if err != nil {
 		return err, nil
 	}
-	var response ESSearchResponse
-	err = esr.marshaller.BytesToResponse(res.Body, func() interface{} {
-		return &response
-	})
+	return nil, esr.searchResult(response, source)
+}
+
+// Query runs source, built with the query DSL, against the index.
+func (esr *ElasticsearchRepo) Query(ctx context.Context, source *SearchSource) (err error, result *SearchResult) {
+	ctx, done := esr.instrument(ctx, "query")
+	defer done(&err)
+	response, err := esr.search(ctx, source)
 	if err != nil {
 		return err, nil
 	}
-	var result []pkg.Base
-	for _, hit := range response.Hits.Hits {
-		result = append(result, esr.entityConverter(hit.Source))
+	return nil, esr.searchResult(response, source)
+}
+
+func (esr *ElasticsearchRepo) mapping() (*Mapping, error) {
+	if esr.defaultEntity == nil {
+		return nil, fmt.Errorf("mappings of %v: %w WithDefaultEntity", esr.index, pkg.ErrMissingOption)
+	}
+	mapping, err := GenerateMapping(esr.defaultEntity)
+	if err != nil {
+		return nil, fmt.Errorf("generating mappings of %v: %w", esr.index, err)
+	}
+	return mapping, nil
+}
+
+// indexBody is the create index request derived from WithDefaultEntity and WithSettings.
+func (esr *ElasticsearchRepo) indexBody() ([]byte, error) {
+	mapping, err := esr.mapping()
+	if err != nil {
+		return nil, err
+	}
+	if err := esr.settings.Validate(); err != nil {
+		return nil, fmt.Errorf("settings of %v: %w", esr.index, err)
 	}
-	return nil, result
+	if err := esr.settings.CheckMapping(mapping); err != nil {
+		return nil, fmt.Errorf("mappings of %v: %w", esr.index, err)
+	}
+	body, err := json.Marshal(map[string]interface{}{"mappings": mapping, "settings": esr.settings})
+	if err != nil {
+		return nil, fmt.Errorf("marshalling mappings of %v: %w", esr.index, err)
+	}
+	return body, nil
 }
 
+func (esr *ElasticsearchRepo) indexManager() (*IndexManager, error) {
+	return NewIndexManager(esr.client, WithIndexManagerLogger(esr.logger))
+}
+
+// IndexMappings creates the index with the mappings derived from WithDefaultEntity and the WithSettings settings.
+// With WithESAliasedIndex it creates the first versioned index behind the alias instead, and leaves an existing
+// alias alone: mapping changes then go through Reindex.
 func (esr *ElasticsearchRepo) IndexMappings(ctx context.Context) error {
-	v := reflect.ValueOf(esr.defaultEntity)
-	var mapping map[string]interface{}
-	err := json.Unmarshal([]byte(esr.getMapping(v)), &mapping)
+	body, err := esr.indexBody()
 	if err != nil {
-		log.Fatalf("An error %v occurred while indexing mappings, cannot continue.", err)
+		return err
 	}
-	mapping["settings"] = esr.settings
-	mappingStr, err := json.Marshal(mapping)
-	esr.logger.Infof("Mappings %v", string(mappingStr))
+	esr.logger.Debug(ctx, "index mappings", logging.F("index", esr.index), logging.F("mappings", string(body)))
+	manager, err := esr.indexManager()
 	if err != nil {
-		log.Fatalf("An error %v occurred while marshalling mapping to json", err)
+		return err
+	}
+	if !esr.aliased {
+		if err := manager.CreateIndex(ctx, esr.index, body); err != nil {
+			return err
+		}
+		esr.logger.Info(ctx, "index mappings created", logging.F("index", esr.index))
+		return nil
 	}
-	req, err := http.NewRequest(http.MethodPut, "http://localhost:9200/"+esr.index, bytes.NewBuffer(mappingStr))
+	current, _, err := manager.CurrentIndex(ctx, esr.index)
 	if err != nil {
 		return err
 	}
-	req.Header.Add("content-type", "application/json")
-	resp, err := esr.httpClient.Do(req)
+	if current != "" {
+		esr.logger.Info(ctx, "alias already exists", logging.F("alias", esr.index), logging.F("index", current))
+		return nil
+	}
+	legacy, err := manager.Exists(ctx, esr.index)
 	if err != nil {
 		return err
 	}
-	defer resp.Body.Close()
-	respBytes, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
+	if legacy {
+		return fmt.Errorf("index %v exists without an alias, use Reindex to move it behind one", esr.index)
+	}
+	first := VersionedIndexName(esr.index, 1)
+	if err := manager.CreateIndex(ctx, first, body); err != nil {
 		return err
 	}
-	esr.logger.Infof("Mapping created %v", string(respBytes))
+	if err := manager.SwapAlias(ctx, esr.index, "", first); err != nil {
+		return err
+	}
+	esr.logger.Info(ctx, "index mappings created", logging.F("alias", esr.index), logging.F("index", first))
 	return nil
 }
 
+// Reindex moves the alias to a new versioned index carrying the current mappings, see IndexManager.Reindex for
+// why writers must pause while it runs.
+func (esr *ElasticsearchRepo) Reindex(ctx context.Context, opts ...ReindexOption) (err error, result *ReindexResult) {
+	ctx, done := esr.instrument(ctx, "reindex")
+	defer done(&err)
+	body, err := esr.indexBody()
+	if err != nil {
+		return err, nil
+	}
+	manager, err := esr.indexManager()
+	if err != nil {
+		return err, nil
+	}
+	result, err = manager.Reindex(ctx, esr.index, body, opts...)
+	return err, result
+}
+
 func (esh *ESHealth) IsHealthy() bool {
 	return (esh.Status == "yellow" || esh.Status == "green") && esh.ActiveShardsPercentAsNumber >= 50.00
 }
 
-func (esr *ElasticsearchRepo) IsHealthy() bool {
-	info, err := esr.client.Cluster.Health()
+func (esr *ElasticsearchRepo) Health(ctx context.Context) error {
+	info, err := esr.client.Cluster.Health(esr.client.Cluster.Health.WithContext(ctx))
 	if err != nil {
-		return false
+		return err
+	}
+	defer info.Body.Close()
+	if !esr.sChecker.IsSuccessFul(info.StatusCode) {
+		return fmt.Errorf("cluster health returned status %v", info.StatusCode)
 	}
 	var esResponse ESHealth
 	err = esr.marshaller.BytesToResponse(info.Body, func() interface{} {
 		return &esResponse
 	})
 	if err != nil {
-		return false
+		return err
+	}
+	if !esResponse.IsHealthy() {
+		return fmt.Errorf("cluster %v is %v with %.2f%% active shards", esResponse.ClusterName, esResponse.Status, esResponse.ActiveShardsPercentAsNumber)
 	}
-	return esResponse.IsHealthy()
+	return nil
 }
 
-func (esr *ElasticsearchRepo) Create(ctx context.Context, base pkg.Base) (error, pkg.Base) {
+func (esr *ElasticsearchRepo) IsHealthy() bool {
+	return esr.Health(context.Background()) == nil
+}
+
+// document assigns an external id when base has none and returns the JSON that is indexed for it.
+func (esr *ElasticsearchRepo) document(base pkg.Base) (pkg.Base, string, error) {
+	if base.GetExternalId() == "" {
+		externalId, err := idgen.Resolve(esr.idGenerators, esr.idGenerator, base.GetName()).Generate()
+		if err != nil {
+			return nil, "", err
+		}
+		base = esr.externalIdSetter(externalId, base)
+	}
 	jBody, err := base.ToJson()
 	if err != nil {
-		return err, nil
-	}
-	req := esapi.IndexRequest{
-		Index:      string(base.GetName()),
-		DocumentID: base.GetExternalId(),
-		Body:       strings.NewReader(jBody),
-		Refresh:    "true",
+		return nil, "", err
 	}
-	res, err := req.Do(ctx, esr.client)
-	if err != nil || esr.sChecker.IsInternalError(res.StatusCode) || esr.sChecker.IsClientError(res.StatusCode) {
-		return errors.New(fmt.Sprintf("Error while indexing %v", err)), nil
+	jBody, err = stripEncryptedFields(base, jBody)
+	if err != nil {
+		return nil, "", err
 	}
+	return base, jBody, nil
+}
 
+func (esr *ElasticsearchRepo) Create(ctx context.Context, base pkg.Base) (err error, result pkg.Base) {
+	ctx, done := esr.instrument(ctx, "create")
+	defer done(&err)
+	base, jBody, err := esr.document(base)
+	if err != nil {
+		return err, nil
+	}
+	req := esapi.IndexRequest{Index: esr.index, DocumentID: base.GetExternalId(), Body: strings.NewReader(jBody), Refresh: "true"}
+	if err := doRequest(ctx, esr.client, "indexing "+base.GetExternalId()+" in "+esr.index, req, nil); err != nil {
+		return err, nil
+	}
 	return nil, base
 }
 
-func (esr *ElasticsearchRepo) Update(ctx context.Context, entityId string, base pkg.Base) (error, pkg.Base) {
-	req := esapi.UpdateRequest{DocumentID: entityId, Index: base.GetExternalId()}
-	res, err := req.Do(ctx, esr.client)
-	if err != nil || esr.sChecker.IsInternalError(res.StatusCode) || esr.sChecker.IsClientError(res.StatusCode) {
-		return errors.New(fmt.Sprintf("Error while updating %v", err)), nil
+// BulkCreate indexes bases through the _bulk API and returns one result per base, in order. The error reports
+// how many items failed; the results say which ones and why.
+func (esr *ElasticsearchRepo) BulkCreate(ctx context.Context, bases []pkg.Base) (err error, results []BulkItemResult) {
+	ctx, done := esr.instrument(ctx, "bulk_create")
+	defer done(&err)
+	results = make([]BulkItemResult, len(bases))
+	var mu sync.Mutex
+	onResult := func(ctx context.Context, result BulkItemResult) {
+		mu.Lock()
+		defer mu.Unlock()
+		results[result.Item.position] = result
+	}
+	opts := append([]BulkIndexerOption{WithBulkIndex(esr.index), WithBulkLogger(esr.logger)}, esr.bulkOptions...)
+	indexer, err := NewBulkIndexer(esr.client, append(opts, WithBulkOnResult(onResult))...)
+	if err != nil {
+		return err, nil
 	}
-	return nil, base
+	for i, base := range bases {
+		base, jBody, err := esr.document(base)
+		if err != nil {
+			_ = indexer.Close(ctx)
+			return fmt.Errorf("preparing document %v: %w", i, err), nil
+		}
+		if err := indexer.Add(ctx, BulkItem{Action: BulkIndex, DocumentId: base.GetExternalId(), Body: []byte(jBody), position: i}); err != nil {
+			_ = indexer.Close(ctx)
+			return err, nil
+		}
+	}
+	if err := indexer.Close(ctx); err != nil {
+		return err, nil
+	}
+	failed := 0
+	for _, result := range results {
+		if result.Failed() {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("bulk create on %v: %v of %v documents failed", esr.index, failed, len(bases)), results
+	}
+	return nil, results
 }
 
-func (esr *ElasticsearchRepo) GetByExternalId(ctx context.Context, entityId string) (error, pkg.Base) {
-	truthy := true
-	req := esapi.GetRequest{DocumentID: entityId, Refresh: &truthy, Realtime: &truthy}
-	res, err := req.Do(ctx, esr.client)
+// Update merges base into the stored document of entityId and writes the merged document back.
+func (esr *ElasticsearchRepo) Update(ctx context.Context, entityId string, base pkg.Base) (err error, result pkg.Base) {
+	ctx, done := esr.instrument(ctx, "update")
+	defer done(&err)
+	err, entity := esr.GetByExternalId(ctx, entityId)
 	if err != nil {
 		return err, nil
 	}
-	entity := esr.entityCreator()
-	err = esr.marshaller.BytesToResponse(res.Body, func() interface{} {
-		return &entity
-	})
+	entity.Merge(base)
+	entity, jBody, err := esr.document(entity)
+	if err != nil {
+		return err, nil
+	}
+	body, err := json.Marshal(map[string]json.RawMessage{"doc": json.RawMessage(jBody)})
 	if err != nil {
 		return err, nil
 	}
+	req := esapi.UpdateRequest{Index: esr.index, DocumentID: entityId, Body: bytes.NewReader(body), Refresh: "true"}
+	if err := doRequest(ctx, esr.client, "updating "+entityId+" in "+esr.index, req, nil); err != nil {
+		return err, nil
+	}
 	return nil, entity
 }
 
+// Replace indexes replacement as the whole document of externalId, so fields it leaves empty are cleared.
+func (esr *ElasticsearchRepo) Replace(ctx context.Context, externalId string, replacement pkg.Base) (err error, result pkg.Base) {
+	ctx, done := esr.instrument(ctx, "replace")
+	defer done(&err)
+	if replacement.GetExternalId() != externalId {
+		return fmt.Errorf("replacing %v with the document of %v", externalId, replacement.GetExternalId()), nil
+	}
+	replacement, jBody, err := esr.document(replacement)
+	if err != nil {
+		return err, nil
+	}
+	req := esapi.IndexRequest{Index: esr.index, DocumentID: externalId, Body: strings.NewReader(jBody), Refresh: "true"}
+	if err := doRequest(ctx, esr.client, "replacing "+externalId+" in "+esr.index, req, nil); err != nil {
+		return err, nil
+	}
+	return nil, replacement
+}
+
+func (esr *ElasticsearchRepo) GetByExternalId(ctx context.Context, entityId string) (err error, result pkg.Base) {
+	ctx, done := esr.instrument(ctx, "get_by_external_id")
+	defer done(&err)
+	truthy := true
+	res, err := esapi.GetRequest{Index: esr.index, DocumentID: entityId, Refresh: &truthy, Realtime: &truthy}.Do(ctx, esr.client)
+	if err != nil {
+		return fmt.Errorf("getting %v from %v: %w", entityId, esr.index, err), nil
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("document %v of %v: %w", entityId, esr.index, ErrNotFound), nil
+	}
+	if res.IsError() {
+		return responseError("getting "+entityId+" from "+esr.index, res), nil
+	}
+	var document struct {
+		Source map[string]interface{} `json:"_source"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&document); err != nil {
+		return fmt.Errorf("decoding %v of %v: %w", entityId, esr.index, err), nil
+	}
+	return nil, esr.entityConverter(document.Source)
+}
+
+// stripEncryptedFields keeps encrypted attributes out of the search index, whatever key ToJson used for them.
+func stripEncryptedFields(base pkg.Base, jBody string) (string, error) {
+	fields := fieldcrypt.EncryptedStructFields(base)
+	if len(fields) == 0 {
+		return jBody, nil
+	}
+	var document map[string]interface{}
+	if err := json.Unmarshal([]byte(jBody), &document); err != nil {
+		return "", err
+	}
+	for _, field := range fields {
+		delete(document, field.Name)
+		delete(document, toSnakeCase(field.Name))
+		if jsonName := strings.Split(field.Tag.Get("json"), ",")[0]; jsonName != "" {
+			delete(document, jsonName)
+		}
+	}
+	stripped, err := json.Marshal(document)
+	if err != nil {
+		return "", err
+	}
+	return string(stripped), nil
+}
+
 func toSnakeCase(input string) string {
 	return stringy.New(input).SnakeCase().ToLower()
 }
 
-func (esr *ElasticsearchRepo) MultiGetByExternalId(ctx context.Context, entityIds []string) (error, []pkg.Base) {
-	var nEntityIds []string
+func (esr *ElasticsearchRepo) MultiGetByExternalId(ctx context.Context, entityIds []string) (err error, results []pkg.Base) {
+	ctx, done := esr.instrument(ctx, "multi_get")
+	defer done(&err)
+	ids := make([]interface{}, 0, len(entityIds))
 	for _, entityId := range entityIds {
-		nEntityIds = append(nEntityIds, fmt.Sprintf("\"%v\"", entityId))
+		ids = append(ids, entityId)
 	}
-	req := esapi.SearchRequest{
-		Index: []string{esr.index},
-		Body:  strings.NewReader(fmt.Sprintf("{\"query\":{\"terms\":{\"_id\":[%v]}}}", strings.Join(nEntityIds, ","))),
-	}
-	res, err := req.Do(ctx, esr.client)
-	if err != nil || !esr.sChecker.IsSuccessFul(res.StatusCode) {
+	results, err = esr.searchEntities(ctx, NewSearchSource(Terms("_id", ids...)).Size(len(ids)))
+	if err != nil {
 		return err, nil
 	}
-	var response ESSearchResponse
-	err = esr.marshaller.BytesToResponse(res.Body, func() interface{} {
-		return &response
-	})
+	return nil, results
+}
+
+// exactField is the field that matches field exactly, as the generated mapping declares it. Without a default
+// entity there is no mapping, string fields are then taken to have the keyword subfield of dynamic mapping.
+func (esr *ElasticsearchRepo) exactField(field string, fieldType query.FieldType) (string, error) {
+	if esr.exactFields == nil {
+		if fieldType == query.StringField {
+			return field + ".keyword", nil
+		}
+		return field, nil
+	}
+	if exact, ok := esr.exactFields[field]; ok {
+		return exact, nil
+	}
+	return "", fmt.Errorf("%v has no exactly matching field for %v", esr.index, field)
+}
+
+func esCondition(field string, condition query.Condition) (Query, bool) {
+	switch condition.Operator {
+	case query.Eq:
+		return Term(field, condition.Value), false
+	case query.Ne:
+		return Term(field, condition.Value), true
+	case query.Gt:
+		return Range(field).Gt(condition.Value), false
+	case query.Gte:
+		return Range(field).Gte(condition.Value), false
+	case query.Lt:
+		return Range(field).Lt(condition.Value), false
+	case query.Lte:
+		return Range(field).Lte(condition.Value), false
+	case query.In:
+		return Terms(field, condition.Values...), false
+	case query.Nin:
+		return Terms(field, condition.Values...), true
+	case query.Like:
+		return Wildcard(field, "*"+EscapeWildcard(condition.Value.(string))+"*"), false
+	case query.Prefix:
+		return Prefix(field, condition.Value.(string)), false
+	case query.Exists:
+		return Exists(condition.Field), !condition.Value.(bool)
+	}
+	return nil, false
+}
+
+// Find runs a parsed query filter, matching text fields exactly through their keyword subfield.
+func (esr *ElasticsearchRepo) Find(ctx context.Context, filter *query.Filter) (err error, results []pkg.Base) {
+	ctx, done := esr.instrument(ctx, "find")
+	defer done(&err)
+	boolQuery := Bool()
+	for _, condition := range filter.Conditions {
+		field, err := esr.exactField(condition.Field, condition.Type)
+		if err != nil {
+			return err, nil
+		}
+		clause, negated := esCondition(field, condition)
+		if clause == nil {
+			return fmt.Errorf("unsupported operator %v", condition.Operator), nil
+		}
+		if negated {
+			boolQuery.MustNot(clause)
+		} else {
+			boolQuery.Filter(clause)
+		}
+	}
+	source := NewSearchSource(boolQuery).From(filter.Offset)
+	if filter.Limit > 0 {
+		source.Size(filter.Limit)
+	}
+	for _, sortField := range filter.Sort {
+		field, err := esr.exactField(sortField.Field, sortField.Type)
+		if err != nil {
+			return err, nil
+		}
+		source.Sort(field, sortField.Descending)
+	}
+	results, err = esr.searchEntities(ctx, source)
 	if err != nil {
 		return err, nil
 	}
-	var result []pkg.Base
-	for _, hit := range response.Hits.Hits {
-		result = append(result, esr.entityConverter(hit.Source))
-	}
-	return nil, result
+	return nil, results
 }
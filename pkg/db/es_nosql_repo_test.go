@@ -0,0 +1,241 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/elastic/go-elasticsearch/v7"
+	"github.com/kutty-kumar/charminder/pkg"
+	"github.com/kutty-kumar/charminder/pkg/query"
+)
+
+const emptySearchResponse = `{"hits":{"total":{"value":0,"relation":"eq"},"hits":[]}}`
+
+// esRequest is a request the fake cluster received.
+type esRequest struct {
+	method string
+	path   string
+	query  url.Values
+	body   string
+}
+
+// newTestESRepo starts a fake cluster answering every request with respond and returns a repository on it
+// together with the requests it received.
+func newTestESRepo(t *testing.T, respond func(r esRequest) (int, string), opts ...ElasticsearchRepoOption) (*ElasticsearchRepo, *[]esRequest) {
+	t.Helper()
+	var requests []esRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		request := esRequest{method: r.Method, path: r.URL.Path, query: r.URL.Query(), body: string(body)}
+		requests = append(requests, request)
+		status, response := respond(request)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(response))
+	}))
+	t.Cleanup(server.Close)
+	client, err := elasticsearch.NewClient(elasticsearch.Config{Addresses: []string{server.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defaults := []ElasticsearchRepoOption{
+		WithClient(client), WithIndex("test_entities"), WithEntityCreator(newTestEntity), WithESExternalIdSetter(setTestExternalId),
+		WithEntityConverter(func(source map[string]interface{}) pkg.Base {
+			entity := &testEntity{}
+			raw, _ := json.Marshal(source)
+			_ = json.Unmarshal(raw, entity)
+			return entity
+		}),
+	}
+	repo, err := NewElasticsearchRepo(append(defaults, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return repo.(*ElasticsearchRepo), &requests
+}
+
+func TestESFindMatchesThroughTheMappedField(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   string
+		mapped  bool
+		want    []string
+		wantErr bool
+	}{
+		{name: "text field", query: "name=lamp", mapped: true, want: []string{`{"term":{"name.keyword":"lamp"}}`}},
+		{name: "keyword field", query: "sku=A-1", mapped: true, want: []string{`{"term":{"sku":"A-1"}}`}},
+		{name: "number field", query: "price[gt]=3&sort=-price", mapped: true, want: []string{`{"range":{"price":{"gt":3}}}`, `{"price":{"order":"desc"}}`}},
+		{name: "sort on a keyword field", query: "sort=sku", mapped: true, want: []string{`{"sku":{"order":"asc"}}`}},
+		{name: "sort on a text field", query: "sort=-name", mapped: true, want: []string{`{"name.keyword":{"order":"desc"}}`}},
+		{name: "without a mapping", query: "sku=A-1", want: []string{`{"term":{"sku.keyword":"A-1"}}`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var opts []ElasticsearchRepoOption
+			if tt.mapped {
+				opts = append(opts, WithDefaultEntity(&testEntity{}))
+			}
+			repo, requests := newTestESRepo(t, func(esRequest) (int, string) { return http.StatusOK, emptySearchResponse }, opts...)
+			values, _ := url.ParseQuery(tt.query)
+			filter, err := query.Parse(values, query.NewSchema(&testEntity{}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err, _ := repo.Find(context.Background(), filter); err != nil {
+				t.Fatal(err)
+			}
+			body := (*requests)[len(*requests)-1].body
+			for _, want := range tt.want {
+				if !strings.Contains(body, want) {
+					t.Errorf("search body %v does not contain %v", body, want)
+				}
+			}
+		})
+	}
+	repo, _ := newTestESRepo(t, func(esRequest) (int, string) { return http.StatusOK, emptySearchResponse }, WithDefaultEntity(&testEntity{}))
+	filter := &query.Filter{Conditions: []query.Condition{{Field: "color", Type: query.StringField, Operator: query.Eq, Value: "red"}}}
+	if err, _ := repo.Find(context.Background(), filter); err == nil {
+		t.Fatal("Find() on a field outside the mapping succeeded")
+	}
+}
+
+func TestNewElasticsearchRepoRequiresOptions(t *testing.T) {
+	client, err := elasticsearch.NewClient(elasticsearch.Config{Addresses: []string{"http://localhost:9200"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	converter := WithEntityConverter(func(map[string]interface{}) pkg.Base { return &testEntity{} })
+	tests := []struct {
+		name string
+		opts []ElasticsearchRepoOption
+		want string
+	}{
+		{name: "client", opts: []ElasticsearchRepoOption{WithIndex("test_entities"), converter, WithEntityCreator(newTestEntity), WithESExternalIdSetter(setTestExternalId)}, want: "WithClient"},
+		{name: "index", opts: []ElasticsearchRepoOption{WithClient(client), converter, WithEntityCreator(newTestEntity), WithESExternalIdSetter(setTestExternalId)}, want: "WithIndex"},
+		{name: "converter", opts: []ElasticsearchRepoOption{WithClient(client), WithIndex("test_entities"), WithEntityCreator(newTestEntity), WithESExternalIdSetter(setTestExternalId)}, want: "WithEntityConverter"},
+		{name: "creator", opts: []ElasticsearchRepoOption{WithClient(client), WithIndex("test_entities"), converter, WithESExternalIdSetter(setTestExternalId)}, want: "WithEntityCreator"},
+		{name: "external id setter", opts: []ElasticsearchRepoOption{WithClient(client), WithIndex("test_entities"), converter, WithEntityCreator(newTestEntity)}, want: "WithESExternalIdSetter"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewElasticsearchRepo(tt.opts...)
+			if !errors.Is(err, pkg.ErrMissingOption) || !strings.HasSuffix(err.Error(), tt.want) {
+				t.Fatalf("NewElasticsearchRepo() = %v, want a missing %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestESWritesAddressTheIndex(t *testing.T) {
+	stored := `{"_index":"test_entities","_id":"e1","found":true,"_source":{"external_id":"e1","name":"lamp","price":3}}`
+	repo, requests := newTestESRepo(t, func(r esRequest) (int, string) {
+		if r.method == http.MethodGet {
+			return http.StatusOK, stored
+		}
+		return http.StatusOK, `{"result":"updated"}`
+	})
+	ctx := context.Background()
+
+	entity := &testEntity{Name: "lamp"}
+	entity.ExternalId = "e1"
+	if err, _ := repo.Create(ctx, entity); err != nil {
+		t.Fatal(err)
+	}
+	err, got := repo.GetByExternalId(ctx, "e1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := got.(*testEntity); got.ExternalId != "e1" || got.Name != "lamp" || got.Price != 3 {
+		t.Fatalf("GetByExternalId() = %+v, want the stored source", got)
+	}
+	err, updated := repo.Update(ctx, "e1", &testEntity{Name: "desk lamp"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated := updated.(*testEntity); updated.Name != "desk lamp" || updated.Price != 3 {
+		t.Fatalf("Update() = %+v, want the merged entity", updated)
+	}
+
+	want := []struct {
+		method, path, body string
+	}{
+		{method: http.MethodPut, path: "/test_entities/_doc/e1", body: `"name":"lamp"`},
+		{method: http.MethodGet, path: "/test_entities/_doc/e1"},
+		{method: http.MethodGet, path: "/test_entities/_doc/e1"},
+		{method: http.MethodPost, path: "/test_entities/_doc/e1/_update", body: `{"doc":{`},
+	}
+	if len(*requests) != len(want) {
+		t.Fatalf("the cluster received %v requests, want %v", len(*requests), len(want))
+	}
+	for i, w := range want {
+		r := (*requests)[i]
+		if r.method != w.method || r.path != w.path || !strings.Contains(r.body, w.body) {
+			t.Errorf("request %v is %v %v %v, want %v %v containing %v", i, r.method, r.path, r.body, w.method, w.path, w.body)
+		}
+	}
+	if body := (*requests)[3].body; !strings.Contains(body, `"name":"desk lamp"`) || !strings.Contains(body, `"price":3`) {
+		t.Errorf("update body %v is not the merged document", body)
+	}
+}
+
+func TestESWriteErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		call   func(repo *ElasticsearchRepo) error
+		want   string
+		is     error
+	}{
+		{
+			name:   "create rejected",
+			status: http.StatusBadRequest,
+			call: func(repo *ElasticsearchRepo) error {
+				entity := &testEntity{}
+				entity.ExternalId = "e1"
+				err, _ := repo.Create(context.Background(), entity)
+				return err
+			},
+			want: "indexing e1 in test_entities failed with status 400",
+		},
+		{
+			name:   "get missing",
+			status: http.StatusNotFound,
+			call: func(repo *ElasticsearchRepo) error {
+				err, _ := repo.GetByExternalId(context.Background(), "e1")
+				return err
+			},
+			is: ErrNotFound,
+		},
+		{
+			name:   "update missing",
+			status: http.StatusNotFound,
+			call: func(repo *ElasticsearchRepo) error {
+				err, _ := repo.Update(context.Background(), "e1", &testEntity{Name: "lamp"})
+				return err
+			},
+			is: ErrNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo, _ := newTestESRepo(t, func(esRequest) (int, string) { return tt.status, `{"error":"rejected"}` })
+			err := tt.call(repo)
+			if err == nil {
+				t.Fatal("the call succeeded")
+			}
+			if tt.want != "" && !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error %v does not contain %v", err, tt.want)
+			}
+			if tt.is != nil && !errors.Is(err, tt.is) {
+				t.Errorf("error %v is not %v", err, tt.is)
+			}
+		})
+	}
+}
@@ -0,0 +1,594 @@
+package db
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// Query is a node of the Elasticsearch query DSL. Source returns the JSON form as plain values, so field names
+// and user input only ever reach the request body through encoding/json.
+type Query interface {
+	Source() interface{}
+}
+
+func sources(queries []Query) []interface{} {
+	result := make([]interface{}, 0, len(queries))
+	for _, q := range queries {
+		result = append(result, q.Source())
+	}
+	return result
+}
+
+type BoolQuery struct {
+	must               []Query
+	should             []Query
+	filter             []Query
+	mustNot            []Query
+	minimumShouldMatch string
+}
+
+func Bool() *BoolQuery {
+	return &BoolQuery{}
+}
+
+func (q *BoolQuery) Must(queries ...Query) *BoolQuery {
+	q.must = append(q.must, queries...)
+	return q
+}
+
+func (q *BoolQuery) Should(queries ...Query) *BoolQuery {
+	q.should = append(q.should, queries...)
+	return q
+}
+
+// Filter adds clauses that must match but do not contribute to the score.
+func (q *BoolQuery) Filter(queries ...Query) *BoolQuery {
+	q.filter = append(q.filter, queries...)
+	return q
+}
+
+func (q *BoolQuery) MustNot(queries ...Query) *BoolQuery {
+	q.mustNot = append(q.mustNot, queries...)
+	return q
+}
+
+// MinimumShouldMatch takes a count such as "1" or a percentage such as "75%".
+func (q *BoolQuery) MinimumShouldMatch(minimum string) *BoolQuery {
+	q.minimumShouldMatch = minimum
+	return q
+}
+
+func (q *BoolQuery) Source() interface{} {
+	body := make(map[string]interface{})
+	for name, clauses := range map[string][]Query{"must": q.must, "should": q.should, "filter": q.filter, "must_not": q.mustNot} {
+		if len(clauses) > 0 {
+			body[name] = sources(clauses)
+		}
+	}
+	if q.minimumShouldMatch != "" {
+		body["minimum_should_match"] = q.minimumShouldMatch
+	}
+	return map[string]interface{}{"bool": body}
+}
+
+type TermQuery struct {
+	field string
+	value interface{}
+}
+
+// Term matches the exact value, use the keyword subfield of analysed string fields.
+func Term(field string, value interface{}) *TermQuery {
+	return &TermQuery{field: field, value: value}
+}
+
+func (q *TermQuery) Source() interface{} {
+	return map[string]interface{}{"term": map[string]interface{}{q.field: q.value}}
+}
+
+type TermsQuery struct {
+	field  string
+	values []interface{}
+}
+
+func Terms(field string, values ...interface{}) *TermsQuery {
+	return &TermsQuery{field: field, values: values}
+}
+
+func (q *TermsQuery) Source() interface{} {
+	values := q.values
+	if values == nil {
+		values = []interface{}{}
+	}
+	return map[string]interface{}{"terms": map[string]interface{}{q.field: values}}
+}
+
+type MatchQuery struct {
+	field     string
+	text      string
+	analyzer  string
+	operator  string
+	fuzziness string
+}
+
+func Match(field, text string) *MatchQuery {
+	return &MatchQuery{field: field, text: text}
+}
+
+func (q *MatchQuery) Analyzer(analyzer string) *MatchQuery {
+	q.analyzer = analyzer
+	return q
+}
+
+// Operator is "or", the default, or "and".
+func (q *MatchQuery) Operator(operator string) *MatchQuery {
+	q.operator = operator
+	return q
+}
+
+// Fuzziness takes an edit distance such as "1" or "AUTO".
+func (q *MatchQuery) Fuzziness(fuzziness string) *MatchQuery {
+	q.fuzziness = fuzziness
+	return q
+}
+
+func (q *MatchQuery) Source() interface{} {
+	body := map[string]interface{}{"query": q.text}
+	putIfSet(body, "analyzer", q.analyzer)
+	putIfSet(body, "operator", q.operator)
+	putIfSet(body, "fuzziness", q.fuzziness)
+	return map[string]interface{}{"match": map[string]interface{}{q.field: body}}
+}
+
+type MatchPhraseQuery struct {
+	field    string
+	text     string
+	analyzer string
+	slop     int
+}
+
+func MatchPhrase(field, text string) *MatchPhraseQuery {
+	return &MatchPhraseQuery{field: field, text: text}
+}
+
+func (q *MatchPhraseQuery) Analyzer(analyzer string) *MatchPhraseQuery {
+	q.analyzer = analyzer
+	return q
+}
+
+func (q *MatchPhraseQuery) Slop(slop int) *MatchPhraseQuery {
+	q.slop = slop
+	return q
+}
+
+func (q *MatchPhraseQuery) Source() interface{} {
+	body := map[string]interface{}{"query": q.text}
+	putIfSet(body, "analyzer", q.analyzer)
+	if q.slop > 0 {
+		body["slop"] = q.slop
+	}
+	return map[string]interface{}{"match_phrase": map[string]interface{}{q.field: body}}
+}
+
+type MultiMatchQuery struct {
+	text      string
+	fields    []string
+	matchType string
+	analyzer  string
+	fuzziness string
+	name      string
+}
+
+// MultiMatch searches fields, or the index default fields when none are given.
+func MultiMatch(text string, fields ...string) *MultiMatchQuery {
+	return &MultiMatchQuery{text: text, fields: fields}
+}
+
+// Type is one of best_fields, most_fields, cross_fields, phrase, phrase_prefix or bool_prefix.
+func (q *MultiMatchQuery) Type(matchType string) *MultiMatchQuery {
+	q.matchType = matchType
+	return q
+}
+
+func (q *MultiMatchQuery) Analyzer(analyzer string) *MultiMatchQuery {
+	q.analyzer = analyzer
+	return q
+}
+
+func (q *MultiMatchQuery) Fuzziness(fuzziness string) *MultiMatchQuery {
+	q.fuzziness = fuzziness
+	return q
+}
+
+// Name tags the query, hits it matched list the name in their matched queries.
+func (q *MultiMatchQuery) Name(name string) *MultiMatchQuery {
+	q.name = name
+	return q
+}
+
+func (q *MultiMatchQuery) Source() interface{} {
+	body := map[string]interface{}{"query": q.text}
+	if len(q.fields) > 0 {
+		body["fields"] = q.fields
+	}
+	putIfSet(body, "type", q.matchType)
+	putIfSet(body, "analyzer", q.analyzer)
+	putIfSet(body, "fuzziness", q.fuzziness)
+	putIfSet(body, "_name", q.name)
+	return map[string]interface{}{"multi_match": body}
+}
+
+type RangeQuery struct {
+	field  string
+	bounds map[string]interface{}
+	format string
+}
+
+func Range(field string) *RangeQuery {
+	return &RangeQuery{field: field, bounds: make(map[string]interface{})}
+}
+
+func (q *RangeQuery) Gt(value interface{}) *RangeQuery {
+	q.bounds["gt"] = value
+	return q
+}
+
+func (q *RangeQuery) Gte(value interface{}) *RangeQuery {
+	q.bounds["gte"] = value
+	return q
+}
+
+func (q *RangeQuery) Lt(value interface{}) *RangeQuery {
+	q.bounds["lt"] = value
+	return q
+}
+
+func (q *RangeQuery) Lte(value interface{}) *RangeQuery {
+	q.bounds["lte"] = value
+	return q
+}
+
+// Format sets the date format of the bounds, e.g. "yyyy-MM-dd".
+func (q *RangeQuery) Format(format string) *RangeQuery {
+	q.format = format
+	return q
+}
+
+func (q *RangeQuery) Source() interface{} {
+	body := make(map[string]interface{}, len(q.bounds)+1)
+	for key, value := range q.bounds {
+		body[key] = value
+	}
+	putIfSet(body, "format", q.format)
+	return map[string]interface{}{"range": map[string]interface{}{q.field: body}}
+}
+
+type ExistsQuery struct {
+	field string
+}
+
+func Exists(field string) *ExistsQuery {
+	return &ExistsQuery{field: field}
+}
+
+func (q *ExistsQuery) Source() interface{} {
+	return map[string]interface{}{"exists": map[string]interface{}{"field": q.field}}
+}
+
+type NestedQuery struct {
+	path      string
+	query     Query
+	scoreMode string
+}
+
+// Nested runs query against the nested objects under path, whose fields are addressed as path.field.
+func Nested(path string, query Query) *NestedQuery {
+	return &NestedQuery{path: path, query: query}
+}
+
+// ScoreMode is one of avg, max, min, sum or none.
+func (q *NestedQuery) ScoreMode(scoreMode string) *NestedQuery {
+	q.scoreMode = scoreMode
+	return q
+}
+
+func (q *NestedQuery) Source() interface{} {
+	body := map[string]interface{}{"path": q.path, "query": q.query.Source()}
+	putIfSet(body, "score_mode", q.scoreMode)
+	return map[string]interface{}{"nested": body}
+}
+
+type PrefixQuery struct {
+	field  string
+	prefix string
+}
+
+func Prefix(field, prefix string) *PrefixQuery {
+	return &PrefixQuery{field: field, prefix: prefix}
+}
+
+func (q *PrefixQuery) Source() interface{} {
+	return map[string]interface{}{"prefix": map[string]interface{}{q.field: q.prefix}}
+}
+
+type WildcardQuery struct {
+	field   string
+	pattern string
+}
+
+// Wildcard takes a pattern where * and ? are wildcards, pass user input through EscapeWildcard first.
+func Wildcard(field, pattern string) *WildcardQuery {
+	return &WildcardQuery{field: field, pattern: pattern}
+}
+
+func (q *WildcardQuery) Source() interface{} {
+	return map[string]interface{}{"wildcard": map[string]interface{}{q.field: q.pattern}}
+}
+
+var wildcardEscaper = strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`)
+
+// EscapeWildcard makes value match literally inside a wildcard pattern.
+func EscapeWildcard(value string) string {
+	return wildcardEscaper.Replace(value)
+}
+
+func putIfSet(body map[string]interface{}, key, value string) {
+	if value != "" {
+		body[key] = value
+	}
+}
+
+// Highlight asks for the fragments of the hit fields that matched the query.
+type Highlight struct {
+	fields            []string
+	preTags           []string
+	postTags          []string
+	fragmentSize      int
+	numberOfFragments int
+	requireMatch      *bool
+}
+
+// NewHighlight highlights fields, which may use wildcards such as "title*".
+func NewHighlight(fields ...string) *Highlight {
+	return &Highlight{fields: fields, fragmentSize: -1, numberOfFragments: -1}
+}
+
+// Tags wraps each match in pre and post, <em> and </em> by default.
+func (h *Highlight) Tags(pre, post string) *Highlight {
+	h.preTags, h.postTags = []string{pre}, []string{post}
+	return h
+}
+
+// FragmentSize is the length of a fragment in characters, 100 by default.
+func (h *Highlight) FragmentSize(size int) *Highlight {
+	h.fragmentSize = size
+	return h
+}
+
+// NumberOfFragments is the most fragments per field, 0 returns the whole field value.
+func (h *Highlight) NumberOfFragments(number int) *Highlight {
+	h.numberOfFragments = number
+	return h
+}
+
+// RequireFieldMatch only highlights fields the query searched, which is the default.
+func (h *Highlight) RequireFieldMatch(require bool) *Highlight {
+	h.requireMatch = &require
+	return h
+}
+
+func (h *Highlight) Source() interface{} {
+	fields := make(map[string]interface{}, len(h.fields))
+	for _, field := range h.fields {
+		fields[field] = map[string]interface{}{}
+	}
+	body := map[string]interface{}{"fields": fields}
+	if h.requireMatch != nil {
+		body["require_field_match"] = *h.requireMatch
+	}
+	if len(h.preTags) > 0 {
+		body["pre_tags"], body["post_tags"] = h.preTags, h.postTags
+	}
+	if h.fragmentSize >= 0 {
+		body["fragment_size"] = h.fragmentSize
+	}
+	if h.numberOfFragments >= 0 {
+		body["number_of_fragments"] = h.numberOfFragments
+	}
+	return body
+}
+
+// Suggester is a named entry of the suggest section of a search request.
+type Suggester interface {
+	Source() interface{}
+}
+
+type CompletionSuggester struct {
+	field          string
+	prefix         string
+	size           int
+	fuzziness      string
+	contexts       map[string][]interface{}
+	skipDuplicates bool
+}
+
+// Completion suggests the inputs of the completion field that start with prefix.
+func Completion(field, prefix string) *CompletionSuggester {
+	return &CompletionSuggester{field: field, prefix: prefix}
+}
+
+func (s *CompletionSuggester) Size(size int) *CompletionSuggester {
+	s.size = size
+	return s
+}
+
+func (s *CompletionSuggester) Fuzziness(fuzziness string) *CompletionSuggester {
+	s.fuzziness = fuzziness
+	return s
+}
+
+// Context keeps the suggestions whose context name has one of values.
+func (s *CompletionSuggester) Context(name string, values ...interface{}) *CompletionSuggester {
+	if s.contexts == nil {
+		s.contexts = make(map[string][]interface{})
+	}
+	s.contexts[name] = append(s.contexts[name], values...)
+	return s
+}
+
+func (s *CompletionSuggester) SkipDuplicates(skip bool) *CompletionSuggester {
+	s.skipDuplicates = skip
+	return s
+}
+
+func (s *CompletionSuggester) Source() interface{} {
+	completion := map[string]interface{}{"field": s.field}
+	if s.size > 0 {
+		completion["size"] = s.size
+	}
+	if s.fuzziness != "" {
+		completion["fuzzy"] = map[string]interface{}{"fuzziness": s.fuzziness}
+	}
+	if len(s.contexts) > 0 {
+		completion["contexts"] = s.contexts
+	}
+	if s.skipDuplicates {
+		completion["skip_duplicates"] = true
+	}
+	return map[string]interface{}{"prefix": s.prefix, "completion": completion}
+}
+
+type sortField struct {
+	source interface{}
+	// distanceUnit is set on geo distance sorts, whose sort value is the distance in that unit.
+	distanceUnit string
+}
+
+// SearchSource is the body of a search request.
+type SearchSource struct {
+	query   Query
+	from    int
+	size    int
+	sort    []sortField
+	suggest map[string]Suggester
+	// trackTotalHits is nil for the Elasticsearch default, an exact count up to 10,000.
+	trackTotalHits interface{}
+	highlight      *Highlight
+	postFilter     Query
+	aggregations   map[string]Aggregation
+}
+
+// Aggregation is a named entry of the aggs section of a search request.
+type Aggregation interface {
+	Source() interface{}
+}
+
+func NewSearchSource(query Query) *SearchSource {
+	return &SearchSource{query: query, size: -1}
+}
+
+func (s *SearchSource) From(from int) *SearchSource {
+	s.from = from
+	return s
+}
+
+// Size limits the number of hits, Elasticsearch returns 10 when it is not set.
+func (s *SearchSource) Size(size int) *SearchSource {
+	s.size = size
+	return s
+}
+
+func (s *SearchSource) Sort(field string, descending bool) *SearchSource {
+	order := "asc"
+	if descending {
+		order = "desc"
+	}
+	s.sort = append(s.sort, sortField{source: map[string]interface{}{field: map[string]interface{}{"order": order}}})
+	return s
+}
+
+func (s *SearchSource) Highlight(highlight *Highlight) *SearchSource {
+	s.highlight = highlight
+	return s
+}
+
+// TrackTotalHits counts every match when true, so the total is exact beyond 10,000 hits.
+func (s *SearchSource) TrackTotalHits(track bool) *SearchSource {
+	s.trackTotalHits = track
+	return s
+}
+
+// TrackTotalHitsUpTo counts matches exactly up to limit, the total is a lower bound beyond it.
+func (s *SearchSource) TrackTotalHitsUpTo(limit int) *SearchSource {
+	s.trackTotalHits = limit
+	return s
+}
+
+// PostFilter narrows the hits after aggregations were computed, so the aggregations ignore it.
+func (s *SearchSource) PostFilter(filter Query) *SearchSource {
+	s.postFilter = filter
+	return s
+}
+
+func (s *SearchSource) Aggregation(name string, aggregation Aggregation) *SearchSource {
+	if s.aggregations == nil {
+		s.aggregations = make(map[string]Aggregation)
+	}
+	s.aggregations[name] = aggregation
+	return s
+}
+
+func (s *SearchSource) Suggest(name string, suggester Suggester) *SearchSource {
+	if s.suggest == nil {
+		s.suggest = make(map[string]Suggester)
+	}
+	s.suggest[name] = suggester
+	return s
+}
+
+func (s *SearchSource) Source() map[string]interface{} {
+	body := make(map[string]interface{})
+	if s.query != nil {
+		body["query"] = s.query.Source()
+	}
+	if s.from > 0 {
+		body["from"] = s.from
+	}
+	if s.size >= 0 {
+		body["size"] = s.size
+	}
+	if len(s.sort) > 0 {
+		sorts := make([]interface{}, 0, len(s.sort))
+		for _, sort := range s.sort {
+			sorts = append(sorts, sort.source)
+		}
+		body["sort"] = sorts
+	}
+	if s.highlight != nil {
+		body["highlight"] = s.highlight.Source()
+	}
+	if s.postFilter != nil {
+		body["post_filter"] = s.postFilter.Source()
+	}
+	if len(s.aggregations) > 0 {
+		aggregations := make(map[string]interface{}, len(s.aggregations))
+		for name, aggregation := range s.aggregations {
+			aggregations[name] = aggregation.Source()
+		}
+		body["aggs"] = aggregations
+	}
+	if s.trackTotalHits != nil {
+		body["track_total_hits"] = s.trackTotalHits
+	}
+	if len(s.suggest) > 0 {
+		suggest := make(map[string]interface{}, len(s.suggest))
+		for name, suggester := range s.suggest {
+			suggest[name] = suggester.Source()
+		}
+		body["suggest"] = suggest
+	}
+	return body
+}
+
+func (s *SearchSource) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.Source())
+}
@@ -0,0 +1,181 @@
+package db
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestQuerySource(t *testing.T) {
+	tests := []struct {
+		name  string
+		query Query
+		want  string
+	}{
+		{name: "term", query: Term("status", "active"), want: `{"term":{"status":"active"}}`},
+		{name: "term number", query: Term("age", 42), want: `{"term":{"age":42}}`},
+		{name: "terms", query: Terms("tags", "a", "b"), want: `{"terms":{"tags":["a","b"]}}`},
+		{name: "terms without values", query: Terms("tags"), want: `{"terms":{"tags":[]}}`},
+		{name: "match", query: Match("title", "red shoes"), want: `{"match":{"title":{"query":"red shoes"}}}`},
+		{
+			name:  "match options",
+			query: Match("title", "red shoes").Analyzer("english").Operator("and").Fuzziness("AUTO"),
+			want:  `{"match":{"title":{"analyzer":"english","fuzziness":"AUTO","operator":"and","query":"red shoes"}}}`,
+		},
+		{name: "match phrase", query: MatchPhrase("title", "red shoes").Slop(2), want: `{"match_phrase":{"title":{"query":"red shoes","slop":2}}}`},
+		{
+			name:  "multi match",
+			query: MultiMatch("red", "title^2", "body").Type("best_fields").Name("search"),
+			want:  `{"multi_match":{"_name":"search","fields":["title^2","body"],"query":"red","type":"best_fields"}}`,
+		},
+		{name: "multi match default fields", query: MultiMatch("red"), want: `{"multi_match":{"query":"red"}}`},
+		{name: "range", query: Range("price").Gte(10).Lt(20), want: `{"range":{"price":{"gte":10,"lt":20}}}`},
+		{
+			name:  "date range",
+			query: Range("created").Gt("2021-01-01").Lte("now").Format("yyyy-MM-dd"),
+			want:  `{"range":{"created":{"format":"yyyy-MM-dd","gt":"2021-01-01","lte":"now"}}}`,
+		},
+		{name: "exists", query: Exists("email"), want: `{"exists":{"field":"email"}}`},
+		{
+			name:  "nested",
+			query: Nested("comments", Term("comments.author", "ann")).ScoreMode("max"),
+			want:  `{"nested":{"path":"comments","query":{"term":{"comments.author":"ann"}},"score_mode":"max"}}`,
+		},
+		{name: "prefix", query: Prefix("name", "jo"), want: `{"prefix":{"name":"jo"}}`},
+		{name: "wildcard", query: Wildcard("name", "jo*n"), want: `{"wildcard":{"name":"jo*n"}}`},
+		{name: "empty bool", query: Bool(), want: `{"bool":{}}`},
+		{
+			name:  "bool",
+			query: Bool().Must(Term("a", 1)).Should(Term("b", 2)).Filter(Exists("c")).MustNot(Term("d", 4)).MinimumShouldMatch("1"),
+			want:  `{"bool":{"filter":[{"exists":{"field":"c"}}],"minimum_should_match":"1","must":[{"term":{"a":1}}],"must_not":[{"term":{"d":4}}],"should":[{"term":{"b":2}}]}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sourceJson(t, tt.query); got != tt.want {
+				t.Fatalf("source = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestQuerySourceKeepsInputAsData checks that field names and values carrying JSON syntax are escaped instead of
+// adding clauses to the request body.
+func TestQuerySourceKeepsInputAsData(t *testing.T) {
+	const field = `name"},"match_all":{"`
+	const value = `x"}},{"match_all":{}}]}}`
+	queries := map[string]Query{
+		"term":         Term(field, value),
+		"terms":        Terms(field, value),
+		"match":        Match(field, value),
+		"match phrase": MatchPhrase(field, value),
+		"multi match":  MultiMatch(value, field),
+		"range":        Range(field).Gte(value),
+		"exists":       Exists(field),
+		"nested":       Nested(field, Term(field, value)),
+		"prefix":       Prefix(field, value),
+		"wildcard":     Wildcard(field, value),
+		"bool":         Bool().Must(Term(field, value)).MinimumShouldMatch(value),
+	}
+	for name, query := range queries {
+		t.Run(name, func(t *testing.T) {
+			var decoded interface{}
+			if err := json.Unmarshal([]byte(sourceJson(t, query)), &decoded); err != nil {
+				t.Fatal(err)
+			}
+			if !containsString(decoded, field) {
+				t.Fatalf("the body %v lost the field %v", decoded, field)
+			}
+			if countKeys(decoded, "match_all") != 0 {
+				t.Fatalf("the body %v grew a match_all clause", decoded)
+			}
+		})
+	}
+}
+
+func TestSearchSourceJson(t *testing.T) {
+	source := NewSearchSource(Match("title", "shoes")).
+		From(20).
+		Size(10).
+		Sort("price", true).
+		Sort("_score", false).
+		PostFilter(Term("brand", "acme")).
+		Aggregation("brands", aggregation{"terms": map[string]interface{}{"field": "brand"}}).
+		TrackTotalHits(true).
+		Highlight(NewHighlight("title").Tags("[", "]"))
+	out, err := json.Marshal(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"aggs":{"brands":{"terms":{"field":"brand"}}},"from":20,` +
+		`"highlight":{"fields":{"title":{}},"post_tags":["]"],"pre_tags":["["]},` +
+		`"post_filter":{"term":{"brand":"acme"}},"query":{"match":{"title":{"query":"shoes"}}},"size":10,` +
+		`"sort":[{"price":{"order":"desc"}},{"_score":{"order":"asc"}}],"track_total_hits":true}`
+	if string(out) != want {
+		t.Fatalf("json = %s\nwant %s", out, want)
+	}
+}
+
+func TestSearchSourceOmitsUnsetParts(t *testing.T) {
+	out, err := json.Marshal(NewSearchSource(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != `{}` {
+		t.Fatalf("json = %s, want {}", out)
+	}
+	out, err = json.Marshal(NewSearchSource(nil).Size(0).TrackTotalHitsUpTo(500))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != `{"size":0,"track_total_hits":500}` {
+		t.Fatalf("json = %s", out)
+	}
+}
+
+func TestEscapeWildcard(t *testing.T) {
+	if got := EscapeWildcard(`a*b?c\d`); got != `a\*b\?c\\d` {
+		t.Fatalf("EscapeWildcard = %v", got)
+	}
+	if got := sourceJson(t, Wildcard("name", EscapeWildcard("50%*"))); got != `{"wildcard":{"name":"50%\\*"}}` {
+		t.Fatalf("source = %v", got)
+	}
+}
+
+// containsString reports whether s is a key or a string value anywhere in v.
+func containsString(v interface{}, s string) bool {
+	switch v := v.(type) {
+	case string:
+		return v == s
+	case map[string]interface{}:
+		for k, child := range v {
+			if k == s || containsString(child, s) {
+				return true
+			}
+		}
+	case []interface{}:
+		for _, child := range v {
+			if containsString(child, s) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func countKeys(v interface{}, key string) int {
+	count := 0
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, child := range v {
+			if k == key {
+				count++
+			}
+			count += countKeys(child, key)
+		}
+	case []interface{}:
+		for _, child := range v {
+			count += countKeys(child, key)
+		}
+	}
+	return count
+}
@@ -0,0 +1,68 @@
+package db
+
+import (
+	"github.com/kutty-kumar/charminder/pkg"
+	"time"
+)
+
+type SearchHit struct {
+	Id     string
+	Score  float64
+	Entity pkg.Base
+	// Highlights holds the highlighted fragments per field, when the search asked for them.
+	Highlights map[string][]string
+	// Sort holds the sort values of the hit, when the search was sorted.
+	Sort []interface{}
+	// Distance is the distance to the origin of a SortByDistance sort, in its unit.
+	Distance *float64
+}
+
+// SearchResult is one page of a search. Total counts every match, exactly when TotalRelation is "eq" and as a
+// lower bound when it is "gte".
+type SearchResult struct {
+	Hits          []SearchHit
+	Total         uint
+	TotalRelation string
+	MaxScore      float64
+	Took          time.Duration
+}
+
+// Exact reports whether Total is the exact number of matches.
+func (r *SearchResult) Exact() bool {
+	return r.TotalRelation != "gte"
+}
+
+func (r *SearchResult) Entities() []pkg.Base {
+	var entities []pkg.Base
+	for _, hit := range r.Hits {
+		entities = append(entities, hit.Entity)
+	}
+	return entities
+}
+
+func (esr *ElasticsearchRepo) searchResult(response *ESSearchResponse, source *SearchSource) *SearchResult {
+	result := &SearchResult{
+		Hits:          make([]SearchHit, 0, len(response.Hits.Hits)),
+		Total:         response.Hits.Total.Value,
+		TotalRelation: response.Hits.Total.Relation,
+		MaxScore:      response.Hits.MaxScore,
+		Took:          time.Duration(response.Took) * time.Millisecond,
+	}
+	distanceSort := source.distanceSort()
+	for _, hit := range response.Hits.Hits {
+		searchHit := SearchHit{
+			Id:         hit.Id,
+			Score:      hit.Score,
+			Entity:     esr.entityConverter(hit.Source),
+			Highlights: hit.Highlight,
+			Sort:       hit.Sort,
+		}
+		if distanceSort >= 0 && distanceSort < len(hit.Sort) {
+			if distance, ok := hit.Sort[distanceSort].(float64); ok {
+				searchHit.Distance = &distance
+			}
+		}
+		result.Hits = append(result.Hits, searchHit)
+	}
+	return result
+}
@@ -0,0 +1,68 @@
+package db
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSearchResultMapsTheResponse(t *testing.T) {
+	repo, _ := newTestESRepo(t, func(esRequest) (int, string) { return http.StatusOK, emptySearchResponse })
+	response := &ESSearchResponse{
+		Took: 12,
+		Hits: Hits{
+			Total:    Total{Value: 10000, Relation: "gte"},
+			MaxScore: 4.5,
+			Hits: []EsHit{
+				{
+					Id: "a", Score: 4.5, Source: map[string]interface{}{"name": "desk lamp"},
+					Highlight: map[string][]string{"name": {"desk <em>lamp</em>"}},
+					Sort:      []interface{}{4.5, "a"},
+				},
+				{Id: "b", Score: 1.25, Source: map[string]interface{}{"name": "lamp shade"}},
+			},
+		},
+	}
+	result := repo.searchResult(response, NewSearchSource(Match("name", "lamp")))
+	if result.Total != 10000 || result.TotalRelation != "gte" || result.Exact() {
+		t.Fatalf("total = %v %v, exact %v, want a lower bound of 10000", result.Total, result.TotalRelation, result.Exact())
+	}
+	if result.MaxScore != 4.5 || result.Took != 12*time.Millisecond {
+		t.Fatalf("max score %v, took %v, want 4.5 and 12ms", result.MaxScore, result.Took)
+	}
+	if len(result.Hits) != 2 {
+		t.Fatalf("hits = %+v, want 2", result.Hits)
+	}
+	first, second := result.Hits[0], result.Hits[1]
+	if first.Id != "a" || first.Score != 4.5 || first.Distance != nil {
+		t.Fatalf("first hit = %+v", first)
+	}
+	if want := map[string][]string{"name": {"desk <em>lamp</em>"}}; !reflect.DeepEqual(first.Highlights, want) {
+		t.Fatalf("highlights = %v, want %v", first.Highlights, want)
+	}
+	if want := []interface{}{4.5, "a"}; !reflect.DeepEqual(first.Sort, want) {
+		t.Fatalf("sort = %v, want %v", first.Sort, want)
+	}
+	if second.Id != "b" || second.Score != 1.25 || second.Highlights != nil || second.Sort != nil {
+		t.Fatalf("second hit = %+v", second)
+	}
+	var names []string
+	for _, entity := range result.Entities() {
+		names = append(names, entity.(*testEntity).Name)
+	}
+	if want := []string{"desk lamp", "lamp shade"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("entities = %v, want %v", names, want)
+	}
+}
+
+func TestSearchResultExact(t *testing.T) {
+	for relation, want := range map[string]bool{"eq": true, "gte": false, "": true} {
+		if got := (&SearchResult{TotalRelation: relation}).Exact(); got != want {
+			t.Fatalf("Exact() with relation %q = %v, want %v", relation, got, want)
+		}
+	}
+	if entities := (&SearchResult{}).Entities(); entities != nil {
+		t.Fatalf("Entities() of an empty result = %v", entities)
+	}
+}
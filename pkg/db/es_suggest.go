@@ -0,0 +1,215 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"github.com/kutty-kumar/charminder/pkg"
+	"sort"
+	"strings"
+)
+
+type SuggestEntry struct {
+	Text    string             `json:"text"`
+	Offset  int                `json:"offset"`
+	Length  int                `json:"length"`
+	Options []CompletionOption `json:"options"`
+}
+
+type CompletionOption struct {
+	Text     string                 `json:"text"`
+	Id       string                 `json:"_id"`
+	Score    float64                `json:"_score"`
+	Source   map[string]interface{} `json:"_source"`
+	Contexts map[string][]string    `json:"contexts,omitempty"`
+}
+
+// Suggestion is one typeahead candidate: the text of Field that completes the prefix and the entity holding it.
+type Suggestion struct {
+	Text   string
+	Field  string
+	Score  float64
+	Entity pkg.Base
+}
+
+type suggestConfig struct {
+	fields    []string
+	fuzziness string
+}
+
+type SuggestOption func(c *suggestConfig)
+
+// WithSuggestFields limits suggestions to some of the autocomplete fields, all of them are used by default.
+func WithSuggestFields(fields ...string) SuggestOption {
+	return func(c *suggestConfig) {
+		c.fields = fields
+	}
+}
+
+// WithSuggestFuzziness tolerates typos in the prefix, e.g. "1" or "AUTO".
+func WithSuggestFuzziness(fuzziness string) SuggestOption {
+	return func(c *suggestConfig) {
+		c.fuzziness = fuzziness
+	}
+}
+
+// Suggest completes prefix from the fields tagged es:"autocomplete" and returns at most limit suggestions, best
+// first and without repeated texts. Filters restrict the entities suggestions come from: on search_as_you_type
+// fields they are exact term filters, so string fields need their keyword subfield; on completion fields every
+// filter must name one of the field's contexts.
+func (esr *ElasticsearchRepo) Suggest(ctx context.Context, prefix string, limit int, filters map[string]interface{}, opts ...SuggestOption) (err error, suggestions []Suggestion) {
+	ctx, done := esr.instrument(ctx, "suggest")
+	defer done(&err)
+	config := &suggestConfig{}
+	for _, opt := range opts {
+		opt(config)
+	}
+	if limit <= 0 {
+		return fmt.Errorf("suggest limit must be positive, got %v", limit), nil
+	}
+	fields := config.fields
+	if len(fields) == 0 {
+		for field := range esr.autocomplete {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+	}
+	if len(fields) == 0 {
+		return fmt.Errorf("%v has no autocomplete fields, tag them with es:\"autocomplete\"", esr.index), nil
+	}
+	filterKeys := make([]string, 0, len(filters))
+	for key := range filters {
+		filterKeys = append(filterKeys, key)
+	}
+	sort.Strings(filterKeys)
+
+	prefixQuery := Bool().MinimumShouldMatch("1")
+	source := NewSearchSource(nil).Size(0)
+	hasPrefixQuery := false
+	for _, field := range fields {
+		autocomplete, ok := esr.autocomplete[field]
+		if !ok {
+			return fmt.Errorf("%v is not an autocomplete field of %v", field, esr.index), nil
+		}
+		suggestField := field + "." + SuggestSubfield
+		if autocomplete.kind == "completion" {
+			suggester := Completion(suggestField, prefix).Size(limit).Fuzziness(config.fuzziness).SkipDuplicates(true)
+			for _, key := range filterKeys {
+				if !contains(autocomplete.contexts, key) {
+					return fmt.Errorf("%v has no completion context %v", field, key), nil
+				}
+				suggester.Context(key, filterValues(filters[key])...)
+			}
+			source.Suggest(field, suggester)
+			continue
+		}
+		hasPrefixQuery = true
+		prefixQuery.Should(MultiMatch(prefix, suggestField, suggestField+"._2gram", suggestField+"._3gram").
+			Type("bool_prefix").Fuzziness(config.fuzziness).Name(field))
+	}
+	if hasPrefixQuery {
+		for _, key := range filterKeys {
+			prefixQuery.Filter(Terms(key, filterValues(filters[key])...))
+		}
+		source.query = prefixQuery
+		source.Size(limit)
+	}
+
+	response, err := esr.search(ctx, source)
+	if err != nil {
+		return err, nil
+	}
+	for _, hit := range response.Hits.Hits {
+		for _, field := range hit.MatchedQueries {
+			if text, ok := completingValue(hit.Source, field, prefix); ok {
+				suggestions = append(suggestions, Suggestion{Text: text, Field: field, Score: hit.Score, Entity: esr.entityConverter(hit.Source)})
+			}
+		}
+	}
+	for field, entries := range response.Suggest {
+		for _, entry := range entries {
+			for _, option := range entry.Options {
+				suggestions = append(suggestions, Suggestion{Text: option.Text, Field: field, Score: option.Score, Entity: esr.entityConverter(option.Source)})
+			}
+		}
+	}
+	return nil, rankSuggestions(suggestions, limit)
+}
+
+func rankSuggestions(suggestions []Suggestion, limit int) []Suggestion {
+	sort.SliceStable(suggestions, func(i, j int) bool {
+		if suggestions[i].Score != suggestions[j].Score {
+			return suggestions[i].Score > suggestions[j].Score
+		}
+		return suggestions[i].Text < suggestions[j].Text
+	})
+	seen := make(map[string]bool, len(suggestions))
+	ranked := make([]Suggestion, 0, limit)
+	for _, suggestion := range suggestions {
+		key := strings.ToLower(suggestion.Text)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		ranked = append(ranked, suggestion)
+		if len(ranked) == limit {
+			break
+		}
+	}
+	return ranked
+}
+
+// completingValue finds the value of the dotted path in source, preferring the one of a multi-valued field that
+// starts with prefix.
+func completingValue(source map[string]interface{}, path, prefix string) (string, bool) {
+	var value interface{} = source
+	for _, name := range strings.Split(path, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return "", false
+		}
+		value = object[name]
+	}
+	switch typed := value.(type) {
+	case string:
+		return typed, true
+	case []interface{}:
+		first := ""
+		for _, element := range typed {
+			text, ok := element.(string)
+			if !ok {
+				continue
+			}
+			if strings.HasPrefix(strings.ToLower(text), strings.ToLower(prefix)) {
+				return text, true
+			}
+			if first == "" {
+				first = text
+			}
+		}
+		return first, first != ""
+	}
+	return "", false
+}
+
+func filterValues(value interface{}) []interface{} {
+	switch typed := value.(type) {
+	case []interface{}:
+		return typed
+	case []string:
+		values := make([]interface{}, 0, len(typed))
+		for _, v := range typed {
+			values = append(values, v)
+		}
+		return values
+	}
+	return []interface{}{value}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,138 @@
+package db
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+// suggestEntity completes titles as you type and brands through a completion field with contexts.
+type suggestEntity struct {
+	testEntity
+	Title string `json:"title" es:"text,autocomplete"`
+	Brand string `json:"brand" es:"keyword,autocomplete=completion,contexts=tenant|status"`
+}
+
+func TestSuggestCompletionRequest(t *testing.T) {
+	repo, requests := newTestESRepo(t, func(esRequest) (int, string) {
+		return http.StatusOK, `{"hits":{"total":{"value":0,"relation":"eq"},"hits":[]},"suggest":{"brand":[{"text":"ac","options":[
+			{"text":"Acme","_id":"1","_score":2,"_source":{"name":"lamp"}},
+			{"text":"Acorn","_id":"2","_score":3,"_source":{"name":"desk"}}
+		]}]}}`
+	}, WithDefaultEntity(&suggestEntity{}))
+	filters := map[string]interface{}{"tenant": "t1", "status": []string{"active", "draft"}}
+	err, suggestions := repo.Suggest(context.Background(), "ac", 3, filters, WithSuggestFields("brand"), WithSuggestFuzziness("1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"size":0,"suggest":{"brand":{"completion":{"contexts":{"status":["active","draft"],"tenant":["t1"]},` +
+		`"field":"brand.suggest","fuzzy":{"fuzziness":"1"},"size":3,"skip_duplicates":true},"prefix":"ac"}}}`
+	if got := (*requests)[0].body; got != want {
+		t.Fatalf("body = %v\nwant %v", got, want)
+	}
+	if len(suggestions) != 2 || suggestions[0].Text != "Acorn" || suggestions[0].Field != "brand" || suggestions[1].Text != "Acme" {
+		t.Fatalf("suggestions = %+v, want Acorn before Acme", suggestions)
+	}
+	if entity := suggestions[0].Entity.(*testEntity); entity.Name != "desk" {
+		t.Fatalf("entity of the first suggestion = %+v", entity)
+	}
+}
+
+func TestSuggestSearchAsYouTypeRequest(t *testing.T) {
+	repo, requests := newTestESRepo(t, func(esRequest) (int, string) {
+		return http.StatusOK, `{"hits":{"total":{"value":2,"relation":"eq"},"hits":[
+			{"_id":"1","_score":1.5,"_source":{"title":"Lamp shade"},"matched_queries":["title"]},
+			{"_id":"2","_score":1.2,"_source":{"title":"lamp Shade"},"matched_queries":["title"]}
+		]}}`
+	}, WithDefaultEntity(&suggestEntity{}))
+	err, suggestions := repo.Suggest(context.Background(), "la", 3, map[string]interface{}{"sku": "A-1"}, WithSuggestFields("title"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"query":{"bool":{"filter":[{"terms":{"sku":["A-1"]}}],"minimum_should_match":"1","should":[{"multi_match":` +
+		`{"_name":"title","fields":["title.suggest","title.suggest._2gram","title.suggest._3gram"],"query":"la","type":"bool_prefix"}}]}},"size":3}`
+	if got := (*requests)[0].body; got != want {
+		t.Fatalf("body = %v\nwant %v", got, want)
+	}
+	if len(suggestions) != 1 || suggestions[0].Text != "Lamp shade" || suggestions[0].Field != "title" {
+		t.Fatalf("suggestions = %+v, want one Lamp shade", suggestions)
+	}
+}
+
+func TestSuggestRejectsBadArguments(t *testing.T) {
+	repo, requests := newTestESRepo(t, func(esRequest) (int, string) { return http.StatusOK, emptySearchResponse }, WithDefaultEntity(&suggestEntity{}))
+	tests := []struct {
+		name    string
+		limit   int
+		filters map[string]interface{}
+		opts    []SuggestOption
+	}{
+		{name: "limit", limit: 0},
+		{name: "filter outside the contexts", limit: 3, filters: map[string]interface{}{"sku": "A-1"}, opts: []SuggestOption{WithSuggestFields("brand")}},
+		{name: "field without autocomplete", limit: 3, opts: []SuggestOption{WithSuggestFields("name")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err, _ := repo.Suggest(context.Background(), "la", tt.limit, tt.filters, tt.opts...); err == nil {
+				t.Fatal("Suggest() succeeded")
+			}
+		})
+	}
+	if len(*requests) != 0 {
+		t.Fatalf("rejected suggestions reached the cluster: %v", *requests)
+	}
+}
+
+func TestRankSuggestions(t *testing.T) {
+	suggestions := []Suggestion{
+		{Text: "lamp", Score: 1},
+		{Text: "Lamp", Score: 3},
+		{Text: "ladder", Score: 3},
+		{Text: "lantern", Score: 2},
+		{Text: "LAMP", Score: 0.5},
+		{Text: "label", Score: 0.1},
+	}
+	var texts []string
+	for _, suggestion := range rankSuggestions(suggestions, 3) {
+		texts = append(texts, suggestion.Text)
+	}
+	if want := []string{"Lamp", "ladder", "lantern"}; !reflect.DeepEqual(texts, want) {
+		t.Fatalf("rankSuggestions() = %v, want %v", texts, want)
+	}
+	if ranked := rankSuggestions(suggestions, 10); len(ranked) != 4 {
+		t.Fatalf("rankSuggestions() = %+v, want the 4 distinct texts", ranked)
+	}
+}
+
+func TestCompletingValue(t *testing.T) {
+	source := map[string]interface{}{
+		"title": "Lamp",
+		"tags":  []interface{}{"desk", 7, "Lighting", "lamps"},
+		"maker": map[string]interface{}{"name": "Acme"},
+		"count": 3,
+	}
+	tests := []struct {
+		path, prefix string
+		want         string
+		found        bool
+	}{
+		{path: "title", prefix: "la", want: "Lamp", found: true},
+		{path: "tags", prefix: "li", want: "Lighting", found: true},
+		{path: "tags", prefix: "LAM", want: "lamps", found: true},
+		{path: "tags", prefix: "zz", want: "desk", found: true},
+		{path: "maker.name", prefix: "ac", want: "Acme", found: true},
+		{path: "maker.name.first", prefix: "ac"},
+		{path: "count", prefix: "3"},
+		{path: "missing", prefix: "a"},
+	}
+	for _, tt := range tests {
+		got, found := completingValue(source, tt.path, tt.prefix)
+		if got != tt.want || found != tt.found {
+			t.Fatalf("completingValue(%v, %v) = %q, %v, want %q, %v", tt.path, tt.prefix, got, found, tt.want, tt.found)
+		}
+	}
+	if got, found := completingValue(map[string]interface{}{"tags": []interface{}{1, 2}}, "tags", "a"); found {
+		t.Fatalf("completingValue() without strings = %q", got)
+	}
+}
@@ -4,10 +4,19 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"fmt"
 	"github.com/kutty-kumar/charminder/pkg"
-	"github.com/satori/go.uuid"
-	"github.com/sirupsen/logrus"
+	"github.com/kutty-kumar/charminder/pkg/idgen"
+	"github.com/kutty-kumar/charminder/pkg/logging"
+	"github.com/kutty-kumar/charminder/pkg/metrics"
+	"github.com/kutty-kumar/charminder/pkg/query"
+	"github.com/kutty-kumar/charminder/pkg/tracing"
+	"github.com/kutty-kumar/charminder/pkg/util/fieldcrypt"
+	"go.opentelemetry.io/otel/trace"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"sort"
+	"strings"
 )
 
 type GORMRepositoryOption func(repository *GORMRepository)
@@ -16,7 +25,12 @@ type GORMRepository struct {
 	db               *gorm.DB
 	creator          pkg.EntityCreator
 	externalIdSetter pkg.ExternalIdSetter
-	logger           *logrus.Logger
+	idGenerator      idgen.ExternalIdGenerator
+	idGenerators     *idgen.Registry
+	keyring          *fieldcrypt.Keyring
+	metrics          *metrics.RepositoryMetrics
+	tracer           trace.Tracer
+	logger           logging.Logger
 }
 
 func WithCreator(creator pkg.EntityCreator) GORMRepositoryOption {
@@ -31,9 +45,41 @@ func WithExternalIdSetter(setter pkg.ExternalIdSetter) GORMRepositoryOption {
 	}
 }
 
-func WithLogger(logger *logrus.Logger) GORMRepositoryOption {
+func WithExternalIdGenerator(generator idgen.ExternalIdGenerator) GORMRepositoryOption {
 	return func(r *GORMRepository) {
-		r.logger = logger
+		r.idGenerator = generator
+	}
+}
+
+func WithExternalIdGenerators(registry *idgen.Registry) GORMRepositoryOption {
+	return func(r *GORMRepository) {
+		r.idGenerators = registry
+	}
+}
+
+func WithKeyring(keyring *fieldcrypt.Keyring) GORMRepositoryOption {
+	return func(r *GORMRepository) {
+		r.keyring = keyring
+	}
+}
+
+// WithMetrics records call counts, latencies and error kinds for every repository operation.
+func WithMetrics(m *metrics.RepositoryMetrics) GORMRepositoryOption {
+	return func(r *GORMRepository) {
+		r.metrics = m
+	}
+}
+
+// WithTracerProvider overrides the global OpenTelemetry provider, e.g. with one backed by an in-memory exporter.
+func WithTracerProvider(provider trace.TracerProvider) GORMRepositoryOption {
+	return func(r *GORMRepository) {
+		r.tracer = tracing.Tracer(provider)
+	}
+}
+
+func WithLogger(logger logging.Logger) GORMRepositoryOption {
+	return func(r *GORMRepository) {
+		r.logger = logging.OrNop(logger)
 	}
 }
 
@@ -48,31 +94,86 @@ func (r *GORMRepository) GetDb() interface{} {
 	return r.db
 }
 
-func NewGORMRepository(opts ...GORMRepositoryOption) *GORMRepository {
-	repo := GORMRepository{}
+func (r *GORMRepository) Health(ctx context.Context) error {
+	sqlDb, err := r.db.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDb.PingContext(ctx)
+}
+
+// NewGORMRepository requires WithDb, WithCreator and WithExternalIdSetter.
+func NewGORMRepository(opts ...GORMRepositoryOption) (*GORMRepository, error) {
+	repo := GORMRepository{tracer: tracing.Tracer(nil), logger: logging.Nop()}
 	for _, opt := range opts {
 		opt(&repo)
 	}
-	return &repo
+	switch {
+	case repo.db == nil:
+		return nil, fmt.Errorf("gorm repository: %w WithDb", pkg.ErrMissingOption)
+	case repo.creator == nil:
+		return nil, fmt.Errorf("gorm repository: %w WithCreator", pkg.ErrMissingOption)
+	case repo.externalIdSetter == nil:
+		return nil, fmt.Errorf("gorm repository: %w WithExternalIdSetter", pkg.ErrMissingOption)
+	}
+	return &repo, nil
+}
+
+func (r *GORMRepository) domainName() pkg.DomainName {
+	return r.creator().GetName()
+}
+
+func (r *GORMRepository) instrument(ctx context.Context, operation string) (context.Context, func(err *error)) {
+	in := instrumentation{store: storeGORM, system: r.db.Dialector.Name(), tracer: r.tracer, metrics: r.metrics}
+	return in.start(ctx, r.domainName(), operation)
+}
+
+// encryptFields seals the encrypted fields of base for the row of externalId, right before base is written.
+func (r *GORMRepository) encryptFields(base pkg.Base, externalId string) error {
+	if r.keyring == nil {
+		if fieldcrypt.HasEncryptedFields(base) {
+			return fmt.Errorf("%v has encrypted fields but no keyring is configured", base.GetName())
+		}
+		return nil
+	}
+	return r.keyring.EncryptFields(base, externalId)
 }
 
-func (r *GORMRepository) GetById(ctx context.Context, id uint64) (error, pkg.Base) {
+func (r *GORMRepository) decryptFields(base pkg.Base, externalId string) error {
+	if r.keyring == nil {
+		return nil
+	}
+	return r.keyring.DecryptFields(base, externalId)
+}
+
+func (r *GORMRepository) GetById(ctx context.Context, id uint64) (err error, result pkg.Base) {
+	ctx, done := r.instrument(ctx, "get_by_id")
+	defer done(&err)
 	entity := r.creator()
 	if err := r.db.WithContext(ctx).Where("id = ?", id).First(&entity).Error; err != nil {
 		return err, nil
 	}
+	if err := r.decryptFields(entity, entity.GetExternalId()); err != nil {
+		return err, nil
+	}
 	return nil, entity
 }
 
-func (r *GORMRepository) GetByExternalId(ctx context.Context, externalId string) (error, pkg.Base) {
+func (r *GORMRepository) GetByExternalId(ctx context.Context, externalId string) (err error, result pkg.Base) {
+	ctx, done := r.instrument(ctx, "get_by_external_id")
+	defer done(&err)
 	entity := r.creator()
 	if err := r.db.WithContext(ctx).Table(string(entity.GetName())).Where("external_id = ?", externalId).First(entity).Error; err != nil {
 		return err, nil
 	}
+	if err := r.decryptFields(entity, entity.GetExternalId()); err != nil {
+		return err, nil
+	}
 	return nil, entity
 }
 
 func (r *GORMRepository) populateRows(rows *sql.Rows) (error, []pkg.Base) {
+	defer rows.Close()
 	var models []pkg.Base
 	for rows.Next() {
 		entity := r.creator()
@@ -80,12 +181,40 @@ func (r *GORMRepository) populateRows(rows *sql.Rows) (error, []pkg.Base) {
 		if err != nil {
 			return err, nil
 		}
+		if err := r.decryptFields(entity, entity.GetExternalId()); err != nil {
+			return err, nil
+		}
 		models = append(models, entity)
 	}
 	return nil, models
 }
 
-func (r *GORMRepository) MultiGetByExternalId(ctx context.Context, externalIds []string) (error, []pkg.Base) {
+// FindByBlindIndex looks up entities whose encrypted field equals value by comparing blind index hashes.
+func (r *GORMRepository) FindByBlindIndex(ctx context.Context, fieldName string, value string) (err error, results []pkg.Base) {
+	ctx, done := r.instrument(ctx, "find_by_blind_index")
+	defer done(&err)
+	if r.keyring == nil {
+		return errors.New("no keyring is configured"), nil
+	}
+	entity := r.creator()
+	indexField, blindIndex, err := r.keyring.BlindIndexFor(entity, fieldName, value)
+	if err != nil {
+		return err, nil
+	}
+	column, err := r.column(entity, indexField, "")
+	if err != nil {
+		return err, nil
+	}
+	rows, err := r.db.WithContext(ctx).Table(string(entity.GetName())).Where(clause.Eq{Column: column, Value: blindIndex}).Rows()
+	if err != nil {
+		return err, nil
+	}
+	return r.populateRows(rows)
+}
+
+func (r *GORMRepository) MultiGetByExternalId(ctx context.Context, externalIds []string) (err error, results []pkg.Base) {
+	ctx, done := r.instrument(ctx, "multi_get")
+	defer done(&err)
 	entity := r.creator()
 	rows, err := r.db.WithContext(ctx).Table(string(entity.GetName())).Where("external_id IN (?)", externalIds).Rows()
 	if err != nil {
@@ -96,36 +225,201 @@ func (r *GORMRepository) MultiGetByExternalId(ctx context.Context, externalIds [
 
 func (r *GORMRepository) generateExternalId(base pkg.Base) (error, string) {
 	if base.GetExternalId() == "" {
-		uid := uuid.NewV4()
-		return nil, uid.String()
+		externalId, err := idgen.Resolve(r.idGenerators, r.idGenerator, base.GetName()).Generate()
+		if err != nil {
+			return err, ""
+		}
+		return nil, externalId
 	}
 	return nil, base.GetExternalId()
 }
 
-func (r *GORMRepository) Create(ctx context.Context, base pkg.Base) (error, pkg.Base) {
+func (r *GORMRepository) Create(ctx context.Context, base pkg.Base) (err error, result pkg.Base) {
+	ctx, done := r.instrument(ctx, "create")
+	defer done(&err)
 	err, externalId := r.generateExternalId(base)
 	if err != nil {
 		return err, nil
 	}
-	r.externalIdSetter(externalId, base)
-	if err := r.db.WithContext(ctx).Create(base).Error; err != nil {
+	base = r.externalIdSetter(externalId, base)
+	if err := r.encryptFields(base, externalId); err != nil {
+		return err, nil
+	}
+	err = r.db.WithContext(ctx).Create(base).Error
+	if decryptErr := r.decryptFields(base, externalId); err == nil {
+		err = decryptErr
+	}
+	if err != nil {
 		return err, nil
 	}
 	return nil, base
 }
 
-func (r *GORMRepository) Update(ctx context.Context, externalId string, updatedBase pkg.Base) (error, pkg.Base) {
+func (r *GORMRepository) Update(ctx context.Context, externalId string, updatedBase pkg.Base) (err error, result pkg.Base) {
+	ctx, done := r.instrument(ctx, "update")
+	defer done(&err)
 	err, entity := r.GetByExternalId(ctx, externalId)
 	if err != nil {
 		return err, nil
 	}
 	entity.Merge(updatedBase)
-	if err := r.db.WithContext(ctx).Table(string(entity.GetName())).Model(entity).Updates(entity).Error; err != nil {
+	if err := r.write(ctx, externalId, entity); err != nil {
 		return err, nil
 	}
 	return nil, entity
 }
 
-func (r *GORMRepository) Search(ctx context.Context, params map[string]string) (error, []pkg.Base) {
-	return errors.New("not implemented"), nil
+// Replace overwrites every column of the row of externalId with replacement, zero values included; the id,
+// external id and creation time of the row stay.
+func (r *GORMRepository) Replace(ctx context.Context, externalId string, replacement pkg.Base) (err error, result pkg.Base) {
+	ctx, done := r.instrument(ctx, "replace")
+	defer done(&err)
+	if err := r.write(ctx, externalId, replacement); err != nil {
+		return err, nil
+	}
+	return r.GetByExternalId(ctx, externalId)
+}
+
+// write updates all columns rather than the non-zero fields Updates picks from a struct, so a cleared field is
+// cleared in the row too. Entity is decrypted again whether the write succeeded or not.
+func (r *GORMRepository) write(ctx context.Context, externalId string, entity pkg.Base) error {
+	if err := r.encryptFields(entity, externalId); err != nil {
+		return err
+	}
+	err := r.db.WithContext(ctx).Table(string(entity.GetName())).Where("external_id = ?", externalId).
+		Select("*").Omit("id", "external_id", "created_at").Updates(entity).Error
+	if decryptErr := r.decryptFields(entity, externalId); err == nil {
+		err = decryptErr
+	}
+	return err
+}
+
+func (r *GORMRepository) Delete(ctx context.Context, externalId string) (err error) {
+	ctx, done := r.instrument(ctx, "delete")
+	defer done(&err)
+	entity := r.creator()
+	result := r.db.WithContext(ctx).Table(string(entity.GetName())).Where("external_id = ?", externalId).Delete(entity)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// Search matches every param exactly; keys are the query names or the columns of the entity, others are rejected.
+func (r *GORMRepository) Search(ctx context.Context, params map[string]string) (err error, results []pkg.Base) {
+	ctx, done := r.instrument(ctx, "search")
+	defer done(&err)
+	entity := r.creator()
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	schema := query.NewSchema(entity)
+	conditions := make([]clause.Expression, 0, len(keys))
+	for _, key := range keys {
+		structField := ""
+		if field, ok := schema.Field(key); ok {
+			structField = field.StructField
+		}
+		column, err := r.column(entity, structField, key)
+		if err != nil {
+			return err, nil
+		}
+		conditions = append(conditions, clause.Eq{Column: column, Value: params[key]})
+	}
+	tx := r.db.WithContext(ctx).Table(string(entity.GetName()))
+	if len(conditions) > 0 {
+		tx = tx.Where(clause.And(conditions...))
+	}
+	rows, err := tx.Rows()
+	if err != nil {
+		return err, nil
+	}
+	return r.populateRows(rows)
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// column resolves a field of a query filter to its column through the parsed schema of entity, so gorm column
+// tags and the naming strategy apply.
+func (r *GORMRepository) column(entity pkg.Base, structField, field string) (clause.Column, error) {
+	statement := &gorm.Statement{DB: r.db}
+	if err := statement.Parse(entity); err != nil {
+		return clause.Column{}, err
+	}
+	for _, name := range []string{structField, field} {
+		if name == "" {
+			continue
+		}
+		if schemaField := statement.Schema.LookUpField(name); schemaField != nil && schemaField.DBName != "" {
+			return clause.Column{Name: schemaField.DBName}, nil
+		}
+	}
+	return clause.Column{}, fmt.Errorf("%v has no column for %v", entity.GetName(), field)
+}
+
+func gormCondition(column clause.Column, condition query.Condition) clause.Expression {
+	switch condition.Operator {
+	case query.Ne:
+		return clause.Neq{Column: column, Value: condition.Value}
+	case query.Gt:
+		return clause.Gt{Column: column, Value: condition.Value}
+	case query.Gte:
+		return clause.Gte{Column: column, Value: condition.Value}
+	case query.Lt:
+		return clause.Lt{Column: column, Value: condition.Value}
+	case query.Lte:
+		return clause.Lte{Column: column, Value: condition.Value}
+	case query.In:
+		return clause.IN{Column: column, Values: condition.Values}
+	case query.Nin:
+		return clause.Not(clause.IN{Column: column, Values: condition.Values})
+	case query.Like:
+		return clause.Like{Column: column, Value: "%" + likeEscaper.Replace(condition.Value.(string)) + "%"}
+	case query.Prefix:
+		return clause.Like{Column: column, Value: likeEscaper.Replace(condition.Value.(string)) + "%"}
+	case query.Exists:
+		if condition.Value.(bool) {
+			return clause.Neq{Column: column, Value: nil}
+		}
+		return clause.Eq{Column: column, Value: nil}
+	}
+	return clause.Eq{Column: column, Value: condition.Value}
+}
+
+// Find runs a parsed query filter, mapping its fields onto the columns of the entity.
+func (r *GORMRepository) Find(ctx context.Context, filter *query.Filter) (err error, results []pkg.Base) {
+	ctx, done := r.instrument(ctx, "find")
+	defer done(&err)
+	entity := r.creator()
+	tx := r.db.WithContext(ctx).Table(string(entity.GetName()))
+	for _, condition := range filter.Conditions {
+		column, err := r.column(entity, condition.StructField, condition.Field)
+		if err != nil {
+			return err, nil
+		}
+		tx = tx.Where(gormCondition(column, condition))
+	}
+	for _, sortField := range filter.Sort {
+		column, err := r.column(entity, sortField.StructField, sortField.Field)
+		if err != nil {
+			return err, nil
+		}
+		tx = tx.Order(clause.OrderByColumn{Column: column, Desc: sortField.Descending})
+	}
+	if filter.Limit > 0 {
+		tx = tx.Limit(filter.Limit)
+	}
+	if filter.Offset > 0 {
+		tx = tx.Offset(filter.Offset)
+	}
+	rows, err := tx.Rows()
+	if err != nil {
+		return err, nil
+	}
+	return r.populateRows(rows)
 }
@@ -0,0 +1,213 @@
+package db
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kutty-kumar/charminder/pkg"
+	"github.com/kutty-kumar/charminder/pkg/query"
+	"github.com/kutty-kumar/charminder/pkg/util/fieldcrypt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/callbacks"
+	"gorm.io/gorm/logger"
+	"gorm.io/gorm/utils/tests"
+)
+
+// dryRunDialector registers the default callbacks the dummy dialector leaves out, so statements are built.
+type dryRunDialector struct {
+	tests.DummyDialector
+}
+
+func (dryRunDialector) Initialize(db *gorm.DB) error {
+	callbacks.RegisterDefaultCallbacks(db, &callbacks.Config{})
+	return nil
+}
+
+// sqlRecorder is a gorm logger keeping the statements a dry run would have executed.
+type sqlRecorder struct {
+	logger.Interface
+	statements []string
+}
+
+func (s *sqlRecorder) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
+	statement, _ := fc()
+	s.statements = append(s.statements, statement)
+}
+
+func newDryRunRepository(t *testing.T) (*GORMRepository, *sqlRecorder) {
+	t.Helper()
+	recorder := &sqlRecorder{Interface: logger.Discard}
+	db, err := gorm.Open(dryRunDialector{}, &gorm.Config{DryRun: true, Logger: recorder})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a dry run neither executes nor logs row queries
+	err = db.Callback().Row().After("gorm:row").Register("test:record", func(db *gorm.DB) {
+		recorder.statements = append(recorder.statements, db.Dialector.Explain(db.Statement.SQL.String(), db.Statement.Vars...))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo, err := NewGORMRepository(WithDb(db), WithCreator(newTestEntity), WithExternalIdSetter(setTestExternalId))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return repo, recorder
+}
+
+func TestGORMWritesZeroValues(t *testing.T) {
+	tests := []struct {
+		name  string
+		write func(repo *GORMRepository) error
+	}{
+		{
+			name: "update",
+			write: func(repo *GORMRepository) error {
+				err, _ := repo.Update(context.Background(), "a", &testEntity{Name: "lamp"})
+				return err
+			},
+		},
+		{
+			name: "replace",
+			write: func(repo *GORMRepository) error {
+				err, _ := repo.Replace(context.Background(), "a", &testEntity{BaseDomain: pkg.BaseDomain{ExternalId: "a"}, Name: "lamp"})
+				return err
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo, recorder := newDryRunRepository(t)
+			if err := tt.write(repo); err != nil {
+				t.Fatal(err)
+			}
+			var update string
+			for _, statement := range recorder.statements {
+				if strings.HasPrefix(statement, "UPDATE") {
+					update = statement
+				}
+			}
+			if update == "" {
+				t.Fatalf("no update among %v", recorder.statements)
+			}
+			for _, column := range []string{"`name`", "`price`", "`status`", "`updated_at`"} {
+				if !strings.Contains(update, column) {
+					t.Errorf("update %v does not write %v", update, column)
+				}
+			}
+			for _, column := range []string{"`id`=", "`external_id`=", "`created_at`="} {
+				if strings.Contains(update, column) {
+					t.Errorf("update %v overwrites %v", update, column)
+				}
+			}
+			if !strings.Contains(update, "WHERE external_id = \"a\"") {
+				t.Errorf("update %v is not limited to the row", update)
+			}
+		})
+	}
+}
+
+type secretEntity struct {
+	testEntity
+	Secret string `json:"secret" encrypt:"true"`
+}
+
+func TestGORMEncryptsOnWriteAndDecryptsAfter(t *testing.T) {
+	keyring, err := fieldcrypt.NewKeyring(1, map[uint32][]byte{1: []byte("0123456789abcdef0123456789abcdef")}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder := &sqlRecorder{Interface: logger.Discard}
+	db, err := gorm.Open(dryRunDialector{}, &gorm.Config{DryRun: true, Logger: recorder})
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo, err := NewGORMRepository(WithDb(db), WithKeyring(keyring),
+		WithCreator(func() pkg.Base { return &secretEntity{} }),
+		WithExternalIdSetter(func(externalId string, base pkg.Base) pkg.Base {
+			base.(*secretEntity).ExternalId = externalId
+			return base
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, plaintext := range []string{"hunter2", "enc:v1:looks-sealed"} {
+		entity := &secretEntity{testEntity: testEntity{BaseDomain: pkg.BaseDomain{ExternalId: "a"}}, Secret: plaintext}
+		err, created := repo.Create(context.Background(), entity)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := created.(*secretEntity).Secret; got != plaintext {
+			t.Fatalf("Create() returned secret %q, want the plaintext %q", got, plaintext)
+		}
+		insert := recorder.statements[len(recorder.statements)-1]
+		if strings.Contains(insert, plaintext) || !strings.Contains(insert, "enc:v1:") {
+			t.Fatalf("insert %v does not hold the ciphertext of %q", insert, plaintext)
+		}
+	}
+}
+
+func TestGORMFindResolvesColumns(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  string
+	}{
+		{name: "untagged field by snake case", query: "sort=-updated_at", want: "ORDER BY `updated_at` DESC"},
+		{name: "untagged field by go name", query: "UpdatedAt[gte]=2021-01-01", want: "WHERE `updated_at` >= "},
+		{name: "json name", query: "external_id=a", want: "WHERE `external_id` = \"a\""},
+		{name: "gorm column tag", query: "caption[prefix]=la&sort=caption", want: "WHERE `title` LIKE \"la%\" ORDER BY `title`"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo, recorder := newDryRunRepository(t)
+			values, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			filter, err := query.Parse(values, query.NewSchema(repo.creator()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			repo.Find(context.Background(), filter)
+			if len(recorder.statements) == 0 || !strings.Contains(recorder.statements[0], tt.want) {
+				t.Fatalf("statements %v, want one containing %v", recorder.statements, tt.want)
+			}
+		})
+	}
+	repo, _ := newDryRunRepository(t)
+	err, _ := repo.Find(context.Background(), &query.Filter{Conditions: []query.Condition{{Field: "color", Operator: query.Eq, Value: "red"}}})
+	if err == nil {
+		t.Fatal("Find() on an unknown field succeeded")
+	}
+}
+
+func TestGORMSearchOnlyMatchesColumns(t *testing.T) {
+	tests := []struct {
+		name    string
+		params  map[string]string
+		want    string
+		wantErr bool
+	}{
+		{name: "column and field names", params: map[string]string{"caption": "lamp", "Name": "a"}, want: "WHERE (`name` = \"a\" AND `title` = \"lamp\")"},
+		{name: "unknown key", params: map[string]string{"1=1) OR (1": "1"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo, recorder := newDryRunRepository(t)
+			err, _ := repo.Search(context.Background(), tt.params)
+			if tt.wantErr {
+				if err == nil || len(recorder.statements) != 0 {
+					t.Fatalf("Search() = %v after %v, want an error before any statement", err, recorder.statements)
+				}
+				return
+			}
+			if len(recorder.statements) == 0 || !strings.Contains(recorder.statements[0], tt.want) {
+				t.Fatalf("statements %v, want one containing %v", recorder.statements, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package db
+
+import (
+	"context"
+	"github.com/kutty-kumar/charminder/pkg"
+	"github.com/kutty-kumar/charminder/pkg/metrics"
+	"github.com/kutty-kumar/charminder/pkg/tracing"
+	"go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+	"time"
+)
+
+const (
+	storeGORM          = "gorm"
+	storeElasticsearch = "elasticsearch"
+)
+
+func errorKind(err error) string {
+	if err == nil {
+		return ""
+	}
+	if IsNotFound(err) {
+		return metrics.KindNotFound
+	}
+	return metrics.ErrorKind(err)
+}
+
+type instrumentation struct {
+	store   string
+	system  string
+	tracer  trace.Tracer
+	metrics *metrics.RepositoryMetrics
+}
+
+// start opens a span and starts timing one repository call. The returned func records the outcome once the
+// named error result is known:
+//
+//	ctx, done := r.instrument(ctx, "create")
+//	defer done(&err)
+func (in instrumentation) start(ctx context.Context, domain pkg.DomainName, operation string) (context.Context, func(err *error)) {
+	ctx, span := in.tracer.Start(ctx, in.store+"."+operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.DBSystemKey.String(in.system),
+			semconv.DBOperationKey.String(operation),
+			tracing.DomainKey.String(string(domain)),
+		))
+	start := time.Now()
+	return ctx, func(err *error) {
+		if in.metrics != nil {
+			in.metrics.Observe(in.store, string(domain), operation, time.Since(start), errorKind(*err))
+		}
+		if IsNotFound(*err) {
+			// an empty lookup is an answer, not a failed call
+			span.End()
+			return
+		}
+		tracing.End(span, *err)
+	}
+}
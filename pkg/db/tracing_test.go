@@ -0,0 +1,92 @@
+package db
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/elastic/go-elasticsearch/v7"
+	"github.com/kutty-kumar/charminder/pkg"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"gorm.io/gorm"
+	"gorm.io/gorm/utils/tests"
+)
+
+func TestRepositorySpansAreParentedUnderTheCaller(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"hits":{"total":{"value":1,"relation":"eq"},"hits":[{"_id":"a","_source":{"name":"a"}}]}}`))
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name     string
+		spanName string
+		call     func(ctx context.Context, provider *sdktrace.TracerProvider) error
+	}{
+		{
+			name:     "gorm",
+			spanName: "gorm.get_by_external_id",
+			call: func(ctx context.Context, provider *sdktrace.TracerProvider) error {
+				db, err := gorm.Open(tests.DummyDialector{}, &gorm.Config{DryRun: true})
+				if err != nil {
+					return err
+				}
+				repo, err := NewGORMRepository(WithDb(db), WithCreator(newTestEntity), WithExternalIdSetter(setTestExternalId), WithTracerProvider(provider))
+				if err != nil {
+					return err
+				}
+				repo.GetByExternalId(ctx, "a")
+				return nil
+			},
+		},
+		{
+			name:     "elasticsearch",
+			spanName: "elasticsearch.text_search",
+			call: func(ctx context.Context, provider *sdktrace.TracerProvider) error {
+				client, err := elasticsearch.NewClient(elasticsearch.Config{Addresses: []string{server.URL}})
+				if err != nil {
+					return err
+				}
+				repo, err := NewElasticsearchRepo(WithClient(client), WithIndex("test_entities"), WithESTracerProvider(provider),
+					WithEntityCreator(newTestEntity), WithESExternalIdSetter(setTestExternalId),
+					WithEntityConverter(func(map[string]interface{}) pkg.Base { return &testEntity{} }))
+				if err != nil {
+					return err
+				}
+				err, _ = repo.TextSearch(ctx, "a")
+				return err
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := tracetest.NewSpanRecorder()
+			provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+			ctx, caller := provider.Tracer("test").Start(context.Background(), "caller")
+			if err := tt.call(ctx, provider); err != nil {
+				t.Fatal(err)
+			}
+			caller.End()
+
+			var found bool
+			for _, span := range recorder.Ended() {
+				if span.Name() != tt.spanName {
+					continue
+				}
+				found = true
+				if span.SpanContext().TraceID() != caller.SpanContext().TraceID() {
+					t.Errorf("span trace id = %v, want %v", span.SpanContext().TraceID(), caller.SpanContext().TraceID())
+				}
+				if span.Parent().SpanID() != caller.SpanContext().SpanID() {
+					t.Errorf("span parent = %v, want the caller %v", span.Parent().SpanID(), caller.SpanContext().SpanID())
+				}
+			}
+			if !found {
+				t.Fatalf("no %v span recorded", tt.spanName)
+			}
+		})
+	}
+}
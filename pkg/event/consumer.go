@@ -1,8 +1,29 @@
 package event
 
-import "sync"
+import (
+	"context"
+	"sync"
+)
 
 type Consumer interface {
 	Close()
 	Consume(wg *sync.WaitGroup)
+	Health(ctx context.Context) error
+	Start(ctx context.Context) error
+	Stop(ctx context.Context) error
+}
+
+// waitGroup waits for wg unless ctx expires first.
+func waitGroup(ctx context.Context, wg *sync.WaitGroup) error {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
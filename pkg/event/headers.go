@@ -0,0 +1,37 @@
+package event
+
+import (
+	"gopkg.in/confluentinc/confluent-kafka-go.v1/kafka"
+)
+
+// headerCarrier lets OpenTelemetry propagators read and write kafka message headers.
+type headerCarrier struct {
+	headers *[]kafka.Header
+}
+
+func (hc headerCarrier) Get(key string) string {
+	for _, header := range *hc.headers {
+		if header.Key == key {
+			return string(header.Value)
+		}
+	}
+	return ""
+}
+
+func (hc headerCarrier) Set(key string, value string) {
+	for i, header := range *hc.headers {
+		if header.Key == key {
+			(*hc.headers)[i].Value = []byte(value)
+			return
+		}
+	}
+	*hc.headers = append(*hc.headers, kafka.Header{Key: key, Value: []byte(value)})
+}
+
+func (hc headerCarrier) Keys() []string {
+	keys := make([]string, 0, len(*hc.headers))
+	for _, header := range *hc.headers {
+		keys = append(keys, header.Key)
+	}
+	return keys
+}
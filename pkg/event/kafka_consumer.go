@@ -1,50 +1,161 @@
 package event
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/kutty-kumar/charminder/pkg"
+	"github.com/kutty-kumar/charminder/pkg/logging"
+	"github.com/kutty-kumar/charminder/pkg/metrics"
+	"github.com/kutty-kumar/charminder/pkg/tracing"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
 	"gopkg.in/confluentinc/confluent-kafka-go.v1/kafka"
-	"log"
-	"os"
-	"os/signal"
+	"strconv"
 	"sync"
-	"syscall"
+	"time"
 )
 
-type EventConsumer func(event pkg.Event)
+// EventConsumer receives a context carrying the trace context extracted from the message headers.
+type EventConsumer func(ctx context.Context, event pkg.Event)
 
 type KafkaConsumer struct {
-	done             chan bool
+	done             chan struct{}
+	stopOnce         sync.Once
+	closeOnce        sync.Once
+	closeErr         error
+	running          sync.WaitGroup
 	consumer         *kafka.Consumer
 	consumerMapping  map[string]EventConsumer
-	sigChan          chan os.Signal
 	channels         []string
 	eventConstructor func() pkg.Event
+	metrics          *metrics.KafkaMetrics
+	tracer           trace.Tracer
+	propagator       propagation.TextMapPropagator
+	logger           logging.Logger
 }
 
-func getKafkaConsumerConfigMap(config map[string]interface{}) *kafka.ConfigMap {
+type KafkaConsumerOption func(kc *KafkaConsumer)
+
+// WithConsumerMetrics counts consumed messages and handler latency per event type, and tracks partition lag.
+func WithConsumerMetrics(m *metrics.KafkaMetrics) KafkaConsumerOption {
+	return func(kc *KafkaConsumer) {
+		kc.metrics = m
+	}
+}
+
+func WithConsumerTracerProvider(provider trace.TracerProvider) KafkaConsumerOption {
+	return func(kc *KafkaConsumer) {
+		kc.tracer = tracing.Tracer(provider)
+	}
+}
+
+// WithConsumerPropagator must match the propagator of the publishing side, W3C trace context by default.
+func WithConsumerPropagator(propagator propagation.TextMapPropagator) KafkaConsumerOption {
+	return func(kc *KafkaConsumer) {
+		kc.propagator = tracing.Propagator(propagator)
+	}
+}
+
+// WithEventConstructor returns the empty event that each message value is decoded into.
+func WithEventConstructor(constructor func() pkg.Event) KafkaConsumerOption {
+	return func(kc *KafkaConsumer) {
+		kc.eventConstructor = constructor
+	}
+}
+
+func WithConsumerLogger(logger logging.Logger) KafkaConsumerOption {
+	return func(kc *KafkaConsumer) {
+		kc.logger = logging.OrNop(logger)
+	}
+}
+
+// getKafkaConsumerConfigMap names only the key in its error, the value may be a credential.
+func getKafkaConsumerConfigMap(config map[string]interface{}) (*kafka.ConfigMap, error) {
 	configMap := &kafka.ConfigMap{}
 	for key, value := range config {
-		err := configMap.SetKey(key, value)
-		if err != nil {
-			log.Println(fmt.Sprintf("An error %v occurred while setting %v: %v", err, key, value))
+		if err := configMap.SetKey(key, value); err != nil {
+			return nil, fmt.Errorf("kafka consumer config key %v: %w", key, err)
 		}
 	}
-	return configMap
+	return configMap, nil
 }
 
-func NewKafkaConsumer(channels []string, config map[string]interface{}, consumerMapping map[string]EventConsumer) *KafkaConsumer {
-	sigChan := make(chan os.Signal, 1)
-	consumer, err := kafka.NewConsumer(getKafkaConsumerConfigMap(config))
-	done := make(chan bool, 1)
+// NewKafkaConsumer requires WithEventConstructor and subscribes to channels right away.
+func NewKafkaConsumer(channels []string, config map[string]interface{}, consumerMapping map[string]EventConsumer, opts ...KafkaConsumerOption) (*KafkaConsumer, error) {
+	kc := &KafkaConsumer{
+		channels:        channels,
+		done:            make(chan struct{}),
+		consumerMapping: consumerMapping,
+		tracer:          tracing.Tracer(nil),
+		propagator:      tracing.Propagator(nil),
+		logger:          logging.Nop(),
+	}
+	for _, opt := range opts {
+		opt(kc)
+	}
+	switch {
+	case len(channels) == 0:
+		return nil, errors.New("kafka consumer: no topics to subscribe to")
+	case len(consumerMapping) == 0:
+		return nil, errors.New("kafka consumer: no event consumers registered")
+	case kc.eventConstructor == nil:
+		return nil, fmt.Errorf("kafka consumer: %w WithEventConstructor", pkg.ErrMissingOption)
+	}
+	configMap, err := getKafkaConsumerConfigMap(config)
 	if err != nil {
-		log.Fatalf("An error %v occurred while starting kafka consumer.", err)
+		return nil, err
+	}
+	consumer, err := kafka.NewConsumer(configMap)
+	if err != nil {
+		return nil, fmt.Errorf("creating kafka consumer: %w", err)
 	}
 	err = consumer.SubscribeTopics(channels, nil)
 	if err != nil {
-		log.Fatalf("An error %v occurred while subscribing to kafka topics %v.", err, channels)
+		_ = consumer.Close()
+		return nil, fmt.Errorf("subscribing to kafka topics %v: %w", channels, err)
+	}
+	kc.consumer = consumer
+	return kc, nil
+}
+
+// recordLag uses the locally cached high watermark, so it does not query the broker per message.
+func (kc *KafkaConsumer) recordLag(partition kafka.TopicPartition) {
+	if kc.metrics == nil || partition.Topic == nil {
+		return
+	}
+	_, high, err := kc.consumer.GetWatermarkOffsets(*partition.Topic, partition.Partition)
+	if err != nil || high < 0 {
+		return
 	}
-	return &KafkaConsumer{channels: channels, sigChan: sigChan, done: done, consumer: consumer, consumerMapping: consumerMapping}
+	lag := high - int64(partition.Offset) - 1
+	if lag < 0 {
+		lag = 0
+	}
+	kc.metrics.Lag(*partition.Topic, strconv.Itoa(int(partition.Partition)), lag)
+}
+
+// startSpan continues the trace whose context the publisher wrote into the message headers.
+func (kc *KafkaConsumer) startSpan(message *kafka.Message) (context.Context, trace.Span) {
+	topic := topicOf(message.TopicPartition)
+	ctx := kc.propagator.Extract(context.Background(), headerCarrier{headers: &message.Headers})
+	return kc.tracer.Start(ctx, topic+" process",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			semconv.MessagingSystemKey.String("kafka"),
+			semconv.MessagingDestinationKey.String(topic),
+			semconv.MessagingDestinationKindTopic,
+			semconv.MessagingOperationProcess,
+			semconv.MessagingKafkaPartitionKey.Int(int(message.TopicPartition.Partition)),
+		))
+}
+
+func topicOf(partition kafka.TopicPartition) string {
+	if partition.Topic == nil {
+		return ""
+	}
+	return *partition.Topic
 }
 
 func (kc *KafkaConsumer) getEvent(eventData []byte) pkg.Event {
@@ -53,50 +164,93 @@ func (kc *KafkaConsumer) getEvent(eventData []byte) pkg.Event {
 	return event
 }
 
+// Consume polls in the background until Stop is called. Signal handling is left to the lifecycle manager.
 func (kc *KafkaConsumer) Consume(wg *sync.WaitGroup) {
-	signal.Notify(kc.sigChan, syscall.SIGINT, syscall.SIGTERM)
 	wg.Add(1)
+	kc.running.Add(1)
 	go func() {
 		run := true
 		defer wg.Done()
+		defer kc.running.Done()
 		for run == true {
 			select {
-			case sig := <-kc.sigChan:
-				fmt.Printf("Caught signal %v: terminating\n", sig)
+			case <-kc.done:
+				kc.logger.Info(context.Background(), "stopping kafka consumer")
 				run = false
 			case ev := <-kc.consumer.Events():
 				switch e := ev.(type) {
 				case kafka.AssignedPartitions:
-					_, _ = fmt.Fprintf(os.Stderr, "%% %v\n", e)
+					kc.logger.Info(context.Background(), "kafka partitions assigned", logging.F("partitions", e.String()))
 					_ = kc.consumer.Assign(e.Partitions)
 				case kafka.RevokedPartitions:
-					_, _ = fmt.Fprintf(os.Stderr, "%% %v\n", e)
+					kc.logger.Info(context.Background(), "kafka partitions revoked", logging.F("partitions", e.String()))
 					_ = kc.consumer.Unassign()
 				case *kafka.Message:
 					domainEvent := kc.getEvent(e.Value)
+					kc.recordLag(e.TopicPartition)
+					topic := topicOf(e.TopicPartition)
+					ctx, span := kc.startSpan(e)
 					wg.Add(1)
-					go func(event pkg.Event) {
+					kc.running.Add(1)
+					go func(ctx context.Context, event pkg.Event) {
 						defer wg.Done()
+						defer kc.running.Done()
+						defer span.End()
 						if eventConsumer := kc.consumerMapping[domainEvent.GetEntityType()]; eventConsumer != nil {
-							eventConsumer(domainEvent)
+							start := time.Now()
+							eventConsumer(ctx, domainEvent)
+							if kc.metrics != nil {
+								kc.metrics.Consumed(topic, domainEvent.GetEntityType(), time.Since(start))
+							}
 						} else {
-							log.Println(fmt.Sprintf("Event consumer not found for %v event type", domainEvent.GetEntityType()))
+							if kc.metrics != nil {
+								kc.metrics.ConsumeFailed(topic, "no_consumer")
+							}
+							kc.logger.Warn(ctx, "no event consumer registered", logging.F("event_type", domainEvent.GetEntityType()), logging.F("topic", topic))
 						}
-					}(domainEvent)
+					}(ctx, domainEvent)
 				case kafka.PartitionEOF:
-					fmt.Printf("%% Reached %v\n", e)
+					kc.logger.Debug(context.Background(), "reached end of kafka partition", logging.F("partition", e.String()))
 				case kafka.Error:
 					// Errors should generally be considered as informational, the client will try to automatically recover
-					_, _ = fmt.Fprintf(os.Stderr, "%% Error: %v\n", e)
+					if kc.metrics != nil {
+						kc.metrics.ConsumeFailed("", e.Code().String())
+					}
+					kc.logger.Warn(context.Background(), "kafka consumer error", logging.Err(e), logging.F("code", e.Code().String()))
 				}
 			}
 		}
 	}()
 }
 
+func (kc *KafkaConsumer) Health(ctx context.Context) error {
+	_, err := kc.consumer.GetMetadata(nil, false, metadataTimeoutMs(ctx))
+	return err
+}
+
+func (kc *KafkaConsumer) Start(ctx context.Context) error {
+	kc.Consume(&sync.WaitGroup{})
+	return nil
+}
+
+// Stop stops polling, waits for the in flight handlers to finish and closes the consumer, committing its offsets.
+// The consumer is left open when ctx expires first.
+func (kc *KafkaConsumer) Stop(ctx context.Context) error {
+	kc.stopOnce.Do(func() {
+		close(kc.done)
+	})
+	if err := waitGroup(ctx, &kc.running); err != nil {
+		return fmt.Errorf("draining kafka consumer: %w", err)
+	}
+	kc.closeOnce.Do(func() {
+		kc.closeErr = kc.consumer.Close()
+	})
+	return kc.closeErr
+}
+
+// Close is Stop without a deadline, for callers outside the lifecycle manager.
 func (kc *KafkaConsumer) Close() {
-	err := kc.consumer.Close()
-	if err != nil {
-		log.Println(fmt.Sprintf("An error %v occurred while closing kafka consumer.", err))
+	if err := kc.Stop(context.Background()); err != nil {
+		kc.logger.Error(context.Background(), "closing kafka consumer failed", logging.Err(err))
 	}
 }
@@ -1,72 +1,240 @@
 package event
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/kutty-kumar/charminder/pkg"
+	"github.com/kutty-kumar/charminder/pkg/logging"
+	"github.com/kutty-kumar/charminder/pkg/metrics"
+	"github.com/kutty-kumar/charminder/pkg/tracing"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
 	"gopkg.in/confluentinc/confluent-kafka-go.v1/kafka"
-	"log"
 	"sync"
+	"time"
 )
 
+// ErrPublisherStopped is returned by Publish and PublishAsync once Stop or Close was called.
+var ErrPublisherStopped = errors.New("kafka publisher is stopped")
+
 type KafkaPublisher struct {
 	done          chan bool
 	eventChannels []string
 	producer      *kafka.Producer
 	wg            *sync.WaitGroup
+	mu            sync.RWMutex
+	stopped       bool
+	closeOnce     sync.Once
+	closeErr      error
+	metrics       *metrics.KafkaMetrics
+	tracer        trace.Tracer
+	propagator    propagation.TextMapPropagator
+	logger        logging.Logger
+}
+
+type KafkaPublisherOption func(kP *KafkaPublisher)
+
+// WithPublisherMetrics counts delivered and failed messages per topic and the time until the broker acknowledged them.
+func WithPublisherMetrics(m *metrics.KafkaMetrics) KafkaPublisherOption {
+	return func(kP *KafkaPublisher) {
+		kP.metrics = m
+	}
+}
+
+func WithPublisherTracerProvider(provider trace.TracerProvider) KafkaPublisherOption {
+	return func(kP *KafkaPublisher) {
+		kP.tracer = tracing.Tracer(provider)
+	}
 }
 
-func newKafkaProducerConfig(config map[string]string) *kafka.ConfigMap {
+// WithPublisherPropagator sets how the trace context is written to message headers, W3C trace context by default.
+func WithPublisherPropagator(propagator propagation.TextMapPropagator) KafkaPublisherOption {
+	return func(kP *KafkaPublisher) {
+		kP.propagator = tracing.Propagator(propagator)
+	}
+}
+
+func WithPublisherLogger(logger logging.Logger) KafkaPublisherOption {
+	return func(kP *KafkaPublisher) {
+		kP.logger = logging.OrNop(logger)
+	}
+}
+
+// newKafkaProducerConfig names only the key in its error, the value may be a credential.
+func newKafkaProducerConfig(config map[string]string) (*kafka.ConfigMap, error) {
 	kafkaConfigMap := &kafka.ConfigMap{}
 	for key, value := range config {
-		err := kafkaConfigMap.SetKey(key, value)
-		if err != nil {
-			log.Println(fmt.Sprintf("An error %v occurred while setting key %v against %v", err, key, value))
+		if err := kafkaConfigMap.SetKey(key, value); err != nil {
+			return nil, fmt.Errorf("kafka producer config key %v: %w", key, err)
 		}
 	}
-	return kafkaConfigMap
+	return kafkaConfigMap, nil
 }
 
-func NewKafkaProducer(channels []string, config map[string]string) *KafkaPublisher {
+// NewKafkaProducer requires the topics it publishes to and the bootstrap.servers of the cluster.
+func NewKafkaProducer(channels []string, config map[string]string, opts ...KafkaPublisherOption) (*KafkaPublisher, error) {
+	if len(channels) == 0 {
+		return nil, errors.New("kafka producer: no topics to publish to")
+	}
+	for i, channel := range channels {
+		if channel == "" {
+			return nil, fmt.Errorf("kafka producer: topic %v is empty", i)
+		}
+	}
+	if config["bootstrap.servers"] == "" && config["metadata.broker.list"] == "" {
+		return nil, errors.New("kafka producer: bootstrap.servers is required")
+	}
 	var wg sync.WaitGroup
-	kafkaConfigMap := newKafkaProducerConfig(config)
-	producer, err := kafka.NewProducer(kafkaConfigMap)
+	done := make(chan bool)
+	kP := &KafkaPublisher{
+		done:          done,
+		eventChannels: channels,
+		wg:            &wg,
+		tracer:        tracing.Tracer(nil),
+		propagator:    tracing.Propagator(nil),
+		logger:        logging.Nop(),
+	}
+	for _, opt := range opts {
+		opt(kP)
+	}
+	configMap, err := newKafkaProducerConfig(config)
 	if err != nil {
-		log.Fatalf(fmt.Sprintf("An error %v occurred while starting kafka producer.", err))
+		return nil, err
 	}
-	done := make(chan bool)
-	return &KafkaPublisher{done: done, eventChannels: channels, wg: &wg, producer: producer}
+	producer, err := kafka.NewProducer(configMap)
+	if err != nil {
+		return nil, fmt.Errorf("creating kafka producer: %w", err)
+	}
+	kP.producer = producer
+	go kP.handleDeliveryReports()
+	return kP, nil
 }
 
-func (kP *KafkaPublisher) Publish(event pkg.Event) {
-	log.Println("Calling publish")
-	kP.wg.Add(1)
-	go func() {
-		defer kP.wg.Done()
-		eventBytes := event.ToBytes()
-		entityType := event.GetEntityType()
-		kP.producer.ProduceChannel() <- &kafka.Message{
-			TopicPartition: kafka.TopicPartition{Topic: &entityType, Partition: kafka.PartitionAny},
-			Value:          eventBytes,
+// handleDeliveryReports drains the producer events, otherwise pending reports block Flush until it times out.
+func (kP *KafkaPublisher) handleDeliveryReports() {
+	for ev := range kP.producer.Events() {
+		switch e := ev.(type) {
+		case *kafka.Message:
+			kP.handleDeliveryReport(e)
+		case kafka.Error:
+			kP.logger.Warn(context.Background(), "kafka producer error", logging.Err(e), logging.F("code", e.Code().String()))
 		}
-		log.Println(fmt.Printf("Produced %v to kafka", event))
-	}()
+	}
+}
 
+func (kP *KafkaPublisher) handleDeliveryReport(m *kafka.Message) {
+	topic := topicOf(m.TopicPartition)
+	if m.TopicPartition.Error != nil {
+		kind := metrics.KindInternal
+		if kafkaErr, ok := m.TopicPartition.Error.(kafka.Error); ok {
+			kind = kafkaErr.Code().String()
+		}
+		ctx := kP.propagator.Extract(context.Background(), headerCarrier{headers: &m.Headers})
+		kP.logger.Error(ctx, "kafka delivery failed", logging.Err(m.TopicPartition.Error), logging.F("topic", topic))
+		if kP.metrics != nil {
+			kP.metrics.PublishFailed(topic, kind)
+		}
+		return
+	}
+	if producedAt, ok := m.Opaque.(time.Time); ok && kP.metrics != nil {
+		kP.metrics.Delivered(topic, time.Since(producedAt))
+	}
 }
 
-func (kP *KafkaPublisher) PublishAsync(event pkg.Event) {
-	eventBytes := event.ToBytes()
+func (kP *KafkaPublisher) startSpan(ctx context.Context, topic string) (context.Context, trace.Span) {
+	return kP.tracer.Start(ctx, topic+" send",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(semconv.MessagingSystemKey.String("kafka"), semconv.MessagingDestinationKey.String(topic), semconv.MessagingDestinationKindTopic))
+}
+
+// message carries the trace context of ctx in the headers so consumers continue the same trace.
+func (kP *KafkaPublisher) message(ctx context.Context, event pkg.Event) *kafka.Message {
 	entityType := event.GetEntityType()
-	kP.producer.ProduceChannel() <- &kafka.Message{
+	message := &kafka.Message{
 		TopicPartition: kafka.TopicPartition{Topic: &entityType, Partition: kafka.PartitionAny},
-		Value:          eventBytes,
+		Value:          event.ToBytes(),
+		Opaque:         time.Now(),
+	}
+	kP.propagator.Inject(ctx, headerCarrier{headers: &message.Headers})
+	return message
+}
+
+func (kP *KafkaPublisher) produce(ctx context.Context, event pkg.Event) {
+	ctx, span := kP.startSpan(ctx, event.GetEntityType())
+	defer span.End()
+	kP.producer.ProduceChannel() <- kP.message(ctx, event)
+}
+
+// begin registers a publish with the wait group Stop drains, it fails once the publisher is stopped.
+func (kP *KafkaPublisher) begin() error {
+	kP.mu.RLock()
+	defer kP.mu.RUnlock()
+	if kP.stopped {
+		return ErrPublisherStopped
+	}
+	kP.wg.Add(1)
+	return nil
+}
+
+func (kP *KafkaPublisher) Publish(ctx context.Context, event pkg.Event) error {
+	if err := kP.begin(); err != nil {
+		return err
 	}
+	go func() {
+		defer kP.wg.Done()
+		kP.produce(ctx, event)
+		kP.logger.Debug(ctx, "produced event to kafka", logging.F("topic", event.GetEntityType()))
+	}()
+	return nil
+}
+
+func (kP *KafkaPublisher) PublishAsync(ctx context.Context, event pkg.Event) error {
+	if err := kP.begin(); err != nil {
+		return err
+	}
+	defer kP.wg.Done()
+	kP.produce(ctx, event)
+	return nil
+}
+
+func (kP *KafkaPublisher) Health(ctx context.Context) error {
+	_, err := kP.producer.GetMetadata(nil, false, metadataTimeoutMs(ctx))
+	return err
 }
 
 func (kP *KafkaPublisher) Flush() {
 	kP.producer.Flush(15 * 1000)
 }
 
+func (kP *KafkaPublisher) Start(ctx context.Context) error {
+	return nil
+}
+
+// Stop rejects further publishes, waits for the pending ones, flushes the queued messages within the deadline of
+// ctx and closes the producer. Messages still undelivered when ctx expires are reported, not silently dropped.
+// The producer is closed once, later calls return the result of the first close.
+func (kP *KafkaPublisher) Stop(ctx context.Context) error {
+	kP.mu.Lock()
+	kP.stopped = true
+	kP.mu.Unlock()
+	if err := waitGroup(ctx, kP.wg); err != nil {
+		return fmt.Errorf("waiting for kafka publishes: %w", err)
+	}
+	kP.closeOnce.Do(func() {
+		remaining := kP.producer.Flush(metadataTimeoutMs(ctx))
+		kP.producer.Close()
+		if remaining > 0 {
+			kP.closeErr = fmt.Errorf("kafka producer closed with %v undelivered messages", remaining)
+		}
+	})
+	return kP.closeErr
+}
+
+// Close is Stop without a deadline, for callers outside the lifecycle manager.
 func (kP *KafkaPublisher) Close() {
-	kP.wg.Wait()
-	kP.producer.Close()
+	if err := kP.Stop(context.Background()); err != nil {
+		kP.logger.Error(context.Background(), "closing kafka publisher failed", logging.Err(err))
+	}
 }
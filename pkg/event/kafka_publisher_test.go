@@ -0,0 +1,70 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// newTestPublisher creates a producer on an unreachable broker, with librdkafka logging off.
+func newTestPublisher(t *testing.T) *KafkaPublisher {
+	t.Helper()
+	publisher, err := NewKafkaProducer([]string{"events"}, map[string]string{"bootstrap.servers": "127.0.0.1:1", "log_level": "0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return publisher
+}
+
+func TestKafkaPublisherStopsOnce(t *testing.T) {
+	publisher := newTestPublisher(t)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := publisher.Stop(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := publisher.Stop(ctx); err != nil {
+		t.Fatalf("second Stop() = %v", err)
+	}
+	publisher.Close()
+}
+
+func TestKafkaPublisherRejectsPublishesAfterStop(t *testing.T) {
+	publisher := newTestPublisher(t)
+	publisher.Close()
+	if err := publisher.Publish(context.Background(), testEvent{entityType: "events"}); !errors.Is(err, ErrPublisherStopped) {
+		t.Fatalf("Publish() after Close = %v, want ErrPublisherStopped", err)
+	}
+	if err := publisher.PublishAsync(context.Background(), testEvent{entityType: "events"}); !errors.Is(err, ErrPublisherStopped) {
+		t.Fatalf("PublishAsync() after Close = %v, want ErrPublisherStopped", err)
+	}
+}
+
+func TestNewKafkaProducerValidatesItsConfig(t *testing.T) {
+	servers := map[string]string{"bootstrap.servers": "127.0.0.1:1", "log_level": "0"}
+	tests := []struct {
+		name     string
+		channels []string
+		config   map[string]string
+		want     string
+	}{
+		{name: "no topics", config: servers, want: "kafka producer: no topics to publish to"},
+		{name: "empty topic", channels: []string{"events", ""}, config: servers, want: "kafka producer: topic 1 is empty"},
+		{name: "no servers", channels: []string{"events"}, config: map[string]string{"acks": "all"}, want: "kafka producer: bootstrap.servers is required"},
+		{name: "empty servers", channels: []string{"events"}, config: map[string]string{"bootstrap.servers": ""}, want: "kafka producer: bootstrap.servers is required"},
+		{name: "unknown key", channels: []string{"events"}, config: map[string]string{"bootstrap.servers": "127.0.0.1:1", "no.such.key": "1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			publisher, err := NewKafkaProducer(tt.channels, tt.config)
+			if err == nil {
+				publisher.Close()
+				t.Fatal("NewKafkaProducer() succeeded")
+			}
+			if tt.want != "" && err.Error() != tt.want {
+				t.Fatalf("NewKafkaProducer() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
@@ -1,10 +1,31 @@
 package event
 
-import "github.com/kutty-kumar/charminder/pkg"
+import (
+	"context"
+	"github.com/kutty-kumar/charminder/pkg"
+	"time"
+)
 
 type Publisher interface {
-	Publish(event pkg.Event)
-	PublishAsync(event pkg.Event)
+	Publish(ctx context.Context, event pkg.Event) error
+	PublishAsync(ctx context.Context, event pkg.Event) error
 	Flush()
 	Close()
+	Health(ctx context.Context) error
+	Start(ctx context.Context) error
+	Stop(ctx context.Context) error
+}
+
+const defaultMetadataTimeout = 5 * time.Second
+
+// metadataTimeoutMs converts the context deadline into the millisecond timeout librdkafka expects.
+func metadataTimeoutMs(ctx context.Context) int {
+	timeout := defaultMetadataTimeout
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = time.Until(deadline)
+	}
+	if timeout < time.Millisecond {
+		timeout = time.Millisecond
+	}
+	return int(timeout.Milliseconds())
 }
@@ -0,0 +1,106 @@
+package event
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kutty-kumar/charminder/pkg/tracing"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type testEvent struct {
+	entityType string
+}
+
+func (e testEvent) GetEntityId() string   { return "entity-1" }
+func (e testEvent) GetEntityType() string { return e.entityType }
+func (e testEvent) GetId() string         { return "event-1" }
+func (e testEvent) ToBytes() []byte       { return []byte(`{}`) }
+func (e testEvent) FromByte([]byte)       {}
+func (e testEvent) Entity() interface{}   { return nil }
+
+func TestKafkaHeadersCarryTraceFromPublishToConsume(t *testing.T) {
+	tests := []struct {
+		name       string
+		propagator propagation.TextMapPropagator
+	}{
+		{name: "default propagator"},
+		{name: "trace context only", propagator: propagation.TraceContext{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := tracetest.NewSpanRecorder()
+			provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+			publisher := &KafkaPublisher{tracer: tracing.Tracer(provider), propagator: tracing.Propagator(tt.propagator)}
+			consumer := &KafkaConsumer{tracer: tracing.Tracer(provider), propagator: tracing.Propagator(tt.propagator)}
+
+			ctx, caller := provider.Tracer("test").Start(context.Background(), "caller")
+			sendCtx, send := publisher.startSpan(ctx, "users")
+			message := publisher.message(sendCtx, testEvent{entityType: "users"})
+			send.End()
+			caller.End()
+
+			processCtx, process := consumer.startSpan(message)
+			process.End()
+
+			if got, want := tracing.TraceId(processCtx), caller.SpanContext().TraceID().String(); got != want {
+				t.Fatalf("consumer trace id = %v, want %v", got, want)
+			}
+			spans := map[string]sdktrace.ReadOnlySpan{}
+			for _, span := range recorder.Ended() {
+				spans[span.Name()] = span
+			}
+			if len(spans) != 3 {
+				t.Fatalf("recorded %v spans, want caller, send and process", len(spans))
+			}
+			traceId := spans["caller"].SpanContext().TraceID()
+			for name, span := range spans {
+				if span.SpanContext().TraceID() != traceId {
+					t.Errorf("span %v has trace id %v, want %v", name, span.SpanContext().TraceID(), traceId)
+				}
+			}
+			if got, want := spans["users send"].Parent().SpanID(), spans["caller"].SpanContext().SpanID(); got != want {
+				t.Errorf("send parent = %v, want %v", got, want)
+			}
+			if got, want := spans["users process"].Parent().SpanID(), spans["users send"].SpanContext().SpanID(); got != want {
+				t.Errorf("process parent = %v, want %v", got, want)
+			}
+			if !spans["users process"].Parent().IsRemote() {
+				t.Error("process parent should be the remote send span")
+			}
+			if kind := spans["users process"].SpanKind(); kind != trace.SpanKindConsumer {
+				t.Errorf("process span kind = %v, want consumer", kind)
+			}
+		})
+	}
+}
+
+func TestHeaderCarrierReplacesExistingKeys(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	publisher := &KafkaPublisher{tracer: tracing.Tracer(provider), propagator: tracing.Propagator(nil)}
+
+	ctx, span := provider.Tracer("test").Start(context.Background(), "first")
+	message := publisher.message(ctx, testEvent{entityType: "users"})
+	span.End()
+	ctx, span = provider.Tracer("test").Start(context.Background(), "second")
+	publisher.propagator.Inject(ctx, headerCarrier{headers: &message.Headers})
+	span.End()
+
+	count := 0
+	for _, header := range message.Headers {
+		if header.Key == "traceparent" {
+			count++
+		}
+	}
+	if count != 1 {
+		t.Fatalf("traceparent appears %v times, want once", count)
+	}
+	extracted := trace.SpanContextFromContext(publisher.propagator.Extract(context.Background(), headerCarrier{headers: &message.Headers}))
+	if extracted.SpanID() != span.SpanContext().SpanID() {
+		t.Fatalf("extracted span %v, want %v", extracted.SpanID(), span.SpanContext().SpanID())
+	}
+}
@@ -0,0 +1,66 @@
+package pkg
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const earthRadiusMeters = 6371008.8
+
+// GeoPoint is a location in degrees. It maps to an Elasticsearch geo_point and is stored in SQL as "lat,lon".
+type GeoPoint struct {
+	Lat float64 `json:"lat"`
+	Lon float64 `json:"lon"`
+}
+
+func (p GeoPoint) Valid() bool {
+	return p.Lat >= -90 && p.Lat <= 90 && p.Lon >= -180 && p.Lon <= 180
+}
+
+// DistanceMeters is the great circle distance to other.
+func (p GeoPoint) DistanceMeters(other GeoPoint) float64 {
+	lat1, lat2 := p.Lat*math.Pi/180, other.Lat*math.Pi/180
+	dLat, dLon := lat2-lat1, (other.Lon-p.Lon)*math.Pi/180
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusMeters * math.Asin(math.Sqrt(h))
+}
+
+func (p GeoPoint) String() string {
+	return strconv.FormatFloat(p.Lat, 'f', -1, 64) + "," + strconv.FormatFloat(p.Lon, 'f', -1, 64)
+}
+
+func (p GeoPoint) Value() (driver.Value, error) {
+	return p.String(), nil
+}
+
+func (p *GeoPoint) Scan(value interface{}) error {
+	var s string
+	switch typed := value.(type) {
+	case nil:
+		return nil
+	case []byte:
+		s = string(typed)
+	case string:
+		s = typed
+	default:
+		return errors.New("invalid scan source")
+	}
+	parts := strings.Split(s, ",")
+	if len(parts) != 2 {
+		return fmt.Errorf("geo point %q must be lat,lon", s)
+	}
+	lat, err := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+	if err != nil {
+		return fmt.Errorf("geo point %q: %w", s, err)
+	}
+	lon, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+	if err != nil {
+		return fmt.Errorf("geo point %q: %w", s, err)
+	}
+	p.Lat, p.Lon = lat, lon
+	return nil
+}
@@ -0,0 +1,75 @@
+package pkg
+
+import (
+	"math"
+	"testing"
+)
+
+func TestGeoPointScanAndValue(t *testing.T) {
+	point := GeoPoint{Lat: 52.520008, Lon: -13.404954}
+	value, err := point.Value()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value != "52.520008,-13.404954" {
+		t.Fatalf("Value() = %v", value)
+	}
+	for _, source := range []interface{}{value, []byte(value.(string)), " 52.520008 , -13.404954 "} {
+		var scanned GeoPoint
+		if err := scanned.Scan(source); err != nil {
+			t.Fatal(err)
+		}
+		if scanned != point {
+			t.Fatalf("Scan(%q) = %v, want %v", source, scanned, point)
+		}
+	}
+	scanned := point
+	if err := scanned.Scan(nil); err != nil || scanned != point {
+		t.Fatalf("Scan(nil) = %v, %v, want the point unchanged", scanned, err)
+	}
+}
+
+func TestGeoPointScanRejectsBadInput(t *testing.T) {
+	for _, source := range []interface{}{"52.5", "52.5,13.4,7", "north,13.4", "52.5,east", "", 52.5} {
+		var scanned GeoPoint
+		if err := scanned.Scan(source); err == nil {
+			t.Fatalf("Scan(%v) = %v, want an error", source, scanned)
+		}
+		if scanned != (GeoPoint{}) {
+			t.Fatalf("Scan(%v) changed the point to %v", source, scanned)
+		}
+	}
+}
+
+func TestGeoPointValid(t *testing.T) {
+	for point, want := range map[GeoPoint]bool{
+		{Lat: 90, Lon: 180}: true, {Lat: -90, Lon: -180}: true, {Lat: 90.1}: false, {Lon: -180.1}: false,
+	} {
+		if got := point.Valid(); got != want {
+			t.Fatalf("%v.Valid() = %v, want %v", point, got, want)
+		}
+	}
+}
+
+func TestGeoPointDistanceMeters(t *testing.T) {
+	tests := []struct {
+		name     string
+		from, to GeoPoint
+		want     float64
+	}{
+		{name: "same point", from: GeoPoint{Lat: 48.8566, Lon: 2.3522}, to: GeoPoint{Lat: 48.8566, Lon: 2.3522}, want: 0},
+		{name: "one degree of the equator", from: GeoPoint{}, to: GeoPoint{Lon: 1}, want: 111195.08},
+		{name: "equator to pole", from: GeoPoint{}, to: GeoPoint{Lat: 90}, want: 10007557.18},
+		{name: "paris to london", from: GeoPoint{Lat: 48.8566, Lon: 2.3522}, to: GeoPoint{Lat: 51.5074, Lon: -0.1278}, want: 343556},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.from.DistanceMeters(tt.to); math.Abs(got-tt.want) > 1 {
+				t.Fatalf("DistanceMeters() = %v, want %v", got, tt.want)
+			}
+			if got, back := tt.from.DistanceMeters(tt.to), tt.to.DistanceMeters(tt.from); math.Abs(got-back) > 1e-6 {
+				t.Fatalf("the distance is not symmetric: %v and %v", got, back)
+			}
+		})
+	}
+}
@@ -0,0 +1,222 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	StatusUp       = "up"
+	StatusDegraded = "degraded"
+	StatusDown     = "down"
+
+	defaultTimeout  = 2 * time.Second
+	defaultCacheTTL = time.Second
+)
+
+// Checker is implemented by every component that can report its own health, such as the repositories,
+// the cache and the Kafka publisher and consumer.
+type Checker interface {
+	Health(ctx context.Context) error
+}
+
+type CheckerFunc func(ctx context.Context) error
+
+func (f CheckerFunc) Health(ctx context.Context) error {
+	return f(ctx)
+}
+
+type Result struct {
+	Status     string    `json:"status"`
+	Critical   bool      `json:"critical"`
+	Error      string    `json:"error,omitempty"`
+	DurationMs int64     `json:"duration_ms"`
+	CheckedAt  time.Time `json:"checked_at"`
+}
+
+type Report struct {
+	Status string            `json:"status"`
+	Checks map[string]Result `json:"checks"`
+}
+
+type check struct {
+	name     string
+	checker  Checker
+	timeout  time.Duration
+	critical bool
+	liveness bool
+
+	mu        sync.Mutex
+	result    Result
+	expiresAt time.Time
+}
+
+type CheckOption func(c *check)
+
+func WithTimeout(timeout time.Duration) CheckOption {
+	return func(c *check) {
+		c.timeout = timeout
+	}
+}
+
+// NonCritical checks only degrade readiness instead of failing it.
+func NonCritical() CheckOption {
+	return func(c *check) {
+		c.critical = false
+	}
+}
+
+// ForLiveness also runs the check on /livez. Only checks whose failure requires a restart belong here.
+func ForLiveness() CheckOption {
+	return func(c *check) {
+		c.liveness = true
+	}
+}
+
+type Registry struct {
+	mu       sync.RWMutex
+	checks   map[string]*check
+	cacheTTL time.Duration
+}
+
+type RegistryOption func(r *Registry)
+
+func WithCacheTTL(ttl time.Duration) RegistryOption {
+	return func(r *Registry) {
+		r.cacheTTL = ttl
+	}
+}
+
+func NewRegistry(opts ...RegistryOption) *Registry {
+	r := &Registry{checks: make(map[string]*check), cacheTTL: defaultCacheTTL}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+func (r *Registry) Register(name string, checker Checker, opts ...CheckOption) {
+	c := &check{name: name, checker: checker, timeout: defaultTimeout, critical: true}
+	for _, opt := range opts {
+		opt(c)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.checks[name] = c
+}
+
+// run checks on a context of its own, bounded by the timeout of the check, so its result does not depend on the
+// caller and can be cached. ctx only bounds the wait: a caller that goes away gets a result that is not cached.
+func (r *Registry) run(ctx context.Context, c *check) Result {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if now.Before(c.expiresAt) {
+		return c.result
+	}
+	checkCtx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- c.checker.Health(checkCtx)
+	}()
+	var err error
+	select {
+	case err = <-errChan:
+	case <-checkCtx.Done():
+		err = checkCtx.Err()
+	case <-ctx.Done():
+		return Result{Status: StatusDown, Critical: c.critical, Error: ctx.Err().Error(), CheckedAt: now, DurationMs: time.Since(now).Milliseconds()}
+	}
+	result := Result{Status: StatusUp, Critical: c.critical, CheckedAt: now, DurationMs: time.Since(now).Milliseconds()}
+	if err != nil {
+		result.Status = StatusDown
+		result.Error = err.Error()
+	}
+	c.result = result
+	c.expiresAt = now.Add(r.cacheTTL)
+	return result
+}
+
+func (r *Registry) selected(livenessOnly bool) []*check {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var checks []*check
+	for _, c := range r.checks {
+		if !livenessOnly || c.liveness {
+			checks = append(checks, c)
+		}
+	}
+	sort.Slice(checks, func(i, j int) bool {
+		return checks[i].name < checks[j].name
+	})
+	return checks
+}
+
+func (r *Registry) report(ctx context.Context, livenessOnly bool) Report {
+	checks := r.selected(livenessOnly)
+	results := make([]Result, len(checks))
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		wg.Add(1)
+		go func(i int, c *check) {
+			defer wg.Done()
+			results[i] = r.run(ctx, c)
+		}(i, c)
+	}
+	wg.Wait()
+	report := Report{Status: StatusUp, Checks: make(map[string]Result, len(checks))}
+	for i, c := range checks {
+		report.Checks[c.name] = results[i]
+		if results[i].Status == StatusUp {
+			continue
+		}
+		if c.critical {
+			report.Status = StatusDown
+		} else if report.Status == StatusUp {
+			report.Status = StatusDegraded
+		}
+	}
+	return report
+}
+
+// Ready runs every registered check concurrently, reusing results younger than the cache TTL.
+func (r *Registry) Ready(ctx context.Context) Report {
+	return r.report(ctx, false)
+}
+
+func (r *Registry) Live(ctx context.Context) Report {
+	return r.report(ctx, true)
+}
+
+func writeReport(w http.ResponseWriter, report Report) {
+	status := http.StatusOK
+	if report.Status == StatusDown {
+		status = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(report)
+}
+
+func (r *Registry) LivezHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		writeReport(w, r.Live(req.Context()))
+	})
+}
+
+func (r *Registry) ReadyzHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		writeReport(w, r.Ready(req.Context()))
+	})
+}
+
+// Mount registers /livez and /readyz on mux.
+func (r *Registry) Mount(mux *http.ServeMux) {
+	mux.Handle("/livez", r.LivezHandler())
+	mux.Handle("/readyz", r.ReadyzHandler())
+}
@@ -0,0 +1,81 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRegistryCachesResults(t *testing.T) {
+	var calls int32
+	registry := NewRegistry(WithCacheTTL(time.Hour))
+	registry.Register("db", CheckerFunc(func(ctx context.Context) error {
+		atomic.AddInt32(&calls, 1)
+		return errors.New("unreachable")
+	}))
+	for i := 0; i < 3; i++ {
+		if report := registry.Ready(context.Background()); report.Status != StatusDown || report.Checks["db"].Error != "unreachable" {
+			t.Fatalf("report %+v, want db down", report)
+		}
+	}
+	if calls != 1 {
+		t.Fatalf("the check ran %v times, want once", calls)
+	}
+}
+
+func TestRegistryDoesNotCacheTheCancellationOfTheCaller(t *testing.T) {
+	release := make(chan struct{})
+	registry := NewRegistry(WithCacheTTL(time.Hour))
+	registry.Register("db", CheckerFunc(func(ctx context.Context) error {
+		select {
+		case <-release:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if report := registry.Ready(ctx); report.Status != StatusDown {
+		t.Fatalf("report %+v for a cancelled caller, want down", report)
+	}
+	close(release)
+	if report := registry.Ready(context.Background()); report.Status != StatusUp {
+		t.Fatalf("report %+v after the cancelled call, want up", report)
+	}
+}
+
+func TestRegistryTimesOutChecks(t *testing.T) {
+	registry := NewRegistry()
+	registry.Register("db", CheckerFunc(func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}), WithTimeout(10*time.Millisecond))
+	registry.Register("cache", CheckerFunc(func(ctx context.Context) error {
+		return errors.New("evicted")
+	}), NonCritical())
+	report := registry.Ready(context.Background())
+	if report.Status != StatusDown || report.Checks["db"].Error != context.DeadlineExceeded.Error() {
+		t.Fatalf("report %+v, want db down on its deadline", report)
+	}
+	if result := report.Checks["cache"]; result.Status != StatusDown || result.Critical {
+		t.Fatalf("cache result %+v, want a non critical failure", result)
+	}
+}
+
+func TestRegistryLiveOnlyRunsLivenessChecks(t *testing.T) {
+	registry := NewRegistry()
+	registry.Register("db", CheckerFunc(func(ctx context.Context) error { return errors.New("unreachable") }))
+	registry.Register("loop", CheckerFunc(func(ctx context.Context) error { return nil }), ForLiveness())
+	registry.Register("cache", CheckerFunc(func(ctx context.Context) error { return errors.New("evicted") }), NonCritical())
+	if report := registry.Live(context.Background()); report.Status != StatusUp || len(report.Checks) != 1 {
+		t.Fatalf("liveness report %+v, want only the loop check", report)
+	}
+	registry = NewRegistry()
+	registry.Register("cache", CheckerFunc(func(ctx context.Context) error { return errors.New("evicted") }), NonCritical())
+	if report := registry.Ready(context.Background()); report.Status != StatusDegraded {
+		t.Fatalf("readiness report %+v, want degraded", report)
+	}
+}
@@ -0,0 +1,79 @@
+package idgen
+
+import (
+	"sync"
+	"time"
+
+	"github.com/kutty-kumar/charminder/pkg"
+)
+
+type ExternalIdGenerator interface {
+	Generate() (string, error)
+}
+
+type GeneratorFunc func() (string, error)
+
+func (f GeneratorFunc) Generate() (string, error) {
+	return f()
+}
+
+// currentTime reads now, or time.Now for the zero value of a generator.
+func currentTime(now func() time.Time) time.Time {
+	if now == nil {
+		return time.Now()
+	}
+	return now()
+}
+
+type PrefixedGenerator struct {
+	prefix string
+	inner  ExternalIdGenerator
+}
+
+// NewPrefixedGenerator produces readable ids such as usr_01F8MECHZX3TBDSZ7XRADM79XV from any other generator.
+func NewPrefixedGenerator(prefix string, inner ExternalIdGenerator) *PrefixedGenerator {
+	return &PrefixedGenerator{prefix: prefix, inner: inner}
+}
+
+func (p *PrefixedGenerator) Generate() (string, error) {
+	id, err := p.inner.Generate()
+	if err != nil {
+		return "", err
+	}
+	return p.prefix + "_" + id, nil
+}
+
+type Registry struct {
+	mu         sync.RWMutex
+	generators map[pkg.DomainName]ExternalIdGenerator
+}
+
+func NewRegistry() *Registry {
+	return &Registry{generators: make(map[pkg.DomainName]ExternalIdGenerator)}
+}
+
+func (r *Registry) Register(domainName pkg.DomainName, generator ExternalIdGenerator) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.generators[domainName] = generator
+}
+
+func (r *Registry) Get(domainName pkg.DomainName) (ExternalIdGenerator, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	generator, ok := r.generators[domainName]
+	return generator, ok
+}
+
+// Resolve picks the generator registered for domainName, then fallback, then UUIDv4.
+func Resolve(registry *Registry, fallback ExternalIdGenerator, domainName pkg.DomainName) ExternalIdGenerator {
+	if registry != nil {
+		if generator, ok := registry.Get(domainName); ok {
+			return generator
+		}
+	}
+	if fallback != nil {
+		return fallback
+	}
+	return NewUUIDv4Generator()
+}
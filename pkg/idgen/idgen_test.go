@@ -0,0 +1,340 @@
+package idgen
+
+import (
+	"errors"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kutty-kumar/charminder/pkg"
+)
+
+var (
+	ulidFormat      = regexp.MustCompile(`^[0-7][0-9A-HJKMNP-TV-Z]{25}$`)
+	ksuidFormat     = regexp.MustCompile(`^[0-9A-Za-z]{27}$`)
+	snowflakeFormat = regexp.MustCompile(`^[1-9][0-9]*$`)
+	uuidV7Format    = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+)
+
+// clock returns the times it is given in turn and repeats the last one.
+func clock(times ...time.Time) func() time.Time {
+	calls := 0
+	return func() time.Time {
+		now := times[len(times)-1]
+		if calls < len(times) {
+			now = times[calls]
+		}
+		calls++
+		return now
+	}
+}
+
+func ulidMillis(t *testing.T, id string) uint64 {
+	t.Helper()
+	var ms uint64
+	for _, c := range id[:10] {
+		ms = ms<<5 | uint64(strings.IndexRune(crockfordAlphabet, c))
+	}
+	return ms
+}
+
+func snowflakeParts(t *testing.T, id string) (ms, worker, sequence int64) {
+	t.Helper()
+	value, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return value >> (snowflakeWorkerBits + snowflakeSequenceBits), value >> snowflakeSequenceBits & snowflakeMaxWorker, value & snowflakeMaxSequence
+}
+
+func ksuidSeconds(t *testing.T, id string) int64 {
+	t.Helper()
+	value := new(big.Int)
+	for _, c := range id {
+		value.Mul(value, big.NewInt(62))
+		value.Add(value, big.NewInt(int64(strings.IndexRune(base62Alphabet, c))))
+	}
+	bytes := make([]byte, 4+ksuidPayloadLen)
+	value.FillBytes(bytes)
+	return int64(bytes[0])<<24 | int64(bytes[1])<<16 | int64(bytes[2])<<8 | int64(bytes[3])
+}
+
+func generate(t *testing.T, generator ExternalIdGenerator, n int) []string {
+	t.Helper()
+	ids := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		id, err := generator.Generate()
+		if err != nil {
+			t.Fatalf("id %v: %v", i, err)
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func assertIncreasing(t *testing.T, ids []string, less func(a, b string) bool) {
+	t.Helper()
+	for i := 1; i < len(ids); i++ {
+		if !less(ids[i-1], ids[i]) {
+			t.Fatalf("id %v %v does not follow %v", i, ids[i], ids[i-1])
+		}
+	}
+}
+
+func lexically(a, b string) bool {
+	return a < b
+}
+
+func numerically(a, b string) bool {
+	x, _ := strconv.ParseInt(a, 10, 64)
+	y, _ := strconv.ParseInt(b, 10, 64)
+	return x < y
+}
+
+func TestULID(t *testing.T) {
+	t0 := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		times  []time.Time
+		n      int
+		wantMs []time.Time
+	}{
+		{name: "same millisecond", times: []time.Time{t0}, n: 1000, wantMs: []time.Time{t0}},
+		{name: "clock moving forward", times: []time.Time{t0, t0.Add(time.Millisecond), t0.Add(time.Second)}, n: 3, wantMs: []time.Time{t0, t0.Add(time.Millisecond), t0.Add(time.Second)}},
+		{name: "clock rollback", times: []time.Time{t0, t0.Add(-time.Second), t0.Add(-time.Millisecond), t0.Add(time.Millisecond)}, n: 4, wantMs: []time.Time{t0, t0, t0, t0.Add(time.Millisecond)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			generator := &ULIDGenerator{now: clock(tt.times...)}
+			ids := generate(t, generator, tt.n)
+			for i, id := range ids {
+				if !ulidFormat.MatchString(id) {
+					t.Fatalf("id %v is not a ULID", id)
+				}
+				want := tt.wantMs[len(tt.wantMs)-1]
+				if i < len(tt.wantMs) {
+					want = tt.wantMs[i]
+				}
+				if got := ulidMillis(t, id); got != uint64(want.UnixNano()/int64(time.Millisecond)) {
+					t.Fatalf("id %v carries %v, want %v", id, time.Unix(0, int64(got)*int64(time.Millisecond)).UTC(), want)
+				}
+			}
+			assertIncreasing(t, ids, lexically)
+		})
+	}
+}
+
+func TestULIDRandomOverflowMovesToTheNextMillisecond(t *testing.T) {
+	t0 := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	generator := &ULIDGenerator{now: clock(t0)}
+	first := generate(t, generator, 1)[0]
+	for i := range generator.lastRand {
+		generator.lastRand[i] = 0xff
+	}
+	second := generate(t, generator, 1)[0]
+	if got, want := ulidMillis(t, second), ulidMillis(t, first)+1; got != want {
+		t.Fatalf("after an overflow the id carries %v, want %v", got, want)
+	}
+	assertIncreasing(t, []string{first, second}, lexically)
+}
+
+func TestEncodeULID(t *testing.T) {
+	var max [16]byte
+	for i := range max {
+		max[i] = 0xff
+	}
+	tests := []struct {
+		id   [16]byte
+		want string
+	}{
+		{id: [16]byte{}, want: "00000000000000000000000000"},
+		{id: max, want: "7ZZZZZZZZZZZZZZZZZZZZZZZZZ"},
+		{id: [16]byte{15: 1}, want: "00000000000000000000000001"},
+		{id: [16]byte{15: 32}, want: "00000000000000000000000010"},
+	}
+	for _, tt := range tests {
+		if got := encodeULID(tt.id); got != tt.want {
+			t.Errorf("encodeULID(%x) = %v, want %v", tt.id, got, tt.want)
+		}
+	}
+}
+
+func TestSnowflake(t *testing.T) {
+	t0 := SnowflakeEpoch.Add(24 * time.Hour)
+	tests := []struct {
+		name    string
+		times   []time.Time
+		n       int
+		wantErr error
+	}{
+		{name: "same millisecond", times: []time.Time{t0}, n: 100},
+		{name: "clock moving forward", times: []time.Time{t0, t0.Add(time.Millisecond), t0.Add(time.Hour)}, n: 3},
+		{name: "clock rollback", times: []time.Time{t0, t0.Add(-time.Millisecond)}, n: 2, wantErr: ErrClockMovedBackwards},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			generator, err := NewSnowflakeGenerator(7)
+			if err != nil {
+				t.Fatal(err)
+			}
+			generator.now = clock(tt.times...)
+			var ids []string
+			for i := 0; i < tt.n; i++ {
+				id, err := generator.Generate()
+				if err != nil {
+					if !errors.Is(err, tt.wantErr) || i != tt.n-1 {
+						t.Fatalf("id %v: %v, want %v on the last id", i, err, tt.wantErr)
+					}
+					return
+				}
+				if !snowflakeFormat.MatchString(id) {
+					t.Fatalf("id %v is not a snowflake", id)
+				}
+				ms, worker, _ := snowflakeParts(t, id)
+				if worker != 7 {
+					t.Fatalf("id %v carries worker %v, want 7", id, worker)
+				}
+				now := tt.times[len(tt.times)-1]
+				if i < len(tt.times) {
+					now = tt.times[i]
+				}
+				if want := now.Sub(SnowflakeEpoch).Milliseconds(); ms != want {
+					t.Fatalf("id %v carries %vms, want %vms", id, ms, want)
+				}
+				ids = append(ids, id)
+			}
+			if tt.wantErr != nil {
+				t.Fatalf("generated %v ids, want %v", len(ids), tt.wantErr)
+			}
+			assertIncreasing(t, ids, numerically)
+		})
+	}
+}
+
+func TestSnowflakeSequenceExhaustionWaitsForTheNextMillisecond(t *testing.T) {
+	t0 := SnowflakeEpoch.Add(time.Hour)
+	times := make([]time.Time, snowflakeMaxSequence+3)
+	for i := range times {
+		times[i] = t0
+	}
+	times[len(times)-1] = t0.Add(time.Millisecond)
+	generator, err := NewSnowflakeGenerator(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	generator.now = clock(times...)
+	ids := generate(t, generator, snowflakeMaxSequence+2)
+	assertIncreasing(t, ids, numerically)
+	ms, _, sequence := snowflakeParts(t, ids[len(ids)-1])
+	if ms != t0.Add(time.Millisecond).Sub(SnowflakeEpoch).Milliseconds() || sequence != 0 {
+		t.Fatalf("the id after %v in one millisecond is at %vms, sequence %v", snowflakeMaxSequence+1, ms, sequence)
+	}
+}
+
+func TestNewSnowflakeGeneratorRejectsWorkerIds(t *testing.T) {
+	for _, workerId := range []int64{-1, snowflakeMaxWorker + 1} {
+		if _, err := NewSnowflakeGenerator(workerId); err == nil {
+			t.Errorf("NewSnowflakeGenerator(%v) succeeded", workerId)
+		}
+	}
+}
+
+func TestKSUID(t *testing.T) {
+	t0 := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		times   []time.Time
+		wantErr bool
+	}{
+		{name: "seconds apart", times: []time.Time{t0, t0.Add(time.Second), t0.Add(time.Hour)}},
+		{name: "at the epoch", times: []time.Time{time.Unix(ksuidEpoch, 0)}},
+		{name: "before the epoch", times: []time.Time{time.Unix(ksuidEpoch-1, 0)}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			generator := &KSUIDGenerator{now: clock(tt.times...)}
+			var ids []string
+			for _, now := range tt.times {
+				id, err := generator.Generate()
+				if tt.wantErr {
+					if err == nil {
+						t.Fatalf("Generate() = %v, want an error", id)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !ksuidFormat.MatchString(id) {
+					t.Fatalf("id %v is not a KSUID", id)
+				}
+				if got, want := ksuidSeconds(t, id), now.Unix()-ksuidEpoch; got != want {
+					t.Fatalf("id %v carries %v, want %v", id, got, want)
+				}
+				ids = append(ids, id)
+			}
+			assertIncreasing(t, ids, lexically)
+		})
+	}
+}
+
+func TestUUIDv7(t *testing.T) {
+	t0 := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	generator := &UUIDv7Generator{now: clock(t0, t0.Add(time.Millisecond), t0.Add(time.Second))}
+	ids := generate(t, generator, 3)
+	for _, id := range ids {
+		if !uuidV7Format.MatchString(id) {
+			t.Fatalf("id %v is not a version 7 UUID", id)
+		}
+	}
+	assertIncreasing(t, ids, lexically)
+}
+
+func TestZeroValueGeneratorsUseTheWallClock(t *testing.T) {
+	generators := map[string]ExternalIdGenerator{
+		"ulid":      &ULIDGenerator{},
+		"ksuid":     &KSUIDGenerator{},
+		"uuidv7":    &UUIDv7Generator{},
+		"snowflake": &SnowflakeGenerator{},
+	}
+	for name, generator := range generators {
+		t.Run(name, func(t *testing.T) {
+			if ids := generate(t, generator, 2); ids[0] == ids[1] {
+				t.Fatalf("ids = %v, want distinct ids", ids)
+			}
+		})
+	}
+}
+
+func TestResolve(t *testing.T) {
+	fixed := func(id string) ExternalIdGenerator {
+		return GeneratorFunc(func() (string, error) { return id, nil })
+	}
+	registry := NewRegistry()
+	registry.Register("users", NewPrefixedGenerator("usr", fixed("1")))
+	tests := []struct {
+		name       string
+		registry   *Registry
+		fallback   ExternalIdGenerator
+		domainName pkg.DomainName
+		want       *regexp.Regexp
+	}{
+		{name: "registered", registry: registry, fallback: fixed("fallback"), domainName: "users", want: regexp.MustCompile(`^usr_1$`)},
+		{name: "fallback", registry: registry, fallback: fixed("fallback"), domainName: "orders", want: regexp.MustCompile(`^fallback$`)},
+		{name: "uuid", domainName: "orders", want: regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, err := Resolve(tt.registry, tt.fallback, tt.domainName).Generate()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tt.want.MatchString(id) {
+				t.Fatalf("Generate() = %v, want %v", id, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package idgen
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+	"time"
+)
+
+const (
+	ksuidEpoch      = 1400000000
+	ksuidLength     = 27
+	base62Alphabet  = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+	ksuidPayloadLen = 16
+)
+
+type KSUIDGenerator struct {
+	now func() time.Time
+}
+
+func NewKSUIDGenerator() *KSUIDGenerator {
+	return &KSUIDGenerator{now: time.Now}
+}
+
+// Generate returns a 27 character base62 id made of a 32 bit timestamp and 128 random bits. Ids sort by the second
+// they were made in, not within it.
+func (g *KSUIDGenerator) Generate() (string, error) {
+	seconds := currentTime(g.now).Unix() - ksuidEpoch
+	if seconds < 0 || seconds > math.MaxUint32 {
+		return "", fmt.Errorf("ksuid timestamps cover %v to %v", time.Unix(ksuidEpoch, 0).UTC(), time.Unix(ksuidEpoch+math.MaxUint32, 0).UTC())
+	}
+	var id [4 + ksuidPayloadLen]byte
+	binary.BigEndian.PutUint32(id[:4], uint32(seconds))
+	if _, err := rand.Read(id[4:]); err != nil {
+		return "", err
+	}
+	value := new(big.Int).SetBytes(id[:])
+	base := big.NewInt(62)
+	remainder := new(big.Int)
+	var encoded []byte
+	for value.Sign() > 0 {
+		value.DivMod(value, base, remainder)
+		encoded = append(encoded, base62Alphabet[remainder.Int64()])
+	}
+	for i, j := 0, len(encoded)-1; i < j; i, j = i+1, j-1 {
+		encoded[i], encoded[j] = encoded[j], encoded[i]
+	}
+	return strings.Repeat("0", ksuidLength-len(encoded)) + string(encoded), nil
+}
@@ -0,0 +1,62 @@
+package idgen
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	snowflakeWorkerBits   = 10
+	snowflakeSequenceBits = 12
+	snowflakeMaxWorker    = 1<<snowflakeWorkerBits - 1
+	snowflakeMaxSequence  = 1<<snowflakeSequenceBits - 1
+)
+
+// SnowflakeEpoch is the custom epoch (2021-01-01 UTC) that snowflake timestamps are measured from.
+var SnowflakeEpoch = time.Date(2021, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+var ErrClockMovedBackwards = errors.New("clock moved backwards")
+
+type SnowflakeGenerator struct {
+	mu       sync.Mutex
+	now      func() time.Time
+	workerId int64
+	lastMs   int64
+	sequence int64
+}
+
+func NewSnowflakeGenerator(workerId int64) (*SnowflakeGenerator, error) {
+	if workerId < 0 || workerId > snowflakeMaxWorker {
+		return nil, fmt.Errorf("snowflake worker id %v must be between 0 and %v", workerId, snowflakeMaxWorker)
+	}
+	return &SnowflakeGenerator{now: time.Now, workerId: workerId}, nil
+}
+
+func (g *SnowflakeGenerator) millis() int64 {
+	return currentTime(g.now).Sub(SnowflakeEpoch).Milliseconds()
+}
+
+func (g *SnowflakeGenerator) Generate() (string, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	ms := g.millis()
+	if ms < g.lastMs {
+		return "", fmt.Errorf("%w: refusing to generate ids for %vms", ErrClockMovedBackwards, g.lastMs-ms)
+	}
+	if ms == g.lastMs {
+		g.sequence = (g.sequence + 1) & snowflakeMaxSequence
+		if g.sequence == 0 {
+			for ms <= g.lastMs {
+				ms = g.millis()
+			}
+		}
+	} else {
+		g.sequence = 0
+	}
+	g.lastMs = ms
+	id := ms<<(snowflakeWorkerBits+snowflakeSequenceBits) | g.workerId<<snowflakeSequenceBits | g.sequence
+	return strconv.FormatInt(id, 10), nil
+}
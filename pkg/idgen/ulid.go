@@ -0,0 +1,81 @@
+package idgen
+
+import (
+	"crypto/rand"
+	"sync"
+	"time"
+)
+
+const crockfordAlphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+type ULIDGenerator struct {
+	mu       sync.Mutex
+	now      func() time.Time
+	lastMs   uint64
+	lastRand [10]byte
+}
+
+func NewULIDGenerator() *ULIDGenerator {
+	return &ULIDGenerator{now: time.Now}
+}
+
+// incrementRandom bumps the random component so ids minted within the same millisecond stay ordered.
+func incrementRandom(entropy *[10]byte) bool {
+	for i := len(entropy) - 1; i >= 0; i-- {
+		entropy[i]++
+		if entropy[i] != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Generate returns a 26 character ULID. Ids of one generator are strictly increasing: within a millisecond, and
+// when the clock moves backwards, the random part of the previous id is incremented instead of drawn again.
+func (g *ULIDGenerator) Generate() (string, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	ms := uint64(currentTime(g.now).UnixNano() / int64(time.Millisecond))
+	if ms < g.lastMs {
+		ms = g.lastMs
+	}
+	if ms == g.lastMs {
+		if !incrementRandom(&g.lastRand) {
+			ms++
+		}
+	}
+	if ms != g.lastMs {
+		if _, err := rand.Read(g.lastRand[:]); err != nil {
+			return "", err
+		}
+		g.lastMs = ms
+	}
+	var id [16]byte
+	for i := 0; i < 6; i++ {
+		id[i] = byte(ms >> (40 - 8*uint(i)))
+	}
+	copy(id[6:], g.lastRand[:])
+	return encodeULID(id), nil
+}
+
+func encodeULID(id [16]byte) string {
+	// 128 bits are encoded as 26 base32 characters with the first character carrying only 3 bits.
+	dst := make([]byte, 26)
+	var carry uint
+	var bits uint
+	pos := 25
+	for i := 15; i >= 0; i-- {
+		carry |= uint(id[i]) << bits
+		bits += 8
+		for bits >= 5 && pos >= 0 {
+			dst[pos] = crockfordAlphabet[carry&0x1f]
+			carry >>= 5
+			bits -= 5
+			pos--
+		}
+	}
+	if pos >= 0 {
+		dst[pos] = crockfordAlphabet[carry&0x1f]
+	}
+	return string(dst)
+}
@@ -0,0 +1,42 @@
+package idgen
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"time"
+
+	"github.com/satori/go.uuid"
+)
+
+type UUIDv4Generator struct {
+}
+
+func NewUUIDv4Generator() *UUIDv4Generator {
+	return &UUIDv4Generator{}
+}
+
+func (g *UUIDv4Generator) Generate() (string, error) {
+	return uuid.NewV4().String(), nil
+}
+
+type UUIDv7Generator struct {
+	now func() time.Time
+}
+
+func NewUUIDv7Generator() *UUIDv7Generator {
+	return &UUIDv7Generator{now: time.Now}
+}
+
+// Generate lays out a 48 bit unix millisecond timestamp followed by random bits, so ids sort by creation time.
+func (g *UUIDv7Generator) Generate() (string, error) {
+	var id uuid.UUID
+	if _, err := rand.Read(id[6:]); err != nil {
+		return "", err
+	}
+	var ts [8]byte
+	binary.BigEndian.PutUint64(ts[:], uint64(currentTime(g.now).UnixNano()/int64(time.Millisecond)))
+	copy(id[:6], ts[2:])
+	id[6] = (id[6] & 0x0f) | 0x70
+	id[8] = (id[8] & 0x3f) | 0x80
+	return id.String(), nil
+}
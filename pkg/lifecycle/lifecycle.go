@@ -0,0 +1,224 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/kutty-kumar/charminder/pkg/logging"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const defaultShutdownTimeout = 30 * time.Second
+
+var (
+	ErrAlreadyRegistered = errors.New("component already registered")
+	ErrUnknownDependency = errors.New("unknown dependency")
+	ErrDependencyCycle   = errors.New("dependency cycle")
+)
+
+type Hook func(ctx context.Context) error
+
+// Service is implemented by the components that own a long lived resource, such as the Kafka consumer,
+// the Kafka publisher and the Redis cache.
+type Service interface {
+	Start(ctx context.Context) error
+	Stop(ctx context.Context) error
+}
+
+type component struct {
+	name      string
+	start     Hook
+	stop      Hook
+	dependsOn []string
+}
+
+type Manager struct {
+	mu              sync.Mutex
+	components      []*component
+	byName          map[string]*component
+	started         []*component
+	shutdownTimeout time.Duration
+	signals         []os.Signal
+	logger          logging.Logger
+}
+
+type ManagerOption func(m *Manager)
+
+// WithShutdownTimeout bounds the whole shutdown; components still stopping after it are abandoned.
+func WithShutdownTimeout(timeout time.Duration) ManagerOption {
+	return func(m *Manager) {
+		m.shutdownTimeout = timeout
+	}
+}
+
+func WithSignals(signals ...os.Signal) ManagerOption {
+	return func(m *Manager) {
+		m.signals = signals
+	}
+}
+
+func WithLogger(logger logging.Logger) ManagerOption {
+	return func(m *Manager) {
+		m.logger = logging.OrNop(logger)
+	}
+}
+
+func NewManager(opts ...ManagerOption) *Manager {
+	m := &Manager{
+		byName:          make(map[string]*component),
+		shutdownTimeout: defaultShutdownTimeout,
+		signals:         []os.Signal{syscall.SIGINT, syscall.SIGTERM},
+		logger:          logging.Nop(),
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+// Append registers start and stop hooks, either may be nil. Components start after everything they depend on
+// and stop before it.
+func (m *Manager) Append(name string, start, stop Hook, dependsOn ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.byName[name]; ok {
+		return fmt.Errorf("%v: %w", name, ErrAlreadyRegistered)
+	}
+	c := &component{name: name, start: start, stop: stop, dependsOn: dependsOn}
+	m.components = append(m.components, c)
+	m.byName[name] = c
+	return nil
+}
+
+func (m *Manager) Add(name string, service Service, dependsOn ...string) error {
+	return m.Append(name, service.Start, service.Stop, dependsOn...)
+}
+
+// order sorts the components topologically, keeping registration order between independent components.
+func (m *Manager) order() ([]*component, error) {
+	state := make(map[string]int, len(m.components))
+	const visiting, done = 1, 2
+	var ordered []*component
+	var visit func(c *component, path []string) error
+	visit = func(c *component, path []string) error {
+		switch state[c.name] {
+		case done:
+			return nil
+		case visiting:
+			return fmt.Errorf("%w: %v", ErrDependencyCycle, strings.Join(append(path, c.name), " -> "))
+		}
+		state[c.name] = visiting
+		for _, dependency := range c.dependsOn {
+			next, ok := m.byName[dependency]
+			if !ok {
+				return fmt.Errorf("%v depends on %v: %w", c.name, dependency, ErrUnknownDependency)
+			}
+			if err := visit(next, append(path, c.name)); err != nil {
+				return err
+			}
+		}
+		state[c.name] = done
+		ordered = append(ordered, c)
+		return nil
+	}
+	for _, c := range m.components {
+		if err := visit(c, nil); err != nil {
+			return nil, err
+		}
+	}
+	return ordered, nil
+}
+
+// Start runs the start hooks in dependency order. When one fails, the components already started are stopped
+// again and the start error is returned.
+func (m *Manager) Start(ctx context.Context) error {
+	m.mu.Lock()
+	ordered, err := m.order()
+	m.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	for _, c := range ordered {
+		m.logger.Info(ctx, "starting component", logging.F("component", c.name))
+		if c.start != nil {
+			if err := c.start(ctx); err != nil {
+				startErr := fmt.Errorf("starting %v: %w", c.name, err)
+				m.logger.Error(ctx, "component failed to start", logging.F("component", c.name), logging.Err(err))
+				stopCtx, cancel := context.WithTimeout(context.Background(), m.shutdownTimeout)
+				defer cancel()
+				if stopErr := m.Stop(stopCtx); stopErr != nil {
+					return fmt.Errorf("%v, rolling back: %v", startErr, stopErr)
+				}
+				return startErr
+			}
+		}
+		m.mu.Lock()
+		m.started = append(m.started, c)
+		m.mu.Unlock()
+	}
+	return nil
+}
+
+func runHook(ctx context.Context, hook Hook) error {
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- hook(ctx)
+	}()
+	select {
+	case err := <-errChan:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Stop runs the stop hooks of the started components in reverse order. Every component gets the chance to stop
+// even when an earlier one failed; once ctx expires the remaining ones are reported as abandoned.
+func (m *Manager) Stop(ctx context.Context) error {
+	m.mu.Lock()
+	started := m.started
+	m.started = nil
+	m.mu.Unlock()
+	var failures []string
+	for i := len(started) - 1; i >= 0; i-- {
+		c := started[i]
+		if c.stop == nil {
+			continue
+		}
+		if ctx.Err() != nil {
+			failures = append(failures, fmt.Sprintf("%v: abandoned, %v", c.name, ctx.Err()))
+			continue
+		}
+		m.logger.Info(ctx, "stopping component", logging.F("component", c.name))
+		if err := runHook(ctx, c.stop); err != nil {
+			m.logger.Error(ctx, "component failed to stop", logging.F("component", c.name), logging.Err(err))
+			failures = append(failures, fmt.Sprintf("%v: %v", c.name, err))
+		}
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("shutdown incomplete: %v", strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+// Run starts every component, blocks until ctx is cancelled or one of the signals arrives, then shuts down
+// within the shutdown timeout. It is the only place that handles process signals; they are handled from the
+// start on, so a signal during Start cancels the components still starting instead of killing the process.
+func (m *Manager) Run(ctx context.Context) error {
+	signalCtx, stopSignals := signal.NotifyContext(ctx, m.signals...)
+	defer stopSignals()
+	if err := m.Start(signalCtx); err != nil {
+		return err
+	}
+	<-signalCtx.Done()
+	stopSignals()
+	m.logger.Info(ctx, "shutting down", logging.F("timeout", m.shutdownTimeout.String()))
+	stopCtx, cancel := context.WithTimeout(context.Background(), m.shutdownTimeout)
+	defer cancel()
+	return m.Stop(stopCtx)
+}
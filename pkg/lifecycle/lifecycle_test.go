@@ -0,0 +1,195 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// journal records the hooks in the order they ran.
+type journal struct {
+	mu      sync.Mutex
+	entries []string
+}
+
+func (j *journal) hook(entry string, err error) Hook {
+	return func(ctx context.Context) error {
+		j.mu.Lock()
+		defer j.mu.Unlock()
+		j.entries = append(j.entries, entry)
+		return err
+	}
+}
+
+func (j *journal) String() string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return strings.Join(j.entries, " ")
+}
+
+func (j *journal) add(t *testing.T, m *Manager, name string, startErr error, dependsOn ...string) {
+	t.Helper()
+	if err := m.Append(name, j.hook("start:"+name, startErr), j.hook("stop:"+name, nil), dependsOn...); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestManagerOrdersByDependency(t *testing.T) {
+	j := &journal{}
+	m := NewManager()
+	j.add(t, m, "server", nil, "cache", "db")
+	j.add(t, m, "consumer", nil, "db")
+	j.add(t, m, "db", nil)
+	j.add(t, m, "cache", nil)
+	if err := m.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	want := "start:cache start:db start:server start:consumer stop:consumer stop:server stop:db stop:cache"
+	if got := j.String(); got != want {
+		t.Fatalf("hooks ran as\n%v\nwant\n%v", got, want)
+	}
+}
+
+func TestManagerRejectsInvalidGraphs(t *testing.T) {
+	tests := []struct {
+		name  string
+		build func(m *Manager) error
+		want  error
+	}{
+		{
+			name: "duplicate",
+			build: func(m *Manager) error {
+				_ = m.Append("db", nil, nil)
+				return m.Append("db", nil, nil)
+			},
+			want: ErrAlreadyRegistered,
+		},
+		{
+			name: "unknown dependency",
+			build: func(m *Manager) error {
+				_ = m.Append("server", nil, nil, "db")
+				return m.Start(context.Background())
+			},
+			want: ErrUnknownDependency,
+		},
+		{
+			name: "cycle",
+			build: func(m *Manager) error {
+				_ = m.Append("a", nil, nil, "b")
+				_ = m.Append("b", nil, nil, "a")
+				return m.Start(context.Background())
+			},
+			want: ErrDependencyCycle,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.build(NewManager()); !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestManagerRollsBackAFailedStart(t *testing.T) {
+	j := &journal{}
+	m := NewManager()
+	j.add(t, m, "db", nil)
+	j.add(t, m, "cache", nil)
+	j.add(t, m, "consumer", errors.New("no brokers"), "db")
+	j.add(t, m, "server", nil, "consumer")
+	err := m.Start(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "starting consumer: no brokers") {
+		t.Fatalf("Start() = %v, want the consumer failure", err)
+	}
+	want := "start:db start:cache start:consumer stop:cache stop:db"
+	if got := j.String(); got != want {
+		t.Fatalf("hooks ran as\n%v\nwant\n%v", got, want)
+	}
+	if err := m.Stop(context.Background()); err != nil || j.String() != want {
+		t.Fatalf("a second Stop() = %v ran %v, want nothing more", err, j)
+	}
+}
+
+func TestManagerStopAbandonsComponentsPastTheDeadline(t *testing.T) {
+	j := &journal{}
+	m := NewManager()
+	j.add(t, m, "db", nil)
+	if err := m.Append("consumer", nil, func(ctx context.Context) error {
+		<-ctx.Done()
+		time.Sleep(10 * time.Millisecond)
+		return nil
+	}, "db"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Append("publisher", nil, func(ctx context.Context) error { return errors.New("undelivered") }); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err := m.Stop(ctx)
+	if err == nil {
+		t.Fatal("Stop() succeeded past its deadline")
+	}
+	for _, want := range []string{"publisher: undelivered", "consumer: context deadline exceeded", "db: abandoned"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Stop() = %v, want it to report %v", err, want)
+		}
+	}
+	if strings.Contains(j.String(), "stop:db") {
+		t.Errorf("db stopped after the deadline: %v", j)
+	}
+}
+
+func TestRunStopsWhenTheContextEnds(t *testing.T) {
+	j := &journal{}
+	m := NewManager()
+	j.add(t, m, "db", nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- m.Run(ctx)
+	}()
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run() did not return")
+	}
+	if got, want := j.String(), "start:db stop:db"; got != want {
+		t.Fatalf("hooks ran as %v, want %v", got, want)
+	}
+}
+
+func TestRunHandlesSignalsDuringStart(t *testing.T) {
+	m := NewManager(WithSignals(syscall.SIGUSR1))
+	if err := m.Append("slow", func(ctx context.Context) error {
+		if err := syscall.Kill(syscall.Getpid(), syscall.SIGUSR1); err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(5 * time.Second):
+			return errors.New("the signal did not reach the starting component")
+		}
+	}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Run(context.Background()); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Run() = %v, want the start cancelled by the signal", err)
+	}
+}
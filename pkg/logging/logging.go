@@ -0,0 +1,193 @@
+package logging
+
+import (
+	"context"
+	"github.com/kutty-kumar/charminder/pkg/tracing"
+	"github.com/sirupsen/logrus"
+	"strings"
+)
+
+const (
+	RequestIdKey = "request_id"
+	TenantKey    = "tenant"
+	TraceIdKey   = "trace_id"
+	DomainKey    = "domain"
+
+	Redacted = "[REDACTED]"
+)
+
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+func F(key string, value interface{}) Field {
+	return Field{Key: key, Value: value}
+}
+
+func Err(err error) Field {
+	return Field{Key: "error", Value: err}
+}
+
+// Logger is the only logging dependency of the library components. Every call takes the context so the
+// request id, tenant, trace id and domain stored in it end up on the entry.
+type Logger interface {
+	Debug(ctx context.Context, msg string, fields ...Field)
+	Info(ctx context.Context, msg string, fields ...Field)
+	Warn(ctx context.Context, msg string, fields ...Field)
+	Error(ctx context.Context, msg string, fields ...Field)
+	With(fields ...Field) Logger
+}
+
+type contextKey string
+
+func WithRequestId(ctx context.Context, requestId string) context.Context {
+	return context.WithValue(ctx, contextKey(RequestIdKey), requestId)
+}
+
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, contextKey(TenantKey), tenant)
+}
+
+func WithDomain(ctx context.Context, domain string) context.Context {
+	return context.WithValue(ctx, contextKey(DomainKey), domain)
+}
+
+func RequestId(ctx context.Context) string {
+	value, _ := ctx.Value(contextKey(RequestIdKey)).(string)
+	return value
+}
+
+func Tenant(ctx context.Context) string {
+	value, _ := ctx.Value(contextKey(TenantKey)).(string)
+	return value
+}
+
+func Domain(ctx context.Context) string {
+	value, _ := ctx.Value(contextKey(DomainKey)).(string)
+	return value
+}
+
+// ContextFields returns the non empty request id, tenant, trace id and domain of ctx.
+func ContextFields(ctx context.Context) []Field {
+	if ctx == nil {
+		return nil
+	}
+	var fields []Field
+	for _, field := range []Field{
+		{Key: RequestIdKey, Value: RequestId(ctx)},
+		{Key: TenantKey, Value: Tenant(ctx)},
+		{Key: TraceIdKey, Value: tracing.TraceId(ctx)},
+		{Key: DomainKey, Value: Domain(ctx)},
+	} {
+		if field.Value != "" {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+// Redactor rewrites a field value before it is written, returning the value unchanged when it is not sensitive.
+type Redactor func(key string, value interface{}) interface{}
+
+// RedactKeys masks the values of fields whose key contains one of keys, ignoring case.
+func RedactKeys(keys ...string) Redactor {
+	lowered := make([]string, len(keys))
+	for i, key := range keys {
+		lowered[i] = strings.ToLower(key)
+	}
+	return func(key string, value interface{}) interface{} {
+		key = strings.ToLower(key)
+		for _, sensitive := range lowered {
+			if strings.Contains(key, sensitive) {
+				return Redacted
+			}
+		}
+		return value
+	}
+}
+
+// DefaultRedactor masks the usual credential fields.
+var DefaultRedactor = RedactKeys("password", "secret", "token", "authorization", "api_key", "apikey")
+
+type LogrusLogger struct {
+	logger    *logrus.Logger
+	fields    []Field
+	redactors []Redactor
+}
+
+type LogrusOption func(l *LogrusLogger)
+
+// WithRedactor adds redactor after the default one, redactors run in the order they were added.
+func WithRedactor(redactor Redactor) LogrusOption {
+	return func(l *LogrusLogger) {
+		l.redactors = append(l.redactors, redactor)
+	}
+}
+
+// NewLogrusLogger writes through logger, whose output, level and formatter stay under the caller's control.
+func NewLogrusLogger(logger *logrus.Logger, opts ...LogrusOption) *LogrusLogger {
+	l := &LogrusLogger{logger: logger, redactors: []Redactor{DefaultRedactor}}
+	for _, opt := range opts {
+		opt(l)
+	}
+	return l
+}
+
+func (l *LogrusLogger) entry(ctx context.Context, fields []Field) *logrus.Entry {
+	data := make(logrus.Fields, len(l.fields)+len(fields)+4)
+	for _, group := range [][]Field{ContextFields(ctx), l.fields, fields} {
+		for _, field := range group {
+			value := field.Value
+			if err, ok := value.(error); ok {
+				value = err.Error()
+			}
+			for _, redactor := range l.redactors {
+				value = redactor(field.Key, value)
+			}
+			data[field.Key] = value
+		}
+	}
+	return l.logger.WithFields(data)
+}
+
+func (l *LogrusLogger) Debug(ctx context.Context, msg string, fields ...Field) {
+	l.entry(ctx, fields).Debug(msg)
+}
+
+func (l *LogrusLogger) Info(ctx context.Context, msg string, fields ...Field) {
+	l.entry(ctx, fields).Info(msg)
+}
+
+func (l *LogrusLogger) Warn(ctx context.Context, msg string, fields ...Field) {
+	l.entry(ctx, fields).Warn(msg)
+}
+
+func (l *LogrusLogger) Error(ctx context.Context, msg string, fields ...Field) {
+	l.entry(ctx, fields).Error(msg)
+}
+
+func (l *LogrusLogger) With(fields ...Field) Logger {
+	return &LogrusLogger{logger: l.logger, fields: append(append([]Field{}, l.fields...), fields...), redactors: l.redactors}
+}
+
+type nopLogger struct{}
+
+// Nop discards everything, it is what components use until a logger is injected.
+func Nop() Logger {
+	return nopLogger{}
+}
+
+func (nopLogger) Debug(context.Context, string, ...Field) {}
+func (nopLogger) Info(context.Context, string, ...Field)  {}
+func (nopLogger) Warn(context.Context, string, ...Field)  {}
+func (nopLogger) Error(context.Context, string, ...Field) {}
+func (n nopLogger) With(...Field) Logger                  { return n }
+
+// OrNop lets constructors accept a nil logger.
+func OrNop(logger Logger) Logger {
+	if logger == nil {
+		return Nop()
+	}
+	return logger
+}
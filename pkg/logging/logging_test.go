@@ -0,0 +1,119 @@
+package logging
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func newTestLogger(opts ...LogrusOption) (*LogrusLogger, *test.Hook) {
+	logger, hook := test.NewNullLogger()
+	logger.SetLevel(logrus.DebugLevel)
+	return NewLogrusLogger(logger, opts...), hook
+}
+
+func TestLoggerAddsTheContextFields(t *testing.T) {
+	logger, hook := newTestLogger()
+	spanContext := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID: trace.TraceID{0x4b, 0xf9, 0x2f, 0x35, 0x77, 0xb3, 0x4d, 0xa6, 0xa3, 0xce, 0x92, 0x9d, 0x0e, 0x0e, 0x47, 0x36},
+		SpanID:  trace.SpanID{0x00, 0xf0, 0x67, 0xaa, 0x0b, 0xa9, 0x02, 0xb7},
+	})
+	ctx := trace.ContextWithSpanContext(context.Background(), spanContext)
+	ctx = WithDomain(WithTenant(WithRequestId(ctx, "req-1"), "acme"), "users")
+
+	logger.Info(ctx, "created", F("id", 7))
+	want := logrus.Fields{
+		RequestIdKey: "req-1", TenantKey: "acme", TraceIdKey: "4bf92f3577b34da6a3ce929d0e0e4736", DomainKey: "users", "id": 7,
+	}
+	if got := hook.LastEntry().Data; !reflect.DeepEqual(got, want) {
+		t.Fatalf("fields = %v, want %v", got, want)
+	}
+
+	logger.Info(context.Background(), "plain")
+	if got := hook.LastEntry().Data; len(got) != 0 {
+		t.Fatalf("fields without context values = %v, want none", got)
+	}
+}
+
+func TestLoggerLevels(t *testing.T) {
+	logger, hook := newTestLogger()
+	ctx := context.Background()
+	logger.Debug(ctx, "debug")
+	logger.Info(ctx, "info")
+	logger.Warn(ctx, "warn")
+	logger.Error(ctx, "error", Err(errors.New("broken")))
+	want := []logrus.Level{logrus.DebugLevel, logrus.InfoLevel, logrus.WarnLevel, logrus.ErrorLevel}
+	messages := []string{"debug", "info", "warn", "error"}
+	entries := hook.AllEntries()
+	if len(entries) != len(want) {
+		t.Fatalf("got %v entries, want %v", len(entries), len(want))
+	}
+	for i, entry := range entries {
+		if entry.Level != want[i] || entry.Message != messages[i] {
+			t.Fatalf("entry %v = %v %q, want level %v", i, entry.Level, entry.Message, want[i])
+		}
+	}
+	if got := entries[3].Data["error"]; got != "broken" {
+		t.Fatalf("error field = %v, want the message of the error", got)
+	}
+
+	hook.Reset()
+	logger.logger.SetLevel(logrus.WarnLevel)
+	logger.Debug(ctx, "debug")
+	logger.Info(ctx, "info")
+	if len(hook.AllEntries()) != 0 {
+		t.Fatalf("entries below the level were written: %v", hook.AllEntries())
+	}
+}
+
+func TestLoggerRedactsValues(t *testing.T) {
+	logger, hook := newTestLogger(WithRedactor(RedactKeys("Email")), WithRedactor(func(key string, value interface{}) interface{} {
+		if key == "card" {
+			return "****"
+		}
+		return value
+	}))
+	logger.With(F("db_password", "hunter2")).Info(context.Background(), "login",
+		F("Authorization", "Bearer abc"), F("user_email", "ann@example.com"), F("card", "4111"), F("user", "ann"))
+	want := logrus.Fields{"db_password": Redacted, "Authorization": Redacted, "user_email": Redacted, "card": "****", "user": "ann"}
+	if got := hook.LastEntry().Data; !reflect.DeepEqual(got, want) {
+		t.Fatalf("fields = %v, want %v", got, want)
+	}
+}
+
+func TestWithCopiesTheParentFields(t *testing.T) {
+	logger, hook := newTestLogger()
+	parent := logger.With(F("component", "repo"), F("store", "gorm")).(*LogrusLogger)
+	// Spare capacity lets a child that appends to the parent slice overwrite the field of its sibling.
+	parent.fields = append(make([]Field, 0, 8), parent.fields...)
+	first := parent.With(F("domain", "users"))
+	second := parent.With(F("domain", "orders"))
+
+	first.Info(context.Background(), "first")
+	if got := hook.LastEntry().Data["domain"]; got != "users" {
+		t.Fatalf("domain of the first child = %v, want users", got)
+	}
+	second.Info(context.Background(), "second")
+	if got := hook.LastEntry().Data["domain"]; got != "orders" {
+		t.Fatalf("domain of the second child = %v, want orders", got)
+	}
+	parent.Info(context.Background(), "parent")
+	if _, ok := hook.LastEntry().Data["domain"]; ok || len(parent.fields) != 2 {
+		t.Fatalf("the children changed the fields of the parent: %v", parent.fields)
+	}
+}
+
+func TestOrNop(t *testing.T) {
+	if _, ok := OrNop(nil).(nopLogger); !ok {
+		t.Fatal("OrNop(nil) is not the nop logger")
+	}
+	logger, _ := newTestLogger()
+	if OrNop(logger) != Logger(logger) {
+		t.Fatal("OrNop replaced a logger")
+	}
+}
@@ -0,0 +1,283 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"net"
+	"time"
+)
+
+const namespace = "charminder"
+
+const (
+	KindTimeout  = "timeout"
+	KindCanceled = "canceled"
+	KindNotFound = "not_found"
+	KindInternal = "internal"
+)
+
+// ErrorKind classifies err into a low cardinality label value. Components refine it with their own kinds.
+func ErrorKind(err error) string {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return KindTimeout
+	}
+	if errors.Is(err, context.Canceled) {
+		return KindCanceled
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return KindTimeout
+	}
+	return KindInternal
+}
+
+// register adds collector to registerer, returning the already registered collector when another component
+// created the same metric first, so several repositories can share one registry.
+func register(registerer prometheus.Registerer, collector prometheus.Collector) (prometheus.Collector, error) {
+	if err := registerer.Register(collector); err != nil {
+		var alreadyRegistered prometheus.AlreadyRegisteredError
+		if errors.As(err, &alreadyRegistered) {
+			return alreadyRegistered.ExistingCollector, nil
+		}
+		return nil, err
+	}
+	return collector, nil
+}
+
+func counterVec(registerer prometheus.Registerer, opts prometheus.CounterOpts, labels ...string) (*prometheus.CounterVec, error) {
+	opts.Namespace = namespace
+	collector, err := register(registerer, prometheus.NewCounterVec(opts, labels))
+	if err != nil {
+		return nil, err
+	}
+	return collector.(*prometheus.CounterVec), nil
+}
+
+func histogramVec(registerer prometheus.Registerer, opts prometheus.HistogramOpts, labels ...string) (*prometheus.HistogramVec, error) {
+	opts.Namespace = namespace
+	if opts.Buckets == nil {
+		opts.Buckets = prometheus.DefBuckets
+	}
+	collector, err := register(registerer, prometheus.NewHistogramVec(opts, labels))
+	if err != nil {
+		return nil, err
+	}
+	return collector.(*prometheus.HistogramVec), nil
+}
+
+func gaugeVec(registerer prometheus.Registerer, opts prometheus.GaugeOpts, labels ...string) (*prometheus.GaugeVec, error) {
+	opts.Namespace = namespace
+	collector, err := register(registerer, prometheus.NewGaugeVec(opts, labels))
+	if err != nil {
+		return nil, err
+	}
+	return collector.(*prometheus.GaugeVec), nil
+}
+
+type RepositoryMetrics struct {
+	operations *prometheus.CounterVec
+	errors     *prometheus.CounterVec
+	duration   *prometheus.HistogramVec
+}
+
+func NewRepositoryMetrics(registerer prometheus.Registerer) (*RepositoryMetrics, error) {
+	operations, err := counterVec(registerer, prometheus.CounterOpts{
+		Subsystem: "repository", Name: "operations_total", Help: "Repository operations by store, domain and operation.",
+	}, "store", "domain", "operation")
+	if err != nil {
+		return nil, err
+	}
+	errs, err := counterVec(registerer, prometheus.CounterOpts{
+		Subsystem: "repository", Name: "errors_total", Help: "Failed repository operations by error kind.",
+	}, "store", "domain", "operation", "kind")
+	if err != nil {
+		return nil, err
+	}
+	duration, err := histogramVec(registerer, prometheus.HistogramOpts{
+		Subsystem: "repository", Name: "operation_duration_seconds", Help: "Repository operation latency.",
+	}, "store", "domain", "operation")
+	if err != nil {
+		return nil, err
+	}
+	return &RepositoryMetrics{operations: operations, errors: errs, duration: duration}, nil
+}
+
+// Observe records one operation; errKind is empty for successful calls.
+func (m *RepositoryMetrics) Observe(store, domain, operation string, elapsed time.Duration, errKind string) {
+	m.operations.WithLabelValues(store, domain, operation).Inc()
+	m.duration.WithLabelValues(store, domain, operation).Observe(elapsed.Seconds())
+	if errKind != "" {
+		m.errors.WithLabelValues(store, domain, operation, errKind).Inc()
+	}
+}
+
+type CacheMetrics struct {
+	operations *prometheus.CounterVec
+	hits       *prometheus.CounterVec
+	misses     *prometheus.CounterVec
+	errors     *prometheus.CounterVec
+	duration   *prometheus.HistogramVec
+}
+
+func NewCacheMetrics(registerer prometheus.Registerer) (*CacheMetrics, error) {
+	operations, err := counterVec(registerer, prometheus.CounterOpts{
+		Subsystem: "cache", Name: "operations_total", Help: "Cache operations by domain and operation.",
+	}, "domain", "operation")
+	if err != nil {
+		return nil, err
+	}
+	hits, err := counterVec(registerer, prometheus.CounterOpts{
+		Subsystem: "cache", Name: "hits_total", Help: "Cache lookups that found an entry.",
+	}, "domain")
+	if err != nil {
+		return nil, err
+	}
+	misses, err := counterVec(registerer, prometheus.CounterOpts{
+		Subsystem: "cache", Name: "misses_total", Help: "Cache lookups that found no entry.",
+	}, "domain")
+	if err != nil {
+		return nil, err
+	}
+	errs, err := counterVec(registerer, prometheus.CounterOpts{
+		Subsystem: "cache", Name: "errors_total", Help: "Failed cache operations by error kind.",
+	}, "domain", "operation", "kind")
+	if err != nil {
+		return nil, err
+	}
+	duration, err := histogramVec(registerer, prometheus.HistogramOpts{
+		Subsystem: "cache", Name: "operation_duration_seconds", Help: "Cache operation latency.",
+		Buckets: []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+	}, "domain", "operation")
+	if err != nil {
+		return nil, err
+	}
+	return &CacheMetrics{operations: operations, hits: hits, misses: misses, errors: errs, duration: duration}, nil
+}
+
+func (m *CacheMetrics) Observe(domain, operation string, elapsed time.Duration, errKind string) {
+	m.operations.WithLabelValues(domain, operation).Inc()
+	m.duration.WithLabelValues(domain, operation).Observe(elapsed.Seconds())
+	if errKind != "" {
+		m.errors.WithLabelValues(domain, operation, errKind).Inc()
+	}
+}
+
+func (m *CacheMetrics) Hit(domain string) {
+	m.hits.WithLabelValues(domain).Inc()
+}
+
+func (m *CacheMetrics) Miss(domain string) {
+	m.misses.WithLabelValues(domain).Inc()
+}
+
+type KafkaMetrics struct {
+	published        *prometheus.CounterVec
+	publishErrors    *prometheus.CounterVec
+	deliveryDuration *prometheus.HistogramVec
+	consumed         *prometheus.CounterVec
+	consumeErrors    *prometheus.CounterVec
+	handleDuration   *prometheus.HistogramVec
+	lag              *prometheus.GaugeVec
+}
+
+func NewKafkaMetrics(registerer prometheus.Registerer) (*KafkaMetrics, error) {
+	m := &KafkaMetrics{}
+	var err error
+	if m.published, err = counterVec(registerer, prometheus.CounterOpts{
+		Subsystem: "kafka", Name: "published_total", Help: "Messages acknowledged by the broker.",
+	}, "topic"); err != nil {
+		return nil, err
+	}
+	if m.publishErrors, err = counterVec(registerer, prometheus.CounterOpts{
+		Subsystem: "kafka", Name: "publish_errors_total", Help: "Messages that failed delivery by error kind.",
+	}, "topic", "kind"); err != nil {
+		return nil, err
+	}
+	if m.deliveryDuration, err = histogramVec(registerer, prometheus.HistogramOpts{
+		Subsystem: "kafka", Name: "delivery_duration_seconds", Help: "Time from publish to delivery report.",
+	}, "topic"); err != nil {
+		return nil, err
+	}
+	if m.consumed, err = counterVec(registerer, prometheus.CounterOpts{
+		Subsystem: "kafka", Name: "consumed_total", Help: "Messages received by event type.",
+	}, "topic", "event_type"); err != nil {
+		return nil, err
+	}
+	if m.consumeErrors, err = counterVec(registerer, prometheus.CounterOpts{
+		Subsystem: "kafka", Name: "consume_errors_total", Help: "Consumer errors by error kind.",
+	}, "topic", "kind"); err != nil {
+		return nil, err
+	}
+	if m.handleDuration, err = histogramVec(registerer, prometheus.HistogramOpts{
+		Subsystem: "kafka", Name: "handle_duration_seconds", Help: "Time spent in event consumers.",
+	}, "topic", "event_type"); err != nil {
+		return nil, err
+	}
+	if m.lag, err = gaugeVec(registerer, prometheus.GaugeOpts{
+		Subsystem: "kafka", Name: "consumer_lag", Help: "Messages between the consumed offset and the high watermark.",
+	}, "topic", "partition"); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (m *KafkaMetrics) Delivered(topic string, elapsed time.Duration) {
+	m.published.WithLabelValues(topic).Inc()
+	m.deliveryDuration.WithLabelValues(topic).Observe(elapsed.Seconds())
+}
+
+func (m *KafkaMetrics) PublishFailed(topic, kind string) {
+	m.publishErrors.WithLabelValues(topic, kind).Inc()
+}
+
+func (m *KafkaMetrics) Consumed(topic, eventType string, elapsed time.Duration) {
+	m.consumed.WithLabelValues(topic, eventType).Inc()
+	m.handleDuration.WithLabelValues(topic, eventType).Observe(elapsed.Seconds())
+}
+
+func (m *KafkaMetrics) ConsumeFailed(topic, kind string) {
+	m.consumeErrors.WithLabelValues(topic, kind).Inc()
+}
+
+func (m *KafkaMetrics) Lag(topic, partition string, lag int64) {
+	m.lag.WithLabelValues(topic, partition).Set(float64(lag))
+}
+
+type HttpClientMetrics struct {
+	requests *prometheus.CounterVec
+	errors   *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+}
+
+func NewHttpClientMetrics(registerer prometheus.Registerer) (*HttpClientMetrics, error) {
+	requests, err := counterVec(registerer, prometheus.CounterOpts{
+		Subsystem: "http_client", Name: "requests_total", Help: "Outgoing HTTP requests by host, method and status code.",
+	}, "host", "method", "code")
+	if err != nil {
+		return nil, err
+	}
+	errs, err := counterVec(registerer, prometheus.CounterOpts{
+		Subsystem: "http_client", Name: "errors_total", Help: "Outgoing HTTP requests that failed before a response.",
+	}, "host", "method", "kind")
+	if err != nil {
+		return nil, err
+	}
+	duration, err := histogramVec(registerer, prometheus.HistogramOpts{
+		Subsystem: "http_client", Name: "request_duration_seconds", Help: "Outgoing HTTP request latency.",
+	}, "host", "method")
+	if err != nil {
+		return nil, err
+	}
+	return &HttpClientMetrics{requests: requests, errors: errs, duration: duration}, nil
+}
+
+func (m *HttpClientMetrics) Observe(host, method, code string, elapsed time.Duration) {
+	m.requests.WithLabelValues(host, method, code).Inc()
+	m.duration.WithLabelValues(host, method).Observe(elapsed.Seconds())
+}
+
+func (m *HttpClientMetrics) Failed(host, method, kind string, elapsed time.Duration) {
+	m.errors.WithLabelValues(host, method, kind).Inc()
+	m.duration.WithLabelValues(host, method).Observe(elapsed.Seconds())
+}
@@ -0,0 +1,130 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestRepositoryMetricsObserve(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	m, err := NewRepositoryMetrics(registry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Observe("gorm", "users", "create", 10*time.Millisecond, "")
+	m.Observe("gorm", "users", "create", 20*time.Millisecond, KindTimeout)
+	m.Observe("elasticsearch", "users", "search", time.Millisecond, "")
+
+	want := `
+# HELP charminder_repository_errors_total Failed repository operations by error kind.
+# TYPE charminder_repository_errors_total counter
+charminder_repository_errors_total{domain="users",kind="timeout",operation="create",store="gorm"} 1
+# HELP charminder_repository_operations_total Repository operations by store, domain and operation.
+# TYPE charminder_repository_operations_total counter
+charminder_repository_operations_total{domain="users",operation="create",store="gorm"} 2
+charminder_repository_operations_total{domain="users",operation="search",store="elasticsearch"} 1
+`
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(want),
+		"charminder_repository_operations_total", "charminder_repository_errors_total"); err != nil {
+		t.Fatal(err)
+	}
+	if got := testutil.CollectAndCount(m.duration); got != 2 {
+		t.Fatalf("duration series = %v, want one per store, domain and operation", got)
+	}
+}
+
+func TestCacheMetrics(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	m, err := NewCacheMetrics(registry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Observe("users", "get", time.Millisecond, "")
+	m.Observe("users", "set", time.Millisecond, KindInternal)
+	m.Hit("users")
+	m.Hit("users")
+	m.Miss("users")
+
+	want := `
+# HELP charminder_cache_errors_total Failed cache operations by error kind.
+# TYPE charminder_cache_errors_total counter
+charminder_cache_errors_total{domain="users",kind="internal",operation="set"} 1
+# HELP charminder_cache_hits_total Cache lookups that found an entry.
+# TYPE charminder_cache_hits_total counter
+charminder_cache_hits_total{domain="users"} 2
+# HELP charminder_cache_misses_total Cache lookups that found no entry.
+# TYPE charminder_cache_misses_total counter
+charminder_cache_misses_total{domain="users"} 1
+# HELP charminder_cache_operations_total Cache operations by domain and operation.
+# TYPE charminder_cache_operations_total counter
+charminder_cache_operations_total{domain="users",operation="get"} 1
+charminder_cache_operations_total{domain="users",operation="set"} 1
+`
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(want), "charminder_cache_operations_total",
+		"charminder_cache_hits_total", "charminder_cache_misses_total", "charminder_cache_errors_total"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRegisterSharesExistingCollectors(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	first, err := NewRepositoryMetrics(registry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := NewRepositoryMetrics(registry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.operations != second.operations || first.errors != second.errors || first.duration != second.duration {
+		t.Fatal("a second NewRepositoryMetrics on the same registry created new collectors")
+	}
+	second.Observe("gorm", "users", "get", time.Millisecond, "")
+	if got := testutil.ToFloat64(first.operations.WithLabelValues("gorm", "users", "get")); got != 1 {
+		t.Fatalf("operations seen through the first metrics = %v, want 1", got)
+	}
+}
+
+func TestRegisterRejectsConflictingLabels(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	if _, err := NewRepositoryMetrics(registry); err != nil {
+		t.Fatal(err)
+	}
+	_, err := counterVec(registry, prometheus.CounterOpts{Subsystem: "repository", Name: "operations_total", Help: "Repository operations by store, domain and operation."}, "store")
+	if err == nil {
+		t.Fatal("registering operations_total with other labels succeeded")
+	}
+	var alreadyRegistered prometheus.AlreadyRegisteredError
+	if errors.As(err, &alreadyRegistered) {
+		t.Fatalf("register() = %v, want a conflict instead of the existing collector", err)
+	}
+}
+
+func TestErrorKind(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{name: "deadline", err: fmt.Errorf("querying: %w", context.DeadlineExceeded), want: KindTimeout},
+		{name: "canceled", err: fmt.Errorf("querying: %w", context.Canceled), want: KindCanceled},
+		{name: "net timeout", err: &net.OpError{Op: "dial", Net: "tcp", Err: &net.DNSError{Err: "i/o timeout", IsTimeout: true}}, want: KindTimeout},
+		{name: "net error", err: &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}, want: KindInternal},
+		{name: "other", err: errors.New("broken"), want: KindInternal},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ErrorKind(tt.err); got != tt.want {
+				t.Fatalf("ErrorKind(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
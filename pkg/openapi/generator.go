@@ -0,0 +1,251 @@
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/kutty-kumar/charminder/pkg"
+	"github.com/kutty-kumar/charminder/pkg/db"
+	"github.com/kutty-kumar/charminder/pkg/rest"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+const (
+	version         = "3.0.3"
+	jsonContentType = "application/json"
+
+	errorSchema          = "Error"
+	errorDetailSchema    = "ErrorDetail"
+	patchOperationSchema = "JsonPatchOperation"
+)
+
+type Generator struct {
+	handler  *rest.Handler
+	info     Info
+	basePath string
+	servers  []Server
+}
+
+type GeneratorOption func(g *Generator)
+
+func WithInfo(title, description, version string) GeneratorOption {
+	return func(g *Generator) {
+		g.info = Info{Title: title, Description: description, Version: version}
+	}
+}
+
+// WithBasePath replaces the base path of the handler, e.g. when a proxy in front of it adds a prefix.
+func WithBasePath(basePath string) GeneratorOption {
+	return func(g *Generator) {
+		g.basePath = strings.TrimSuffix(basePath, "/")
+	}
+}
+
+func WithServer(url string) GeneratorOption {
+	return func(g *Generator) {
+		g.servers = append(g.servers, Server{Url: url})
+	}
+}
+
+// NewGenerator describes the endpoints handler serves, at its base path.
+func NewGenerator(handler *rest.Handler, opts ...GeneratorOption) *Generator {
+	g := &Generator{handler: handler, info: Info{Title: "API", Version: "1.0.0"}, basePath: handler.BasePath()}
+	for _, opt := range opts {
+		opt(g)
+	}
+	return g
+}
+
+func jsonContent(schema *Schema) map[string]*MediaType {
+	return map[string]*MediaType{jsonContentType: {Schema: schema}}
+}
+
+func standardComponents() Components {
+	errorResponse := func(description string) *Response {
+		return &Response{Description: description, Content: jsonContent(SchemaRef(errorSchema))}
+	}
+	return Components{
+		Schemas: map[string]*Schema{
+			errorDetailSchema: {
+				Type: "object",
+				Properties: map[string]*Schema{
+					"field":   {Type: "string"},
+					"code":    {Type: "string"},
+					"message": {Type: "string"},
+				},
+			},
+			errorSchema: {
+				Type:     "object",
+				Required: []string{"error"},
+				Properties: map[string]*Schema{
+					"error": {
+						Type:     "object",
+						Required: []string{"code", "message"},
+						Properties: map[string]*Schema{
+							"code":    {Type: "string"},
+							"message": {Type: "string"},
+							"details": {Type: "array", Items: SchemaRef(errorDetailSchema)},
+						},
+					},
+				},
+			},
+			patchOperationSchema: {
+				Type:     "object",
+				Required: []string{"op", "path"},
+				Properties: map[string]*Schema{
+					"op":    {Type: "string", Enum: []interface{}{"add", "remove", "replace", "move", "copy", "test"}},
+					"path":  {Type: "string"},
+					"from":  {Type: "string"},
+					"value": {},
+				},
+			},
+		},
+		Responses: map[string]*Response{
+			"BadRequest":       errorResponse("The request could not be parsed"),
+			"NotFound":         errorResponse("The resource does not exist"),
+			"Conflict":         errorResponse("The status transition is not allowed"),
+			"ValidationFailed": errorResponse("The entity failed validation"),
+			"InternalError":    errorResponse("An unexpected error occurred"),
+		},
+		Parameters: map[string]*Parameter{
+			"ExternalId": {Name: "externalId", In: "path", Required: true, Schema: &Schema{Type: "string"}},
+			"Limit":      {Name: "limit", In: "query", Description: "Maximum number of items to return", Schema: &Schema{Type: "integer", Minimum: floatPtr("0"), Maximum: floatPtr("1000")}},
+			"Offset":     {Name: "offset", In: "query", Description: "Number of items to skip", Schema: &Schema{Type: "integer", Minimum: floatPtr("0")}},
+			"Sort":       {Name: "sort", In: "query", Description: "Comma separated fields, prefixed with - for descending order", Schema: &Schema{Type: "string"}},
+			"Fields":     {Name: "fields", In: "query", Description: "Comma separated fields to return", Schema: &Schema{Type: "string"}},
+		},
+	}
+}
+
+func responses(success string, successResponse *Response, errors ...string) map[string]*Response {
+	result := map[string]*Response{success: successResponse, "500": ResponseRef("InternalError")}
+	for _, code := range errors {
+		switch code {
+		case "400":
+			result[code] = ResponseRef("BadRequest")
+		case "404":
+			result[code] = ResponseRef("NotFound")
+		case "409":
+			result[code] = ResponseRef("Conflict")
+		case "422":
+			result[code] = ResponseRef("ValidationFailed")
+		}
+	}
+	return result
+}
+
+func filterParameters(schema *Schema) []*Parameter {
+	parameters := []*Parameter{ParameterRef("Limit"), ParameterRef("Offset"), ParameterRef("Sort"), ParameterRef("Fields")}
+	for _, name := range sortedKeys(schema.Properties) {
+		property := schema.Properties[name]
+		if property.Type == "object" || property.Type == "array" || property.Type == "" {
+			continue
+		}
+		parameters = append(parameters, &Parameter{
+			Name:        name,
+			In:          "query",
+			Description: fmt.Sprintf("Filter on %v, operators are given as %v[gte]=value", name, name),
+			Schema:      &Schema{Type: property.Type, Format: property.Format},
+		})
+	}
+	return parameters
+}
+
+func (g *Generator) addDomain(doc *Document, domainName pkg.DomainName, creator pkg.EntityCreator) {
+	name := string(domainName)
+	dto := creator().ToDto()
+	if dto == nil {
+		return
+	}
+	entitySchema := SchemaOf(dto)
+	entitySchema.Nullable = false
+	doc.Components.Schemas[name] = entitySchema
+	listName := name + "List"
+	doc.Components.Schemas[listName] = &Schema{
+		Type: "object",
+		Properties: map[string]*Schema{
+			"items":  {Type: "array", Items: SchemaRef(name)},
+			"count":  {Type: "integer"},
+			"limit":  {Type: "integer"},
+			"offset": {Type: "integer"},
+		},
+	}
+	entityResponse := &Response{Description: name, Content: jsonContent(SchemaRef(name))}
+	listResponse := &Response{Description: listName, Content: jsonContent(SchemaRef(listName))}
+	entityBody := &RequestBody{Required: true, Content: jsonContent(SchemaRef(name))}
+	tags := []string{name}
+	collectionPath := fmt.Sprintf("%v/%v", g.basePath, name)
+	doc.Paths[collectionPath] = &PathItem{
+		Get: &Operation{
+			OperationId: "list" + name, Summary: "List " + name, Tags: tags,
+			Parameters: filterParameters(entitySchema),
+			Responses:  responses("200", listResponse, "422"),
+		},
+		Post: &Operation{
+			OperationId: "create" + name, Summary: "Create " + name, Tags: tags,
+			RequestBody: entityBody,
+			Responses:   responses("201", entityResponse, "400", "422"),
+		},
+	}
+	explode := false
+	doc.Paths[collectionPath+"/_batch"] = &PathItem{
+		Get: &Operation{
+			OperationId: "batchGet" + name, Summary: "Get several " + name + " by external id", Tags: tags,
+			Parameters: []*Parameter{{Name: "ids", In: "query", Required: true, Style: "form", Explode: &explode, Schema: &Schema{Type: "array", Items: &Schema{Type: "string"}}}},
+			Responses:  responses("200", listResponse, "400"),
+		},
+	}
+	doc.Paths[collectionPath+"/{externalId}"] = &PathItem{
+		Parameters: []*Parameter{ParameterRef("ExternalId")},
+		Get: &Operation{
+			OperationId: "get" + name, Summary: "Get " + name, Tags: tags,
+			Responses: responses("200", entityResponse, "404"),
+		},
+		Put: &Operation{
+			OperationId: "update" + name, Summary: "Update " + name, Tags: tags,
+			RequestBody: entityBody,
+			Responses:   responses("200", entityResponse, "400", "404", "409", "422"),
+		},
+		Patch: &Operation{
+			OperationId: "patch" + name, Summary: "Patch " + name, Tags: tags,
+			RequestBody: &RequestBody{Required: true, Content: map[string]*MediaType{
+				string(db.MergePatch): {Schema: &Schema{Type: "object"}},
+				string(db.JSONPatch):  {Schema: &Schema{Type: "array", Items: SchemaRef(patchOperationSchema)}},
+			}},
+			Responses: responses("200", entityResponse, "400", "404", "409", "422"),
+		},
+		Delete: &Operation{
+			OperationId: "delete" + name, Summary: "Delete " + name, Tags: tags,
+			Responses: responses("204", &Response{Description: "Deleted"}, "404"),
+		},
+	}
+}
+
+// Generate builds the document for every domain the handler serves.
+func (g *Generator) Generate() *Document {
+	doc := &Document{
+		OpenAPI:    version,
+		Info:       g.info,
+		Servers:    g.servers,
+		Paths:      make(map[string]*PathItem),
+		Components: standardComponents(),
+	}
+	for _, domainName := range g.handler.DomainNames() {
+		g.addDomain(doc, domainName, g.handler.Creator(domainName))
+	}
+	return doc
+}
+
+func (g *Generator) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", jsonContentType)
+	_ = json.NewEncoder(w).Encode(g.Generate())
+}
+
+func (g *Generator) WriteFile(path string) error {
+	docBytes, err := json.MarshalIndent(g.Generate(), "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, docBytes, 0644)
+}
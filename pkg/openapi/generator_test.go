@@ -0,0 +1,115 @@
+package openapi
+
+import (
+	"database/sql"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/kutty-kumar/charminder/pkg"
+	"github.com/kutty-kumar/charminder/pkg/db"
+	"github.com/kutty-kumar/charminder/pkg/rest"
+)
+
+type gadget struct {
+	pkg.BaseDomain
+	Name  string   `json:"name" validate:"required,min_len=2,max_len=10"`
+	Code  string   `json:"code" validate:"regex=^[a-z]{1,3}$"`
+	Kind  string   `json:"kind" validate:"enum=a|b"`
+	Price float64  `json:"price" validate:"min=0"`
+	Tags  []string `json:"tags,omitempty"`
+	// domainName lets one type stand for several domains.
+	domainName pkg.DomainName
+}
+
+func (g *gadget) GetName() pkg.DomainName {
+	return g.domainName
+}
+
+func (g *gadget) ToDto() interface{} {
+	return g
+}
+
+func (g *gadget) FillProperties(dto interface{}) pkg.Base {
+	*g = *dto.(*gadget)
+	return g
+}
+
+func (g *gadget) Merge(other interface{}) {}
+
+func (g *gadget) FromSqlRow(rows *sql.Rows) (pkg.Base, error) {
+	return g, nil
+}
+
+func (g *gadget) ToJson() (string, error) {
+	out, err := json.Marshal(g)
+	return string(out), err
+}
+
+func (g *gadget) MarshalBinary() ([]byte, error) {
+	return json.Marshal(g)
+}
+
+func (g *gadget) UnmarshalBinary(buffer []byte) error {
+	return json.Unmarshal(buffer, g)
+}
+
+func TestGenerateOnlyDescribesServedDomains(t *testing.T) {
+	factory := pkg.NewDomainFactory()
+	for _, name := range []pkg.DomainName{"gadgets", "widgets"} {
+		name := name
+		factory.RegisterMapping(name, func() pkg.Base { return &gadget{domainName: name} })
+	}
+	handler := rest.NewHandler(factory, rest.WithBasePath("/api/"), rest.WithService("gadgets", &db.BaseSvc{}))
+	tests := []struct {
+		name      string
+		opts      []GeneratorOption
+		wantPaths []string
+	}{
+		{name: "handler base path", wantPaths: []string{"/api/gadgets", "/api/gadgets/_batch", "/api/gadgets/{externalId}"}},
+		{name: "replaced base path", opts: []GeneratorOption{WithBasePath("/v1/api")}, wantPaths: []string{"/v1/api/gadgets", "/v1/api/gadgets/_batch", "/v1/api/gadgets/{externalId}"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc := NewGenerator(handler, tt.opts...).Generate()
+			var paths []string
+			for path := range doc.Paths {
+				paths = append(paths, path)
+			}
+			sort.Strings(paths)
+			if !reflect.DeepEqual(paths, tt.wantPaths) {
+				t.Fatalf("paths %v, want %v", paths, tt.wantPaths)
+			}
+			if _, ok := doc.Components.Schemas["widgets"]; ok {
+				t.Fatal("the document describes widgets, which the handler does not serve")
+			}
+		})
+	}
+}
+
+func TestSchemaOfCarriesValidationRules(t *testing.T) {
+	schema := SchemaOf(&gadget{})
+	if !reflect.DeepEqual(schema.Required, []string{"name"}) {
+		t.Errorf("required %v, want [name]", schema.Required)
+	}
+	name := schema.Properties["name"]
+	if name.MinLength == nil || *name.MinLength != 2 || name.MaxLength == nil || *name.MaxLength != 10 {
+		t.Errorf("name %+v, want lengths 2 to 10", name)
+	}
+	if code := schema.Properties["code"]; code.Pattern != "^[a-z]{1,3}$" {
+		t.Errorf("code pattern %q, want the whole regex", code.Pattern)
+	}
+	if kind := schema.Properties["kind"]; !reflect.DeepEqual(kind.Enum, []interface{}{"a", "b"}) {
+		t.Errorf("kind enum %v, want [a b]", kind.Enum)
+	}
+	if price := schema.Properties["price"]; price.Minimum == nil || *price.Minimum != 0 || price.Type != "number" {
+		t.Errorf("price %+v, want a number of at least 0", price)
+	}
+	if tags := schema.Properties["tags"]; tags.Type != "array" || tags.Items.Type != "string" {
+		t.Errorf("tags %+v, want an array of strings", tags)
+	}
+	if _, ok := schema.Properties["domainName"]; ok {
+		t.Error("the schema describes an unexported field")
+	}
+}
@@ -0,0 +1,168 @@
+package openapi
+
+import (
+	"github.com/kutty-kumar/charminder/pkg/validation"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func jsonField(field reflect.StructField) (string, bool) {
+	tag := field.Tag.Get("json")
+	if tag == "-" {
+		return "", false
+	}
+	if name := strings.Split(tag, ",")[0]; name != "" {
+		return name, true
+	}
+	return field.Name, true
+}
+
+func intPtr(value string) *int {
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		return nil
+	}
+	return &parsed
+}
+
+func floatPtr(value string) *float64 {
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return nil
+	}
+	return &parsed
+}
+
+// applyValidation carries the `validate` tag rules understood by the validation package over to the schema.
+func applyValidation(schema *Schema, tag string) bool {
+	required := false
+	for _, rule := range validation.ParseRules(tag) {
+		switch rule.Name {
+		case "required":
+			required = true
+		case "len":
+			schema.MinLength, schema.MaxLength = intPtr(rule.Param), intPtr(rule.Param)
+		case "min_len":
+			schema.MinLength = intPtr(rule.Param)
+		case "max_len":
+			schema.MaxLength = intPtr(rule.Param)
+		case "min":
+			schema.Minimum = floatPtr(rule.Param)
+		case "max":
+			schema.Maximum = floatPtr(rule.Param)
+		case "regex":
+			schema.Pattern = rule.Param
+		case "email":
+			schema.Format = "email"
+		case "enum":
+			for _, value := range strings.Split(rule.Param, "|") {
+				schema.Enum = append(schema.Enum, value)
+			}
+		}
+	}
+	return required
+}
+
+type schemaBuilder struct {
+	visiting map[reflect.Type]bool
+}
+
+func (sb *schemaBuilder) build(t reflect.Type) *Schema {
+	nullable := false
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+		nullable = true
+	}
+	schema := sb.buildType(t)
+	schema.Nullable = nullable
+	return schema
+}
+
+func (sb *schemaBuilder) buildType(t reflect.Type) *Schema {
+	if t == timeType || t.ConvertibleTo(timeType) {
+		return &Schema{Type: "string", Format: "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &Schema{Type: "integer", Format: "int32"}
+	case reflect.Int64, reflect.Uint64:
+		return &Schema{Type: "integer", Format: "int64"}
+	case reflect.Float32:
+		return &Schema{Type: "number", Format: "float"}
+	case reflect.Float64:
+		return &Schema{Type: "number", Format: "double"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}
+		}
+		return &Schema{Type: "array", Items: sb.build(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: sb.build(t.Elem())}
+	case reflect.Struct:
+		return sb.buildStruct(t)
+	}
+	return &Schema{}
+}
+
+func (sb *schemaBuilder) buildStruct(t reflect.Type) *Schema {
+	schema := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+	if sb.visiting[t] {
+		return schema
+	}
+	sb.visiting[t] = true
+	defer delete(sb.visiting, t)
+	sb.addFields(schema, t)
+	return schema
+}
+
+func (sb *schemaBuilder) addFields(schema *Schema, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Anonymous && field.Tag.Get("json") == "" {
+			embedded := field.Type
+			for embedded.Kind() == reflect.Ptr {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				sb.addFields(schema, embedded)
+				continue
+			}
+		}
+		if field.PkgPath != "" {
+			continue
+		}
+		name, ok := jsonField(field)
+		if !ok {
+			continue
+		}
+		property := sb.build(field.Type)
+		if applyValidation(property, field.Tag.Get("validate")) {
+			schema.Required = append(schema.Required, name)
+		}
+		schema.Properties[name] = property
+	}
+}
+
+// SchemaOf reflects over v the way encoding/json would serialise it.
+func SchemaOf(v interface{}) *Schema {
+	sb := &schemaBuilder{visiting: make(map[reflect.Type]bool)}
+	return sb.build(reflect.TypeOf(v))
+}
+
+func sortedKeys(properties map[string]*Schema) []string {
+	keys := make([]string, 0, len(properties))
+	for key := range properties {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
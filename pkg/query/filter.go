@@ -0,0 +1,234 @@
+package query
+
+import (
+	"fmt"
+	"github.com/kutty-kumar/charminder/pkg"
+	"github.com/kutty-kumar/charminder/pkg/validation"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Operator string
+
+const (
+	Eq     Operator = "eq"
+	Ne     Operator = "ne"
+	Gt     Operator = "gt"
+	Gte    Operator = "gte"
+	Lt     Operator = "lt"
+	Lte    Operator = "lte"
+	In     Operator = "in"
+	Nin    Operator = "nin"
+	Like   Operator = "like"
+	Prefix Operator = "prefix"
+	Exists Operator = "exists"
+)
+
+const (
+	DefaultLimit = 50
+	MaxLimit     = 1000
+
+	sortParam   = "sort"
+	limitParam  = "limit"
+	offsetParam = "offset"
+	fieldsParam = "fields"
+
+	CodeUnknownField    = "unknown_field"
+	CodeInvalidOperator = "invalid_operator"
+	CodeInvalidValue    = "invalid_value"
+)
+
+var operatorsByType = map[FieldType][]Operator{
+	StringField:  {Eq, Ne, In, Nin, Like, Prefix, Exists},
+	IntegerField: {Eq, Ne, Gt, Gte, Lt, Lte, In, Nin, Exists},
+	FloatField:   {Eq, Ne, Gt, Gte, Lt, Lte, In, Nin, Exists},
+	BoolField:    {Eq, Ne, Exists},
+	TimeField:    {Eq, Ne, Gt, Gte, Lt, Lte, Exists},
+}
+
+type Condition struct {
+	Field       string
+	StructField string
+	Type        FieldType
+	Operator    Operator
+	// Value holds the single operand; In and Nin use Values and Exists holds a bool.
+	Value  interface{}
+	Values []interface{}
+}
+
+type SortField struct {
+	Field       string
+	StructField string
+	Type        FieldType
+	Descending  bool
+}
+
+type Filter struct {
+	Conditions []Condition
+	Sort       []SortField
+	Limit      int
+	Offset     int
+	Fields     []string
+}
+
+func supports(fieldType FieldType, operator Operator) bool {
+	for _, candidate := range operatorsByType[fieldType] {
+		if candidate == operator {
+			return true
+		}
+	}
+	return false
+}
+
+// splitKey turns `created_at[gte]` into the field name and operator, defaulting to Eq.
+func splitKey(key string) (string, Operator, bool) {
+	open := strings.Index(key, "[")
+	if open < 0 {
+		return key, Eq, true
+	}
+	if !strings.HasSuffix(key, "]") || open == 0 {
+		return "", "", false
+	}
+	return key[:open], Operator(key[open+1 : len(key)-1]), true
+}
+
+func parseTime(raw string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, raw); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", raw)
+}
+
+func (s *Schema) parseValue(field Field, raw string) (interface{}, error) {
+	switch field.Type {
+	case IntegerField:
+		value, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil && strings.EqualFold(field.Name, "status") {
+			// status accepts the lifecycle names registered for the domain as well as raw integers
+			status, statusErr := pkg.GetStatusLifecycle(s.DomainName).StatusInt(raw)
+			return int64(status), statusErr
+		}
+		return value, err
+	case FloatField:
+		return strconv.ParseFloat(raw, 64)
+	case BoolField:
+		return strconv.ParseBool(raw)
+	case TimeField:
+		return parseTime(raw)
+	}
+	return raw, nil
+}
+
+func (s *Schema) parseCondition(vErr *validation.Error, key, raw string) (Condition, bool) {
+	name, operator, ok := splitKey(key)
+	if !ok {
+		vErr.Add(key, CodeInvalidOperator, "malformed filter parameter")
+		return Condition{}, false
+	}
+	field, ok := s.Field(name)
+	if !ok {
+		vErr.Add(name, CodeUnknownField, "is not a filterable field")
+		return Condition{}, false
+	}
+	if !supports(field.Type, operator) {
+		vErr.Add(name, CodeInvalidOperator, fmt.Sprintf("operator %v is not supported", operator))
+		return Condition{}, false
+	}
+	condition := Condition{Field: field.Name, StructField: field.StructField, Type: field.Type, Operator: operator}
+	switch operator {
+	case Exists:
+		exists, err := strconv.ParseBool(raw)
+		if err != nil {
+			vErr.Add(name, CodeInvalidValue, "exists expects true or false")
+			return Condition{}, false
+		}
+		condition.Value = exists
+	case In, Nin:
+		for _, part := range strings.Split(raw, ",") {
+			value, err := s.parseValue(field, part)
+			if err != nil {
+				vErr.Add(name, CodeInvalidValue, fmt.Sprintf("invalid value %q", part))
+				return Condition{}, false
+			}
+			condition.Values = append(condition.Values, value)
+		}
+	default:
+		value, err := s.parseValue(field, raw)
+		if err != nil {
+			vErr.Add(name, CodeInvalidValue, fmt.Sprintf("invalid value %q", raw))
+			return Condition{}, false
+		}
+		condition.Value = value
+	}
+	return condition, true
+}
+
+func parseBound(vErr *validation.Error, name, raw string, fallback, max int) int {
+	if raw == "" {
+		return fallback
+	}
+	value, err := strconv.Atoi(raw)
+	if err != nil || value < 0 || (max > 0 && value > max) {
+		vErr.Add(name, CodeInvalidValue, fmt.Sprintf("invalid value %q", raw))
+		return fallback
+	}
+	return value
+}
+
+// Parse converts query parameters such as
+//
+//	?status=active&created_at[gte]=2021-01-01&sort=-updated_at&limit=50&fields=external_id,name
+//
+// into a Filter, rejecting unknown fields, unsupported operators and values of the wrong type with a validation error.
+func Parse(values url.Values, schema *Schema) (*Filter, error) {
+	vErr := &validation.Error{}
+	filter := &Filter{
+		Limit:  parseBound(vErr, limitParam, values.Get(limitParam), DefaultLimit, MaxLimit),
+		Offset: parseBound(vErr, offsetParam, values.Get(offsetParam), 0, 0),
+	}
+	if raw := values.Get(sortParam); raw != "" {
+		for _, part := range strings.Split(raw, ",") {
+			sortField := SortField{Field: strings.TrimPrefix(part, "-"), Descending: strings.HasPrefix(part, "-")}
+			field, ok := schema.Field(sortField.Field)
+			if !ok {
+				vErr.Add(sortField.Field, CodeUnknownField, "is not a sortable field")
+				continue
+			}
+			sortField.Field, sortField.StructField, sortField.Type = field.Name, field.StructField, field.Type
+			filter.Sort = append(filter.Sort, sortField)
+		}
+	}
+	if raw := values.Get(fieldsParam); raw != "" {
+		for _, name := range strings.Split(raw, ",") {
+			field, ok := schema.Field(name)
+			if !ok {
+				vErr.Add(name, CodeUnknownField, "is not a selectable field")
+				continue
+			}
+			filter.Fields = append(filter.Fields, field.Name)
+		}
+	}
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		switch key {
+		case sortParam, limitParam, offsetParam, fieldsParam:
+			continue
+		}
+		for _, raw := range values[key] {
+			if condition, ok := schema.parseCondition(vErr, key, raw); ok {
+				filter.Conditions = append(filter.Conditions, condition)
+			}
+		}
+	}
+	if vErr.HasErrors() {
+		return nil, vErr
+	}
+	return filter, nil
+}
@@ -0,0 +1,194 @@
+package query
+
+import (
+	"database/sql"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/kutty-kumar/charminder/pkg"
+	"github.com/kutty-kumar/charminder/pkg/validation"
+)
+
+type product struct {
+	pkg.BaseDomain
+	Name     string    `json:"name"`
+	Price    float64   `json:"price"`
+	Stock    *int      `json:"stock,omitempty"`
+	OnSale   bool      `json:"on_sale"`
+	Released time.Time `json:"released"`
+	Secret   string    `json:"-"`
+	Tags     []string  `json:"tags"`
+	internal string
+}
+
+func (p *product) GetName() pkg.DomainName                     { return "products" }
+func (p *product) ToDto() interface{}                          { return nil }
+func (p *product) FillProperties(dto interface{}) pkg.Base     { return p }
+func (p *product) Merge(other interface{})                     {}
+func (p *product) FromSqlRow(rows *sql.Rows) (pkg.Base, error) { return p, nil }
+func (p *product) MarshalBinary() ([]byte, error)              { return nil, nil }
+func (p *product) UnmarshalBinary(buffer []byte) error         { return nil }
+
+// productDto is the wire form of product, with names the entity does not use.
+type productDto struct {
+	Title string `json:"title"`
+	Cost  int    `json:"cost"`
+}
+
+type dtoProduct struct {
+	product
+}
+
+func (p *dtoProduct) ToDto() interface{} { return &productDto{} }
+
+func TestNewSchema(t *testing.T) {
+	tests := []struct {
+		name    string
+		entity  pkg.Base
+		lookup  string
+		want    Field
+		present bool
+	}{
+		{name: "json name", entity: &product{}, lookup: "name", want: Field{Name: "name", Type: StringField, StructField: "Name"}, present: true},
+		{name: "pointer", entity: &product{}, lookup: "stock", want: Field{Name: "stock", Type: IntegerField, StructField: "Stock"}, present: true},
+		{name: "float", entity: &product{}, lookup: "price", want: Field{Name: "price", Type: FloatField, StructField: "Price"}, present: true},
+		{name: "bool", entity: &product{}, lookup: "on_sale", want: Field{Name: "on_sale", Type: BoolField, StructField: "OnSale"}, present: true},
+		{name: "time", entity: &product{}, lookup: "released", want: Field{Name: "released", Type: TimeField, StructField: "Released"}, present: true},
+		{name: "embedded with a json tag", entity: &product{}, lookup: "external_id", want: Field{Name: "external_id", Type: StringField, StructField: "ExternalId"}, present: true},
+		{name: "embedded pointer to time", entity: &product{}, lookup: "created_at", want: Field{Name: "created_at", Type: TimeField, StructField: "CreatedAt"}, present: true},
+		{name: "untagged by go name", entity: &product{}, lookup: "UpdatedAt", want: Field{Name: "UpdatedAt", Type: TimeField, StructField: "UpdatedAt"}, present: true},
+		{name: "untagged by snake case", entity: &product{}, lookup: "updated_at", want: Field{Name: "UpdatedAt", Type: TimeField, StructField: "UpdatedAt"}, present: true},
+		{name: "status", entity: &product{}, lookup: "status", want: Field{Name: "Status", Type: IntegerField, StructField: "Status"}, present: true},
+		{name: "json dash", entity: &product{}, lookup: "Secret"},
+		{name: "slice", entity: &product{}, lookup: "tags"},
+		{name: "unexported", entity: &product{}, lookup: "internal"},
+		{name: "dto name", entity: &dtoProduct{}, lookup: "title", want: Field{Name: "title", Type: StringField, StructField: "Title"}, present: true},
+		{name: "entity name hidden by the dto", entity: &dtoProduct{}, lookup: "name"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := NewSchema(tt.entity).Field(tt.lookup)
+			if ok != tt.present {
+				t.Fatalf("Field(%v) present = %v, want %v", tt.lookup, ok, tt.present)
+			}
+			if got != tt.want {
+				t.Fatalf("Field(%v) = %+v, want %+v", tt.lookup, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	day := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name  string
+		query string
+		want  *Filter
+	}{
+		{
+			name:  "defaults",
+			query: "",
+			want:  &Filter{Limit: DefaultLimit},
+		},
+		{
+			name:  "equality",
+			query: "name=lamp",
+			want:  &Filter{Limit: DefaultLimit, Conditions: []Condition{{Field: "name", StructField: "Name", Type: StringField, Operator: Eq, Value: "lamp"}}},
+		},
+		{
+			name:  "typed operators",
+			query: "price[gte]=9.5&stock[lt]=3&on_sale=true&released[gt]=2021-01-01",
+			want: &Filter{Limit: DefaultLimit, Conditions: []Condition{
+				{Field: "on_sale", StructField: "OnSale", Type: BoolField, Operator: Eq, Value: true},
+				{Field: "price", StructField: "Price", Type: FloatField, Operator: Gte, Value: 9.5},
+				{Field: "released", StructField: "Released", Type: TimeField, Operator: Gt, Value: day},
+				{Field: "stock", StructField: "Stock", Type: IntegerField, Operator: Lt, Value: int64(3)},
+			}},
+		},
+		{
+			name:  "lists and exists",
+			query: "name[in]=a,b&stock[exists]=false",
+			want: &Filter{Limit: DefaultLimit, Conditions: []Condition{
+				{Field: "name", StructField: "Name", Type: StringField, Operator: In, Values: []interface{}{"a", "b"}},
+				{Field: "stock", StructField: "Stock", Type: IntegerField, Operator: Exists, Value: false},
+			}},
+		},
+		{
+			name:  "status by name",
+			query: "status=inactive",
+			want:  &Filter{Limit: DefaultLimit, Conditions: []Condition{{Field: "Status", StructField: "Status", Type: IntegerField, Operator: Eq, Value: int64(1)}}},
+		},
+		{
+			name:  "sort, page and fields",
+			query: "sort=-updated_at,name&limit=10&offset=20&fields=external_id,updated_at",
+			want: &Filter{
+				Limit:  10,
+				Offset: 20,
+				Sort: []SortField{
+					{Field: "UpdatedAt", StructField: "UpdatedAt", Type: TimeField, Descending: true},
+					{Field: "name", StructField: "Name", Type: StringField},
+				},
+				Fields: []string{"external_id", "UpdatedAt"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := Parse(values, NewSchema(&product{}))
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("Parse() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRejects(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  []validation.FieldError
+	}{
+		{name: "unknown field", query: "color=red", want: []validation.FieldError{{Field: "color", Code: CodeUnknownField}}},
+		{name: "hidden field", query: "Secret=x", want: []validation.FieldError{{Field: "Secret", Code: CodeUnknownField}}},
+		{name: "operator of another type", query: "name[gt]=a", want: []validation.FieldError{{Field: "name", Code: CodeInvalidOperator}}},
+		{name: "unknown operator", query: "price[near]=1", want: []validation.FieldError{{Field: "price", Code: CodeInvalidOperator}}},
+		{name: "malformed key", query: "price[gt=1", want: []validation.FieldError{{Field: "price[gt", Code: CodeInvalidOperator}}},
+		{name: "wrong type", query: "stock=many", want: []validation.FieldError{{Field: "stock", Code: CodeInvalidValue}}},
+		{name: "wrong list element", query: "price[in]=1,x", want: []validation.FieldError{{Field: "price", Code: CodeInvalidValue}}},
+		{name: "exists without a bool", query: "stock[exists]=maybe", want: []validation.FieldError{{Field: "stock", Code: CodeInvalidValue}}},
+		{name: "unknown status", query: "status=archived", want: []validation.FieldError{{Field: "status", Code: CodeInvalidValue}}},
+		{name: "limit above the maximum", query: "limit=5000", want: []validation.FieldError{{Field: "limit", Code: CodeInvalidValue}}},
+		{name: "negative offset", query: "offset=-1", want: []validation.FieldError{{Field: "offset", Code: CodeInvalidValue}}},
+		{name: "unknown sort field", query: "sort=-color", want: []validation.FieldError{{Field: "color", Code: CodeUnknownField}}},
+		{name: "unknown selected field", query: "fields=name,color", want: []validation.FieldError{{Field: "color", Code: CodeUnknownField}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			filter, err := Parse(values, NewSchema(&product{}))
+			vErr, ok := validation.AsError(err)
+			if !ok {
+				t.Fatalf("Parse() = %+v, %v, want a validation error", filter, err)
+			}
+			var got []validation.FieldError
+			for _, fieldError := range vErr.Errors {
+				got = append(got, validation.FieldError{Field: fieldError.Field, Code: fieldError.Code})
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("Parse() errors = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package query
+
+import (
+	"github.com/gobeam/stringy"
+	"github.com/kutty-kumar/charminder/pkg"
+	"reflect"
+	"strings"
+	"time"
+)
+
+type FieldType int
+
+const (
+	StringField FieldType = iota
+	IntegerField
+	FloatField
+	BoolField
+	TimeField
+)
+
+var timeType = reflect.TypeOf(time.Time{})
+
+type Field struct {
+	Name string
+	Type FieldType
+	// StructField is the Go name of the field, repositories resolve their column or document field from it.
+	StructField string
+}
+
+// Schema lists the filterable fields of an entity, keyed by the names its DTO uses on the wire. Fields without a
+// json tag also answer to their snake_case name, e.g. updated_at for UpdatedAt.
+type Schema struct {
+	DomainName pkg.DomainName
+	fields     map[string]Field
+}
+
+func fieldType(t reflect.Type) (FieldType, bool) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == timeType || t.ConvertibleTo(timeType) {
+		return TimeField, true
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return StringField, true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return IntegerField, true
+	case reflect.Float32, reflect.Float64:
+		return FloatField, true
+	case reflect.Bool:
+		return BoolField, true
+	}
+	return 0, false
+}
+
+// jsonName returns the name encoding/json uses for field and whether the json tag set it.
+func jsonName(field reflect.StructField) (string, bool, bool) {
+	tag := field.Tag.Get("json")
+	if tag == "-" {
+		return "", false, false
+	}
+	if name := strings.Split(tag, ",")[0]; name != "" {
+		return name, true, true
+	}
+	return field.Name, false, true
+}
+
+func collectFields(t reflect.Type, fields map[string]Field, aliases map[string]string) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Anonymous {
+			embedded := field.Type
+			for embedded.Kind() == reflect.Ptr {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				collectFields(embedded, fields, aliases)
+			}
+			continue
+		}
+		if field.PkgPath != "" {
+			continue
+		}
+		name, tagged, ok := jsonName(field)
+		if !ok {
+			continue
+		}
+		kind, ok := fieldType(field.Type)
+		if !ok {
+			continue
+		}
+		fields[name] = Field{Name: name, Type: kind, StructField: field.Name}
+		if alias := stringy.New(field.Name).SnakeCase().ToLower(); !tagged && alias != name {
+			aliases[alias] = name
+		}
+	}
+}
+
+// NewSchema reflects over the DTO of entity, or the entity itself when ToDto returns nil.
+func NewSchema(entity pkg.Base) *Schema {
+	schema := &Schema{DomainName: entity.GetName(), fields: make(map[string]Field)}
+	var source interface{} = entity
+	if dto := entity.ToDto(); dto != nil {
+		source = dto
+	}
+	t := reflect.TypeOf(source)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() == reflect.Struct {
+		aliases := make(map[string]string)
+		collectFields(t, schema.fields, aliases)
+		for alias, name := range aliases {
+			if _, taken := schema.fields[alias]; !taken {
+				schema.fields[alias] = schema.fields[name]
+			}
+		}
+	}
+	return schema
+}
+
+func (s *Schema) Field(name string) (Field, bool) {
+	field, ok := s.fields[name]
+	return field, ok
+}
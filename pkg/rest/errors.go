@@ -0,0 +1,85 @@
+package rest
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/kutty-kumar/charminder/pkg"
+	"github.com/kutty-kumar/charminder/pkg/db"
+	"github.com/kutty-kumar/charminder/pkg/validation"
+	"net/http"
+)
+
+const (
+	CodeBadRequest        = "bad_request"
+	CodeNotFound          = "not_found"
+	CodeUnknownDomain     = "unknown_domain"
+	CodeMethodNotAllowed  = "method_not_allowed"
+	CodeValidationFailed  = "validation_failed"
+	CodeInvalidTransition = "invalid_transition"
+	CodeNotSupported      = "not_supported"
+	CodeInternal          = "internal_error"
+)
+
+type ErrorBody struct {
+	Code    string                  `json:"code"`
+	Message string                  `json:"message"`
+	Details []validation.FieldError `json:"details,omitempty"`
+}
+
+type ErrorEnvelope struct {
+	Error ErrorBody `json:"error"`
+}
+
+type HttpError struct {
+	Status int
+	Body   ErrorBody
+}
+
+func (e *HttpError) Error() string {
+	return e.Body.Message
+}
+
+func NewHttpError(status int, code, message string) *HttpError {
+	return &HttpError{Status: status, Body: ErrorBody{Code: code, Message: message}}
+}
+
+func badRequest(err error) *HttpError {
+	return NewHttpError(http.StatusBadRequest, CodeBadRequest, err.Error())
+}
+
+// toHttpError maps errors surfaced by BaseSvc onto a status code and envelope.
+func toHttpError(err error) *HttpError {
+	var httpErr *HttpError
+	if errors.As(err, &httpErr) {
+		return httpErr
+	}
+	if vErr, ok := validation.AsError(err); ok {
+		return &HttpError{
+			Status: http.StatusUnprocessableEntity,
+			Body:   ErrorBody{Code: CodeValidationFailed, Message: "validation failed", Details: vErr.Errors},
+		}
+	}
+	switch {
+	case db.IsNotFound(err):
+		return NewHttpError(http.StatusNotFound, CodeNotFound, err.Error())
+	case errors.Is(err, pkg.ErrInvalidTransition):
+		return NewHttpError(http.StatusConflict, CodeInvalidTransition, err.Error())
+	case errors.Is(err, pkg.ErrUnknownStatus):
+		return NewHttpError(http.StatusUnprocessableEntity, CodeValidationFailed, err.Error())
+	case errors.Is(err, db.ErrInvalidPatch):
+		return NewHttpError(http.StatusBadRequest, CodeBadRequest, err.Error())
+	case errors.Is(err, db.ErrNotSupported):
+		return NewHttpError(http.StatusNotImplemented, CodeNotSupported, err.Error())
+	}
+	return NewHttpError(http.StatusInternalServerError, CodeInternal, http.StatusText(http.StatusInternalServerError))
+}
+
+func writeJson(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func writeError(w http.ResponseWriter, httpErr *HttpError) {
+	writeJson(w, httpErr.Status, ErrorEnvelope{Error: httpErr.Body})
+}
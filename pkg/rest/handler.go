@@ -0,0 +1,367 @@
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/kutty-kumar/charminder/pkg"
+	"github.com/kutty-kumar/charminder/pkg/db"
+	"github.com/kutty-kumar/charminder/pkg/logging"
+	"github.com/kutty-kumar/charminder/pkg/query"
+	"github.com/satori/go.uuid"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+const (
+	batchSegment = "_batch"
+	// maxBatchIds is the most ids one batch get accepts, the same as the largest page of a list.
+	maxBatchIds = query.MaxLimit
+
+	// DefaultMaxBodyBytes is the largest request body a handler reads unless WithMaxBodyBytes says otherwise.
+	DefaultMaxBodyBytes = 1 << 20
+
+	RequestIdHeader = "X-Request-Id"
+	TenantHeader    = "X-Tenant-Id"
+)
+
+type ListResponse struct {
+	Items  []interface{} `json:"items"`
+	Count  int           `json:"count"`
+	Limit  int           `json:"limit,omitempty"`
+	Offset int           `json:"offset,omitempty"`
+}
+
+type Handler struct {
+	factory      *pkg.DomainFactory
+	services     map[pkg.DomainName]*db.BaseSvc
+	basePath     string
+	maxBodyBytes int64
+	logger       logging.Logger
+}
+
+type HandlerOption func(h *Handler)
+
+func WithService(domainName pkg.DomainName, svc *db.BaseSvc) HandlerOption {
+	return func(h *Handler) {
+		h.services[domainName] = svc
+	}
+}
+
+func WithBasePath(basePath string) HandlerOption {
+	return func(h *Handler) {
+		h.basePath = strings.TrimSuffix(basePath, "/")
+	}
+}
+
+// WithMaxBodyBytes limits the size of create, update and patch bodies; larger ones are rejected with 413.
+func WithMaxBodyBytes(limit int64) HandlerOption {
+	return func(h *Handler) {
+		h.maxBodyBytes = limit
+	}
+}
+
+func WithLogger(logger logging.Logger) HandlerOption {
+	return func(h *Handler) {
+		h.logger = logger
+	}
+}
+
+// NewHandler serves CRUD endpoints for every domain in factory that has a service registered through WithService:
+//
+//	GET    /{domain}                 list, query parameters are parsed by query.Parse
+//	POST   /{domain}                 create
+//	GET    /{domain}/_batch?ids=a,b  batch get
+//	GET    /{domain}/{externalId}    get
+//	PUT    /{domain}/{externalId}    update
+//	PATCH  /{domain}/{externalId}    merge patch or JSON patch, chosen by Content-Type
+//	DELETE /{domain}/{externalId}    delete
+func NewHandler(factory *pkg.DomainFactory, opts ...HandlerOption) *Handler {
+	h := &Handler{
+		factory:      factory,
+		services:     make(map[pkg.DomainName]*db.BaseSvc),
+		maxBodyBytes: DefaultMaxBodyBytes,
+		logger:       logging.Nop(),
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
+func (h *Handler) BasePath() string {
+	return h.basePath
+}
+
+// DomainNames lists the domains the handler serves: those of the factory with a registered service, sorted.
+func (h *Handler) DomainNames() []pkg.DomainName {
+	var domainNames []pkg.DomainName
+	for _, domainName := range h.factory.DomainNames() {
+		if _, ok := h.services[domainName]; ok {
+			domainNames = append(domainNames, domainName)
+		}
+	}
+	return domainNames
+}
+
+func (h *Handler) Creator(domainName pkg.DomainName) pkg.EntityCreator {
+	return h.factory.GetMapping(domainName)
+}
+
+type route struct {
+	domainName pkg.DomainName
+	creator    pkg.EntityCreator
+	svc        *db.BaseSvc
+	externalId string
+}
+
+func (h *Handler) resolve(path string) (*route, *HttpError) {
+	rest := strings.TrimPrefix(path, h.basePath)
+	if !strings.HasPrefix(path, h.basePath) || (rest != "" && !strings.HasPrefix(rest, "/")) {
+		return nil, NewHttpError(http.StatusNotFound, CodeNotFound, "resource not found")
+	}
+	segments := strings.Split(strings.Trim(rest, "/"), "/")
+	if len(segments) == 0 || len(segments) > 2 || segments[0] == "" {
+		return nil, NewHttpError(http.StatusNotFound, CodeNotFound, "resource not found")
+	}
+	domainName := pkg.DomainName(segments[0])
+	creator := h.factory.GetMapping(domainName)
+	svc, ok := h.services[domainName]
+	if creator == nil || !ok {
+		return nil, NewHttpError(http.StatusNotFound, CodeUnknownDomain, fmt.Sprintf("unknown domain %v", domainName))
+	}
+	r := &route{domainName: domainName, creator: creator, svc: svc}
+	if len(segments) == 2 {
+		r.externalId = segments[1]
+	}
+	return r, nil
+}
+
+// requestContext stores the request id, echoed back to the caller, and the tenant in the context for logging.
+func requestContext(w http.ResponseWriter, req *http.Request) context.Context {
+	requestId := req.Header.Get(RequestIdHeader)
+	if requestId == "" {
+		requestId = uuid.NewV4().String()
+	}
+	w.Header().Set(RequestIdHeader, requestId)
+	ctx := logging.WithRequestId(req.Context(), requestId)
+	if tenant := req.Header.Get(TenantHeader); tenant != "" {
+		ctx = logging.WithTenant(ctx, tenant)
+	}
+	return ctx
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	ctx := requestContext(w, req)
+	r, httpErr := h.resolve(req.URL.Path)
+	if httpErr != nil {
+		writeError(w, httpErr)
+		return
+	}
+	req = req.WithContext(logging.WithDomain(ctx, string(r.domainName)))
+	var err error
+	switch {
+	case r.externalId == "" && req.Method == http.MethodGet:
+		err = h.list(w, req, r)
+	case r.externalId == "" && req.Method == http.MethodPost:
+		err = h.create(w, req, r)
+	case r.externalId == batchSegment && req.Method == http.MethodGet:
+		err = h.batchGet(w, req, r)
+	case r.externalId != "" && req.Method == http.MethodGet:
+		err = h.get(w, req, r)
+	case r.externalId != "" && req.Method == http.MethodPut:
+		err = h.update(w, req, r)
+	case r.externalId != "" && req.Method == http.MethodPatch:
+		err = h.patch(w, req, r)
+	case r.externalId != "" && req.Method == http.MethodDelete:
+		err = h.delete(w, req, r)
+	default:
+		err = NewHttpError(http.StatusMethodNotAllowed, CodeMethodNotAllowed, fmt.Sprintf("%v is not allowed on %v", req.Method, req.URL.Path))
+	}
+	if err != nil {
+		httpErr := toHttpError(err)
+		if httpErr.Status >= http.StatusInternalServerError {
+			h.logger.Error(req.Context(), "request failed", logging.Err(err), logging.F("method", req.Method), logging.F("path", req.URL.Path))
+		}
+		writeError(w, httpErr)
+	}
+}
+
+// readBody reads one byte past the limit, so a body that reaches it is told apart from one that exceeds it.
+func (h *Handler) readBody(req *http.Request) ([]byte, error) {
+	body, err := ioutil.ReadAll(io.LimitReader(req.Body, h.maxBodyBytes+1))
+	if err != nil {
+		return nil, badRequest(err)
+	}
+	if int64(len(body)) > h.maxBodyBytes {
+		return nil, NewHttpError(http.StatusRequestEntityTooLarge, CodeBadRequest, fmt.Sprintf("request body is larger than %v bytes", h.maxBodyBytes))
+	}
+	return body, nil
+}
+
+func (h *Handler) decodeEntity(req *http.Request, r *route) (pkg.Base, error) {
+	body, err := h.readBody(req)
+	if err != nil {
+		return nil, err
+	}
+	dto, err := db.DecodeDto(r.creator().ToDto(), body)
+	if err != nil {
+		return nil, badRequest(err)
+	}
+	return r.creator().FillProperties(dto), nil
+}
+
+func (h *Handler) list(w http.ResponseWriter, req *http.Request, r *route) error {
+	filter, err := query.Parse(req.URL.Query(), query.NewSchema(r.creator()))
+	if err != nil {
+		return err
+	}
+	err, entities := r.svc.Find(req.Context(), filter)
+	if err != nil {
+		return err
+	}
+	items := make([]interface{}, 0, len(entities))
+	for _, entity := range entities {
+		item, err := project(entity.ToDto(), filter.Fields)
+		if err != nil {
+			return err
+		}
+		items = append(items, item)
+	}
+	writeJson(w, http.StatusOK, ListResponse{Items: items, Count: len(items), Limit: filter.Limit, Offset: filter.Offset})
+	return nil
+}
+
+// project keeps only the requested top level fields of dto, returning it untouched when none were requested.
+func project(dto interface{}, fields []string) (interface{}, error) {
+	if len(fields) == 0 {
+		return dto, nil
+	}
+	raw, err := json.Marshal(dto)
+	if err != nil {
+		return nil, err
+	}
+	var document map[string]interface{}
+	if err := json.Unmarshal(raw, &document); err != nil {
+		return nil, err
+	}
+	projected := make(map[string]interface{}, len(fields))
+	for _, field := range fields {
+		if value, ok := document[field]; ok {
+			projected[field] = value
+		}
+	}
+	return projected, nil
+}
+
+func (h *Handler) batchGet(w http.ResponseWriter, req *http.Request, r *route) error {
+	var ids []string
+	for _, value := range req.URL.Query()["ids"] {
+		for _, id := range strings.Split(value, ",") {
+			if id = strings.TrimSpace(id); id != "" {
+				ids = append(ids, id)
+			}
+		}
+	}
+	if len(ids) == 0 {
+		return NewHttpError(http.StatusBadRequest, CodeBadRequest, "ids query parameter is required")
+	}
+	if len(ids) > maxBatchIds {
+		return NewHttpError(http.StatusBadRequest, CodeBadRequest, fmt.Sprintf("at most %v ids can be fetched at once, got %v", maxBatchIds, len(ids)))
+	}
+	err, entities := r.svc.MultiGetByExternalId(req.Context(), ids)
+	if err != nil {
+		return err
+	}
+	writeList(w, entities)
+	return nil
+}
+
+func (h *Handler) get(w http.ResponseWriter, req *http.Request, r *route) error {
+	err, entity := r.svc.FindByExternalId(req.Context(), r.externalId)
+	if err != nil {
+		return err
+	}
+	writeJson(w, http.StatusOK, entity.ToDto())
+	return nil
+}
+
+func (h *Handler) create(w http.ResponseWriter, req *http.Request, r *route) error {
+	entity, err := h.decodeEntity(req, r)
+	if err != nil {
+		return err
+	}
+	err, created := r.svc.Create(req.Context(), entity)
+	if err != nil {
+		return err
+	}
+	writeJson(w, http.StatusCreated, created.ToDto())
+	return nil
+}
+
+func (h *Handler) update(w http.ResponseWriter, req *http.Request, r *route) error {
+	entity, err := h.decodeEntity(req, r)
+	if err != nil {
+		return err
+	}
+	err, updated := r.svc.Update(req.Context(), r.externalId, entity)
+	if err != nil {
+		return err
+	}
+	writeJson(w, http.StatusOK, updated.ToDto())
+	return nil
+}
+
+func patchFormat(req *http.Request) (db.PatchFormat, error) {
+	contentType := req.Header.Get("Content-Type")
+	if contentType == "" {
+		return db.MergePatch, nil
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "", badRequest(err)
+	}
+	switch mediaType {
+	case string(db.MergePatch), "application/json":
+		return db.MergePatch, nil
+	case string(db.JSONPatch):
+		return db.JSONPatch, nil
+	}
+	return "", NewHttpError(http.StatusUnsupportedMediaType, CodeBadRequest, fmt.Sprintf("unsupported patch content type %v", mediaType))
+}
+
+func (h *Handler) patch(w http.ResponseWriter, req *http.Request, r *route) error {
+	format, err := patchFormat(req)
+	if err != nil {
+		return err
+	}
+	body, err := h.readBody(req)
+	if err != nil {
+		return err
+	}
+	err, patched := r.svc.Patch(req.Context(), r.externalId, body, format)
+	if err != nil {
+		return err
+	}
+	writeJson(w, http.StatusOK, patched.ToDto())
+	return nil
+}
+
+func (h *Handler) delete(w http.ResponseWriter, req *http.Request, r *route) error {
+	if err := r.svc.Delete(req.Context(), r.externalId); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func writeList(w http.ResponseWriter, entities []pkg.Base) {
+	items := make([]interface{}, 0, len(entities))
+	for _, entity := range entities {
+		items = append(items, entity.ToDto())
+	}
+	writeJson(w, http.StatusOK, ListResponse{Items: items, Count: len(items)})
+}
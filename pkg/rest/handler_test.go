@@ -0,0 +1,122 @@
+package rest
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/kutty-kumar/charminder/pkg"
+	"github.com/kutty-kumar/charminder/pkg/db"
+)
+
+type widget struct {
+	pkg.BaseDomain
+	Name string `json:"name"`
+}
+
+func (w *widget) GetName() pkg.DomainName {
+	return "widgets"
+}
+
+func (w *widget) ToDto() interface{} {
+	return w
+}
+
+func (w *widget) FillProperties(dto interface{}) pkg.Base {
+	*w = *dto.(*widget)
+	return w
+}
+
+func (w *widget) Merge(other interface{}) {
+	if o := other.(*widget); o.Name != "" {
+		w.Name = o.Name
+	}
+}
+
+func (w *widget) FromSqlRow(rows *sql.Rows) (pkg.Base, error) {
+	return w, nil
+}
+
+func (w *widget) ToJson() (string, error) {
+	out, err := json.Marshal(w)
+	return string(out), err
+}
+
+func (w *widget) MarshalBinary() ([]byte, error) {
+	return json.Marshal(w)
+}
+
+func (w *widget) UnmarshalBinary(buffer []byte) error {
+	return json.Unmarshal(buffer, w)
+}
+
+func TestHandlerLimitsTheBody(t *testing.T) {
+	factory := pkg.NewDomainFactory()
+	factory.RegisterMapping("widgets", func() pkg.Base { return &widget{} })
+	handler := NewHandler(factory, WithService("widgets", &db.BaseSvc{}), WithMaxBodyBytes(16))
+	tests := []struct {
+		name        string
+		method      string
+		path        string
+		contentType string
+		body        string
+		want        int
+	}{
+		{name: "create", method: http.MethodPost, path: "/widgets", body: `{"name":"` + strings.Repeat("a", 16) + `"}`, want: http.StatusRequestEntityTooLarge},
+		{name: "update", method: http.MethodPut, path: "/widgets/w1", body: `{"name":"` + strings.Repeat("a", 16) + `"}`, want: http.StatusRequestEntityTooLarge},
+		{name: "patch", method: http.MethodPatch, path: "/widgets/w1", contentType: string(db.MergePatch), body: `{"name":"` + strings.Repeat("a", 16) + `"}`, want: http.StatusRequestEntityTooLarge},
+		{name: "within the limit", method: http.MethodPost, path: "/widgets", body: `{"name":`, want: http.StatusBadRequest},
+		{name: "at the limit", method: http.MethodPost, path: "/widgets", body: `{"name":"` + strings.Repeat("a", 6) + `"`, want: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, req)
+			if recorder.Code != tt.want {
+				t.Fatalf("status %v, want %v: %v", recorder.Code, tt.want, recorder.Body)
+			}
+		})
+	}
+}
+
+func TestHandlerRoutes(t *testing.T) {
+	factory := pkg.NewDomainFactory()
+	factory.RegisterMapping("widgets", func() pkg.Base { return &widget{} })
+	handler := NewHandler(factory, WithService("widgets", &db.BaseSvc{}), WithBasePath("/api"))
+	tooMany := make([]string, maxBatchIds+1)
+	for i := range tooMany {
+		tooMany[i] = fmt.Sprintf("w%v", i)
+	}
+	tests := []struct {
+		name string
+		path string
+		want int
+		code string
+	}{
+		{name: "base path as a prefix of another segment", path: "/apiv2/widgets", want: http.StatusNotFound, code: CodeNotFound},
+		{name: "outside the base path", path: "/widgets", want: http.StatusNotFound, code: CodeNotFound},
+		{name: "base path alone", path: "/api", want: http.StatusNotFound, code: CodeNotFound},
+		{name: "unknown domain", path: "/api/gadgets", want: http.StatusNotFound, code: CodeUnknownDomain},
+		{name: "batch without ids", path: "/api/widgets/_batch", want: http.StatusBadRequest, code: CodeBadRequest},
+		{name: "batch above the cap", path: "/api/widgets/_batch?ids=" + strings.Join(tooMany, ","), want: http.StatusBadRequest, code: CodeBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			var envelope ErrorEnvelope
+			_ = json.Unmarshal(recorder.Body.Bytes(), &envelope)
+			if recorder.Code != tt.want || envelope.Error.Code != tt.code {
+				t.Fatalf("status %v %v, want %v %v: %v", recorder.Code, envelope.Error.Code, tt.want, tt.code, recorder.Body)
+			}
+		})
+	}
+}
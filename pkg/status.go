@@ -0,0 +1,162 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+var (
+	ErrUnknownStatus     = errors.New("unknown status")
+	ErrInvalidTransition = errors.New("invalid status transition")
+)
+
+// TransitionGuard can veto a transition that the lifecycle otherwise allows, e.g. publishing a draft without a title.
+type TransitionGuard func(ctx context.Context, entity Base, from, to Status) error
+
+type StatusLifecycle struct {
+	mu          sync.RWMutex
+	names       map[Status]string
+	values      map[string]Status
+	transitions map[Status]map[Status][]TransitionGuard
+}
+
+func NewStatusLifecycle() *StatusLifecycle {
+	return &StatusLifecycle{
+		names:       make(map[Status]string),
+		values:      make(map[string]Status),
+		transitions: make(map[Status]map[Status][]TransitionGuard),
+	}
+}
+
+func (sl *StatusLifecycle) AddStatus(name string, value int) *StatusLifecycle {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	sl.names[Status(value)] = name
+	sl.values[name] = Status(value)
+	return sl
+}
+
+func (sl *StatusLifecycle) AddTransition(from, to string, guards ...TransitionGuard) error {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	fromStatus, ok := sl.values[from]
+	if !ok {
+		return fmt.Errorf("%w: %v", ErrUnknownStatus, from)
+	}
+	toStatus, ok := sl.values[to]
+	if !ok {
+		return fmt.Errorf("%w: %v", ErrUnknownStatus, to)
+	}
+	if sl.transitions[fromStatus] == nil {
+		sl.transitions[fromStatus] = make(map[Status][]TransitionGuard)
+	}
+	sl.transitions[fromStatus][toStatus] = append(sl.transitions[fromStatus][toStatus], guards...)
+	return nil
+}
+
+func (sl *StatusLifecycle) StatusInt(name string) (int, error) {
+	sl.mu.RLock()
+	defer sl.mu.RUnlock()
+	status, ok := sl.values[name]
+	if !ok {
+		return 0, fmt.Errorf("%w: %v", ErrUnknownStatus, name)
+	}
+	return int(status), nil
+}
+
+func (sl *StatusLifecycle) StatusStr(value int) (string, error) {
+	sl.mu.RLock()
+	defer sl.mu.RUnlock()
+	name, ok := sl.names[Status(value)]
+	if !ok {
+		return "", fmt.Errorf("%w: %v", ErrUnknownStatus, value)
+	}
+	return name, nil
+}
+
+func (sl *StatusLifecycle) CanTransition(from, to Status) bool {
+	sl.mu.RLock()
+	defer sl.mu.RUnlock()
+	if from == to {
+		return true
+	}
+	_, ok := sl.transitions[from][to]
+	return ok
+}
+
+func (sl *StatusLifecycle) NextStatuses(current Status) []string {
+	sl.mu.RLock()
+	defer sl.mu.RUnlock()
+	var next []Status
+	for to := range sl.transitions[current] {
+		next = append(next, to)
+	}
+	sort.Slice(next, func(i, j int) bool {
+		return next[i] < next[j]
+	})
+	result := make([]string, 0, len(next))
+	for _, status := range next {
+		result = append(result, sl.names[status])
+	}
+	return result
+}
+
+// Transition checks that entity may move from one status to another, running every guard registered for the edge.
+func (sl *StatusLifecycle) Transition(ctx context.Context, entity Base, from, to Status) error {
+	if from == to {
+		return nil
+	}
+	sl.mu.RLock()
+	fromName, fromOk := sl.names[from]
+	toName, toOk := sl.names[to]
+	guards, allowed := sl.transitions[from][to]
+	sl.mu.RUnlock()
+	if !fromOk {
+		return fmt.Errorf("%w: %v", ErrUnknownStatus, int(from))
+	}
+	if !toOk {
+		return fmt.Errorf("%w: %v", ErrUnknownStatus, int(to))
+	}
+	if !allowed {
+		return fmt.Errorf("%w: %v -> %v", ErrInvalidTransition, fromName, toName)
+	}
+	for _, guard := range guards {
+		if err := guard(ctx, entity, from, to); err != nil {
+			return fmt.Errorf("%w: %v -> %v: %v", ErrInvalidTransition, fromName, toName, err)
+		}
+	}
+	return nil
+}
+
+var (
+	defaultStatusLifecycle *StatusLifecycle
+	statusLifecyclesMu     sync.RWMutex
+	statusLifecycles       = make(map[DomainName]*StatusLifecycle)
+)
+
+func init() {
+	defaultStatusLifecycle = NewStatusLifecycle().
+		AddStatus("active", active).
+		AddStatus("inactive", inactive)
+	_ = defaultStatusLifecycle.AddTransition("active", "inactive")
+	_ = defaultStatusLifecycle.AddTransition("inactive", "active")
+}
+
+func RegisterStatusLifecycle(domainName DomainName, lifecycle *StatusLifecycle) {
+	statusLifecyclesMu.Lock()
+	defer statusLifecyclesMu.Unlock()
+	statusLifecycles[domainName] = lifecycle
+}
+
+// GetStatusLifecycle returns the lifecycle registered for domainName, falling back to active/inactive.
+func GetStatusLifecycle(domainName DomainName) *StatusLifecycle {
+	statusLifecyclesMu.RLock()
+	defer statusLifecyclesMu.RUnlock()
+	if lifecycle, ok := statusLifecycles[domainName]; ok {
+		return lifecycle
+	}
+	return defaultStatusLifecycle
+}
@@ -0,0 +1,40 @@
+package pkg
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestStatusNamesHonourTheDomainLifecycle(t *testing.T) {
+	RegisterStatusLifecycle("orders", NewStatusLifecycle().AddStatus("placed", 5).AddStatus("shipped", 6))
+	tests := []struct {
+		domainName DomainName
+		name       string
+		value      int
+		wantErr    error
+	}{
+		{domainName: "orders", name: "placed", value: 5},
+		{domainName: "orders", name: "shipped", value: 6},
+		{domainName: "orders", name: "active", wantErr: ErrUnknownStatus},
+		{domainName: "users", name: "active", value: active},
+		{domainName: "users", name: "inactive", value: inactive},
+		{domainName: "users", name: "placed", wantErr: ErrUnknownStatus},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.domainName)+"/"+tt.name, func(t *testing.T) {
+			value, err := GetStatusInt(tt.domainName, tt.name)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("GetStatusInt() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			if value != tt.value {
+				t.Fatalf("GetStatusInt() = %v, want %v", value, tt.value)
+			}
+			if name, err := GetStatusStr(tt.domainName, value); err != nil || name != tt.name {
+				t.Fatalf("GetStatusStr() = %v, %v, want %v", name, err, tt.name)
+			}
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package tracing
+
+import (
+	"context"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const InstrumentationName = "github.com/kutty-kumar/charminder"
+
+const DomainKey = attribute.Key("charminder.domain")
+
+var defaultPropagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+
+// Tracer returns the charminder tracer of provider. Without a provider the global one is used, so spans are
+// dropped until the application installs an SDK provider with otel.SetTracerProvider.
+func Tracer(provider trace.TracerProvider) trace.Tracer {
+	if provider == nil {
+		provider = otel.GetTracerProvider()
+	}
+	return provider.Tracer(InstrumentationName)
+}
+
+// Propagator falls back to W3C trace context and baggage, which is what crosses Kafka headers and outgoing
+// HTTP requests unless the caller configures otherwise.
+func Propagator(propagator propagation.TextMapPropagator) propagation.TextMapPropagator {
+	if propagator == nil {
+		return defaultPropagator
+	}
+	return propagator
+}
+
+// End marks span as failed when err is set and ends it.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// TraceId returns the trace id of the span in ctx, or an empty string when ctx carries no span.
+func TraceId(ctx context.Context) string {
+	spanContext := trace.SpanContextFromContext(ctx)
+	if !spanContext.HasTraceID() {
+		return ""
+	}
+	return spanContext.TraceID().String()
+}
@@ -0,0 +1,202 @@
+package fieldcrypt
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+type profile struct {
+	Email      string `encrypt:"blind_index=EmailIndex"`
+	EmailIndex string
+	Phone      *string `encrypt:"true"`
+	Name       string
+}
+
+type customer struct {
+	profile
+	Note string `encrypt:"true"`
+}
+
+func key(b byte) []byte {
+	return bytes.Repeat([]byte{b}, 32)
+}
+
+func newKeyring(t *testing.T, current uint32, versions ...uint32) *Keyring {
+	t.Helper()
+	keys := make(map[uint32][]byte, len(versions))
+	for _, version := range versions {
+		keys[version] = key(byte(version))
+	}
+	keyring, err := NewKeyring(current, keys, []byte("blind-index-key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keyring
+}
+
+func TestFieldsRoundTrip(t *testing.T) {
+	phone := "+1 555 0100"
+	tests := []struct {
+		name     string
+		customer customer
+	}{
+		{name: "all fields", customer: customer{profile: profile{Email: "ann@example.com", Phone: &phone, Name: "Ann"}, Note: "vip"}},
+		{name: "nil pointer", customer: customer{profile: profile{Email: "ann@example.com", Name: "Ann"}}},
+		{name: "plaintext that looks like a ciphertext", customer: customer{profile: profile{Email: "enc:v1:AAAA"}, Note: "enc:v9:"}},
+		{name: "empty strings", customer: customer{}},
+	}
+	keyring := newKeyring(t, 1, 1)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entity := tt.customer
+			if err := keyring.EncryptFields(&entity, "row-1"); err != nil {
+				t.Fatal(err)
+			}
+			if entity.Name != tt.customer.Name {
+				t.Errorf("untagged field changed to %v", entity.Name)
+			}
+			for field, value := range map[string]string{"Email": entity.Email, "Note": entity.Note} {
+				if !IsEncrypted(value) || value == tt.customer.Email || value == tt.customer.Note {
+					t.Errorf("%v = %q was not encrypted", field, value)
+				}
+			}
+			if tt.customer.Phone != nil && (!IsEncrypted(*entity.Phone) || *entity.Phone == phone) {
+				t.Errorf("Phone = %q was not encrypted", *entity.Phone)
+			}
+			if tt.customer.Phone == nil && entity.Phone != nil {
+				t.Errorf("nil Phone became %q", *entity.Phone)
+			}
+			wantIndex, _ := keyring.BlindIndex(tt.customer.Email, []byte("Email"))
+			if entity.EmailIndex != wantIndex {
+				t.Errorf("EmailIndex = %v, want %v", entity.EmailIndex, wantIndex)
+			}
+			if err := keyring.DecryptFields(&entity, "row-1"); err != nil {
+				t.Fatal(err)
+			}
+			if entity.Email != tt.customer.Email || entity.Note != tt.customer.Note {
+				t.Errorf("decrypted %q/%q, want %q/%q", entity.Email, entity.Note, tt.customer.Email, tt.customer.Note)
+			}
+			if (entity.Phone == nil) != (tt.customer.Phone == nil) || (entity.Phone != nil && *entity.Phone != phone) {
+				t.Errorf("decrypted Phone %v, want %v", entity.Phone, tt.customer.Phone)
+			}
+		})
+	}
+}
+
+func TestCiphertextsAreBoundToTheirRowAndField(t *testing.T) {
+	keyring := newKeyring(t, 1, 1)
+	entity := customer{profile: profile{Email: "ann@example.com"}, Note: "vip"}
+	if err := keyring.EncryptFields(&entity, "row-1"); err != nil {
+		t.Fatal(err)
+	}
+	sealed := entity
+
+	moved := sealed
+	if err := keyring.DecryptFields(&moved, "row-2"); err == nil {
+		t.Error("a ciphertext copied to another row was decrypted")
+	}
+	swapped := sealed
+	swapped.Email, swapped.Note = sealed.Note, sealed.Email
+	if err := keyring.DecryptFields(&swapped, "row-1"); err == nil {
+		t.Error("a ciphertext copied to another field was decrypted")
+	}
+	plain := customer{Note: "never encrypted"}
+	if err := keyring.DecryptFields(&plain, "row-1"); !errors.Is(err, ErrMalformedCipher) {
+		t.Errorf("DecryptFields() on plaintext = %v, want %v", err, ErrMalformedCipher)
+	}
+	if err := keyring.EncryptFields(&customer{Note: "x"}, ""); err == nil {
+		t.Error("EncryptFields() accepted an empty row id")
+	}
+	if err := keyring.EncryptFields(customer{}, "row-1"); !errors.Is(err, ErrNotAddressable) {
+		t.Errorf("EncryptFields() on a value = %v, want %v", err, ErrNotAddressable)
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	old := newKeyring(t, 1, 1)
+	rotated := newKeyring(t, 2, 1, 2)
+	retired := newKeyring(t, 2, 2)
+
+	sealedWithOld, err := old.Encrypt("secret", []byte("ad"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name         string
+		keyring      *Keyring
+		ciphertext   string
+		wantErr      error
+		wantRotation bool
+	}{
+		{name: "old key still readable", keyring: rotated, ciphertext: sealedWithOld, wantRotation: true},
+		{name: "old key retired", keyring: retired, ciphertext: sealedWithOld, wantErr: ErrUnknownKeyVersion, wantRotation: true},
+		{name: "current key", keyring: old, ciphertext: sealedWithOld},
+		{name: "malformed version", keyring: rotated, ciphertext: "enc:vx:AAAA", wantErr: ErrMalformedCipher},
+		{name: "malformed payload", keyring: rotated, ciphertext: "enc:v1:!!", wantErr: ErrMalformedCipher},
+		{name: "truncated payload", keyring: rotated, ciphertext: "enc:v1:AAAA", wantErr: ErrMalformedCipher, wantRotation: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plaintext, err := tt.keyring.Decrypt(tt.ciphertext, []byte("ad"))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Decrypt() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && plaintext != "secret" {
+				t.Fatalf("Decrypt() = %q, want secret", plaintext)
+			}
+			if got := tt.keyring.NeedsRotation(tt.ciphertext); got != tt.wantRotation {
+				t.Fatalf("NeedsRotation() = %v, want %v", got, tt.wantRotation)
+			}
+		})
+	}
+
+	resealed, err := rotated.Encrypt("secret", []byte("ad"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(resealed, "enc:v2:") || rotated.NeedsRotation(resealed) {
+		t.Fatalf("rewriting sealed %v, want the current version 2", resealed)
+	}
+	if plaintext, err := retired.Decrypt(resealed, []byte("ad")); err != nil || plaintext != "secret" {
+		t.Fatalf("Decrypt() after rotation = %q, %v", plaintext, err)
+	}
+}
+
+func TestNewKeyring(t *testing.T) {
+	if _, err := NewKeyring(2, map[uint32][]byte{1: key(1)}, nil); !errors.Is(err, ErrUnknownKeyVersion) {
+		t.Errorf("NewKeyring() without the current key = %v, want %v", err, ErrUnknownKeyVersion)
+	}
+	if _, err := NewKeyring(1, map[uint32][]byte{1: []byte("short")}, nil); err == nil {
+		t.Error("NewKeyring() accepted an invalid AES key")
+	}
+	keyring, err := NewKeyring(1, map[uint32][]byte{1: key(1)}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := keyring.BlindIndex("a", nil); err == nil {
+		t.Error("BlindIndex() without a blind index key succeeded")
+	}
+}
+
+func TestBlindIndexFor(t *testing.T) {
+	keyring := newKeyring(t, 1, 1)
+	field, hash, err := keyring.BlindIndexFor(&customer{}, "Email", "ann@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	entity := customer{profile: profile{Email: "ann@example.com"}}
+	if err := keyring.EncryptFields(&entity, "row-1"); err != nil {
+		t.Fatal(err)
+	}
+	if field != "EmailIndex" || hash != entity.EmailIndex {
+		t.Fatalf("BlindIndexFor() = %v, %v, want EmailIndex, %v", field, hash, entity.EmailIndex)
+	}
+	if _, _, err := keyring.BlindIndexFor(&customer{}, "Note", "vip"); err == nil {
+		t.Error("BlindIndexFor() on a field without a blind index succeeded")
+	}
+	if _, _, err := keyring.BlindIndexFor(&customer{}, "Name", "Ann"); err == nil {
+		t.Error("BlindIndexFor() on an unencrypted field succeeded")
+	}
+}
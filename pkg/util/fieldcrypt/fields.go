@@ -0,0 +1,236 @@
+package fieldcrypt
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// TagName marks a string field for encryption at rest, e.g. `encrypt:"true"` or `encrypt:"blind_index=EmailIndex"`.
+const TagName = "encrypt"
+
+var (
+	ErrNotAddressable = errors.New("entity must be a non-nil pointer to a struct")
+	fieldCache        sync.Map
+)
+
+type encryptedField struct {
+	index      []int
+	name       string
+	blindIndex []int
+}
+
+func IsEncryptedField(field reflect.StructField) bool {
+	_, ok := field.Tag.Lookup(TagName)
+	return ok
+}
+
+func blindIndexTarget(tag string) string {
+	for _, option := range strings.Split(tag, ",") {
+		if strings.HasPrefix(option, "blind_index=") {
+			return strings.TrimPrefix(option, "blind_index=")
+		}
+	}
+	return ""
+}
+
+func isStringField(t reflect.Type) bool {
+	return t.Kind() == reflect.String || (t.Kind() == reflect.Ptr && t.Elem().Kind() == reflect.String)
+}
+
+func collectFields(t reflect.Type, parent []int, fields *[]encryptedField) error {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		index := append(append([]int{}, parent...), i)
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			if err := collectFields(field.Type, index, fields); err != nil {
+				return err
+			}
+			continue
+		}
+		if !IsEncryptedField(field) {
+			continue
+		}
+		if !isStringField(field.Type) {
+			return fmt.Errorf("encrypted field %v must be a string", field.Name)
+		}
+		encrypted := encryptedField{index: index, name: field.Name}
+		if target := blindIndexTarget(field.Tag.Get(TagName)); target != "" {
+			indexField, ok := t.FieldByName(target)
+			if !ok || indexField.Type.Kind() != reflect.String {
+				return fmt.Errorf("blind index field %v for %v must be a string field", target, field.Name)
+			}
+			encrypted.blindIndex = append(append([]int{}, parent...), indexField.Index...)
+		}
+		*fields = append(*fields, encrypted)
+	}
+	return nil
+}
+
+func fieldsOf(t reflect.Type) ([]encryptedField, error) {
+	if cached, ok := fieldCache.Load(t); ok {
+		return cached.([]encryptedField), nil
+	}
+	var fields []encryptedField
+	if err := collectFields(t, nil, &fields); err != nil {
+		return nil, err
+	}
+	fieldCache.Store(t, fields)
+	return fields, nil
+}
+
+func structValue(entity interface{}) (reflect.Value, error) {
+	v := reflect.ValueOf(entity)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return reflect.Value{}, ErrNotAddressable
+	}
+	return v.Elem(), nil
+}
+
+func HasEncryptedFields(entity interface{}) bool {
+	t := reflect.TypeOf(entity)
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return false
+	}
+	fields, err := fieldsOf(t)
+	return err != nil || len(fields) > 0
+}
+
+func stringOf(v reflect.Value) (string, bool) {
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return "", false
+		}
+		v = v.Elem()
+	}
+	return v.String(), true
+}
+
+func setString(v reflect.Value, value string) {
+	if v.Kind() == reflect.Ptr {
+		v.Set(reflect.ValueOf(&value))
+		return
+	}
+	v.SetString(value)
+}
+
+// associatedData binds a ciphertext to its column and its row, so that it cannot be copied into another field or
+// another entity.
+func associatedData(field, rowId string) []byte {
+	return []byte(field + "\x00" + rowId)
+}
+
+// EncryptFields replaces every tagged field of entity with its ciphertext and fills the configured blind indexes.
+// The fields must hold plaintext: a value is encrypted whatever it looks like, so callers encrypt once, right
+// before the entity is written, and decrypt once it was written or loaded. rowId, usually the external id,
+// becomes part of the associated data.
+func (k *Keyring) EncryptFields(entity interface{}, rowId string) error {
+	if rowId == "" {
+		return errors.New("encrypting fields needs the id of the row")
+	}
+	v, err := structValue(entity)
+	if err != nil {
+		return err
+	}
+	fields, err := fieldsOf(v.Type())
+	if err != nil {
+		return err
+	}
+	for _, field := range fields {
+		fv := v.FieldByIndex(field.index)
+		plaintext, ok := stringOf(fv)
+		if !ok {
+			continue
+		}
+		if field.blindIndex != nil {
+			blindIndex, err := k.BlindIndex(plaintext, []byte(field.name))
+			if err != nil {
+				return err
+			}
+			v.FieldByIndex(field.blindIndex).SetString(blindIndex)
+		}
+		ciphertext, err := k.Encrypt(plaintext, associatedData(field.name, rowId))
+		if err != nil {
+			return fmt.Errorf("encrypting %v: %w", field.name, err)
+		}
+		setString(fv, ciphertext)
+	}
+	return nil
+}
+
+// DecryptFields reverses EncryptFields for a row loaded from storage. Every non-empty tagged field must be a
+// ciphertext sealed for rowId; a plaintext value is reported rather than passed through.
+func (k *Keyring) DecryptFields(entity interface{}, rowId string) error {
+	v, err := structValue(entity)
+	if err != nil {
+		return err
+	}
+	fields, err := fieldsOf(v.Type())
+	if err != nil {
+		return err
+	}
+	for _, field := range fields {
+		fv := v.FieldByIndex(field.index)
+		ciphertext, ok := stringOf(fv)
+		if !ok || ciphertext == "" {
+			continue
+		}
+		plaintext, err := k.Decrypt(ciphertext, associatedData(field.name, rowId))
+		if err != nil {
+			return fmt.Errorf("decrypting %v: %w", field.name, err)
+		}
+		setString(fv, plaintext)
+	}
+	return nil
+}
+
+// BlindIndexFor returns the blind index field name and the hash to look up value in the encrypted field.
+func (k *Keyring) BlindIndexFor(entity interface{}, fieldName string, value string) (string, string, error) {
+	v, err := structValue(entity)
+	if err != nil {
+		return "", "", err
+	}
+	fields, err := fieldsOf(v.Type())
+	if err != nil {
+		return "", "", err
+	}
+	for _, field := range fields {
+		if field.name != fieldName {
+			continue
+		}
+		if field.blindIndex == nil {
+			return "", "", fmt.Errorf("encrypted field %v has no blind index", fieldName)
+		}
+		blindIndex, err := k.BlindIndex(value, []byte(field.name))
+		if err != nil {
+			return "", "", err
+		}
+		return v.Type().FieldByIndex(field.blindIndex).Name, blindIndex, nil
+	}
+	return "", "", fmt.Errorf("%v is not an encrypted field", fieldName)
+}
+
+// EncryptedStructFields lists the tagged fields of entity, including those promoted from embedded structs.
+func EncryptedStructFields(entity interface{}) []reflect.StructField {
+	t := reflect.TypeOf(entity)
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil
+	}
+	fields, err := fieldsOf(t)
+	if err != nil {
+		return nil
+	}
+	result := make([]reflect.StructField, 0, len(fields))
+	for _, field := range fields {
+		result = append(result, t.FieldByIndex(field.index))
+	}
+	return result
+}
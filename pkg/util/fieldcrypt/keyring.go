@@ -0,0 +1,121 @@
+package fieldcrypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const ciphertextPrefix = "enc:v"
+
+var (
+	ErrUnknownKeyVersion = errors.New("unknown key version")
+	ErrMalformedCipher   = errors.New("malformed ciphertext")
+)
+
+// Keyring holds every AES key version that may still be found in stored ciphertexts.
+// New values are always sealed with the current version, so rewriting a row rotates its key.
+type Keyring struct {
+	current       uint32
+	aeads         map[uint32]cipher.AEAD
+	blindIndexKey []byte
+}
+
+func NewKeyring(current uint32, keys map[uint32][]byte, blindIndexKey []byte) (*Keyring, error) {
+	if _, ok := keys[current]; !ok {
+		return nil, fmt.Errorf("%w: current version %v has no key", ErrUnknownKeyVersion, current)
+	}
+	aeads := make(map[uint32]cipher.AEAD)
+	for version, key := range keys {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("key version %v: %w", version, err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, fmt.Errorf("key version %v: %w", version, err)
+		}
+		aeads[version] = aead
+	}
+	return &Keyring{current: current, aeads: aeads, blindIndexKey: blindIndexKey}, nil
+}
+
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, ciphertextPrefix)
+}
+
+// Encrypt seals plaintext with the current key. The associated data binds the ciphertext to where it is stored,
+// decrypting needs the same associated data.
+func (k *Keyring) Encrypt(plaintext string, associatedData []byte) (string, error) {
+	aead := k.aeads[k.current]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(plaintext), associatedData)
+	return fmt.Sprintf("%v%v:%v", ciphertextPrefix, k.current, base64.StdEncoding.EncodeToString(sealed)), nil
+}
+
+func (k *Keyring) parse(ciphertext string) (uint32, []byte, error) {
+	if !IsEncrypted(ciphertext) {
+		return 0, nil, ErrMalformedCipher
+	}
+	parts := strings.SplitN(ciphertext[len(ciphertextPrefix):], ":", 2)
+	if len(parts) != 2 {
+		return 0, nil, ErrMalformedCipher
+	}
+	version, err := strconv.ParseUint(parts[0], 10, 32)
+	if err != nil {
+		return 0, nil, ErrMalformedCipher
+	}
+	sealed, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return 0, nil, ErrMalformedCipher
+	}
+	return uint32(version), sealed, nil
+}
+
+func (k *Keyring) Decrypt(ciphertext string, associatedData []byte) (string, error) {
+	version, sealed, err := k.parse(ciphertext)
+	if err != nil {
+		return "", err
+	}
+	aead, ok := k.aeads[version]
+	if !ok {
+		return "", fmt.Errorf("%w: %v", ErrUnknownKeyVersion, version)
+	}
+	if len(sealed) < aead.NonceSize() {
+		return "", ErrMalformedCipher
+	}
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], associatedData)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// NeedsRotation reports whether ciphertext was sealed with a key other than the current one.
+func (k *Keyring) NeedsRotation(ciphertext string) bool {
+	version, _, err := k.parse(ciphertext)
+	return err == nil && version != k.current
+}
+
+// BlindIndex returns a keyed hash of value that allows exact-match lookups without decrypting.
+func (k *Keyring) BlindIndex(value string, associatedData []byte) (string, error) {
+	if len(k.blindIndexKey) == 0 {
+		return "", errors.New("keyring has no blind index key")
+	}
+	mac := hmac.New(sha256.New, k.blindIndexKey)
+	mac.Write(associatedData)
+	mac.Write([]byte{0})
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
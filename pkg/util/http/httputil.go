@@ -4,17 +4,31 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
-	"github.com/sirupsen/logrus"
+	"github.com/kutty-kumar/charminder/pkg/logging"
+	"github.com/kutty-kumar/charminder/pkg/metrics"
+	"github.com/kutty-kumar/charminder/pkg/tracing"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
 	"io"
 	"io/ioutil"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type HttpUtil struct {
 	Client         *http.Client
-	Logger         *logrus.Logger
+	Logger         logging.Logger
 	DefaultHeaders map[string]string
+	// Metrics is optional, when set every request is counted by host, method and status code.
+	Metrics *metrics.HttpClientMetrics
+	// TracerProvider and Propagator default to the global provider and W3C trace context, the request
+	// context is the parent of the client span.
+	TracerProvider trace.TracerProvider
+	Propagator     propagation.TextMapPropagator
 }
 
 func GetQueryParamsString(queryParams map[string]string) string {
@@ -74,29 +88,52 @@ func WithBody(bodyFactory func() []byte) ReqOption {
 	}
 }
 
-func (hul *HttpUtil) DoOperation(req *http.Request, factoryFunc Factory, reqOptions ...ReqOption) error {
-	for _, option := range reqOptions {
-		option(req)
-	}
+func (hul *HttpUtil) do(req *http.Request) (*http.Response, error) {
+	ctx, span := tracing.Tracer(hul.TracerProvider).Start(req.Context(), "HTTP "+req.Method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.HTTPClientAttributesFromHTTPRequest(req)...))
+	defer span.End()
+	req = req.WithContext(ctx)
+	tracing.Propagator(hul.Propagator).Inject(ctx, propagation.HeaderCarrier(req.Header))
+	start := time.Now()
 	resp, err := hul.Client.Do(req)
+	logger := logging.OrNop(hul.Logger)
 	if err != nil {
-		return err
+		logger.Warn(ctx, "http request failed", logging.Err(err), logging.F("method", req.Method), logging.F("host", req.URL.Host))
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	} else {
+		logger.Debug(ctx, "http request completed", logging.F("method", req.Method), logging.F("host", req.URL.Host), logging.F("status", resp.StatusCode))
+		span.SetAttributes(semconv.HTTPAttributesFromHTTPStatusCode(resp.StatusCode)...)
+		span.SetStatus(semconv.SpanStatusFromHTTPStatusCode(resp.StatusCode))
 	}
-	respBytes, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return err
+	if hul.Metrics != nil {
+		if err != nil {
+			hul.Metrics.Failed(req.URL.Host, req.Method, metrics.ErrorKind(err), time.Since(start))
+		} else {
+			hul.Metrics.Observe(req.URL.Host, req.Method, strconv.Itoa(resp.StatusCode), time.Since(start))
+		}
 	}
-	entity := factoryFunc()
-	err = json.Unmarshal(respBytes, entity)
+	return resp, err
+}
+
+func (hul *HttpUtil) DoOperation(req *http.Request, factoryFunc Factory, reqOptions ...ReqOption) error {
+	for _, option := range reqOptions {
+		option(req)
+	}
+	resp, err := hul.do(req)
 	if err != nil {
 		return err
 	}
-	return nil
+	return hul.unmarshal(factoryFunc, resp)
 }
 
 func (hul *HttpUtil) unmarshal(factoryFunc Factory, response *http.Response) error {
 	defer response.Body.Close()
 	respBytes, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
 	entity := factoryFunc()
 	err = json.Unmarshal(respBytes, entity)
 	if err != nil {
@@ -107,26 +144,16 @@ func (hul *HttpUtil) unmarshal(factoryFunc Factory, response *http.Response) err
 
 func (hul *HttpUtil) DoGet(uri string, factoryFunc Factory, reqOptions ...ReqOption) error {
 	req, err := NewGetReq(uri)
-	for _, option := range reqOptions {
-		option(req)
-	}
-	resp, err := hul.Client.Do(req)
-	defer resp.Body.Close()
 	if err != nil {
 		return err
 	}
-	return hul.unmarshal(factoryFunc, resp)
+	return hul.DoOperation(req, factoryFunc, reqOptions...)
 }
 
 func (hul *HttpUtil) DoPost(uri string, factoryFunc Factory, bodyFunc func() []byte, reqOptions ...ReqOption) error {
 	req, err := NewPostReq(uri, bytes.NewReader(bodyFunc()))
-	for _, option := range reqOptions {
-		option(req)
-	}
-	res, err := hul.Client.Do(req)
 	if err != nil {
 		return err
 	}
-
-	return hul.unmarshal(factoryFunc, res)
+	return hul.DoOperation(req, factoryFunc, reqOptions...)
 }
@@ -0,0 +1,142 @@
+package jsonpatch
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func assertJSON(t *testing.T, got []byte, want string) {
+	t.Helper()
+	var gotValue, wantValue interface{}
+	if err := json.Unmarshal(got, &gotValue); err != nil {
+		t.Fatalf("result %s is not json: %v", got, err)
+	}
+	if err := json.Unmarshal([]byte(want), &wantValue); err != nil {
+		t.Fatalf("expectation %s is not json: %v", want, err)
+	}
+	if !reflect.DeepEqual(gotValue, wantValue) {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+}
+
+// The examples of RFC 7396 appendix A.
+func TestMergePatch(t *testing.T) {
+	tests := []struct {
+		target string
+		patch  string
+		want   string
+	}{
+		{target: `{"a":"b"}`, patch: `{"a":"c"}`, want: `{"a":"c"}`},
+		{target: `{"a":"b"}`, patch: `{"b":"c"}`, want: `{"a":"b","b":"c"}`},
+		{target: `{"a":"b"}`, patch: `{"a":null}`, want: `{}`},
+		{target: `{"a":"b","b":"c"}`, patch: `{"a":null}`, want: `{"b":"c"}`},
+		{target: `{"a":["b"]}`, patch: `{"a":"c"}`, want: `{"a":"c"}`},
+		{target: `{"a":"c"}`, patch: `{"a":["b"]}`, want: `{"a":["b"]}`},
+		{target: `{"a":{"b":"c"}}`, patch: `{"a":{"b":"d","c":null}}`, want: `{"a":{"b":"d"}}`},
+		{target: `{"a":[{"b":"c"}]}`, patch: `{"a":[1]}`, want: `{"a":[1]}`},
+		{target: `["a","b"]`, patch: `["c","d"]`, want: `["c","d"]`},
+		{target: `{"a":"b"}`, patch: `["c"]`, want: `["c"]`},
+		{target: `{"a":"foo"}`, patch: `null`, want: `null`},
+		{target: `{"a":"foo"}`, patch: `"bar"`, want: `"bar"`},
+		{target: `{"e":null}`, patch: `{"a":1}`, want: `{"e":null,"a":1}`},
+		{target: `[1,2]`, patch: `{"a":"b","c":null}`, want: `{"a":"b"}`},
+		{target: `{}`, patch: `{"a":{"bb":{"ccc":null}}}`, want: `{"a":{"bb":{}}}`},
+		{target: `{"n":12345678901234567890}`, patch: `{"m":0}`, want: `{"n":12345678901234567890,"m":0}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target+" + "+tt.patch, func(t *testing.T) {
+			got, err := MergePatch([]byte(tt.target), []byte(tt.patch))
+			if err != nil {
+				t.Fatalf("MergePatch() error = %v", err)
+			}
+			assertJSON(t, got, tt.want)
+		})
+	}
+}
+
+func TestMergePatchRejectsInvalidJSON(t *testing.T) {
+	if _, err := MergePatch([]byte(`{"a":1}`), []byte(`{"a":`)); err == nil {
+		t.Fatal("MergePatch() accepted a truncated patch")
+	}
+	if _, err := MergePatch([]byte(`{"a":`), []byte(`{}`)); err == nil {
+		t.Fatal("MergePatch() accepted a truncated document")
+	}
+}
+
+// The examples of RFC 6902 appendix A, followed by cases the RFC requires without an example.
+func TestApplyPatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		doc     string
+		patch   string
+		want    string
+		wantErr error
+	}{
+		{name: "A.1 adding an object member", doc: `{"foo":"bar"}`, patch: `[{"op":"add","path":"/baz","value":"qux"}]`, want: `{"baz":"qux","foo":"bar"}`},
+		{name: "A.2 adding an array element", doc: `{"foo":["bar","baz"]}`, patch: `[{"op":"add","path":"/foo/1","value":"qux"}]`, want: `{"foo":["bar","qux","baz"]}`},
+		{name: "A.3 removing an object member", doc: `{"baz":"qux","foo":"bar"}`, patch: `[{"op":"remove","path":"/baz"}]`, want: `{"foo":"bar"}`},
+		{name: "A.4 removing an array element", doc: `{"foo":["bar","qux","baz"]}`, patch: `[{"op":"remove","path":"/foo/1"}]`, want: `{"foo":["bar","baz"]}`},
+		{name: "A.5 replacing a value", doc: `{"baz":"qux","foo":"bar"}`, patch: `[{"op":"replace","path":"/baz","value":"boo"}]`, want: `{"baz":"boo","foo":"bar"}`},
+		{name: "A.6 moving a value", doc: `{"foo":{"bar":"baz","waldo":"fred"},"qux":{"corge":"grault"}}`, patch: `[{"op":"move","from":"/foo/waldo","path":"/qux/thud"}]`, want: `{"foo":{"bar":"baz"},"qux":{"corge":"grault","thud":"fred"}}`},
+		{name: "A.7 moving an array element", doc: `{"foo":["all","grass","cows","eat"]}`, patch: `[{"op":"move","from":"/foo/1","path":"/foo/3"}]`, want: `{"foo":["all","cows","eat","grass"]}`},
+		{name: "A.8 testing a value: success", doc: `{"baz":"qux","foo":["a",2,"c"]}`, patch: `[{"op":"test","path":"/baz","value":"qux"},{"op":"test","path":"/foo/1","value":2}]`, want: `{"baz":"qux","foo":["a",2,"c"]}`},
+		{name: "A.9 testing a value: error", doc: `{"baz":"qux"}`, patch: `[{"op":"test","path":"/baz","value":"bar"}]`, wantErr: ErrTestFailed},
+		{name: "A.10 adding a nested member object", doc: `{"foo":"bar"}`, patch: `[{"op":"add","path":"/child","value":{"grandchild":{}}}]`, want: `{"foo":"bar","child":{"grandchild":{}}}`},
+		{name: "A.11 ignoring unrecognized elements", doc: `{"foo":"bar"}`, patch: `[{"op":"add","path":"/baz","value":"qux","xyz":123}]`, want: `{"foo":"bar","baz":"qux"}`},
+		{name: "A.12 adding to a nonexistent target", doc: `{"foo":"bar"}`, patch: `[{"op":"add","path":"/baz/bat","value":"qux"}]`, wantErr: ErrPathNotFound},
+		{name: "A.14 ~ escape ordering", doc: `{"/":9,"~1":10}`, patch: `[{"op":"test","path":"/~01","value":10}]`, want: `{"/":9,"~1":10}`},
+		{name: "A.15 comparing strings and numbers", doc: `{"/":9,"~1":10}`, patch: `[{"op":"test","path":"/~01","value":"10"}]`, wantErr: ErrTestFailed},
+		{name: "A.16 adding an array value", doc: `{"foo":["bar"]}`, patch: `[{"op":"add","path":"/foo/-","value":["abc","def"]}]`, want: `{"foo":["bar",["abc","def"]]}`},
+		{name: "add with a null value", doc: `{"foo":"bar"}`, patch: `[{"op":"add","path":"/foo","value":null}]`, want: `{"foo":null}`},
+		{name: "replace with zero", doc: `{"count":3}`, patch: `[{"op":"replace","path":"/count","value":0}]`, want: `{"count":0}`},
+		{name: "replace the root", doc: `{"foo":"bar"}`, patch: `[{"op":"replace","path":"","value":[1]}]`, want: `[1]`},
+		{name: "copy is deep", doc: `{"a":{"b":1}}`, patch: `[{"op":"copy","from":"/a","path":"/c"},{"op":"replace","path":"/c/b","value":2}]`, want: `{"a":{"b":1},"c":{"b":2}}`},
+		{name: "test compares numbers by value", doc: `{"a":1}`, patch: `[{"op":"test","path":"/a","value":1.0}]`, want: `{"a":1}`},
+		{name: "test compares objects regardless of order", doc: `{"a":{"x":1,"y":[2]}}`, patch: `[{"op":"test","path":"/a","value":{"y":[2.0],"x":1}}]`, want: `{"a":{"x":1,"y":[2]}}`},
+		{name: "test of an absent value", doc: `{"a":null}`, patch: `[{"op":"test","path":"/a","value":null}]`, want: `{"a":null}`},
+		{name: "patch fails as a whole", doc: `{"a":1}`, patch: `[{"op":"add","path":"/b","value":2},{"op":"remove","path":"/c"}]`, wantErr: ErrPathNotFound},
+		{name: "remove of a missing member", doc: `{"a":1}`, patch: `[{"op":"remove","path":"/b"}]`, wantErr: ErrPathNotFound},
+		{name: "array index past the end", doc: `{"a":[1]}`, patch: `[{"op":"add","path":"/a/2","value":2}]`, wantErr: ErrPathNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ApplyPatch([]byte(tt.doc), []byte(tt.patch))
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("ApplyPatch() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ApplyPatch() error = %v", err)
+			}
+			assertJSON(t, got, tt.want)
+		})
+	}
+}
+
+func TestApplyPatchRejectsMalformedOperations(t *testing.T) {
+	tests := []struct {
+		name  string
+		patch string
+	}{
+		{name: "add without a value", patch: `[{"op":"add","path":"/b"}]`},
+		{name: "replace without a value", patch: `[{"op":"replace","path":"/a"}]`},
+		{name: "test without a value", patch: `[{"op":"test","path":"/a"}]`},
+		{name: "unknown operation", patch: `[{"op":"frobnicate","path":"/a"}]`},
+		{name: "pointer without a leading slash", patch: `[{"op":"remove","path":"a"}]`},
+		{name: "array index with a leading zero", patch: `[{"op":"remove","path":"/list/01"}]`},
+		{name: "move into its own child", patch: `[{"op":"move","from":"/obj","path":"/obj/child"}]`},
+		{name: "remove the root", patch: `[{"op":"remove","path":""}]`},
+		{name: "not an array of operations", patch: `{"op":"remove","path":"/a"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, err := ApplyPatch([]byte(`{"a":1,"list":[1,2],"obj":{}}`), []byte(tt.patch)); err == nil {
+				t.Fatalf("ApplyPatch() = %s, want an error", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,284 @@
+package jsonpatch
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrTestFailed   = errors.New("test operation failed")
+	ErrPathNotFound = errors.New("path not found")
+)
+
+type Operation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("invalid json pointer %q", pointer)
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+func arrayIndex(token string, length int, allowEnd bool) (int, error) {
+	if token == "-" && allowEnd {
+		return length, nil
+	}
+	index, err := strconv.Atoi(token)
+	if err != nil || index < 0 || (token != "0" && strings.HasPrefix(token, "0")) {
+		return 0, fmt.Errorf("invalid array index %q", token)
+	}
+	limit := length - 1
+	if allowEnd {
+		limit = length
+	}
+	if index > limit {
+		return 0, fmt.Errorf("%w: index %v out of range", ErrPathNotFound, index)
+	}
+	return index, nil
+}
+
+func get(doc interface{}, tokens []string) (interface{}, error) {
+	current := doc
+	for _, token := range tokens {
+		switch node := current.(type) {
+		case map[string]interface{}:
+			value, ok := node[token]
+			if !ok {
+				return nil, fmt.Errorf("%w: %v", ErrPathNotFound, token)
+			}
+			current = value
+		case []interface{}:
+			index, err := arrayIndex(token, len(node), false)
+			if err != nil {
+				return nil, err
+			}
+			current = node[index]
+		default:
+			return nil, fmt.Errorf("%w: %v", ErrPathNotFound, token)
+		}
+	}
+	return current, nil
+}
+
+// update walks to the parent of tokens and replaces the child with whatever apply returns.
+func update(doc interface{}, tokens []string, apply func(parent interface{}, key string) (interface{}, error)) (interface{}, error) {
+	if len(tokens) == 0 {
+		return apply(nil, "")
+	}
+	parentTokens, last := tokens[:len(tokens)-1], tokens[len(tokens)-1]
+	if len(parentTokens) == 0 {
+		return apply(doc, last)
+	}
+	parent, err := get(doc, parentTokens)
+	if err != nil {
+		return nil, err
+	}
+	updated, err := apply(parent, last)
+	if err != nil {
+		return nil, err
+	}
+	grandParent, err := get(doc, parentTokens[:len(parentTokens)-1])
+	if err != nil {
+		return nil, err
+	}
+	key := parentTokens[len(parentTokens)-1]
+	switch node := grandParent.(type) {
+	case map[string]interface{}:
+		node[key] = updated
+	case []interface{}:
+		index, _ := arrayIndex(key, len(node), false)
+		node[index] = updated
+	}
+	return doc, nil
+}
+
+func add(doc interface{}, tokens []string, value interface{}) (interface{}, error) {
+	return update(doc, tokens, func(parent interface{}, key string) (interface{}, error) {
+		switch node := parent.(type) {
+		case nil:
+			return value, nil
+		case map[string]interface{}:
+			node[key] = value
+			return node, nil
+		case []interface{}:
+			index, err := arrayIndex(key, len(node), true)
+			if err != nil {
+				return nil, err
+			}
+			node = append(node, nil)
+			copy(node[index+1:], node[index:])
+			node[index] = value
+			return node, nil
+		}
+		return nil, fmt.Errorf("%w: %v", ErrPathNotFound, key)
+	})
+}
+
+func remove(doc interface{}, tokens []string) (interface{}, error) {
+	if len(tokens) == 0 {
+		return nil, errors.New("cannot remove the document root")
+	}
+	return update(doc, tokens, func(parent interface{}, key string) (interface{}, error) {
+		switch node := parent.(type) {
+		case map[string]interface{}:
+			if _, ok := node[key]; !ok {
+				return nil, fmt.Errorf("%w: %v", ErrPathNotFound, key)
+			}
+			delete(node, key)
+			return node, nil
+		case []interface{}:
+			index, err := arrayIndex(key, len(node), false)
+			if err != nil {
+				return nil, err
+			}
+			return append(node[:index], node[index+1:]...), nil
+		}
+		return nil, fmt.Errorf("%w: %v", ErrPathNotFound, key)
+	})
+}
+
+func deepCopy(value interface{}) (interface{}, error) {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	return decode(raw)
+}
+
+// equal compares decoded documents, numbers by value so that 1 and 1.0 are the same as RFC 6902 requires.
+func equal(a, b interface{}) bool {
+	switch typedA := a.(type) {
+	case json.Number:
+		typedB, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		x, errA := typedA.Float64()
+		y, errB := typedB.Float64()
+		return errA == nil && errB == nil && x == y
+	case map[string]interface{}:
+		typedB, ok := b.(map[string]interface{})
+		if !ok || len(typedA) != len(typedB) {
+			return false
+		}
+		for key, value := range typedA {
+			other, ok := typedB[key]
+			if !ok || !equal(value, other) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		typedB, ok := b.([]interface{})
+		if !ok || len(typedA) != len(typedB) {
+			return false
+		}
+		for i := range typedA {
+			if !equal(typedA[i], typedB[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func applyOperation(doc interface{}, operation Operation) (interface{}, error) {
+	tokens, err := parsePointer(operation.Path)
+	if err != nil {
+		return nil, err
+	}
+	var value interface{}
+	switch operation.Op {
+	case "add", "replace", "test":
+		if len(operation.Value) == 0 {
+			return nil, fmt.Errorf("%v operation without a value", operation.Op)
+		}
+	}
+	if len(operation.Value) > 0 {
+		if value, err = decode(operation.Value); err != nil {
+			return nil, err
+		}
+	}
+	switch operation.Op {
+	case "add":
+		return add(doc, tokens, value)
+	case "remove":
+		return remove(doc, tokens)
+	case "replace":
+		if _, err := get(doc, tokens); err != nil {
+			return nil, err
+		}
+		if len(tokens) == 0 {
+			return value, nil
+		}
+		if doc, err = remove(doc, tokens); err != nil {
+			return nil, err
+		}
+		return add(doc, tokens, value)
+	case "move", "copy":
+		fromTokens, err := parsePointer(operation.From)
+		if err != nil {
+			return nil, err
+		}
+		moved, err := get(doc, fromTokens)
+		if err != nil {
+			return nil, err
+		}
+		if operation.Op == "move" {
+			if strings.HasPrefix(operation.Path+"/", operation.From+"/") && operation.Path != operation.From {
+				return nil, fmt.Errorf("cannot move %v into its own child %v", operation.From, operation.Path)
+			}
+			if doc, err = remove(doc, fromTokens); err != nil {
+				return nil, err
+			}
+		} else if moved, err = deepCopy(moved); err != nil {
+			return nil, err
+		}
+		return add(doc, tokens, moved)
+	case "test":
+		actual, err := get(doc, tokens)
+		if err != nil {
+			return nil, err
+		}
+		if !equal(actual, value) {
+			return nil, fmt.Errorf("%w: %v", ErrTestFailed, operation.Path)
+		}
+		return doc, nil
+	}
+	return nil, fmt.Errorf("unsupported patch operation %q", operation.Op)
+}
+
+// ApplyPatch applies an RFC 6902 JSON Patch to doc. Operations are applied in order and the patch fails as a whole.
+func ApplyPatch(doc, patch []byte) ([]byte, error) {
+	var operations []Operation
+	if err := json.Unmarshal(patch, &operations); err != nil {
+		return nil, err
+	}
+	target, err := decode(doc)
+	if err != nil {
+		return nil, err
+	}
+	for i, operation := range operations {
+		if target, err = applyOperation(target, operation); err != nil {
+			return nil, fmt.Errorf("operation %v (%v %v): %w", i, operation.Op, operation.Path, err)
+		}
+	}
+	return json.Marshal(target)
+}
@@ -0,0 +1,423 @@
+package validation
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	tagName = "validate"
+
+	CodeRequired = "required"
+	CodeLength   = "length"
+	CodeMinLen   = "min_length"
+	CodeMaxLen   = "max_length"
+	CodePattern  = "pattern"
+	CodeEnum     = "enum"
+	CodeEmail    = "email"
+	CodeMin      = "min"
+	CodeMax      = "max"
+	CodeEqField  = "eq_field"
+	CodeNeField  = "ne_field"
+	CodeGtField  = "gt_field"
+	CodeGteField = "gte_field"
+	CodeLtField  = "lt_field"
+	CodeLteField = "lte_field"
+	CodeInvalid  = "invalid"
+)
+
+var (
+	emailRegex = regexp.MustCompile(`^[a-zA-Z0-9.!#$%&'*+/=?^_{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)+$`)
+	regexCache sync.Map
+	timeType   = reflect.TypeOf(time.Time{})
+
+	crossFieldRules = map[string]bool{"eqfield": true, "nefield": true, "gtfield": true, "gtefield": true, "ltfield": true, "ltefield": true}
+)
+
+// Validatable is implemented by entities that need rules which cannot be expressed through struct tags.
+type Validatable interface {
+	Validate() error
+}
+
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type Error struct {
+	Errors []FieldError `json:"errors"`
+}
+
+func (e *Error) Error() string {
+	var messages []string
+	for _, fieldError := range e.Errors {
+		messages = append(messages, fmt.Sprintf("%v: %v", fieldError.Field, fieldError.Message))
+	}
+	return fmt.Sprintf("validation failed: %v", strings.Join(messages, "; "))
+}
+
+func (e *Error) Add(field, code, message string) {
+	e.Errors = append(e.Errors, FieldError{Field: field, Code: code, Message: message})
+}
+
+func (e *Error) HasErrors() bool {
+	return len(e.Errors) > 0
+}
+
+func AsError(err error) (*Error, bool) {
+	var vErr *Error
+	if errors.As(err, &vErr) {
+		return vErr, true
+	}
+	return nil, false
+}
+
+// Rule is one rule of a validate tag, such as min_len=3.
+type Rule struct {
+	Name  string
+	Param string
+}
+
+// ParseRules splits a tag such as `required,min_len=3,regex=^[a-z]+$` into rules.
+// regex consumes the remainder of the tag so patterns may contain commas.
+func ParseRules(tag string) []Rule {
+	var rules []Rule
+	for len(tag) > 0 {
+		var part string
+		if strings.HasPrefix(tag, "regex=") {
+			part, tag = tag, ""
+		} else if idx := strings.Index(tag, ","); idx >= 0 {
+			part, tag = tag[:idx], tag[idx+1:]
+		} else {
+			part, tag = tag, ""
+		}
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		if idx := strings.Index(part, "="); idx >= 0 {
+			rules = append(rules, Rule{Name: part[:idx], Param: part[idx+1:]})
+		} else {
+			rules = append(rules, Rule{Name: part})
+		}
+	}
+	return rules
+}
+
+func fieldName(field reflect.StructField) string {
+	if jsonTag := field.Tag.Get("json"); jsonTag != "" && jsonTag != "-" {
+		if name := strings.Split(jsonTag, ",")[0]; name != "" {
+			return name
+		}
+	}
+	return field.Name
+}
+
+func joinPath(parent, name string) string {
+	if parent == "" {
+		return name
+	}
+	return parent + "." + name
+}
+
+func compileRegex(pattern string) (*regexp.Regexp, error) {
+	if cached, ok := regexCache.Load(pattern); ok {
+		return cached.(*regexp.Regexp), nil
+	}
+	compiled, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	regexCache.Store(pattern, compiled)
+	return compiled, nil
+}
+
+func indirect(v reflect.Value) (reflect.Value, bool) {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return v, false
+		}
+		v = v.Elem()
+	}
+	return v, true
+}
+
+func length(v reflect.Value) (int, bool) {
+	switch v.Kind() {
+	case reflect.String:
+		return utf8.RuneCountInString(v.String()), true
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return v.Len(), true
+	}
+	return 0, false
+}
+
+func toFloat(v reflect.Value) (float64, bool) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true
+	}
+	return 0, false
+}
+
+// scalar formats strings, numbers and booleans without Interface, which panics on fields promoted through an
+// unexported embedded struct.
+func scalar(v reflect.Value) (string, bool) {
+	switch v.Kind() {
+	case reflect.String:
+		return v.String(), true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10), true
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'f', -1, 64), true
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool()), true
+	}
+	if v.CanInterface() {
+		return fmt.Sprintf("%v", v.Interface()), true
+	}
+	return "", false
+}
+
+// compare returns -1, 0 or 1 for numbers, strings and times; ok is false when the values are not comparable.
+func compare(a, b reflect.Value) (int, bool) {
+	if af, ok := toFloat(a); ok {
+		bf, ok := toFloat(b)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case af < bf:
+			return -1, true
+		case af > bf:
+			return 1, true
+		}
+		return 0, true
+	}
+	if a.Kind() == reflect.String && b.Kind() == reflect.String {
+		return strings.Compare(a.String(), b.String()), true
+	}
+	if a.Type() == timeType && b.Type() == timeType && a.CanInterface() && b.CanInterface() {
+		at, bt := a.Interface().(time.Time), b.Interface().(time.Time)
+		switch {
+		case at.Before(bt):
+			return -1, true
+		case at.After(bt):
+			return 1, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+func lookupField(parent reflect.Value, name string) (reflect.Value, bool) {
+	sibling := parent.FieldByName(name)
+	if !sibling.IsValid() {
+		return sibling, false
+	}
+	return indirect(sibling)
+}
+
+func checkRule(vErr *Error, path string, r Rule, value reflect.Value, parent reflect.Value) error {
+	switch r.Name {
+	case "len", "min_len", "max_len":
+		expected, err := strconv.Atoi(r.Param)
+		if err != nil {
+			return fmt.Errorf("invalid %v parameter %q on %v", r.Name, r.Param, path)
+		}
+		actual, ok := length(value)
+		if !ok {
+			return fmt.Errorf("%v is not supported on %v", r.Name, path)
+		}
+		if r.Name == "len" && actual != expected {
+			vErr.Add(path, CodeLength, fmt.Sprintf("must have length %v", expected))
+		} else if r.Name == "min_len" && actual < expected {
+			vErr.Add(path, CodeMinLen, fmt.Sprintf("must have length at least %v", expected))
+		} else if r.Name == "max_len" && actual > expected {
+			vErr.Add(path, CodeMaxLen, fmt.Sprintf("must have length at most %v", expected))
+		}
+	case "min", "max":
+		bound, err := strconv.ParseFloat(r.Param, 64)
+		if err != nil {
+			return fmt.Errorf("invalid %v parameter %q on %v", r.Name, r.Param, path)
+		}
+		actual, ok := toFloat(value)
+		if !ok {
+			return fmt.Errorf("%v is not supported on %v", r.Name, path)
+		}
+		if r.Name == "min" && actual < bound {
+			vErr.Add(path, CodeMin, fmt.Sprintf("must be at least %v", r.Param))
+		} else if r.Name == "max" && actual > bound {
+			vErr.Add(path, CodeMax, fmt.Sprintf("must be at most %v", r.Param))
+		}
+	case "regex":
+		pattern, err := compileRegex(r.Param)
+		if err != nil {
+			return fmt.Errorf("invalid regex %q on %v: %v", r.Param, path, err)
+		}
+		if value.Kind() != reflect.String {
+			return fmt.Errorf("regex is not supported on %v", path)
+		}
+		if !pattern.MatchString(value.String()) {
+			vErr.Add(path, CodePattern, fmt.Sprintf("must match %v", r.Param))
+		}
+	case "email":
+		if value.Kind() != reflect.String {
+			return fmt.Errorf("email is not supported on %v", path)
+		}
+		if !emailRegex.MatchString(value.String()) {
+			vErr.Add(path, CodeEmail, "must be a valid email address")
+		}
+	case "enum":
+		actual, ok := scalar(value)
+		if !ok {
+			return fmt.Errorf("enum is not supported on %v", path)
+		}
+		allowed := strings.Split(r.Param, "|")
+		for _, candidate := range allowed {
+			if candidate == actual {
+				return nil
+			}
+		}
+		vErr.Add(path, CodeEnum, fmt.Sprintf("must be one of %v", strings.Join(allowed, ", ")))
+	case "eqfield", "nefield", "gtfield", "gtefield", "ltfield", "ltefield":
+		other, ok := lookupField(parent, r.Param)
+		if !ok {
+			return nil
+		}
+		result, ok := compare(value, other)
+		if !ok {
+			return fmt.Errorf("%v cannot compare %v with %v", r.Name, path, r.Param)
+		}
+		switch {
+		case r.Name == "eqfield" && result != 0:
+			vErr.Add(path, CodeEqField, fmt.Sprintf("must be equal to %v", r.Param))
+		case r.Name == "nefield" && result == 0:
+			vErr.Add(path, CodeNeField, fmt.Sprintf("must not be equal to %v", r.Param))
+		case r.Name == "gtfield" && result <= 0:
+			vErr.Add(path, CodeGtField, fmt.Sprintf("must be greater than %v", r.Param))
+		case r.Name == "gtefield" && result < 0:
+			vErr.Add(path, CodeGteField, fmt.Sprintf("must be greater than or equal to %v", r.Param))
+		case r.Name == "ltfield" && result >= 0:
+			vErr.Add(path, CodeLtField, fmt.Sprintf("must be less than %v", r.Param))
+		case r.Name == "ltefield" && result > 0:
+			vErr.Add(path, CodeLteField, fmt.Sprintf("must be less than or equal to %v", r.Param))
+		}
+	default:
+		return fmt.Errorf("unknown validation rule %v on %v", r.Name, path)
+	}
+	return nil
+}
+
+func validateField(vErr *Error, path string, field reflect.StructField, value reflect.Value, parent reflect.Value) error {
+	rules := ParseRules(field.Tag.Get(tagName))
+	actual, nonNil := indirect(value)
+	present := nonNil && !actual.IsZero()
+	missing := false
+	for _, r := range rules {
+		if crossFieldRules[r.Name] && !parent.FieldByName(r.Param).IsValid() {
+			return fmt.Errorf("%v on %v names the unknown field %v", r.Name, path, r.Param)
+		}
+		if r.Name == "required" {
+			if !present {
+				vErr.Add(path, CodeRequired, "is required")
+				missing = true
+			}
+			continue
+		}
+		// Only required depends on presence, zero values go through the other rules unless a nil pointer left
+		// the field unset or it is already reported as missing.
+		if !nonNil || missing {
+			continue
+		}
+		if err := checkRule(vErr, path, r, actual, parent); err != nil {
+			return err
+		}
+	}
+	if nonNil {
+		return validateNested(vErr, path, actual)
+	}
+	return nil
+}
+
+func validateNested(vErr *Error, path string, v reflect.Value) error {
+	switch v.Kind() {
+	case reflect.Struct:
+		if v.Type() == timeType {
+			return nil
+		}
+		return validateStruct(vErr, path, v)
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			elem, ok := indirect(v.Index(i))
+			if !ok || elem.Kind() != reflect.Struct || elem.Type() == timeType {
+				continue
+			}
+			if err := validateStruct(vErr, fmt.Sprintf("%v[%v]", path, i), elem); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func validateStruct(vErr *Error, path string, v reflect.Value) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" && !field.Anonymous {
+			continue
+		}
+		if field.Anonymous {
+			embedded, ok := indirect(v.Field(i))
+			if ok && embedded.Kind() == reflect.Struct {
+				if err := validateStruct(vErr, path, embedded); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if err := validateField(vErr, joinPath(path, fieldName(field)), field, v.Field(i), v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Validate applies the `validate` struct tag rules of entity and, when implemented, its Validate method.
+// It returns an *Error listing every failing field, or a plain error when a rule itself is malformed.
+func Validate(entity interface{}) error {
+	vErr := &Error{}
+	if v, ok := indirect(reflect.ValueOf(entity)); ok && v.Kind() == reflect.Struct {
+		if err := validateStruct(vErr, "", v); err != nil {
+			return err
+		}
+	}
+	if validatable, ok := entity.(Validatable); ok {
+		if err := validatable.Validate(); err != nil {
+			if custom, ok := AsError(err); ok {
+				vErr.Errors = append(vErr.Errors, custom.Errors...)
+			} else {
+				vErr.Add("", CodeInvalid, err.Error())
+			}
+		}
+	}
+	if vErr.HasErrors() {
+		return vErr
+	}
+	return nil
+}
@@ -0,0 +1,181 @@
+package validation
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type address struct {
+	City string `json:"city" validate:"required"`
+	Zip  string `json:"zip" validate:"regex=^[0-9]{5}$"`
+}
+
+type account struct {
+	Name      string     `json:"name" validate:"required,min_len=2,max_len=5"`
+	Email     string     `json:"email" validate:"email"`
+	Code      string     `json:"code" validate:"len=3"`
+	Tag       string     `json:"tag" validate:"regex=^[a-z]{1,3}(,[a-z]+)?$"`
+	Role      string     `json:"role" validate:"enum=admin|user"`
+	Level     int        `json:"level" validate:"enum=1|2,min=1,max=2"`
+	Age       *int       `json:"age" validate:"min=18"`
+	Password  string     `json:"password"`
+	Confirm   string     `json:"confirm" validate:"eqfield=Password"`
+	StartsAt  time.Time  `json:"starts_at"`
+	EndsAt    *time.Time `json:"ends_at" validate:"gtfield=StartsAt"`
+	Address   *address   `json:"address"`
+	Addresses []address  `json:"addresses"`
+}
+
+type audit struct {
+	Kind     string    `json:"kind" validate:"enum=create|delete"`
+	Priority int       `json:"priority" validate:"enum=1|2"`
+	From     time.Time `json:"from"`
+	To       time.Time `json:"to" validate:"gtefield=From"`
+}
+
+type quantity struct {
+	Qty int `json:"qty" validate:"min=1"`
+}
+
+type audited struct {
+	audit
+	Name string `json:"name"`
+}
+
+type checked struct {
+	Name string `json:"name"`
+	err  error
+}
+
+func (c checked) Validate() error {
+	return c.err
+}
+
+func valid() account {
+	return account{Name: "ann", Email: "ann@example.com", Code: "abc", Tag: "ab,cd", Role: "user", Level: 1}
+}
+
+func TestValidate(t *testing.T) {
+	age, now := 17, time.Now()
+	earlier := now.Add(-time.Hour)
+	tests := []struct {
+		name   string
+		entity interface{}
+		want   []FieldError
+	}{
+		{name: "valid", entity: valid()},
+		{name: "pointer to valid", entity: func() *account { a := valid(); return &a }()},
+		{name: "zero values", entity: account{}, want: []FieldError{
+			{Field: "name", Code: CodeRequired}, {Field: "email", Code: CodeEmail}, {Field: "code", Code: CodeLength}, {Field: "tag", Code: CodePattern},
+			{Field: "role", Code: CodeEnum}, {Field: "level", Code: CodeEnum}, {Field: "level", Code: CodeMin},
+		}},
+		{name: "zero number below min", entity: quantity{}, want: []FieldError{{Field: "qty", Code: CodeMin}}},
+		{name: "empty string outside enum", entity: audit{Kind: "", Priority: 1}, want: []FieldError{{Field: "kind", Code: CodeEnum}}},
+		{name: "nil pointer skips the rules", entity: func() account { a := valid(); a.Age = nil; return a }()},
+		{name: "too short", entity: func() account { a := valid(); a.Name = "a"; return a }(), want: []FieldError{{Field: "name", Code: CodeMinLen}}},
+		{name: "too long in runes", entity: func() account { a := valid(); a.Name = "ääääää"; return a }(), want: []FieldError{{Field: "name", Code: CodeMaxLen}}},
+		{name: "exact length", entity: func() account { a := valid(); a.Code = "ab"; return a }(), want: []FieldError{{Field: "code", Code: CodeLength}}},
+		{name: "invalid email", entity: func() account { a := valid(); a.Email = "ann@"; return a }(), want: []FieldError{{Field: "email", Code: CodeEmail}}},
+		{name: "regex with a comma", entity: func() account { a := valid(); a.Tag = "ab,1"; return a }(), want: []FieldError{{Field: "tag", Code: CodePattern}}},
+		{name: "string enum", entity: func() account { a := valid(); a.Role = "root"; return a }(), want: []FieldError{{Field: "role", Code: CodeEnum}}},
+		{name: "number enum and max", entity: func() account { a := valid(); a.Level = 3; return a }(), want: []FieldError{{Field: "level", Code: CodeEnum}, {Field: "level", Code: CodeMax}}},
+		{name: "min on a pointer", entity: func() account { a := valid(); a.Age = &age; return a }(), want: []FieldError{{Field: "age", Code: CodeMin}}},
+		{name: "eqfield", entity: func() account { a := valid(); a.Password, a.Confirm = "x", "y"; return a }(), want: []FieldError{{Field: "confirm", Code: CodeEqField}}},
+		{name: "gtfield on times", entity: func() account { a := valid(); a.StartsAt, a.EndsAt = now, &earlier; return a }(), want: []FieldError{{Field: "ends_at", Code: CodeGtField}}},
+		{name: "gtfield on ordered times", entity: func() account { a := valid(); a.StartsAt, a.EndsAt = earlier, &now; return a }()},
+		{name: "nested struct", entity: func() account { a := valid(); a.Address = &address{Zip: "1"}; return a }(), want: []FieldError{{Field: "address.city", Code: CodeRequired}, {Field: "address.zip", Code: CodePattern}}},
+		{name: "slice of structs", entity: func() account {
+			a := valid()
+			a.Addresses = []address{{City: "x", Zip: "12345"}, {Zip: "12345"}}
+			return a
+		}(), want: []FieldError{{Field: "addresses[1].city", Code: CodeRequired}}},
+		{name: "promoted through an unexported embedded struct", entity: audited{audit: audit{Kind: "update", Priority: 3, From: now, To: earlier}}, want: []FieldError{{Field: "kind", Code: CodeEnum}, {Field: "priority", Code: CodeEnum}, {Field: "to", Code: CodeGteField}}},
+		{name: "Validate method", entity: checked{err: errors.New("broken")}, want: []FieldError{{Field: "", Code: CodeInvalid}}},
+		{name: "Validate method returning field errors", entity: checked{err: &Error{Errors: []FieldError{{Field: "name", Code: CodeRequired}}}}, want: []FieldError{{Field: "name", Code: CodeRequired}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Validate(tt.entity)
+			if len(tt.want) == 0 {
+				if err != nil {
+					t.Fatalf("Validate() = %v, want nil", err)
+				}
+				return
+			}
+			vErr, ok := AsError(err)
+			if !ok {
+				t.Fatalf("Validate() = %v, want a validation error", err)
+			}
+			var got []FieldError
+			for _, fieldError := range vErr.Errors {
+				got = append(got, FieldError{Field: fieldError.Field, Code: fieldError.Code})
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("Validate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateReportsMalformedRules(t *testing.T) {
+	tests := []struct {
+		name   string
+		entity interface{}
+	}{
+		{name: "unknown rule", entity: struct {
+			Name string `validate:"shiny"`
+		}{Name: "a"}},
+		{name: "non numeric bound", entity: struct {
+			Name string `validate:"min_len=x"`
+		}{Name: "a"}},
+		{name: "min on a string", entity: struct {
+			Name string `validate:"min=1"`
+		}{Name: "a"}},
+		{name: "invalid regex", entity: struct {
+			Name string `validate:"regex=["`
+		}{Name: "a"}},
+		{name: "cross field naming an unknown field", entity: struct {
+			Confirm string `validate:"eqfield=Pasword"`
+		}{Confirm: "a"}},
+		{name: "cross field naming an unknown field on a zero value", entity: struct {
+			Confirm string `validate:"eqfield=Pasword"`
+		}{}},
+		{name: "cross field on incomparable values", entity: struct {
+			Name  string `validate:"ltfield=Count"`
+			Count int
+		}{Name: "a", Count: 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Validate(tt.entity)
+			if err == nil {
+				t.Fatal("Validate() = nil, want an error")
+			}
+			if _, ok := AsError(err); ok {
+				t.Fatalf("Validate() = %v, want a malformed rule error rather than field errors", err)
+			}
+		})
+	}
+}
+
+func TestParseRules(t *testing.T) {
+	tests := []struct {
+		tag  string
+		want []Rule
+	}{
+		{tag: "", want: nil},
+		{tag: "required", want: []Rule{{Name: "required"}}},
+		{tag: "required, min_len=3 ,max_len=5", want: []Rule{{Name: "required"}, {Name: "min_len", Param: "3"}, {Name: "max_len", Param: "5"}}},
+		{tag: "required,regex=^[a-z]{1,3}$", want: []Rule{{Name: "required"}, {Name: "regex", Param: "^[a-z]{1,3}$"}}},
+		{tag: "enum=a|b,,email", want: []Rule{{Name: "enum", Param: "a|b"}, {Name: "email"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.tag, func(t *testing.T) {
+			if got := ParseRules(tt.tag); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("ParseRules(%q) = %v, want %v", tt.tag, got, tt.want)
+			}
+		})
+	}
+}